@@ -56,6 +56,9 @@ const (
 	MsgRedemptionFailed            = "redemption.failed"
 	MsgRedemptionNotProvided       = "redemption.not_provided"
 	MsgRedemptionExpireTimeInvalid = "redemption.expire_time_invalid"
+	MsgRedemptionAlreadyRedeemed   = "redemption.already_redeemed"
+	MsgRedemptionQuotaPositive     = "redemption.quota_positive"
+	MsgRedemptionMaxUsesPositive   = "redemption.max_uses_positive"
 )
 
 // User related messages
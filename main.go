@@ -65,6 +65,12 @@ func main() {
 		// for compatibility with old versions
 		common.MemoryCacheEnabled = true
 	}
+	// 声明式配置文件，需在加载渠道缓存前同步
+	if err := controller.ReloadConfigFile(); err != nil {
+		common.FatalLog("failed to apply config file: " + err.Error())
+	}
+	go controller.WatchConfigFileReload()
+
 	if common.MemoryCacheEnabled {
 		common.SysLog("memory cache enabled")
 		common.SysLog(fmt.Sprintf("sync frequency: %d seconds", common.SyncFrequency))
@@ -93,6 +99,15 @@ func main() {
 	// 数据看板
 	go model.UpdateQuotaData()
 
+	// 多密钥使用统计写回
+	go model.SyncMultiKeyUsage(60)
+
+	// 渠道健康度统计
+	go model.UpdateChannelHealthData()
+
+	// 外部密钥引用定期刷新
+	go model.RefreshChannelSecretRefs()
+
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
 		if err != nil {
@@ -103,6 +118,11 @@ func main() {
 
 	go controller.AutomaticallyTestChannels()
 
+	go controller.AutomaticallyProbeDisabledKeys()
+
+	go controller.AutomaticallyRotateTokens()
+
+	go controller.AutomaticallyRenewSubscriptions()
 
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
@@ -240,6 +260,25 @@ func InitResources() error {
 		return err
 	}
 
+	// 主密钥轮换：重新加密所有敏感字段后退出
+	if *common.RotateSecrets {
+		count, err := model.ReencryptSecrets(true)
+		if err != nil {
+			common.FatalLog("failed to rotate secrets: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("re-encrypted %d records", count))
+		os.Exit(0)
+	}
+	// 配置主密钥后加密已有的明文数据
+	if common.IsMasterNode && common.SecretEncryptionEnabled() {
+		count, err := model.ReencryptSecrets(false)
+		if err != nil {
+			common.SysError("failed to encrypt plaintext secrets: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("encrypted %d plaintext records", count))
+		}
+	}
+
 	model.CheckSetup()
 
 	// Initialize options, should after model.InitDB()
@@ -276,6 +315,9 @@ func InitResources() error {
 	}
 	// Register user language loader for lazy loading
 	i18n.SetUserLangLoader(model.GetUserLanguage)
+	// Skip circuit-broken or upstream rate-limited keys when selecting multi-key channel keys
+	model.SetMultiKeyAvailabilityChecker(service.IsChannelKeySelectable)
+	model.SetMultiKeyQuotaProvider(service.GetKeyRemainingQuotaRatio)
 
 	return nil
 }
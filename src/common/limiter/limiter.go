@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/go-redis/redis/v8"
@@ -13,9 +14,17 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/token_bucket.lua
+var tokenBucketScript string
+
+//go:embed lua/concurrency.lua
+var concurrencyScript string
+
 type RedisLimiter struct {
 	client         *redis.Client
 	limitScriptSHA string
+	bucketScript   *redis.Script
+	acquireScript  *redis.Script
 }
 
 var (
@@ -33,6 +42,8 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 		instance = &RedisLimiter{
 			client:         r,
 			limitScriptSHA: limitSHA,
+			bucketScript:   redis.NewScript(tokenBucketScript),
+			acquireScript:  redis.NewScript(concurrencyScript),
 		}
 	})
 
@@ -68,11 +79,64 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	return result == 1, nil
 }
 
+// Take 与 Allow 相同，但同时返回扣除后的剩余令牌数，用于生成限流响应头
+func (rl *RedisLimiter) Take(ctx context.Context, key string, opts ...Option) (Result, error) {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	force := 0
+	if config.Force {
+		force = 1
+	}
+	values, err := rl.bucketScript.Run(ctx, rl.client, []string{key},
+		config.Requested, config.Rate, config.Capacity, force).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("rate limit failed: %w", err)
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("rate limit failed: unexpected result %v", values)
+	}
+	return Result{Allowed: values[0] == 1, Tokens: values[1]}, nil
+}
+
+// Acquire 占用一个并发名额，超过 limit 时返回 false；ttl 用于防止进程异常退出后名额无法释放
+func (rl *RedisLimiter) Acquire(ctx context.Context, key string, limit int64, ttl time.Duration) (bool, int64, error) {
+	values, err := rl.acquireScript.Run(ctx, rl.client, []string{key}, limit, int64(ttl.Seconds())).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("concurrency limit failed: %w", err)
+	}
+	if len(values) != 2 {
+		return false, 0, fmt.Errorf("concurrency limit failed: unexpected result %v", values)
+	}
+	return values[0] == 1, values[1], nil
+}
+
+// Release 释放 Acquire 占用的并发名额
+func (rl *RedisLimiter) Release(ctx context.Context, key string) error {
+	current, err := rl.client.Decr(ctx, key).Result()
+	if err == nil && current <= 0 {
+		err = rl.client.Del(ctx, key).Err()
+	}
+	return err
+}
+
+// Result 令牌桶的判断结果
+type Result struct {
+	Allowed bool
+	Tokens  int64 // 扣除后桶内剩余的令牌数，强制扣除时可能为负数
+}
+
 // Config 配置选项模式
 type Config struct {
 	Capacity  int64
 	Rate      int64
 	Requested int64
+	Force     bool
 }
 
 type Option func(*Config)
@@ -88,3 +152,8 @@ func WithRate(r int64) Option {
 func WithRequested(n int64) Option {
 	return func(cfg *Config) { cfg.Requested = n }
 }
+
+// WithForce 令牌不足时也扣除，用于请求结束后按实际用量扣除
+func WithForce() Option {
+	return func(cfg *Config) { cfg.Force = true }
+}
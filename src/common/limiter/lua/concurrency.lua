@@ -0,0 +1,18 @@
+-- 并发名额计数器
+-- KEYS[1]: 计数器 key
+-- ARGV[1]: 并发上限
+-- ARGV[2]: 过期时间（秒），只在计数器创建时设置，异常退出的实例遗留的计数最终会过期
+
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+
+local current = redis.call('INCR', key)
+if current == 1 then
+    redis.call('EXPIRE', key, ttl)
+end
+if current > limit then
+    redis.call('DECR', key)
+    return {0, current - 1}
+end
+return {1, current}
@@ -0,0 +1,38 @@
+-- 令牌桶限流器，返回是否允许与扣除后的剩余令牌数
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 请求令牌数，0 表示只查询剩余令牌
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- ARGV[4]: 为 1 时令牌不足也扣除（请求结束后按实际用量扣除），剩余令牌可为负数
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local force = tonumber(ARGV[4])
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    tokens = capacity
+else
+    local elapsed = math.max(nowInSeconds - last_time, 0)
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+local allowed = 0
+if tokens >= requested or force == 1 then
+    tokens = tokens - requested
+    allowed = 1
+end
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', nowInSeconds)
+-- 桶内令牌恢复满额后即可过期，负数时需要更长时间恢复
+redis.call('EXPIRE', key, math.ceil((capacity - math.min(tokens, 0)) / rate) + 60)
+
+return {allowed, tokens}
@@ -0,0 +1,98 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter 单实例部署或 Redis 不可用时使用的内存令牌桶与并发计数，语义与 RedisLimiter 一致
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	inflight  map[string]int64
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens   int64
+	capacity int64
+	rate     int64
+	lastTime int64
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*memoryBucket),
+		inflight:  make(map[string]int64),
+		lastSweep: time.Now(),
+	}
+}
+
+func (ml *MemoryLimiter) Take(key string, opts ...Option) Result {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	now := time.Now()
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.sweep(now)
+	bucket, ok := ml.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: config.Capacity, lastTime: now.Unix()}
+		ml.buckets[key] = bucket
+	} else if elapsed := now.Unix() - bucket.lastTime; elapsed > 0 {
+		bucket.tokens = int64(math.Min(float64(config.Capacity), float64(bucket.tokens)+float64(elapsed*config.Rate)))
+		bucket.lastTime = now.Unix()
+	}
+	bucket.capacity = config.Capacity
+	bucket.rate = config.Rate
+
+	result := Result{}
+	if bucket.tokens >= config.Requested || config.Force {
+		bucket.tokens -= config.Requested
+		result.Allowed = true
+	}
+	result.Tokens = bucket.tokens
+	return result
+}
+
+func (ml *MemoryLimiter) Acquire(key string, limit int64) (bool, int64) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	current := ml.inflight[key]
+	if current >= limit {
+		return false, current
+	}
+	ml.inflight[key] = current + 1
+	return true, current + 1
+}
+
+func (ml *MemoryLimiter) Release(key string) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	if ml.inflight[key] <= 1 {
+		delete(ml.inflight, key)
+		return
+	}
+	ml.inflight[key]--
+}
+
+// sweep 定期清理已恢复满额的令牌桶，避免长期运行后占用过多内存
+func (ml *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < time.Minute {
+		return
+	}
+	ml.lastSweep = now
+	for key, bucket := range ml.buckets {
+		if bucket.rate <= 0 || bucket.tokens+(now.Unix()-bucket.lastTime)*bucket.rate >= bucket.capacity {
+			delete(ml.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import "testing"
+
+func TestMemoryLimiterTake(t *testing.T) {
+	ml := NewMemoryLimiter()
+	opts := []Option{WithCapacity(3), WithRate(1), WithRequested(1)}
+	for i := 0; i < 3; i++ {
+		if result := ml.Take("rpm", opts...); !result.Allowed || result.Tokens != int64(2-i) {
+			t.Fatalf("request %d: got %+v", i, result)
+		}
+	}
+	if result := ml.Take("rpm", opts...); result.Allowed {
+		t.Fatalf("expected bucket to be exhausted, got %+v", result)
+	}
+
+	// 事后按实际用量强制扣除，剩余令牌可为负数
+	if result := ml.Take("tpm", WithCapacity(10), WithRate(1), WithRequested(25), WithForce()); !result.Allowed || result.Tokens != -15 {
+		t.Fatalf("forced take: got %+v", result)
+	}
+	if result := ml.Take("tpm", WithCapacity(10), WithRate(1), WithRequested(0)); result.Tokens != -15 {
+		t.Fatalf("peek: got %+v", result)
+	}
+}
+
+func TestMemoryLimiterConcurrency(t *testing.T) {
+	ml := NewMemoryLimiter()
+	if ok, _ := ml.Acquire("streams", 2); !ok {
+		t.Fatal("first acquire should succeed")
+	}
+	if ok, _ := ml.Acquire("streams", 2); !ok {
+		t.Fatal("second acquire should succeed")
+	}
+	if ok, current := ml.Acquire("streams", 2); ok || current != 2 {
+		t.Fatalf("third acquire should fail, current=%d", current)
+	}
+	ml.Release("streams")
+	if ok, _ := ml.Acquire("streams", 2); !ok {
+		t.Fatal("acquire after release should succeed")
+	}
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 敏感字段（渠道密钥、用户 webhook 密钥等）的信封加密：
+// 每个值使用随机生成的数据密钥加密，数据密钥再使用主密钥加密后与密文一同存储。
+// 存储格式为 enc:v1:<主密钥ID>:<加密后的数据密钥>:<密文>
+
+const secretPrefix = "enc:v1:"
+
+var (
+	secretActiveKeyId string
+	secretMasterKeys  = make(map[string][]byte)
+)
+
+func parseSecretMasterKey(value string) []byte {
+	value = strings.TrimSpace(value)
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key
+	}
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key
+	}
+	// 非 32 字节的 base64/hex 时，使用 SHA-256 派生主密钥
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
+
+func secretMasterKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// InitSecretEncryption 从环境变量加载主密钥：
+// SECRET_ENCRYPTION_KEY 或 SECRET_ENCRYPTION_KEY_FILE 为当前主密钥，
+// SECRET_ENCRYPTION_OLD_KEYS（逗号分隔）为轮换前的旧主密钥，仅用于解密
+func InitSecretEncryption() error {
+	secretActiveKeyId = ""
+	secretMasterKeys = make(map[string][]byte)
+
+	activeKey := os.Getenv("SECRET_ENCRYPTION_KEY")
+	if keyFile := os.Getenv("SECRET_ENCRYPTION_KEY_FILE"); activeKey == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read SECRET_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		activeKey = string(data)
+	}
+	if strings.TrimSpace(activeKey) != "" {
+		key := parseSecretMasterKey(activeKey)
+		secretActiveKeyId = secretMasterKeyId(key)
+		secretMasterKeys[secretActiveKeyId] = key
+	}
+	for _, oldKey := range strings.Split(os.Getenv("SECRET_ENCRYPTION_OLD_KEYS"), ",") {
+		if strings.TrimSpace(oldKey) == "" {
+			continue
+		}
+		key := parseSecretMasterKey(oldKey)
+		secretMasterKeys[secretMasterKeyId(key)] = key
+	}
+	return nil
+}
+
+// SecretEncryptionEnabled 是否配置了主密钥
+func SecretEncryptionEnabled() bool {
+	return secretActiveKeyId != ""
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+func secretKeyIdOf(value string) string {
+	parts := strings.SplitN(strings.TrimPrefix(value, secretPrefix), ":", 3)
+	return parts[0]
+}
+
+// SecretNeedsReencrypt 判断已存储的值是否需要使用当前主密钥重新加密（明文、旧主密钥加密，或未配置主密钥时的密文）
+func SecretNeedsReencrypt(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !SecretEncryptionEnabled() {
+		return IsEncryptedSecret(value)
+	}
+	return !IsEncryptedSecret(value) || secretKeyIdOf(value) != secretActiveKeyId
+}
+
+// EncryptSecret 使用当前主密钥加密，未配置主密钥或已加密时原样返回
+func EncryptSecret(plaintext string) (string, error) {
+	if plaintext == "" || !SecretEncryptionEnabled() || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := EncryptAESGCM(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := EncryptAESGCM(secretMasterKeys[secretActiveKeyId], dataKey)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + secretActiveKeyId + ":" + wrappedKey + ":" + ciphertext, nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的输出，明文原样返回以兼容尚未迁移的数据
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, secretPrefix), ":", 3)
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted secret")
+	}
+	masterKey, ok := secretMasterKeys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("master key %s for encrypted secret is not configured", parts[0])
+	}
+	dataKey, err := DecryptAESGCM(masterKey, parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := DecryptAESGCM(dataKey, parts[2])
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// ReencryptSecret 使用当前主密钥重新加密已存储的值，未配置主密钥时还原为明文
+func ReencryptSecret(value string) (string, error) {
+	plaintext, err := DecryptSecret(value)
+	if err != nil {
+		return "", err
+	}
+	return EncryptSecret(plaintext)
+}
@@ -0,0 +1,284 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// 外部密钥引用，渠道密钥可填写以下格式，在加载渠道缓存时解析并定期刷新：
+//   env://OPENAI_KEY_3                  读取环境变量，变量名必须以 SECRET_REF_ENV_PREFIX 开头
+//   file:///run/secrets/claude          读取文件内容（去除首尾空白），文件必须位于 SECRET_REF_FILE_DIR 目录下
+//   vault://secret/providers/openai#key 读取 Vault KV v2 密钥（挂载点/路径#字段）
+// Vault 地址与令牌通过 VAULT_ADDR、VAULT_TOKEN（或 VAULT_TOKEN_FILE）、VAULT_NAMESPACE 配置。
+// 未配置 SECRET_REF_ENV_PREFIX / SECRET_REF_FILE_DIR 时不允许对应的引用，防止管理员借渠道密钥读取服务端的其他机密
+
+const (
+	SecretRefSchemeEnv   = "env://"
+	SecretRefSchemeFile  = "file://"
+	SecretRefSchemeVault = "vault://"
+)
+
+// 解析失败后的重试间隔，避免每个请求都访问外部存储
+const secretRefErrorRetryInterval = 30 * time.Second
+
+type secretRefEntry struct {
+	value      string
+	err        error
+	resolvedAt time.Time
+	checkedAt  time.Time
+}
+
+var (
+	secretRefCache     = make(map[string]*secretRefEntry)
+	secretRefCacheLock sync.RWMutex
+	secretRefClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// SecretRefRefreshInterval 外部密钥引用的刷新间隔（秒）
+var SecretRefRefreshInterval = 300
+
+// SecretRefEnvPrefix env:// 引用允许读取的环境变量名前缀，为空时禁止 env:// 引用
+var SecretRefEnvPrefix = ""
+
+// SecretRefFileDir file:// 引用允许读取的目录，为空时禁止 file:// 引用
+var SecretRefFileDir = ""
+
+func IsSecretRef(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, SecretRefSchemeEnv) ||
+		strings.HasPrefix(value, SecretRefSchemeFile) ||
+		strings.HasPrefix(value, SecretRefSchemeVault)
+}
+
+// ResolveSecretRef 解析外部密钥引用，优先使用缓存；非引用原样返回
+func ResolveSecretRef(ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if !IsSecretRef(ref) {
+		return ref, nil
+	}
+	secretRefCacheLock.RLock()
+	entry, ok := secretRefCache[ref]
+	secretRefCacheLock.RUnlock()
+	if ok && (entry.err == nil || time.Since(entry.checkedAt) < secretRefErrorRetryInterval) {
+		return entry.value, entry.err
+	}
+	return refreshSecretRef(ref)
+}
+
+// LookupSecretRef 仅从缓存读取已解析的值，不访问外部存储
+func LookupSecretRef(ref string) (string, bool) {
+	secretRefCacheLock.RLock()
+	defer secretRefCacheLock.RUnlock()
+	entry, ok := secretRefCache[strings.TrimSpace(ref)]
+	if !ok || entry.value == "" {
+		return "", false
+	}
+	return entry.value, true
+}
+
+// refreshSecretRef 重新读取引用；读取失败时保留上次成功的值，同时返回错误
+func refreshSecretRef(ref string) (string, error) {
+	value, err := fetchSecretRef(ref)
+	now := time.Now()
+
+	secretRefCacheLock.Lock()
+	defer secretRefCacheLock.Unlock()
+	entry, ok := secretRefCache[ref]
+	if !ok {
+		entry = &secretRefEntry{}
+		secretRefCache[ref] = entry
+	}
+	entry.checkedAt = now
+	entry.err = err
+	if err == nil {
+		entry.value = value
+		entry.resolvedAt = now
+	}
+	return entry.value, entry.err
+}
+
+// RefreshSecretRefs 刷新所有已缓存的引用
+func RefreshSecretRefs() {
+	secretRefCacheLock.RLock()
+	refs := make([]string, 0, len(secretRefCache))
+	for ref := range secretRefCache {
+		refs = append(refs, ref)
+	}
+	secretRefCacheLock.RUnlock()
+	for _, ref := range refs {
+		if _, err := refreshSecretRef(ref); err != nil {
+			SysError(fmt.Sprintf("failed to refresh secret reference %s: %s", ref, err.Error()))
+		}
+	}
+}
+
+// SecretRefResolvedAt 返回引用最近一次成功解析的时间
+func SecretRefResolvedAt(ref string) int64 {
+	secretRefCacheLock.RLock()
+	defer secretRefCacheLock.RUnlock()
+	if entry, ok := secretRefCache[strings.TrimSpace(ref)]; ok && !entry.resolvedAt.IsZero() {
+		return entry.resolvedAt.Unix()
+	}
+	return 0
+}
+
+// isPathWithin 判断 path 是否位于 dir 目录之下
+func isPathWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// CheckSecretRefAllowed 检查引用是否在允许的范围内：env:// 需匹配配置的变量名前缀，file:// 需位于配置的目录下
+func CheckSecretRefAllowed(ref string) error {
+	ref = strings.TrimSpace(ref)
+	switch {
+	case strings.HasPrefix(ref, SecretRefSchemeEnv):
+		name := strings.TrimPrefix(ref, SecretRefSchemeEnv)
+		if SecretRefEnvPrefix == "" {
+			return errors.New("env:// secret references are disabled, set SECRET_REF_ENV_PREFIX to enable them")
+		}
+		if name == "" || !strings.HasPrefix(name, SecretRefEnvPrefix) {
+			return fmt.Errorf("environment variable %s is not allowed, it must start with %s", name, SecretRefEnvPrefix)
+		}
+	case strings.HasPrefix(ref, SecretRefSchemeFile):
+		path := strings.TrimPrefix(ref, SecretRefSchemeFile)
+		if SecretRefFileDir == "" {
+			return errors.New("file:// secret references are disabled, set SECRET_REF_FILE_DIR to enable them")
+		}
+		if !filepath.IsAbs(path) || !isPathWithin(filepath.Clean(SecretRefFileDir), filepath.Clean(path)) {
+			return fmt.Errorf("secret file %s is not allowed, it must be inside %s", path, SecretRefFileDir)
+		}
+	}
+	return nil
+}
+
+// readSecretFile 读取引用的文件，解析符号链接后仍需位于允许的目录下
+func readSecretFile(path string) ([]byte, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Clean(SecretRefFileDir))
+	if err != nil {
+		return nil, err
+	}
+	real, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	if !isPathWithin(dir, real) {
+		return nil, fmt.Errorf("secret file %s resolves outside %s", path, SecretRefFileDir)
+	}
+	return os.ReadFile(real)
+}
+
+func fetchSecretRef(ref string) (string, error) {
+	if err := CheckSecretRefAllowed(ref); err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(ref, SecretRefSchemeEnv):
+		name := strings.TrimPrefix(ref, SecretRefSchemeEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok || strings.TrimSpace(value) == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return strings.TrimSpace(value), nil
+	case strings.HasPrefix(ref, SecretRefSchemeFile):
+		data, err := readSecretFile(strings.TrimPrefix(ref, SecretRefSchemeFile))
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "" {
+			return "", errors.New("secret file is empty")
+		}
+		return value, nil
+	case strings.HasPrefix(ref, SecretRefSchemeVault):
+		return fetchVaultSecret(strings.TrimPrefix(ref, SecretRefSchemeVault))
+	}
+	return "", fmt.Errorf("unsupported secret reference: %s", ref)
+}
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	if tokenFile := os.Getenv("VAULT_TOKEN_FILE"); tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", errors.New("VAULT_TOKEN is not set")
+}
+
+// fetchVaultSecret 通过 Vault KV v2 读取接口获取密钥：GET {VAULT_ADDR}/v1/{mount}/data/{path}
+func fetchVaultSecret(location string) (string, error) {
+	address := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if address == "" {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return "", err
+	}
+	path, field, _ := strings.Cut(location, "#")
+	mount, secretPath, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || mount == "" || secretPath == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected vault://<mount>/<path>#<field>", location)
+	}
+	segments := strings.Split(secretPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, address+"/v1/"+url.PathEscape(mount)+"/data/"+strings.Join(segments, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := secretRefClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := gjson.GetBytes(body, "errors.0").String()
+		return "", fmt.Errorf("vault returned status %d: %s", resp.StatusCode, message)
+	}
+
+	data := gjson.GetBytes(body, "data.data")
+	if !data.IsObject() {
+		return "", errors.New("vault response has no data")
+	}
+	if field == "" {
+		// 未指定字段时，仅当密钥只有一个字段时取该字段
+		fields := data.Map()
+		if len(fields) != 1 {
+			return "", fmt.Errorf("vault secret has %d fields, specify one with #field", len(fields))
+		}
+		for _, value := range fields {
+			return value.String(), nil
+		}
+	}
+	value := data.Get(gjson.Escape(field))
+	if !value.Exists() {
+		return "", fmt.Errorf("field %s not found in vault secret", field)
+	}
+	return value.String(), nil
+}
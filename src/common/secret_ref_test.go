@@ -0,0 +1,112 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecretRef(t *testing.T) {
+	// Vault KV v2 读取接口的本地替身
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/providers/openai":
+			w.Write([]byte(`{"data":{"data":{"api_key":"sk-vault","org":"org-1"},"metadata":{"version":2}}}`))
+		case "/v1/kv/data/claude":
+			w.Write([]byte(`{"data":{"data":{"key":"sk-ant"},"metadata":{"version":1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer vault.Close()
+
+	secretDir := t.TempDir()
+	secretFile := filepath.Join(secretDir, "claude")
+	if err := os.WriteFile(secretFile, []byte("sk-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	outsideFile := filepath.Join(t.TempDir(), "outside")
+	if err := os.WriteFile(outsideFile, []byte("server-secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideFile, filepath.Join(secretDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	prefix, dir := SecretRefEnvPrefix, SecretRefFileDir
+	SecretRefEnvPrefix, SecretRefFileDir = "TEST_SECRET_REF_", secretDir
+	t.Cleanup(func() { SecretRefEnvPrefix, SecretRefFileDir = prefix, dir })
+	t.Setenv("TEST_SECRET_REF_KEY", "sk-env")
+	t.Setenv("TEST_SERVER_SECRET", "server-secret")
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	tests := []struct {
+		name        string
+		ref         string
+		want        string
+		errContains string
+	}{
+		{name: "plain key", ref: "sk-plain", want: "sk-plain"},
+		{name: "env", ref: "env://TEST_SECRET_REF_KEY", want: "sk-env"},
+		{name: "env missing", ref: "env://TEST_SECRET_REF_MISSING", errContains: "not set"},
+		{name: "env outside prefix", ref: "env://TEST_SERVER_SECRET", errContains: "not allowed"},
+		{name: "file", ref: "file://" + secretFile, want: "sk-file"},
+		{name: "file outside dir", ref: "file://" + outsideFile, errContains: "not allowed"},
+		{name: "file path traversal", ref: "file://" + secretDir + "/../" + filepath.Base(filepath.Dir(outsideFile)) + "/outside", errContains: "not allowed"},
+		{name: "file symlink outside dir", ref: "file://" + filepath.Join(secretDir, "link"), errContains: "resolves outside"},
+		{name: "file relative path", ref: "file://claude", errContains: "not allowed"},
+		{name: "vault field", ref: "vault://secret/providers/openai#api_key", want: "sk-vault"},
+		{name: "vault single field", ref: "vault://kv/claude", want: "sk-ant"},
+		{name: "vault ambiguous field", ref: "vault://secret/providers/openai", errContains: "specify one"},
+		{name: "vault missing field", ref: "vault://secret/providers/openai#nope", errContains: "not found"},
+		{name: "vault not found", ref: "vault://secret/providers/none#key", errContains: "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveSecretRef(tt.ref)
+			if tt.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("expected error containing %q, got %v", tt.errContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 刷新失败时保留上次成功解析的值
+	t.Setenv("VAULT_TOKEN", "revoked")
+	RefreshSecretRefs()
+	got, err := ResolveSecretRef("vault://secret/providers/openai#api_key")
+	if err == nil || got != "sk-vault" {
+		t.Fatalf("expected stale value with error, got %q, %v", got, err)
+	}
+}
+
+func TestCheckSecretRefAllowedDisabled(t *testing.T) {
+	prefix, dir := SecretRefEnvPrefix, SecretRefFileDir
+	SecretRefEnvPrefix, SecretRefFileDir = "", ""
+	t.Cleanup(func() { SecretRefEnvPrefix, SecretRefFileDir = prefix, dir })
+	for _, ref := range []string{"env://SESSION_SECRET", "file:///proc/self/environ"} {
+		if err := CheckSecretRefAllowed(ref); err == nil || !strings.Contains(err.Error(), "disabled") {
+			t.Fatalf("expected %s to be rejected, got %v", ref, err)
+		}
+	}
+	if err := CheckSecretRefAllowed("vault://secret/providers/openai#api_key"); err != nil {
+		t.Fatalf("vault references should be allowed: %v", err)
+	}
+}
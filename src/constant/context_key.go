@@ -12,9 +12,15 @@ const (
 
 	/* token related keys */
 	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
-	ContextKeyTokenKey               ContextKey = "token_key"
+	ContextKeyTokenKeyHash           ContextKey = "token_key_hash"
 	ContextKeyTokenId                ContextKey = "token_id"
 	ContextKeyTokenGroup             ContextKey = "token_group"
+	ContextKeyTokenRPMLimit          ContextKey = "token_rpm_limit"
+	ContextKeyTokenTPMLimit          ContextKey = "token_tpm_limit"
+	ContextKeyTokenConcurrencyLimit  ContextKey = "token_concurrency_limit"
+	ContextKeyTokenOrganizationId    ContextKey = "token_organization_id"
+	ContextKeyTokenBudgetPeriodStart ContextKey = "token_budget_period_start"
+	ContextKeyTokenBudgetModelLimits ContextKey = "token_budget_model_limits"
 	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
@@ -65,4 +71,12 @@ const (
 
 	// ContextKeyLanguage stores the user's language preference for i18n
 	ContextKeyLanguage ContextKey = "language"
+
+	// ContextKeyRelayCompletionTokens stores the completion tokens billed for the current relay attempt,
+	// used by channel health analytics to compute output throughput.
+	ContextKeyRelayCompletionTokens ContextKey = "relay_completion_tokens"
+
+	// ContextKeyRelayTotalTokens stores the input plus output tokens billed for the current request,
+	// used by per-token and per-user TPM limits.
+	ContextKeyRelayTotalTokens ContextKey = "relay_total_tokens"
 )
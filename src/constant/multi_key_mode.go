@@ -3,6 +3,10 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom          MultiKeyMode = "random"            // 随机
+	MultiKeyModePolling         MultiKeyMode = "polling"           // 轮询
+	MultiKeyModeLeastRecentUsed MultiKeyMode = "lru"               // 最久未使用
+	MultiKeyModeLowestErrorRate MultiKeyMode = "lowest_error_rate" // 错误率最低
+	MultiKeyModeQuotaAware      MultiKeyMode = "quota_aware"       // 上游剩余额度最多（基于限流响应头）
+	MultiKeyModeStickyUser      MultiKeyMode = "sticky_user"       // 按用户哈希固定密钥
 )
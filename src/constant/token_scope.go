@@ -0,0 +1,34 @@
+package constant
+
+// TokenScope 令牌可调用的接口范围，令牌未设置范围时可调用全部接口
+type TokenScope string
+
+const (
+	TokenScopeModels     TokenScope = "models" // 只读的模型列表与模型详情
+	TokenScopeChat       TokenScope = "chat"
+	TokenScopeResponses  TokenScope = "responses"
+	TokenScopeClaude     TokenScope = "claude"
+	TokenScopeGemini     TokenScope = "gemini"
+	TokenScopeEmbeddings TokenScope = "embeddings"
+	TokenScopeImages     TokenScope = "images"
+	TokenScopeAudio      TokenScope = "audio"
+	TokenScopeRerank     TokenScope = "rerank"
+	TokenScopeRealtime   TokenScope = "realtime"
+	TokenScopeModeration TokenScope = "moderation"
+	TokenScopeFiles      TokenScope = "files" // files、batches 与 fine-tunes
+)
+
+var TokenScopes = []TokenScope{
+	TokenScopeModels,
+	TokenScopeChat,
+	TokenScopeResponses,
+	TokenScopeClaude,
+	TokenScopeGemini,
+	TokenScopeEmbeddings,
+	TokenScopeImages,
+	TokenScopeAudio,
+	TokenScopeRerank,
+	TokenScopeRealtime,
+	TokenScopeModeration,
+	TokenScopeFiles,
+}
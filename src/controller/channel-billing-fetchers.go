@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/shopspring/decimal"
+)
+
+var errNoBalanceCredential = errors.New("未配置余额查询凭证")
+
+// balanceCredential 返回查询余额专用的凭证，支持外部密钥引用
+func balanceCredential(channel *model.Channel) (string, error) {
+	credential := strings.TrimSpace(channel.GetOtherSettings().BalanceCredential)
+	if credential == "" {
+		return "", errNoBalanceCredential
+	}
+	return model.ResolveChannelKey(channel.Id, credential)
+}
+
+func monthStart(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+}
+
+// updateChannelBudgetBalance 没有余额接口的渠道（Gemini、智谱等）按本地记账估算余额：每月预算 - 本月本地消耗。
+// 结果只是估算值，不代表上游真实余额
+func updateChannelBudgetBalance(channel *model.Channel) (float64, error) {
+	budget := channel.GetOtherSettings().BalanceBudget
+	if budget <= 0 {
+		return 0, errors.New("该渠道没有余额查询接口，可设置每月预算按本地消耗估算余额")
+	}
+	quota, err := model.SumChannelQuotaSince(channel.Id, monthStart(time.Now()).Unix())
+	if err != nil {
+		return 0, err
+	}
+	balance := budget - float64(quota)/common.QuotaPerUnit
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
+type AnthropicCostReportResponse struct {
+	Data []struct {
+		Results []struct {
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		} `json:"results"`
+	} `json:"data"`
+	HasMore  bool   `json:"has_more"`
+	NextPage string `json:"next_page"`
+}
+
+// updateChannelAnthropicBalance Anthropic 没有余额接口，通过 Admin API 的成本报表统计本月花费，余额 = 每月预算 - 本月花费
+func updateChannelAnthropicBalance(channel *model.Channel) (float64, error) {
+	budget := channel.GetOtherSettings().BalanceBudget
+	if budget <= 0 {
+		return 0, errors.New("请设置每月预算")
+	}
+	adminKey, err := balanceCredential(channel)
+	if err != nil {
+		return 0, err
+	}
+	query := url.Values{}
+	query.Set("starting_at", monthStart(time.Now().UTC()).Format(time.RFC3339))
+	query.Set("bucket_width", "1d")
+	query.Set("limit", "31")
+	cents := decimal.Zero
+	for {
+		body, err := GetResponseBody("GET", fmt.Sprintf("%s/v1/organizations/cost_report?%s", channel.GetBaseURL(), query.Encode()), channel, GetClaudeAuthHeader(adminKey))
+		if err != nil {
+			return 0, err
+		}
+		response := AnthropicCostReportResponse{}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return 0, err
+		}
+		for _, bucket := range response.Data {
+			for _, result := range bucket.Results {
+				// 金额单位为美分，以十进制字符串表示
+				amount, err := decimal.NewFromString(result.Amount)
+				if err != nil {
+					return 0, fmt.Errorf("invalid cost amount %q: %w", result.Amount, err)
+				}
+				cents = cents.Add(amount)
+			}
+		}
+		if !response.HasMore || response.NextPage == "" {
+			break
+		}
+		query.Set("page", response.NextPage)
+	}
+	balance := budget - cents.Div(decimal.NewFromInt(100)).InexactFloat64()
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
+type VolcEngineBalanceResponse struct {
+	ResponseMetadata struct {
+		Error *struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Error,omitempty"`
+	} `json:"ResponseMetadata"`
+	Result struct {
+		AvailableBalance string `json:"AvailableBalance"`
+	} `json:"Result"`
+}
+
+// updateChannelVolcEngineBalance 通过火山引擎费用中心 QueryBalanceAcct 查询账户可用余额（人民币）
+func updateChannelVolcEngineBalance(channel *model.Channel) (float64, error) {
+	credential, err := balanceCredential(channel)
+	if err != nil {
+		return 0, err
+	}
+	parts := strings.Split(credential, "|")
+	if len(parts) != 2 {
+		return 0, errors.New("火山引擎余额查询凭证格式应为 AK|SK")
+	}
+	query := url.Values{}
+	query.Set("Action", "QueryBalanceAcct")
+	query.Set("Version", "2022-01-01")
+	reqURL := "https://open.volcengineapi.com/?" + query.Encode()
+	headers := signVolcEngineRequest("GET", reqURL, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), "billing", "cn-beijing")
+	body, err := GetResponseBody("GET", reqURL, channel, headers)
+	if err != nil {
+		return 0, err
+	}
+	response := VolcEngineBalanceResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return 0, err
+	}
+	if response.ResponseMetadata.Error != nil {
+		return 0, fmt.Errorf("failed to query volcengine balance: %s %s", response.ResponseMetadata.Error.Code, response.ResponseMetadata.Error.Message)
+	}
+	balanceCny, err := decimal.NewFromString(response.Result.AvailableBalance)
+	if err != nil {
+		return 0, err
+	}
+	balance := balanceCny.Div(decimal.NewFromFloat(operation_setting.Price)).InexactFloat64()
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
+// signVolcEngineRequest 生成火山引擎 OpenAPI 的 HMAC-SHA256 签名请求头（无请求体）
+func signVolcEngineRequest(method, rawURL, accessKey, secretKey, service, region string) http.Header {
+	u, _ := url.Parse(rawURL)
+	t := time.Now().UTC()
+	xDate := t.Format("20060102T150405Z")
+	shortDate := t.Format("20060102")
+	payloadHash := sha256.Sum256(nil)
+	hexPayloadHash := hex.EncodeToString(payloadHash[:])
+
+	canonicalQuery := strings.ReplaceAll(u.Query().Encode(), "+", "%20")
+	signedHeaders := "host;x-content-sha256;x-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-content-sha256:%s\nx-date:%s\n", u.Host, hexPayloadHash, xDate)
+	canonicalRequest := strings.Join([]string{method, "/", canonicalQuery, canonicalHeaders, signedHeaders, hexPayloadHash}, "\n")
+
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	credentialScope := fmt.Sprintf("%s/%s/%s/request", shortDate, region, service)
+	stringToSign := strings.Join([]string{"HMAC-SHA256", xDate, credentialScope, hex.EncodeToString(hashedRequest[:])}, "\n")
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	signingKey := hmacSHA256([]byte(secretKey), shortDate)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	h := http.Header{}
+	h.Set("Host", u.Host)
+	h.Set("X-Date", xDate)
+	h.Set("X-Content-Sha256", hexPayloadHash)
+	h.Set("Authorization", fmt.Sprintf("HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, credentialScope, signedHeaders, signature))
+	return h
+}
@@ -14,7 +14,6 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
-	"github.com/QuantumNous/new-api/types"
 
 	"github.com/shopspring/decimal"
 
@@ -356,39 +355,8 @@ func updateChannelMoonshotBalance(channel *model.Channel) (float64, error) {
 	return availableBalanceUsd, nil
 }
 
-func updateChannelBalance(channel *model.Channel) (float64, error) {
-	baseURL := constant.ChannelBaseURLs[channel.Type]
-	if channel.GetBaseURL() == "" {
-		channel.BaseURL = &baseURL
-	}
-	switch channel.Type {
-	case constant.ChannelTypeOpenAI:
-		if channel.GetBaseURL() != "" {
-			baseURL = channel.GetBaseURL()
-		}
-	case constant.ChannelTypeAzure:
-		return 0, errors.New("尚未实现")
-	case constant.ChannelTypeCustom:
-		baseURL = channel.GetBaseURL()
-	//case common.ChannelTypeOpenAISB:
-	//	return updateChannelOpenAISBBalance(channel)
-	case constant.ChannelTypeAIProxy:
-		return updateChannelAIProxyBalance(channel)
-	case constant.ChannelTypeAPI2GPT:
-		return updateChannelAPI2GPTBalance(channel)
-	case constant.ChannelTypeAIGC2D:
-		return updateChannelAIGC2DBalance(channel)
-	case constant.ChannelTypeSiliconFlow:
-		return updateChannelSiliconFlowBalance(channel)
-	case constant.ChannelTypeDeepSeek:
-		return updateChannelDeepSeekBalance(channel)
-	case constant.ChannelTypeOpenRouter:
-		return updateChannelOpenRouterBalance(channel)
-	case constant.ChannelTypeMoonshot:
-		return updateChannelMoonshotBalance(channel)
-	default:
-		return 0, errors.New("尚未实现")
-	}
+func updateChannelOpenAIBalance(channel *model.Channel) (float64, error) {
+	baseURL := channel.GetBaseURL()
 	url := fmt.Sprintf("%s/v1/dashboard/billing/subscription", baseURL)
 
 	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
@@ -421,6 +389,64 @@ func updateChannelBalance(channel *model.Channel) (float64, error) {
 	return balance, nil
 }
 
+// balanceFetcher 查询渠道余额（美元）并写回渠道
+type balanceFetcher func(channel *model.Channel) (float64, error)
+
+// balanceFetchers 各渠道类型的余额查询实现，新增渠道在此注册即可
+var balanceFetchers = map[int]balanceFetcher{
+	constant.ChannelTypeOpenAI:      updateChannelOpenAIBalance,
+	constant.ChannelTypeCustom:      updateChannelOpenAIBalance,
+	constant.ChannelTypeAIProxy:     updateChannelAIProxyBalance,
+	constant.ChannelTypeAPI2GPT:     updateChannelAPI2GPTBalance,
+	constant.ChannelTypeAIGC2D:      updateChannelAIGC2DBalance,
+	constant.ChannelTypeSiliconFlow: updateChannelSiliconFlowBalance,
+	constant.ChannelTypeDeepSeek:    updateChannelDeepSeekBalance,
+	constant.ChannelTypeOpenRouter:  updateChannelOpenRouterBalance,
+	constant.ChannelTypeMoonshot:    updateChannelMoonshotBalance,
+	constant.ChannelTypeAnthropic:   updateChannelAnthropicBalance,
+	constant.ChannelTypeVolcEngine:  updateChannelVolcEngineBalance,
+}
+
+// updateChannelBalance 查询并处理渠道余额；estimated 表示余额由本地消耗估算而非上游返回
+func updateChannelBalance(channel *model.Channel) (balance float64, estimated bool, err error) {
+	key, err := channel.ResolvedKey()
+	if err != nil {
+		return 0, false, err
+	}
+	if key != channel.Key {
+		// 使用外部密钥引用时以解析后的密钥查询，避免改写缓存中的渠道
+		resolvedChannel := *channel
+		resolvedChannel.Key = key
+		channel = &resolvedChannel
+	}
+	if channel.GetBaseURL() == "" {
+		baseURL := constant.ChannelBaseURLs[channel.Type]
+		channel.BaseURL = &baseURL
+	}
+	hasBudget := channel.GetOtherSettings().BalanceBudget > 0
+	fetcher, ok := balanceFetchers[channel.Type]
+	if ok {
+		balance, err = fetcher(channel)
+		// 未配置余额查询凭证时，设置了预算则退回本地估算
+		if errors.Is(err, errNoBalanceCredential) && hasBudget {
+			ok = false
+		}
+	}
+	if !ok {
+		if !hasBudget {
+			return 0, false, errors.New("尚未实现")
+		}
+		// 没有余额接口的渠道，设置了预算时按预算减去本月本地消耗估算
+		balance, err = updateChannelBudgetBalance(channel)
+		estimated = true
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	service.HandleChannelBalance(channel.Id, balance, estimated)
+	return balance, estimated, nil
+}
+
 func UpdateChannelBalance(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -439,15 +465,16 @@ func UpdateChannelBalance(c *gin.Context) {
 		})
 		return
 	}
-	balance, err := updateChannelBalance(channel)
+	balance, estimated, err := updateChannelBalance(channel)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
-		"balance": balance,
+		"success":           true,
+		"message":           "",
+		"balance":           balance,
+		"balance_estimated": estimated,
 	})
 }
 
@@ -457,7 +484,8 @@ func updateAllChannelsBalance() error {
 		return err
 	}
 	for _, channel := range channels {
-		if channel.Status != common.ChannelStatusEnabled {
+		// 因余额不足被自动禁用的渠道继续查询，充值后自动恢复
+		if channel.Status != common.ChannelStatusEnabled && !service.IsChannelDisabledByBalance(channel) {
 			continue
 		}
 		if channel.ChannelInfo.IsMultiKey {
@@ -467,15 +495,8 @@ func updateAllChannelsBalance() error {
 		//if channel.Type != common.ChannelTypeOpenAI && channel.Type != common.ChannelTypeCustom {
 		//	continue
 		//}
-		balance, err := updateChannelBalance(channel)
-		if err != nil {
-			continue
-		} else {
-			// err is nil & balance <= 0 means quota is used up
-			if balance <= 0 {
-				service.DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, "", channel.GetAutoBan()), "余额不足")
-			}
-		}
+		// 余额告警、降级与禁用在 updateChannelBalance 中统一处理
+		_, _, _ = updateChannelBalance(channel)
 		time.Sleep(common.RequestInterval)
 	}
 	return nil
@@ -500,6 +521,54 @@ func AutomaticallyUpdateChannels(frequency int) {
 		time.Sleep(time.Duration(frequency) * time.Minute)
 		common.SysLog("updating all channels")
 		_ = updateAllChannelsBalance()
+		cleanupChannelBalanceHistory()
 		common.SysLog("channels update done")
 	}
 }
+
+func cleanupChannelBalanceHistory() {
+	days := operation_setting.GetChannelBalanceSetting().HistoryRetentionDays
+	if days <= 0 || !common.IsMasterNode {
+		return
+	}
+	deleted, err := model.DeleteChannelBalanceHistoryBefore(time.Now().AddDate(0, 0, -days).Unix())
+	if err != nil {
+		common.SysError("failed to clean up channel balance history: " + err.Error())
+	} else if deleted > 0 {
+		common.SysLog(fmt.Sprintf("cleaned up %d channel balance history records", deleted))
+	}
+}
+
+// GetChannelBalanceHistory 返回渠道余额历史及按近期消耗速度估算的耗尽时间
+func GetChannelBalanceHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	hours, _ := strconv.Atoi(c.DefaultQuery("hours", "168"))
+	if hours <= 0 {
+		hours = 168
+	}
+	channel, err := model.GetChannelById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	history, err := model.GetChannelBalanceHistory(id, time.Now().Add(-time.Duration(hours)*time.Hour).Unix())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	rate, err := service.GetChannelBalanceBurnRate(id, channel.Balance)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"balance":            channel.Balance,
+		"balance_updated_at": channel.BalanceUpdatedTime,
+		"history":            history,
+		"burn_rate":          rate,
+	})
+}
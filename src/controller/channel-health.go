@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func parseChannelHealthQuery(c *gin.Context) service.ChannelHealthQuery {
+	query := service.ChannelHealthQuery{
+		ModelName: c.Query("model"),
+	}
+	query.Hours, _ = strconv.Atoi(c.Query("hours"))
+	if keyIndex, err := strconv.Atoi(c.Query("key_index")); err == nil {
+		query.KeyIndex = &keyIndex
+	}
+	return query
+}
+
+// GetChannelHealth 返回单个渠道的健康度统计
+func GetChannelHealth(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	report, err := service.GetChannelHealthReport(id, parseChannelHealthQuery(c))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
+
+// GetChannelHealthSummary 返回所有渠道的健康度汇总
+func GetChannelHealthSummary(c *gin.Context) {
+	summary, err := service.GetChannelHealthFleetSummary(parseChannelHealthQuery(c))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, summary)
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+type keyProbeKey struct {
+	channelId int
+	keyIndex  int
+}
+
+type keyProbeBackoff struct {
+	failures  int
+	nextProbe time.Time
+}
+
+type keyProbeOutcome struct {
+	channelName string
+	channelId   int
+	keyIndex    int
+	reason      string
+}
+
+type keyProbeSummary struct {
+	probed    int
+	recovered []keyProbeOutcome
+	permanent []keyProbeOutcome
+	transient []keyProbeOutcome
+}
+
+var keyProbeBackoffs sync.Map // map[keyProbeKey]*keyProbeBackoff
+
+var probeDisabledKeysLock sync.Mutex
+var probeDisabledKeysRunning bool
+
+// isPermanentKeyError 判断密钥探测失败是否为永久性错误（密钥无效、账号停用等），此类密钥再次探测也不会恢复
+func isPermanentKeyError(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	if err.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	oaiErr := err.ToOpenAIError()
+	switch oaiErr.Code {
+	case "invalid_api_key", "account_deactivated":
+		return true
+	}
+	if oaiErr.Type == "authentication_error" {
+		return true
+	}
+	lowerMessage := strings.ToLower(err.Error())
+	for _, keyword := range []string{"invalid api key", "incorrect api key", "api key not valid", "invalid x-api-key", "api key expired"} {
+		if strings.Contains(lowerMessage, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func probeDisabledKeys() (*keyProbeSummary, error) {
+	probeDisabledKeysLock.Lock()
+	if probeDisabledKeysRunning {
+		probeDisabledKeysLock.Unlock()
+		return nil, errors.New("密钥探测已在运行中")
+	}
+	probeDisabledKeysRunning = true
+	probeDisabledKeysLock.Unlock()
+	defer func() {
+		probeDisabledKeysLock.Lock()
+		probeDisabledKeysRunning = false
+		probeDisabledKeysLock.Unlock()
+	}()
+
+	setting := operation_setting.GetMultiKeyProbeSetting()
+	interval := time.Duration(setting.IntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 30 * time.Minute
+	}
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &keyProbeSummary{}
+	now := time.Now()
+	for _, channel := range channels {
+		if !channel.ChannelInfo.IsMultiKey || len(channel.ChannelInfo.MultiKeyStatusList) == 0 {
+			continue
+		}
+		keys := channel.GetKeys()
+		for idx, status := range channel.ChannelInfo.MultiKeyStatusList {
+			if status != common.ChannelStatusAutoDisabled || idx < 0 || idx >= len(keys) {
+				continue
+			}
+			if setting.MaxProbesPerRun > 0 && summary.probed >= setting.MaxProbesPerRun {
+				break
+			}
+			probeKey := keyProbeKey{channelId: channel.Id, keyIndex: idx}
+			if v, ok := keyProbeBackoffs.Load(probeKey); ok && now.Before(v.(*keyProbeBackoff).nextProbe) {
+				continue
+			}
+
+			// 以单密钥渠道的形式测试该密钥
+			probeChannel := *channel
+			probeChannel.Key = keys[idx]
+			probeChannel.Keys = nil
+			probeChannel.ChannelInfo = model.ChannelInfo{}
+			result := testChannel(&probeChannel, "", "")
+			summary.probed++
+
+			outcome := keyProbeOutcome{channelName: channel.Name, channelId: channel.Id, keyIndex: idx}
+			if result.localErr == nil && result.newAPIError == nil {
+				keyProbeBackoffs.Delete(probeKey)
+				if err := model.UpdateMultiKeyStatus(channel.Id, idx, common.ChannelStatusEnabled, ""); err != nil {
+					common.SysError(fmt.Sprintf("failed to re-enable key: channel_id=%d, key_index=%d, error=%v", channel.Id, idx, err))
+					continue
+				}
+				summary.recovered = append(summary.recovered, outcome)
+				time.Sleep(common.RequestInterval)
+				continue
+			}
+
+			if result.newAPIError != nil {
+				outcome.reason = result.newAPIError.Error()
+			} else {
+				outcome.reason = result.localErr.Error()
+			}
+			if setting.EscalatePermanent && isPermanentKeyError(result.newAPIError) {
+				keyProbeBackoffs.Delete(probeKey)
+				reason := "[permanent] " + outcome.reason
+				if err := model.UpdateMultiKeyStatus(channel.Id, idx, common.ChannelStatusManuallyDisabled, reason); err != nil {
+					common.SysError(fmt.Sprintf("failed to escalate key: channel_id=%d, key_index=%d, error=%v", channel.Id, idx, err))
+				}
+				summary.permanent = append(summary.permanent, outcome)
+			} else {
+				backoff := &keyProbeBackoff{failures: 1}
+				if v, ok := keyProbeBackoffs.Load(probeKey); ok {
+					backoff.failures = v.(*keyProbeBackoff).failures + 1
+				}
+				// 连续失败时按指数退避，最长 32 个探测周期
+				shift := backoff.failures - 1
+				if shift > 5 {
+					shift = 5
+				}
+				backoff.nextProbe = now.Add(interval * time.Duration(1<<shift))
+				keyProbeBackoffs.Store(probeKey, backoff)
+				summary.transient = append(summary.transient, outcome)
+			}
+			time.Sleep(common.RequestInterval)
+		}
+	}
+
+	if common.MemoryCacheEnabled && (len(summary.recovered) > 0 || len(summary.permanent) > 0) {
+		model.InitChannelCache()
+	}
+	return summary, nil
+}
+
+func notifyKeyProbeSummary(summary *keyProbeSummary) {
+	if summary == nil || summary.probed == 0 {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("本次共探测 %d 个自动禁用的密钥：恢复 %d 个，永久失效 %d 个，仍不可用 %d 个。",
+		summary.probed, len(summary.recovered), len(summary.permanent), len(summary.transient)))
+	writeOutcomes := func(title string, outcomes []keyProbeOutcome) {
+		if len(outcomes) == 0 {
+			return
+		}
+		b.WriteString("<br/><br/>" + title + "：")
+		for _, o := range outcomes {
+			b.WriteString(fmt.Sprintf("<br/>通道「%s」（#%d）密钥 #%d", o.channelName, o.channelId, o.keyIndex))
+			if o.reason != "" {
+				b.WriteString("：" + o.reason)
+			}
+		}
+	}
+	writeOutcomes("已恢复", summary.recovered)
+	writeOutcomes("永久失效（已转为手动禁用，请更换密钥）", summary.permanent)
+	service.NotifyRootUser(dto.NotifyTypeChannelUpdate, "多密钥探测完成", b.String())
+}
+
+// ProbeDisabledKeys 手动触发一次自动禁用密钥的探测
+func ProbeDisabledKeys(c *gin.Context) {
+	probeDisabledKeysLock.Lock()
+	running := probeDisabledKeysRunning
+	probeDisabledKeysLock.Unlock()
+	if running {
+		common.ApiError(c, errors.New("密钥探测已在运行中"))
+		return
+	}
+	gopool.Go(func() {
+		summary, err := probeDisabledKeys()
+		if err != nil {
+			common.SysError("probe disabled keys failed: " + err.Error())
+			return
+		}
+		notifyKeyProbeSummary(summary)
+	})
+	common.ApiSuccess(c, nil)
+}
+
+var autoProbeDisabledKeysOnce sync.Once
+
+func AutomaticallyProbeDisabledKeys() {
+	// 只在Master节点定时探测
+	if !common.IsMasterNode {
+		return
+	}
+	autoProbeDisabledKeysOnce.Do(func() {
+		for {
+			setting := operation_setting.GetMultiKeyProbeSetting()
+			interval := setting.IntervalMinutes
+			if interval <= 0 {
+				interval = 30
+			}
+			time.Sleep(time.Duration(interval) * time.Minute)
+			if !operation_setting.GetMultiKeyProbeSetting().Enabled {
+				continue
+			}
+			common.SysLog("automatically probing auto-disabled multi-keys")
+			summary, err := probeDisabledKeys()
+			if err != nil {
+				common.SysError("probe disabled keys failed: " + err.Error())
+				continue
+			}
+			common.SysLog(fmt.Sprintf("multi-key probe finished: probed=%d, recovered=%d, permanent=%d, transient=%d",
+				summary.probed, len(summary.recovered), len(summary.permanent), len(summary.transient)))
+			if operation_setting.GetMultiKeyProbeSetting().NotifySummary {
+				notifyKeyProbeSummary(summary)
+			}
+		}
+	})
+}
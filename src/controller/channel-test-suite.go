@@ -0,0 +1,435 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+type channelTestCaseResult struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Model     string `json:"model"`
+	Success   bool   `json:"success"`
+	LatencyMs int64  `json:"latency_ms"`
+	Message   string `json:"message,omitempty"`
+}
+
+func testCasePath(caseType dto.ChannelTestCaseType) string {
+	switch caseType {
+	case dto.ChannelTestCaseEmbeddings:
+		return "/v1/embeddings"
+	case dto.ChannelTestCaseRerank:
+		return "/v1/rerank"
+	case dto.ChannelTestCaseImage:
+		return "/v1/images/generations"
+	case dto.ChannelTestCaseAudio:
+		return "/v1/audio/speech"
+	case dto.ChannelTestCaseResponses:
+		return "/v1/responses"
+	}
+	return "/v1/chat/completions"
+}
+
+func testCaseRelayFormat(caseType dto.ChannelTestCaseType) types.RelayFormat {
+	switch caseType {
+	case dto.ChannelTestCaseEmbeddings:
+		return types.RelayFormatEmbedding
+	case dto.ChannelTestCaseRerank:
+		return types.RelayFormatRerank
+	case dto.ChannelTestCaseImage:
+		return types.RelayFormatOpenAIImage
+	case dto.ChannelTestCaseAudio:
+		return types.RelayFormatOpenAIAudio
+	case dto.ChannelTestCaseResponses:
+		return types.RelayFormatOpenAIResponses
+	}
+	return types.RelayFormatOpenAI
+}
+
+func buildTestCaseRequest(model string, caseType dto.ChannelTestCaseType) dto.Request {
+	switch caseType {
+	case dto.ChannelTestCaseStream:
+		request := buildChatTestRequest(model)
+		request.Stream = true
+		request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+		return request
+	case dto.ChannelTestCaseToolCall:
+		request := buildChatTestRequest(model)
+		request.Messages = []dto.Message{
+			{
+				Role:    "user",
+				Content: "What is the weather like in Paris today?",
+			},
+		}
+		request.Tools = []dto.ToolCallRequest{
+			{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        "get_weather",
+					Description: "Get the current weather of a city",
+					Parameters: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"city": map[string]any{"type": "string"},
+						},
+						"required": []string{"city"},
+					},
+				},
+			},
+		}
+		request.ToolChoice = "required"
+		// 工具调用参数需要更多输出 token
+		if request.MaxCompletionTokens > 0 {
+			request.MaxCompletionTokens = 256
+		} else if request.MaxTokens > 0 && request.MaxTokens < 256 {
+			request.MaxTokens = 256
+		}
+		return request
+	case dto.ChannelTestCaseEmbeddings:
+		return &dto.EmbeddingRequest{
+			Model: model,
+			Input: []any{"hello world"},
+		}
+	case dto.ChannelTestCaseRerank:
+		return &dto.RerankRequest{
+			Model:     model,
+			Query:     "What is Deep Learning?",
+			Documents: []any{"Deep Learning is a subset of machine learning.", "Machine learning is a field of artificial intelligence."},
+			TopN:      2,
+		}
+	case dto.ChannelTestCaseImage:
+		return &dto.ImageRequest{
+			Model:  model,
+			Prompt: "a cute cat",
+			N:      1,
+			Size:   "1024x1024",
+		}
+	case dto.ChannelTestCaseAudio:
+		return &dto.AudioRequest{
+			Model: model,
+			Input: "hello",
+			Voice: "alloy",
+		}
+	case dto.ChannelTestCaseResponses:
+		return &dto.OpenAIResponsesRequest{
+			Model: model,
+			Input: json.RawMessage(`[{"role":"user","content":"hi"}]`),
+		}
+	}
+	return buildChatTestRequest(model)
+}
+
+func validateChannelTestSuite(suite []dto.ChannelTestCase) error {
+	names := make(map[string]bool, len(suite))
+	for _, testCase := range suite {
+		switch testCase.Type {
+		case dto.ChannelTestCaseChat, dto.ChannelTestCaseStream, dto.ChannelTestCaseToolCall,
+			dto.ChannelTestCaseEmbeddings, dto.ChannelTestCaseRerank, dto.ChannelTestCaseImage,
+			dto.ChannelTestCaseAudio, dto.ChannelTestCaseResponses:
+		default:
+			return fmt.Errorf("不支持的测试用例类型: %s", testCase.Type)
+		}
+		if testCase.MaxLatencyMs < 0 {
+			return fmt.Errorf("测试用例 %s 的延迟阈值不能为负数", testCase.DisplayName())
+		}
+		name := testCase.DisplayName()
+		if names[name] {
+			return fmt.Errorf("测试用例名称重复: %s", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// parseTestStream 解析 SSE 响应，返回拼接后的文本与数据块数量
+func parseTestStream(body []byte) (string, int) {
+	var text strings.Builder
+	chunks := 0
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" || data == "[DONE]" || !gjson.Valid(data) {
+			continue
+		}
+		chunks++
+		text.WriteString(gjson.Get(data, "choices.0.delta.content").String())
+		text.WriteString(gjson.Get(data, "choices.0.delta.reasoning_content").String())
+	}
+	return text.String(), chunks
+}
+
+// validateTestCaseResponse 校验测试用例的响应结构与延迟
+func validateTestCaseResponse(testCase *dto.ChannelTestCase, body []byte, latencyMs int64) error {
+	if testCase == nil {
+		return nil
+	}
+	if testCase.MaxLatencyMs > 0 && latencyMs > testCase.MaxLatencyMs {
+		return fmt.Errorf("响应时间 %dms 超过阈值 %dms", latencyMs, testCase.MaxLatencyMs)
+	}
+	var text string
+	switch testCase.Type {
+	case dto.ChannelTestCaseStream:
+		var chunks int
+		text, chunks = parseTestStream(body)
+		if chunks == 0 {
+			return errors.New("流式响应未包含任何数据块")
+		}
+	case dto.ChannelTestCaseToolCall:
+		if len(gjson.GetBytes(body, "choices.0.message.tool_calls").Array()) == 0 {
+			return errors.New("响应未包含 tool_calls")
+		}
+	case dto.ChannelTestCaseEmbeddings:
+		if len(gjson.GetBytes(body, "data.0.embedding").Array()) == 0 {
+			return errors.New("响应未包含 embedding 向量")
+		}
+	case dto.ChannelTestCaseRerank:
+		if len(gjson.GetBytes(body, "results").Array()) == 0 {
+			return errors.New("响应未包含 rerank 结果")
+		}
+	case dto.ChannelTestCaseImage:
+		if gjson.GetBytes(body, "data.0.url").String() == "" && gjson.GetBytes(body, "data.0.b64_json").String() == "" {
+			return errors.New("响应未包含图片 url 或 b64_json")
+		}
+	case dto.ChannelTestCaseAudio:
+		if len(body) == 0 {
+			return errors.New("音频响应为空")
+		}
+		if gjson.ValidBytes(body) && gjson.GetBytes(body, "error").Exists() {
+			return errors.New("音频响应包含错误信息")
+		}
+	case dto.ChannelTestCaseResponses:
+		output := gjson.GetBytes(body, "output").Array()
+		if len(output) == 0 {
+			return errors.New("响应未包含 output")
+		}
+		var b strings.Builder
+		for _, item := range output {
+			for _, content := range item.Get("content").Array() {
+				b.WriteString(content.Get("text").String())
+			}
+		}
+		text = b.String()
+	default:
+		message := gjson.GetBytes(body, "choices.0.message")
+		if !message.Exists() {
+			return errors.New("响应未包含 choices[0].message")
+		}
+		text = message.Get("content").String()
+		if text == "" && message.Get("reasoning_content").String() == "" {
+			return errors.New("响应内容为空")
+		}
+	}
+	if testCase.ExpectContains != "" && !strings.Contains(text, testCase.ExpectContains) {
+		return fmt.Errorf("响应内容不包含 %q", testCase.ExpectContains)
+	}
+	return nil
+}
+
+func recordChannelTestResult(channelId int, testCase *dto.ChannelTestCase, result testResult, latencyMs int64, assertErr error, source string) {
+	record := &model.ChannelTestResult{
+		ChannelId: channelId,
+		ModelName: result.modelName,
+		Source:    source,
+		LatencyMs: latencyMs,
+		Success:   true,
+	}
+	if testCase != nil {
+		record.CaseName = testCase.DisplayName()
+		record.CaseType = string(testCase.Type)
+	}
+	if result.usage != nil {
+		record.PromptTokens = result.usage.PromptTokens
+		record.CompletionTokens = result.usage.CompletionTokens
+	}
+	switch {
+	case result.newAPIError != nil:
+		record.Success = false
+		record.StatusCode = result.newAPIError.StatusCode
+		record.ErrorMessage = result.newAPIError.Error()
+	case result.localErr != nil:
+		record.Success = false
+		record.ErrorMessage = result.localErr.Error()
+	case assertErr != nil:
+		record.Success = false
+		record.ErrorMessage = assertErr.Error()
+	}
+	if err := record.Insert(); err != nil {
+		common.SysError(fmt.Sprintf("failed to record channel test result: channel_id=%d, error=%v", channelId, err))
+	}
+}
+
+// runChannelTestSuite 执行渠道测试：配置了测试用例时依次执行全部用例，否则执行默认测试。
+// 返回各用例结果，以及首个用例的测试结果与耗时，供自动禁用/启用判断使用（响应结构校验失败不会触发自动禁用）
+func runChannelTestSuite(channel *model.Channel, source string) ([]channelTestCaseResult, testResult, int64) {
+	suite := channel.GetOtherSettings().TestSuite
+	if len(suite) == 0 {
+		suite = []dto.ChannelTestCase{{}}
+	}
+	caseResults := make([]channelTestCaseResult, 0, len(suite))
+	var primary testResult
+	var primaryLatency int64
+	for i := range suite {
+		var testCase *dto.ChannelTestCase
+		if suite[i].Type != "" {
+			testCase = &suite[i]
+		}
+		tik := time.Now()
+		result := testChannelCase(channel, "", "", testCase)
+		latency := time.Since(tik).Milliseconds()
+		var assertErr error
+		if result.localErr == nil && result.newAPIError == nil {
+			assertErr = validateTestCaseResponse(testCase, result.respBody, latency)
+		}
+		recordChannelTestResult(channel.Id, testCase, result, latency, assertErr, source)
+		if i == 0 {
+			primary, primaryLatency = result, latency
+		}
+
+		caseResult := channelTestCaseResult{
+			Name:      "default",
+			Model:     result.modelName,
+			Success:   true,
+			LatencyMs: latency,
+		}
+		if testCase != nil {
+			caseResult.Name = testCase.DisplayName()
+			caseResult.Type = string(testCase.Type)
+		}
+		switch {
+		case result.newAPIError != nil:
+			caseResult.Success, caseResult.Message = false, result.newAPIError.Error()
+		case result.localErr != nil:
+			caseResult.Success, caseResult.Message = false, result.localErr.Error()
+		case assertErr != nil:
+			caseResult.Success, caseResult.Message = false, assertErr.Error()
+		}
+		caseResults = append(caseResults, caseResult)
+		if i < len(suite)-1 {
+			time.Sleep(common.RequestInterval)
+		}
+	}
+	return caseResults, primary, primaryLatency
+}
+
+// RunChannelTestSuite 执行渠道配置的全部测试用例
+func RunChannelTestSuite(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		channel, err = model.GetChannelById(channelId, true)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	results, _, primaryLatency := runChannelTestSuite(channel, model.ChannelTestSourceSuite)
+	go channel.UpdateResponseTime(primaryLatency)
+	success := true
+	for _, result := range results {
+		success = success && result.Success
+	}
+	common.ApiSuccess(c, gin.H{
+		"success": success,
+		"cases":   results,
+	})
+}
+
+type channelTestTrendPoint struct {
+	Timestamp    int64   `json:"timestamp"`
+	CaseName     string  `json:"case_name"`
+	Total        int     `json:"total"`
+	Success      int     `json:"success"`
+	SuccessRate  float64 `json:"success_rate"`
+	AvgLatencyMs int64   `json:"avg_latency_ms"`
+	MaxLatencyMs int64   `json:"max_latency_ms"`
+}
+
+// GetChannelTestResults 返回渠道测试历史记录与按时间聚合的趋势
+func GetChannelTestResults(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	hours, _ := strconv.Atoi(c.Query("hours"))
+	if hours <= 0 {
+		hours = 24 * 7
+	}
+	caseName := c.Query("case")
+	startTime := common.GetTimestamp() - int64(hours)*3600
+
+	pageInfo := common.GetPageQuery(c)
+	items, total, err := model.GetChannelTestResults(channelId, startTime, caseName, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	records, err := model.GetChannelTestResultsForTrend(channelId, startTime)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	// 48 小时以内按小时聚合，否则按天聚合
+	bucketSeconds := int64(3600)
+	if hours > 48 {
+		bucketSeconds = 86400
+	}
+	points := make([]*channelTestTrendPoint, 0)
+	index := make(map[string]*channelTestTrendPoint)
+	latencySum := make(map[*channelTestTrendPoint]int64)
+	for _, record := range records {
+		if caseName != "" && record.CaseName != caseName {
+			continue
+		}
+		bucket := record.CreatedAt - record.CreatedAt%bucketSeconds
+		key := strconv.FormatInt(bucket, 10) + "\x00" + record.CaseName
+		point, ok := index[key]
+		if !ok {
+			point = &channelTestTrendPoint{Timestamp: bucket, CaseName: record.CaseName}
+			index[key] = point
+			points = append(points, point)
+		}
+		point.Total++
+		if record.Success {
+			point.Success++
+			latencySum[point] += record.LatencyMs
+			if record.LatencyMs > point.MaxLatencyMs {
+				point.MaxLatencyMs = record.LatencyMs
+			}
+		}
+	}
+	for _, point := range points {
+		point.SuccessRate = float64(point.Success) / float64(point.Total)
+		if point.Success > 0 {
+			point.AvgLatencyMs = latencySum[point] / int64(point.Success)
+		}
+	}
+
+	common.ApiSuccess(c, gin.H{
+		"items":     items,
+		"total":     total,
+		"page":      pageInfo.GetPage(),
+		"page_size": pageInfo.GetPageSize(),
+		"trend":     points,
+	})
+}
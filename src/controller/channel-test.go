@@ -39,10 +39,22 @@ type testResult struct {
 	context     *gin.Context
 	localErr    error
 	newAPIError *types.NewAPIError
+	modelName   string
+	respBody    []byte
+	usage       *dto.Usage
 }
 
 func testChannel(channel *model.Channel, testModel string, endpointType string) testResult {
+	return testChannelCase(channel, testModel, endpointType, nil)
+}
+
+// testChannelCase 测试渠道，testCase 不为空时按测试用例类型构建请求（此时忽略 endpointType）
+func testChannelCase(channel *model.Channel, testModel string, endpointType string, testCase *dto.ChannelTestCase) (result testResult) {
 	tik := time.Now()
+	var requestedModel string
+	defer func() {
+		result.modelName = requestedModel
+	}()
 	var unsupportedTestChannelTypes = []int{
 		constant.ChannelTypeMidjourney,
 		constant.ChannelTypeMidjourneyPlus,
@@ -62,6 +74,9 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 	c, _ := gin.CreateTestContext(w)
 
 	testModel = strings.TrimSpace(testModel)
+	if testCase != nil && strings.TrimSpace(testCase.Model) != "" {
+		testModel = strings.TrimSpace(testCase.Model)
+	}
 	if testModel == "" {
 		if channel.TestModel != nil && *channel.TestModel != "" {
 			testModel = strings.TrimSpace(*channel.TestModel)
@@ -76,10 +91,13 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		}
 	}
 
+	requestedModel = testModel
 	requestPath := "/v1/chat/completions"
 
-	// 如果指定了端点类型，使用指定的端点类型
-	if endpointType != "" {
+	if testCase != nil {
+		requestPath = testCasePath(testCase.Type)
+	} else if endpointType != "" {
+		// 如果指定了端点类型，使用指定的端点类型
 		if endpointInfo, ok := common.GetDefaultEndpointInfo(constant.EndpointType(endpointType)); ok {
 			requestPath = endpointInfo.Path
 		}
@@ -152,7 +170,9 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 
 	// Determine relay format based on endpoint type or request path
 	var relayFormat types.RelayFormat
-	if endpointType != "" {
+	if testCase != nil {
+		relayFormat = testCaseRelayFormat(testCase.Type)
+	} else if endpointType != "" {
 		// 根据指定的端点类型设置 relayFormat
 		switch constant.EndpointType(endpointType) {
 		case constant.EndpointTypeOpenAI:
@@ -200,7 +220,12 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		}
 	}
 
-	request := buildTestRequest(testModel, endpointType, channel)
+	var request dto.Request
+	if testCase != nil {
+		request = buildTestCaseRequest(testModel, testCase.Type)
+	} else {
+		request = buildTestRequest(testModel, endpointType, channel)
+	}
 
 	info, err := relaycommon.GenRelayInfo(c, relayFormat, request, nil)
 
@@ -288,6 +313,17 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 				newAPIError: types.NewError(errors.New("invalid image request type"), types.ErrorCodeConvertRequestFailed),
 			}
 		}
+	case relayconstant.RelayModeAudioSpeech:
+		// 语音合成请求 - 转换结果为请求体
+		if audioReq, ok := request.(*dto.AudioRequest); ok {
+			convertedRequest, err = adaptor.ConvertAudioRequest(c, info, *audioReq)
+		} else {
+			return testResult{
+				context:     c,
+				localErr:    errors.New("invalid audio request type"),
+				newAPIError: types.NewError(errors.New("invalid audio request type"), types.ErrorCodeConvertRequestFailed),
+			}
+		}
 	case relayconstant.RelayModeRerank:
 		// Rerank 请求 - request 已经是正确的类型
 		if rerankReq, ok := request.(*dto.RerankRequest); ok {
@@ -349,7 +385,12 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 			newAPIError: types.NewError(err, types.ErrorCodeConvertRequestFailed),
 		}
 	}
-	jsonData, err := json.Marshal(convertedRequest)
+	var jsonData []byte
+	if reader, ok := convertedRequest.(io.Reader); ok {
+		jsonData, err = io.ReadAll(reader)
+	} else {
+		jsonData, err = json.Marshal(convertedRequest)
+	}
 	if err != nil {
 		return testResult{
 			context:     c,
@@ -426,8 +467,7 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		}
 	}
 	usage := usageA.(*dto.Usage)
-	result := w.Result()
-	respBody, err := io.ReadAll(result.Body)
+	respBody, err := io.ReadAll(w.Result().Body)
 	if err != nil {
 		return testResult{
 			context:     c,
@@ -460,11 +500,17 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 		Group:            info.UsingGroup,
 		Other:            other,
 	})
-	common.SysLog(fmt.Sprintf("testing channel #%d, response: \n%s", channel.Id, string(respBody)))
+	if info.RelayMode == relayconstant.RelayModeAudioSpeech {
+		common.SysLog(fmt.Sprintf("testing channel #%d, response: %d bytes of audio", channel.Id, len(respBody)))
+	} else {
+		common.SysLog(fmt.Sprintf("testing channel #%d, response: \n%s", channel.Id, string(respBody)))
+	}
 	return testResult{
 		context:     c,
 		localErr:    nil,
 		newAPIError: nil,
+		respBody:    respBody,
+		usage:       usage,
 	}
 }
 
@@ -566,6 +612,10 @@ func buildTestRequest(model string, endpointType string, channel *model.Channel)
 	}
 
 	// Chat/Completion 请求 - 返回 GeneralOpenAIRequest
+	return buildChatTestRequest(model)
+}
+
+func buildChatTestRequest(model string) *dto.GeneralOpenAIRequest {
 	testRequest := &dto.GeneralOpenAIRequest{
 		Model:  model,
 		Stream: false,
@@ -615,6 +665,11 @@ func TestChannel(c *gin.Context) {
 	endpointType := c.Query("endpoint_type")
 	tik := time.Now()
 	result := testChannel(channel, testModel, endpointType)
+	var testCase *dto.ChannelTestCase
+	if endpointType != "" {
+		testCase = &dto.ChannelTestCase{Name: endpointType}
+	}
+	recordChannelTestResult(channel.Id, testCase, result, time.Since(tik).Milliseconds(), nil, model.ChannelTestSourceManual)
 	if result.localErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -646,7 +701,15 @@ var testAllChannelsLock sync.Mutex
 var testAllChannelsRunning bool = false
 
 func testAllChannels(notify bool) error {
+	channels, getChannelErr := model.GetAllChannels(0, 0, true, false)
+	if getChannelErr != nil {
+		return getChannelErr
+	}
+	return testChannels(channels, notify, model.ChannelTestSourceAll)
+}
 
+// testChannels 依次测试指定渠道（配置了测试用例的渠道执行全部用例），并根据测试结果自动禁用或启用渠道
+func testChannels(channels []*model.Channel, notify bool, source string) error {
 	testAllChannelsLock.Lock()
 	if testAllChannelsRunning {
 		testAllChannelsLock.Unlock()
@@ -654,10 +717,6 @@ func testAllChannels(notify bool) error {
 	}
 	testAllChannelsRunning = true
 	testAllChannelsLock.Unlock()
-	channels, getChannelErr := model.GetAllChannels(0, 0, true, false)
-	if getChannelErr != nil {
-		return getChannelErr
-	}
 	var disableThreshold = int64(common.ChannelDisableThreshold * 1000)
 	if disableThreshold == 0 {
 		disableThreshold = 10000000 // a impossible value
@@ -672,10 +731,7 @@ func testAllChannels(notify bool) error {
 
 		for _, channel := range channels {
 			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
-			tik := time.Now()
-			result := testChannel(channel, "", "")
-			tok := time.Now()
-			milliseconds := tok.Sub(tik).Milliseconds()
+			_, result, milliseconds := runChannelTestSuite(channel, source)
 
 			shouldBanChannel := false
 			newAPIError := result.newAPIError
@@ -728,27 +784,85 @@ func TestAllChannels(c *gin.Context) {
 
 var autoTestChannelsOnce sync.Once
 
+// dueChannelsForTest 返回需要自动测试的渠道：设置了独立测试间隔的渠道按各自间隔测试，其余渠道在全局测试周期到达时测试
+func dueChannelsForTest(channels []*model.Channel, globalDue bool) []*model.Channel {
+	now := common.GetTimestamp()
+	due := make([]*model.Channel, 0)
+	for _, channel := range channels {
+		interval := channel.GetOtherSettings().TestIntervalMinutes
+		switch {
+		case interval > 0:
+			if now-channel.TestTime >= int64(interval)*60 {
+				due = append(due, channel)
+			}
+		case interval == 0:
+			if globalDue {
+				due = append(due, channel)
+			}
+		}
+	}
+	return due
+}
+
+func cleanupChannelTestResults() {
+	days := operation_setting.GetMonitorSetting().TestResultRetentionDays
+	if days <= 0 {
+		return
+	}
+	deleted, err := model.DeleteChannelTestResultsBefore(time.Now().AddDate(0, 0, -days).Unix())
+	if err != nil {
+		common.SysError("failed to cleanup channel test results: " + err.Error())
+	} else if deleted > 0 {
+		common.SysLog(fmt.Sprintf("cleaned up %d channel test results", deleted))
+	}
+}
+
 func AutomaticallyTestChannels() {
 	// 只在Master节点定时测试渠道
 	if !common.IsMasterNode {
 		return
 	}
 	autoTestChannelsOnce.Do(func() {
+		lastGlobalTest := time.Now()
+		lastCleanup := time.Time{}
 		for {
-			if !operation_setting.GetMonitorSetting().AutoTestChannelEnabled {
-				time.Sleep(1 * time.Minute)
+			time.Sleep(1 * time.Minute)
+			if time.Since(lastCleanup) >= time.Hour {
+				lastCleanup = time.Now()
+				cleanupChannelTestResults()
+			}
+
+			monitorSetting := operation_setting.GetMonitorSetting()
+			frequency := monitorSetting.AutoTestChannelMinutes
+			globalDue := false
+			if !monitorSetting.AutoTestChannelEnabled {
+				lastGlobalTest = time.Now()
+			} else if time.Since(lastGlobalTest) >= time.Duration(int(math.Round(frequency)))*time.Minute {
+				globalDue = true
+			}
+
+			channels, err := model.GetAllChannels(0, 0, true, false)
+			if err != nil {
+				common.SysError("failed to get channels for automatic test: " + err.Error())
 				continue
 			}
-			for {
-				frequency := operation_setting.GetMonitorSetting().AutoTestChannelMinutes
-				time.Sleep(time.Duration(int(math.Round(frequency))) * time.Minute)
-				common.SysLog(fmt.Sprintf("automatically test channels with interval %f minutes", frequency))
-				common.SysLog("automatically testing all channels")
-				_ = testAllChannels(false)
-				common.SysLog("automatically channel test finished")
-				if !operation_setting.GetMonitorSetting().AutoTestChannelEnabled {
-					break
+			due := dueChannelsForTest(channels, globalDue)
+			if len(due) == 0 {
+				if globalDue {
+					lastGlobalTest = time.Now()
 				}
+				continue
+			}
+			if globalDue {
+				common.SysLog(fmt.Sprintf("automatically test channels with interval %f minutes", frequency))
+			}
+			common.SysLog(fmt.Sprintf("automatically testing %d channels", len(due)))
+			if err := testChannels(due, false, model.ChannelTestSourceScheduled); err != nil {
+				// 上一轮测试仍在进行，下一分钟再试
+				continue
+			}
+			if globalDue {
+				lastGlobalTest = time.Now()
 			}
 		}
 	})
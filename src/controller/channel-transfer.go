@@ -0,0 +1,545 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	channelExportVersion         = 1
+	channelExportAlgorithm       = "scrypt+aes-256-gcm"
+	channelExportPassphraseCheck = "new-api-channel-export"
+)
+
+type channelExportEncryption struct {
+	Algorithm string `json:"algorithm"`
+	Salt      string `json:"salt"`
+	Check     string `json:"check"` // 用于导入时校验口令是否正确
+}
+
+type channelExportItem struct {
+	Name               string            `json:"name"`
+	Type               int               `json:"type"`
+	Key                string            `json:"key,omitempty"` // 使用口令加密后的密钥
+	OpenAIOrganization *string           `json:"openai_organization,omitempty"`
+	TestModel          *string           `json:"test_model,omitempty"`
+	Status             int               `json:"status"`
+	Weight             *uint             `json:"weight,omitempty"`
+	BaseURL            *string           `json:"base_url,omitempty"`
+	Other              string            `json:"other,omitempty"`
+	Models             string            `json:"models"`
+	Group              string            `json:"group"`
+	ModelMapping       *string           `json:"model_mapping,omitempty"`
+	StatusCodeMapping  *string           `json:"status_code_mapping,omitempty"`
+	Priority           *int64            `json:"priority,omitempty"`
+	AutoBan            *int              `json:"auto_ban,omitempty"`
+	OtherInfo          string            `json:"other_info,omitempty"`
+	Tag                *string           `json:"tag,omitempty"`
+	Setting            *string           `json:"setting,omitempty"`
+	ParamOverride      *string           `json:"param_override,omitempty"`
+	HeaderOverride     *string           `json:"header_override,omitempty"`
+	Remark             *string           `json:"remark,omitempty"`
+	ChannelInfo        model.ChannelInfo `json:"channel_info"`
+	OtherSettings      string            `json:"settings,omitempty"`
+}
+
+type channelExportDocument struct {
+	Version      int                      `json:"version"`
+	ExportedAt   int64                    `json:"exported_at"`
+	KeysIncluded bool                     `json:"keys_included"`
+	Encryption   *channelExportEncryption `json:"encryption,omitempty"`
+	Channels     []channelExportItem      `json:"channels"`
+}
+
+type channelExportRequest struct {
+	Ids        []int  `json:"ids"`
+	Tag        string `json:"tag"`
+	Passphrase string `json:"passphrase"` // 为空时不导出密钥
+	Format     string `json:"format"`     // json / yaml
+}
+
+type channelImportRequest struct {
+	Data       string `json:"data"`
+	Format     string `json:"format"` // json / yaml，为空时自动识别
+	Passphrase string `json:"passphrase"`
+	Conflict   string `json:"conflict"` // skip / overwrite / rename
+	MatchBy    string `json:"match_by"` // name：同名即冲突；tag：同标签下同名才冲突
+	DryRun     bool   `json:"dry_run"`
+}
+
+type channelImportResult struct {
+	Name      string   `json:"name"`
+	Tag       string   `json:"tag,omitempty"`
+	Action    string   `json:"action"` // create / update / unchanged / rename / skip / error
+	NewName   string   `json:"new_name,omitempty"`
+	ChannelId int      `json:"channel_id,omitempty"`
+	Changes   []string `json:"changes,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func newChannelExportItem(channel *model.Channel) channelExportItem {
+	channelInfo := channel.ChannelInfo
+	// 轮询位置属于运行时状态，不随配置迁移
+	channelInfo.MultiKeyPollingIndex = 0
+	return channelExportItem{
+		Name:               channel.Name,
+		Type:               channel.Type,
+		OpenAIOrganization: channel.OpenAIOrganization,
+		TestModel:          channel.TestModel,
+		Status:             channel.Status,
+		Weight:             channel.Weight,
+		BaseURL:            channel.BaseURL,
+		Other:              channel.Other,
+		Models:             channel.Models,
+		Group:              channel.Group,
+		ModelMapping:       channel.ModelMapping,
+		StatusCodeMapping:  channel.StatusCodeMapping,
+		Priority:           channel.Priority,
+		AutoBan:            channel.AutoBan,
+		OtherInfo:          channel.OtherInfo,
+		Tag:                channel.Tag,
+		Setting:            channel.Setting,
+		ParamOverride:      channel.ParamOverride,
+		HeaderOverride:     channel.HeaderOverride,
+		Remark:             channel.Remark,
+		ChannelInfo:        channelInfo,
+		OtherSettings:      channel.OtherSettings,
+	}
+}
+
+// applyTo 将导入项写入渠道，key 为解密后的密钥，为空时保留原密钥
+func (item *channelExportItem) applyTo(channel *model.Channel, key string) {
+	if key != "" {
+		channel.Key = key
+	}
+	channel.Name = item.Name
+	channel.Type = item.Type
+	channel.OpenAIOrganization = item.OpenAIOrganization
+	channel.TestModel = item.TestModel
+	channel.Status = item.Status
+	channel.Weight = item.Weight
+	channel.BaseURL = item.BaseURL
+	channel.Other = item.Other
+	channel.Models = item.Models
+	channel.Group = item.Group
+	channel.ModelMapping = item.ModelMapping
+	channel.StatusCodeMapping = item.StatusCodeMapping
+	channel.Priority = item.Priority
+	channel.AutoBan = item.AutoBan
+	channel.OtherInfo = item.OtherInfo
+	channel.Tag = item.Tag
+	channel.Setting = item.Setting
+	channel.ParamOverride = item.ParamOverride
+	channel.HeaderOverride = item.HeaderOverride
+	channel.Remark = item.Remark
+	channel.ChannelInfo = item.ChannelInfo
+	channel.OtherSettings = item.OtherSettings
+}
+
+// diffChannelExportItems 返回两个导出项之间发生变化的字段
+func diffChannelExportItems(existing channelExportItem, imported channelExportItem) ([]string, error) {
+	existingData, err := common.Marshal(existing)
+	if err != nil {
+		return nil, err
+	}
+	importedData, err := common.Marshal(imported)
+	if err != nil {
+		return nil, err
+	}
+	var existingMap, importedMap map[string]any
+	if err := common.Unmarshal(existingData, &existingMap); err != nil {
+		return nil, err
+	}
+	if err := common.Unmarshal(importedData, &importedMap); err != nil {
+		return nil, err
+	}
+	changes := make([]string, 0)
+	for field := range importedMap {
+		if !reflect.DeepEqual(existingMap[field], importedMap[field]) {
+			changes = append(changes, field)
+		}
+	}
+	for field := range existingMap {
+		if _, ok := importedMap[field]; !ok {
+			changes = append(changes, field)
+		}
+	}
+	sort.Strings(changes)
+	return changes, nil
+}
+
+func encodeChannelExport(doc *channelExportDocument, format string) ([]byte, error) {
+	data, err := common.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if format != "yaml" {
+		return data, nil
+	}
+	// 通过中间结构转换，使 YAML 与 JSON 使用相同的字段名
+	var generic any
+	if err := common.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(generic)
+}
+
+func decodeChannelExport(data string, format string) (*channelExportDocument, error) {
+	data = strings.TrimSpace(data)
+	if format == "" {
+		format = "yaml"
+		if strings.HasPrefix(data, "{") {
+			format = "json"
+		}
+	}
+	raw := []byte(data)
+	if format == "yaml" {
+		var generic any
+		if err := yaml.Unmarshal(raw, &generic); err != nil {
+			return nil, fmt.Errorf("YAML 解析失败: %w", err)
+		}
+		converted, err := common.Marshal(generic)
+		if err != nil {
+			return nil, err
+		}
+		raw = converted
+	}
+	doc := &channelExportDocument{}
+	if err := common.Unmarshal(raw, doc); err != nil {
+		return nil, fmt.Errorf("导入数据解析失败: %w", err)
+	}
+	if doc.Version != channelExportVersion {
+		return nil, fmt.Errorf("不支持的导出文件版本: %d", doc.Version)
+	}
+	return doc, nil
+}
+
+// ExportChannels 导出渠道配置，提供口令时密钥使用口令加密后一并导出
+func ExportChannels(c *gin.Context) {
+	req := channelExportRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "yaml" {
+		common.ApiErrorMsg(c, "不支持的导出格式")
+		return
+	}
+
+	var channels []*model.Channel
+	var err error
+	switch {
+	case len(req.Ids) > 0:
+		channels, err = model.GetChannelsByIds(req.Ids)
+	case req.Tag != "":
+		channels, err = model.GetChannelsByTag(req.Tag, true, true)
+	default:
+		channels, err = model.GetAllChannels(0, 0, true, true)
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	doc := &channelExportDocument{
+		Version:    channelExportVersion,
+		ExportedAt: common.GetTimestamp(),
+		Channels:   make([]channelExportItem, 0, len(channels)),
+	}
+	var encryptionKey []byte
+	if req.Passphrase != "" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		encryptionKey, err = common.DerivePassphraseKey(req.Passphrase, salt)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		check, err := common.EncryptAESGCM(encryptionKey, []byte(channelExportPassphraseCheck))
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		doc.KeysIncluded = true
+		doc.Encryption = &channelExportEncryption{
+			Algorithm: channelExportAlgorithm,
+			Salt:      base64.StdEncoding.EncodeToString(salt),
+			Check:     check,
+		}
+	}
+	for _, channel := range channels {
+		item := newChannelExportItem(channel)
+		if encryptionKey != nil {
+			item.Key, err = common.EncryptAESGCM(encryptionKey, []byte(channel.Key))
+			if err != nil {
+				common.ApiError(c, err)
+				return
+			}
+		}
+		doc.Channels = append(doc.Channels, item)
+	}
+
+	format := req.Format
+	if format == "" {
+		format = "json"
+	}
+	data, err := encodeChannelExport(doc, format)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	contentType := "application/json"
+	if format == "yaml" {
+		contentType = "application/x-yaml"
+	}
+	filename := fmt.Sprintf("channels-%s.%s", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func channelImportMatchKey(name string, tag *string, matchBy string) string {
+	if matchBy == "tag" {
+		t := ""
+		if tag != nil {
+			t = *tag
+		}
+		return t + "\x00" + name
+	}
+	return name
+}
+
+// ImportChannels 导入渠道配置，dry_run 时仅返回变更计划
+func ImportChannels(c *gin.Context) {
+	req := channelImportRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Conflict == "" {
+		req.Conflict = "skip"
+	}
+	if req.MatchBy == "" {
+		req.MatchBy = "name"
+	}
+	if req.Conflict != "skip" && req.Conflict != "overwrite" && req.Conflict != "rename" {
+		common.ApiErrorMsg(c, "conflict 只能为 skip、overwrite 或 rename")
+		return
+	}
+	if req.MatchBy != "name" && req.MatchBy != "tag" {
+		common.ApiErrorMsg(c, "match_by 只能为 name 或 tag")
+		return
+	}
+	doc, err := decodeChannelExport(req.Data, req.Format)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
+	var decryptionKey []byte
+	if doc.KeysIncluded {
+		if doc.Encryption == nil || doc.Encryption.Algorithm != channelExportAlgorithm {
+			common.ApiErrorMsg(c, "不支持的密钥加密方式")
+			return
+		}
+		if req.Passphrase == "" {
+			common.ApiErrorMsg(c, "导入数据包含加密的密钥，请提供口令")
+			return
+		}
+		salt, err := base64.StdEncoding.DecodeString(doc.Encryption.Salt)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		decryptionKey, err = common.DerivePassphraseKey(req.Passphrase, salt)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		check, err := common.DecryptAESGCM(decryptionKey, doc.Encryption.Check)
+		if err != nil || string(check) != channelExportPassphraseCheck {
+			common.ApiErrorMsg(c, "口令错误")
+			return
+		}
+	}
+
+	existingChannels, err := model.GetAllChannels(0, 0, true, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	existingByKey := make(map[string]*model.Channel)
+	existingNames := make(map[string]bool)
+	existingNameById := make(map[int]string)
+	for _, channel := range existingChannels {
+		existingNameById[channel.Id] = channel.Name
+		key := channelImportMatchKey(channel.Name, channel.Tag, req.MatchBy)
+		if _, ok := existingByKey[key]; !ok {
+			existingByKey[key] = channel
+		}
+		existingNames[channel.Name] = true
+	}
+
+	results := make([]channelImportResult, 0, len(doc.Channels))
+	creates := make([]*model.Channel, 0)
+	updates := make([]*model.Channel, 0)
+	importedKeys := make(map[string]bool)
+	for i := range doc.Channels {
+		item := doc.Channels[i]
+		result := channelImportResult{Name: item.Name}
+		if item.Tag != nil {
+			result.Tag = *item.Tag
+		}
+		key := ""
+		if item.Key != "" && decryptionKey != nil {
+			plaintext, err := common.DecryptAESGCM(decryptionKey, item.Key)
+			if err != nil {
+				result.Action, result.Error = "error", "密钥解密失败"
+				results = append(results, result)
+				continue
+			}
+			key = string(plaintext)
+		}
+		if item.Name == "" {
+			result.Action, result.Error = "error", "渠道名称不能为空"
+			results = append(results, result)
+			continue
+		}
+
+		matchKey := channelImportMatchKey(item.Name, item.Tag, req.MatchBy)
+		existing, conflict := existingByKey[matchKey]
+		if importedKeys[matchKey] {
+			conflict = true
+		}
+		action := "create"
+		if conflict {
+			action = req.Conflict
+		}
+		if action == "overwrite" && existing == nil {
+			result.Action, result.Error = "error", "导入数据中存在重复的渠道"
+			results = append(results, result)
+			continue
+		}
+
+		switch action {
+		case "skip":
+			result.Action = "skip"
+			if existing != nil {
+				result.ChannelId = existing.Id
+			}
+		case "overwrite":
+			result.ChannelId = existing.Id
+			compare := item
+			compare.Key = ""
+			result.Changes, err = diffChannelExportItems(newChannelExportItem(existing), compare)
+			if err != nil {
+				result.Action, result.Error = "error", err.Error()
+				break
+			}
+			if key != "" && key != existing.Key {
+				result.Changes = append(result.Changes, "key")
+			}
+			if len(result.Changes) == 0 {
+				result.Action = "unchanged"
+				break
+			}
+			result.Action = "update"
+			updated := *existing
+			item.applyTo(&updated, key)
+			if err := updated.ValidateSettings(); err != nil {
+				result.Action, result.Error = "error", err.Error()
+				break
+			}
+			updates = append(updates, &updated)
+		default:
+			if key == "" {
+				result.Action, result.Error = "error", "新建渠道缺少密钥"
+				break
+			}
+			channel := &model.Channel{CreatedTime: common.GetTimestamp()}
+			item.applyTo(channel, key)
+			if action == "rename" {
+				name := item.Name
+				for n := 1; existingNames[name] || importedKeys[channelImportMatchKey(name, item.Tag, req.MatchBy)]; n++ {
+					name = fmt.Sprintf("%s (imported %d)", item.Name, n)
+				}
+				channel.Name = name
+				result.NewName = name
+			}
+			if err := channel.ValidateSettings(); err != nil {
+				result.Action, result.Error = "error", err.Error()
+				break
+			}
+			result.Action = action
+			creates = append(creates, channel)
+			existingNames[channel.Name] = true
+			importedKeys[channelImportMatchKey(channel.Name, item.Tag, req.MatchBy)] = true
+		}
+		importedKeys[matchKey] = true
+		results = append(results, result)
+	}
+
+	// 新建、改名后的渠道不能占用配置文件管理的渠道名称
+	for _, channel := range creates {
+		if !checkConfigChannelNameAvailable(c, channel.Name) {
+			return
+		}
+	}
+	for _, channel := range updates {
+		if channel.Name != existingNameById[channel.Id] && !checkConfigChannelNameAvailable(c, channel.Name) {
+			return
+		}
+	}
+
+	summary := gin.H{
+		"dry_run": req.DryRun,
+		"results": results,
+		"created": len(creates),
+		"updated": len(updates),
+	}
+	if req.DryRun {
+		common.ApiSuccess(c, summary)
+		return
+	}
+	updateIds := make([]int, 0, len(updates))
+	for _, channel := range updates {
+		updateIds = append(updateIds, channel.Id)
+	}
+	if !checkConfigManagedChannels(c, updateIds...) {
+		return
+	}
+	if err := model.ImportChannels(creates, updates); err != nil {
+		common.ApiError(c, errors.New("导入失败: "+err.Error()))
+		return
+	}
+	for _, channel := range updates {
+		model.ResetMultiKeyUsage(channel.Id)
+		service.ResetChannelBreakers(channel.Id)
+	}
+	// 回填新建渠道的 ID
+	createIdx := 0
+	for i := range results {
+		if results[i].Action == "create" || results[i].Action == "rename" {
+			results[i].ChannelId = creates[createIdx].Id
+			createIdx++
+		}
+	}
+	model.InitChannelCache()
+	common.ApiSuccess(c, summary)
+}
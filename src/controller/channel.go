@@ -14,6 +14,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/channel/gemini"
 	"github.com/QuantumNous/new-api/relay/channel/ollama"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -166,6 +167,8 @@ func GetAllChannels(c *gin.Context) {
 		"page":        pageInfo.GetPage(),
 		"page_size":   pageInfo.GetPageSize(),
 		"type_counts": typeCounts,
+		"breakers":    service.GetOpenChannelBreakers(),
+		"in_flight":   service.GetChannelInFlightCounts(channelData),
 	})
 	return
 }
@@ -214,7 +217,11 @@ func FetchUpstreamModels(c *gin.Context) {
 
 	// 对于 Ollama 渠道，使用特殊处理
 	if channel.Type == constant.ChannelTypeOllama {
-		key := strings.Split(channel.Key, "\n")[0]
+		key, err := firstChannelKey(channel)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
 		models, err := ollama.FetchOllamaModels(baseURL, key)
 		if err != nil {
 			c.JSON(http.StatusOK, gin.H{
@@ -490,6 +497,8 @@ func SearchChannels(c *gin.Context) {
 			"items":       pagedData,
 			"total":       total,
 			"type_counts": typeCounts,
+			"breakers":    service.GetOpenChannelBreakers(),
+			"in_flight":   service.GetChannelInFlightCounts(pagedData),
 		},
 	})
 	return
@@ -517,6 +526,51 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// GetChannelBreaker 获取渠道（含各密钥）的熔断器状态
+func GetChannelBreaker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"enabled":  operation_setting.GetCircuitBreakerSetting().Enabled,
+		"breakers": service.GetChannelBreakerStates(id),
+	})
+}
+
+// GetChannelRateLimit 获取渠道的上游限流状态与当前分钟的静态 RPM/TPM 用量
+func GetChannelRateLimit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	channel, err := model.GetChannelById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	settings := channel.GetOtherSettings()
+	common.ApiSuccess(c, gin.H{
+		"upstream":     service.GetUpstreamRateLimitStates(id),
+		"rpm_limit":    settings.RPMLimit,
+		"tpm_limit":    settings.TPMLimit,
+		"minute_usage": service.GetChannelStaticLimitUsage(id),
+	})
+}
+
+// ResetChannelBreaker 手动重置渠道（含各密钥）的熔断器
+func ResetChannelBreaker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.ResetChannelBreakers(id)
+	common.ApiSuccess(c, nil)
+}
+
 // GetChannelKey 获取渠道密钥（需要通过安全验证中间件）
 // 此函数依赖 SecureVerificationRequired 中间件，确保用户已通过安全验证
 func GetChannelKey(c *gin.Context) {
@@ -558,6 +612,9 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 	if err := channel.ValidateSettings(); err != nil {
 		return fmt.Errorf("渠道额外设置[channel setting] 格式错误：%s", err.Error())
 	}
+	if err := validateChannelTestSuite(channel.GetOtherSettings().TestSuite); err != nil {
+		return err
+	}
 
 	// 如果是添加操作，检查 channel 和 key 是否为空
 	if isAdd {
@@ -573,6 +630,15 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 		}
 	}
 
+	// 外部密钥引用只能读取允许的环境变量与目录
+	for _, line := range strings.Split(channel.Key, "\n") {
+		if common.IsSecretRef(line) {
+			if err := common.CheckSecretRefAllowed(line); err != nil {
+				return err
+			}
+		}
+	}
+
 	// VertexAI 特殊校验
 	if channel.Type == constant.ChannelTypeVertexAi {
 		if channel.Other == "" {
@@ -667,6 +733,9 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if !checkConfigChannelNameAvailable(c, addChannelRequest.Channel.Name) {
+		return
+	}
 
 	addChannelRequest.Channel.CreatedTime = common.GetTimestamp()
 	keys := make([]string, 0)
@@ -753,6 +822,9 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	if !checkConfigManagedChannels(c, id) {
+		return
+	}
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
@@ -768,6 +840,9 @@ func DeleteChannel(c *gin.Context) {
 }
 
 func DeleteDisabledChannel(c *gin.Context) {
+	if !checkConfigManagedDisabledChannels(c) {
+		return
+	}
 	rows, err := model.DeleteDisabledChannel()
 	if err != nil {
 		common.ApiError(c, err)
@@ -804,6 +879,9 @@ func DisableTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	if !checkConfigManagedChannelsByTag(c, channelTag.Tag) {
+		return
+	}
 	err = model.DisableChannelByTag(channelTag.Tag)
 	if err != nil {
 		common.ApiError(c, err)
@@ -827,6 +905,9 @@ func EnableTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	if !checkConfigManagedChannelsByTag(c, channelTag.Tag) {
+		return
+	}
 	err = model.EnableChannelByTag(channelTag.Tag)
 	if err != nil {
 		common.ApiError(c, err)
@@ -879,6 +960,9 @@ func EditTagChannels(c *gin.Context) {
 		}
 		channelTag.HeaderOverride = common.GetPointer[string](trimmed)
 	}
+	if !checkConfigManagedChannelsByTag(c, channelTag.Tag) {
+		return
+	}
 	err = model.EditChannelByTag(channelTag.Tag, channelTag.NewTag, channelTag.ModelMapping, channelTag.Models, channelTag.Groups, channelTag.Priority, channelTag.Weight, channelTag.ParamOverride, channelTag.HeaderOverride)
 	if err != nil {
 		common.ApiError(c, err)
@@ -907,6 +991,9 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	if !checkConfigManagedChannels(c, channelBatch.Ids...) {
+		return
+	}
 	err = model.BatchDeleteChannels(channelBatch.Ids)
 	if err != nil {
 		common.ApiError(c, err)
@@ -934,6 +1021,9 @@ func UpdateChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if !checkConfigManagedChannels(c, channel.Id) {
+		return
+	}
 
 	// 使用统一的校验函数
 	if err := validateChannel(&channel.Channel, false); err != nil {
@@ -952,6 +1042,9 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if channel.Name != "" && channel.Name != originChannel.Name && !checkConfigChannelNameAvailable(c, channel.Name) {
+		return
+	}
 
 	// Always copy the original ChannelInfo so that fields like IsMultiKey and MultiKeySize are retained.
 	channel.ChannelInfo = originChannel.ChannelInfo
@@ -1188,6 +1281,9 @@ func BatchSetChannelTag(c *gin.Context) {
 		})
 		return
 	}
+	if !checkConfigManagedChannels(c, channelBatch.Ids...) {
+		return
+	}
 	err = model.BatchSetChannelTag(channelBatch.Ids, channelBatch.Tag)
 	if err != nil {
 		common.ApiError(c, err)
@@ -1298,7 +1394,7 @@ func CopyChannel(c *gin.Context) {
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
 	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
+	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "reset_key_usage"
 	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
 	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
 	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
@@ -1324,6 +1420,14 @@ type KeyStatus struct {
 	DisabledTime int64  `json:"disabled_time,omitempty"`
 	Reason       string `json:"reason,omitempty"`
 	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	BreakerState string `json:"breaker_state,omitempty"`
+
+	Requests         int64   `json:"requests"`
+	Failures         int64   `json:"failures"`
+	ErrorRate        float64 `json:"error_rate"`
+	LastUsedAt       int64   `json:"last_used_at,omitempty"`
+	RemainingQuota   float64 `json:"remaining_quota"`              // 上游剩余额度比例，-1 表示未知
+	RateLimitedUntil int64   `json:"rate_limited_until,omitempty"` // 上游限流冷却结束时间
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1352,6 +1456,10 @@ func ManageMultiKeys(c *gin.Context) {
 		return
 	}
 
+	if request.Action != "get_key_status" && !checkConfigManagedChannels(c, channel.Id) {
+		return
+	}
+
 	lock := model.GetChannelPollingLock(channel.Id)
 	lock.Lock()
 	defer lock.Unlock()
@@ -1373,6 +1481,20 @@ func ManageMultiKeys(c *gin.Context) {
 		// Statistics for all keys (unchanged by filtering)
 		var enabledCount, manualDisabledCount, autoDisabledCount int
 
+		keyUsage := model.GetMultiKeyUsage(channel)
+		rateLimitedUntil := make(map[int]int64)
+		for _, state := range service.GetUpstreamRateLimitStates(channel.Id) {
+			rateLimitedUntil[state.KeyIndex] = state.BlockedUntil
+		}
+
+		// Circuit breaker state per key index
+		breakerStates := make(map[int]string)
+		for _, state := range service.GetChannelBreakerStates(channel.Id) {
+			if state.KeyIndex != service.ChannelBreakerKeyIndexAll {
+				breakerStates[state.KeyIndex] = state.State
+			}
+		}
+
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
 		for i, key := range keys {
@@ -1411,12 +1533,24 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
+			remainingQuota := -1.0
+			if ratio, ok := service.GetKeyRemainingQuotaRatio(channel.Id, i); ok {
+				remainingQuota = ratio
+			}
+			usage := keyUsage[i]
 			allKeyStatusList = append(allKeyStatusList, KeyStatus{
-				Index:        i,
-				Status:       status,
-				DisabledTime: disabledTime,
-				Reason:       reason,
-				KeyPreview:   keyPreview,
+				Index:            i,
+				Status:           status,
+				DisabledTime:     disabledTime,
+				Reason:           reason,
+				KeyPreview:       keyPreview,
+				BreakerState:     breakerStates[i],
+				Requests:         usage.Requests,
+				Failures:         usage.Failures,
+				ErrorRate:        usage.ErrorRate,
+				LastUsedAt:       usage.LastUsedAt,
+				RemainingQuota:   remainingQuota,
+				RateLimitedUntil: rateLimitedUntil[i],
 			})
 		}
 
@@ -1580,6 +1714,15 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "reset_key_usage":
+		// 清空所有密钥的使用统计
+		model.ResetMultiKeyUsage(channel.Id)
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "密钥使用统计已重置",
+		})
+		return
+
 	case "disable_all_keys":
 		// 禁用所有启用的密钥
 		if channel.ChannelInfo.MultiKeyStatusList == nil {
@@ -1700,6 +1843,9 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		// 密钥索引已变化，清除旧的熔断状态与使用统计
+		service.ResetChannelBreakers(channel.Id)
+		model.ResetMultiKeyUsage(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1768,6 +1914,9 @@ func ManageMultiKeys(c *gin.Context) {
 			return
 		}
 
+		// 密钥索引已变化，清除旧的熔断状态与使用统计
+		service.ResetChannelBreakers(channel.Id)
+		model.ResetMultiKeyUsage(channel.Id)
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -1832,7 +1981,11 @@ func OllamaPullModel(c *gin.Context) {
 		baseURL = channel.GetBaseURL()
 	}
 
-	key := strings.Split(channel.Key, "\n")[0]
+	key, err := firstChannelKey(channel)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	err = ollama.PullOllamaModel(baseURL, key, req.ModelName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -1895,14 +2048,18 @@ func OllamaPullModelStream(c *gin.Context) {
 		baseURL = channel.GetBaseURL()
 	}
 
+	key, err := firstChannelKey(channel)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+
 	// 设置 SSE 头部
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
 
-	key := strings.Split(channel.Key, "\n")[0]
-
 	// 创建进度回调函数
 	progressCallback := func(progress ollama.OllamaPullResponse) {
 		data, _ := json.Marshal(progress)
@@ -1977,7 +2134,11 @@ func OllamaDeleteModel(c *gin.Context) {
 		baseURL = channel.GetBaseURL()
 	}
 
-	key := strings.Split(channel.Key, "\n")[0]
+	key, err := firstChannelKey(channel)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	err = ollama.DeleteOllamaModel(baseURL, key, req.ModelName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -2026,7 +2187,11 @@ func OllamaVersion(c *gin.Context) {
 		baseURL = channel.GetBaseURL()
 	}
 
-	key := strings.Split(channel.Key, "\n")[0]
+	key, err := firstChannelKey(channel)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
 	version, err := ollama.FetchOllamaVersion(baseURL, key)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -2043,3 +2208,22 @@ func OllamaVersion(c *gin.Context) {
 		},
 	})
 }
+
+// firstChannelKey 返回渠道的第一个密钥，外部密钥引用会被解析
+func firstChannelKey(channel *model.Channel) (string, error) {
+	return model.ResolveChannelKey(channel.Id, strings.Split(channel.Key, "\n")[0])
+}
+
+// GetChannelSecretRefStatus 返回使用外部密钥引用的渠道及其解析状态
+func GetChannelSecretRefStatus(c *gin.Context) {
+	common.ApiSuccess(c, model.GetChannelSecretRefStatuses())
+}
+
+// RefreshChannelSecretRefs 立即刷新外部密钥引用
+func RefreshChannelSecretRefs(c *gin.Context) {
+	if err := model.CheckChannelSecretRefs(true); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, model.GetChannelSecretRefStatuses())
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// randomCheckinQuota 在配置的范围内随机生成签到奖励
+func randomCheckinQuota() int {
+	minQuota, maxQuota := operation_setting.GetCheckinQuotaRange()
+	if minQuota < 0 {
+		minQuota = 0
+	}
+	if maxQuota <= minQuota {
+		return minQuota
+	}
+	return minQuota + common.GetRandomInt(maxQuota-minQuota+1)
+}
+
+// Checkin 每日签到
+func Checkin(c *gin.Context) {
+	if !operation_setting.IsCheckinEnabled() {
+		common.ApiErrorMsg(c, "管理员未开启签到功能")
+		return
+	}
+	checkin, err := model.UserCheckin(c.GetInt("id"), time.Now(), randomCheckinQuota(), operation_setting.GetCheckinStreakBonus)
+	if err != nil {
+		if errors.Is(err, model.ErrCheckinAlreadyDone) {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, checkin)
+}
+
+// GetCheckinCalendar 返回指定月份（默认当月）的签到日历与当前连续签到天数
+func GetCheckinCalendar(c *gin.Context) {
+	userId := c.GetInt("id")
+	now := time.Now()
+	month := now
+	if monthStr := c.Query("month"); monthStr != "" {
+		parsed, err := time.ParseInLocation("2006-01", monthStr, now.Location())
+		if err != nil {
+			common.ApiErrorMsg(c, "月份格式应为 YYYY-MM")
+			return
+		}
+		month = parsed
+	}
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, now.Location())
+	end := start.AddDate(0, 1, -1)
+	checkins, err := model.GetUserCheckins(userId, start.Format(model.CheckinDateLayout), end.Format(model.CheckinDateLayout))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	last, err := model.GetLastCheckin(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	totalQuota := 0
+	for _, checkin := range checkins {
+		totalQuota += checkin.Quota + checkin.BonusQuota
+	}
+	setting := operation_setting.GetCheckinSetting()
+	common.ApiSuccess(c, gin.H{
+		"enabled":          setting.Enabled,
+		"min_quota":        setting.MinQuota,
+		"max_quota":        setting.MaxQuota,
+		"streak_bonuses":   setting.StreakBonuses,
+		"checked_in_today": last != nil && last.CheckinDate == now.Format(model.CheckinDateLayout),
+		"streak":           model.CurrentCheckinStreak(last, now),
+		"month":            start.Format("2006-01"),
+		"month_quota":      totalQuota,
+		"records":          checkins,
+	})
+}
@@ -0,0 +1,941 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// 声明式配置文件（CONFIG_FILE 指定路径，YAML 或 JSON），启动时及收到 SIGHUP 时同步到数据库。
+// 配置文件中声明的渠道、模型和选项视为由配置文件管理，
+// CONFIG_FILE_OWNERSHIP=reject（默认）时拒绝在界面修改，flag 时允许修改但记录为漂移，下次同步时覆盖
+
+const configFileVersion = 1
+
+const (
+	configOwnershipReject = "reject"
+	configOwnershipFlag   = "flag"
+)
+
+type configFileRateLimit struct {
+	Enabled         *bool             `json:"enabled,omitempty"`
+	DurationMinutes *int              `json:"duration_minutes,omitempty"`
+	Count           *int              `json:"count,omitempty"`
+	SuccessCount    *int              `json:"success_count,omitempty"`
+	Groups          map[string][2]int `json:"groups,omitempty"`
+}
+
+type configFileDocument struct {
+	Version          int                       `json:"version"`
+	Options          map[string]any            `json:"options,omitempty"`
+	Settings         map[string]map[string]any `json:"settings,omitempty"` // config.GlobalConfig 注册的配置，按 模块名.配置项 写入
+	GroupRatios      map[string]float64        `json:"group_ratios,omitempty"`
+	UserUsableGroups map[string]string         `json:"user_usable_groups,omitempty"`
+	ModelRatios      map[string]float64        `json:"model_ratios,omitempty"`
+	CompletionRatios map[string]float64        `json:"completion_ratios,omitempty"`
+	ModelPrices      map[string]float64        `json:"model_prices,omitempty"`
+	RateLimit        *configFileRateLimit      `json:"rate_limit,omitempty"`
+	Models           []map[string]any          `json:"models,omitempty"`   // 按 model_name 匹配，仅同步声明的字段
+	Channels         []map[string]any          `json:"channels,omitempty"` // 按 name 匹配，仅同步声明的字段
+}
+
+type configPlanChange struct {
+	Kind    string   `json:"kind"` // option / model / channel
+	Name    string   `json:"name"`
+	Action  string   `json:"action"` // create / update / error
+	Id      int      `json:"id,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type configPlan struct {
+	File      string             `json:"file"`
+	Applied   bool               `json:"applied"`
+	Changes   []configPlanChange `json:"changes"`
+	Unchanged int                `json:"unchanged"`
+	Errors    int                `json:"errors"`
+}
+
+type configDrift struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Time int64  `json:"time"`
+}
+
+type configFileState struct {
+	sync.RWMutex
+	managedOptions  map[string]bool
+	managedModels   map[string]bool
+	managedChannels map[string]bool
+	loadedAt        int64
+	appliedAt       int64
+	lastError       string
+	lastPlan        *configPlan
+	drifts          []configDrift
+}
+
+var configState = &configFileState{}
+
+// 串行化配置同步，避免 SIGHUP 与接口同时触发
+var configApplyLock sync.Mutex
+
+func configFilePath() string {
+	return os.Getenv("CONFIG_FILE")
+}
+
+func configOwnershipMode() string {
+	if os.Getenv("CONFIG_FILE_OWNERSHIP") == configOwnershipFlag {
+		return configOwnershipFlag
+	}
+	return configOwnershipReject
+}
+
+func loadConfigFile(path string) (*configFileDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := []byte(strings.TrimSpace(string(data)))
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" && !strings.HasPrefix(string(raw), "{") {
+		// 通过中间结构转换，使 YAML 与 JSON 使用相同的字段名
+		var generic any
+		if err := yaml.Unmarshal(raw, &generic); err != nil {
+			return nil, fmt.Errorf("YAML 解析失败: %w", err)
+		}
+		if raw, err = common.Marshal(generic); err != nil {
+			return nil, err
+		}
+	}
+	doc := &configFileDocument{}
+	if err := common.Unmarshal(raw, doc); err != nil {
+		return nil, fmt.Errorf("配置文件解析失败: %w", err)
+	}
+	if doc.Version != configFileVersion {
+		return nil, fmt.Errorf("不支持的配置文件版本: %d", doc.Version)
+	}
+	return doc, nil
+}
+
+// configOptionValue 将配置文件中的值转换为选项存储格式
+func configOptionValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		data, err := common.Marshal(v)
+		return string(data), err
+	}
+}
+
+// normalizeConfigValue 将 JSON 字符串展开后比较，避免格式差异被识别为变更
+func normalizeConfigValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var decoded any
+			if err := common.Unmarshal([]byte(trimmed), &decoded); err == nil {
+				return normalizeConfigValue(decoded)
+			}
+		}
+		return v
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeConfigValue(item)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeConfigValue(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+func configValueEqual(a, b any) bool {
+	return reflect.DeepEqual(normalizeConfigValue(a), normalizeConfigValue(b))
+}
+
+// mergeConfigValues 将声明的字段合并到现有值上，嵌套对象逐层合并
+func mergeConfigValues(base map[string]any, overlay map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(overlay))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		overlayMap, ok := value.(map[string]any)
+		baseMap, baseOk := merged[key].(map[string]any)
+		if ok && baseOk {
+			merged[key] = mergeConfigValues(baseMap, overlayMap)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+func toConfigMap(value any) (map[string]any, error) {
+	data, err := common.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]any)
+	err = common.Unmarshal(data, &result)
+	return result, err
+}
+
+// fromConfigMap 将合并结果写回结构体，stringFields 中的字段在数据库中以 JSON 字符串存储
+func fromConfigMap(values map[string]any, stringFields map[string]bool, target any) error {
+	converted := make(map[string]any, len(values))
+	for key, value := range values {
+		switch value.(type) {
+		case map[string]any, []any:
+			if stringFields[key] {
+				data, err := common.Marshal(value)
+				if err != nil {
+					return err
+				}
+				value = string(data)
+			}
+		}
+		converted[key] = value
+	}
+	data, err := common.Marshal(converted)
+	if err != nil {
+		return err
+	}
+	return common.Unmarshal(data, target)
+}
+
+// declaredChanges 返回声明字段中与现有值不同的字段
+func declaredChanges(existing map[string]any, merged map[string]any, declared map[string]any) []string {
+	changes := make([]string, 0)
+	for key := range declared {
+		if !configValueEqual(existing[key], merged[key]) {
+			changes = append(changes, key)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func (doc *configFileDocument) desiredOptions() (map[string]string, error) {
+	options := make(map[string]string)
+	for key, value := range doc.Options {
+		str, err := configOptionValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("选项 %s: %w", key, err)
+		}
+		options[key] = str
+	}
+	for name, fields := range doc.Settings {
+		for field, value := range fields {
+			str, err := configOptionValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("配置 %s.%s: %w", name, field, err)
+			}
+			options[name+"."+field] = str
+		}
+	}
+	// 简写段落，整体写入对应的 JSON 选项
+	sections := map[string]any{}
+	if doc.GroupRatios != nil {
+		sections["GroupRatio"] = doc.GroupRatios
+	}
+	if doc.UserUsableGroups != nil {
+		sections["UserUsableGroups"] = doc.UserUsableGroups
+	}
+	if doc.ModelRatios != nil {
+		sections["ModelRatio"] = doc.ModelRatios
+	}
+	if doc.CompletionRatios != nil {
+		sections["CompletionRatio"] = doc.CompletionRatios
+	}
+	if doc.ModelPrices != nil {
+		sections["ModelPrice"] = doc.ModelPrices
+	}
+	if rl := doc.RateLimit; rl != nil {
+		if rl.Groups != nil {
+			sections["ModelRequestRateLimitGroup"] = rl.Groups
+		}
+		if rl.Enabled != nil {
+			options["ModelRequestRateLimitEnabled"] = strconv.FormatBool(*rl.Enabled)
+		}
+		if rl.DurationMinutes != nil {
+			options["ModelRequestRateLimitDurationMinutes"] = strconv.Itoa(*rl.DurationMinutes)
+		}
+		if rl.Count != nil {
+			options["ModelRequestRateLimitCount"] = strconv.Itoa(*rl.Count)
+		}
+		if rl.SuccessCount != nil {
+			options["ModelRequestRateLimitSuccessCount"] = strconv.Itoa(*rl.SuccessCount)
+		}
+	}
+	for key, value := range sections {
+		data, err := common.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		options[key] = string(data)
+	}
+	return options, nil
+}
+
+func validateConfigOption(key string, value string) error {
+	common.OptionMapRWMutex.RLock()
+	_, known := common.OptionMap[key]
+	common.OptionMapRWMutex.RUnlock()
+	if !known {
+		return errors.New("未知的选项")
+	}
+	switch key {
+	case model.TokenKeyHashSecretOption:
+		return errors.New("令牌哈希密钥不允许修改")
+	case "GroupRatio":
+		return ratio_setting.CheckGroupRatio(value)
+	case "ModelRequestRateLimitGroup":
+		return setting.CheckModelRequestRateLimitGroup(value)
+	}
+	return nil
+}
+
+// reconcileConfigFile 计算配置文件与数据库的差异，apply 为 true 时写入数据库
+func reconcileConfigFile(apply bool) (*configPlan, error) {
+	configApplyLock.Lock()
+	defer configApplyLock.Unlock()
+
+	path := configFilePath()
+	if path == "" {
+		return nil, errors.New("未配置 CONFIG_FILE")
+	}
+	doc, err := loadConfigFile(path)
+	if err != nil {
+		configState.Lock()
+		configState.lastError = err.Error()
+		configState.Unlock()
+		return nil, err
+	}
+
+	plan := &configPlan{File: path, Changes: make([]configPlanChange, 0)}
+	addChange := func(change configPlanChange) {
+		if change.Action == "error" {
+			plan.Errors++
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+	managedOptions := make(map[string]bool)
+	managedModels := make(map[string]bool)
+	managedChannels := make(map[string]bool)
+
+	// 选项
+	desiredOptions, err := doc.desiredOptions()
+	if err != nil {
+		return nil, err
+	}
+	optionUpdates := make(map[string]string)
+	optionKeys := make([]string, 0, len(desiredOptions))
+	for key := range desiredOptions {
+		optionKeys = append(optionKeys, key)
+	}
+	sort.Strings(optionKeys)
+	for _, key := range optionKeys {
+		value := desiredOptions[key]
+		managedOptions[key] = true
+		if err := validateConfigOption(key, value); err != nil {
+			addChange(configPlanChange{Kind: "option", Name: key, Action: "error", Error: err.Error()})
+			continue
+		}
+		common.OptionMapRWMutex.RLock()
+		current := common.OptionMap[key]
+		common.OptionMapRWMutex.RUnlock()
+		if configValueEqual(current, value) {
+			plan.Unchanged++
+			continue
+		}
+		addChange(configPlanChange{Kind: "option", Name: key, Action: "update"})
+		optionUpdates[key] = value
+	}
+
+	// 模型元数据
+	modelUpdates := make([]configModelChange, 0)
+	modelStringFields := map[string]bool{"endpoints": true}
+	for _, spec := range doc.Models {
+		name, _ := spec["model_name"].(string)
+		if name == "" {
+			addChange(configPlanChange{Kind: "model", Action: "error", Error: "缺少 model_name"})
+			continue
+		}
+		managedModels[name] = true
+		declared := make(map[string]any, len(spec))
+		for key, value := range spec {
+			if key != "id" && key != "created_time" && key != "updated_time" {
+				declared[key] = value
+			}
+		}
+		existing := &model.Model{}
+		if err := model.DB.Where("model_name = ?", name).Limit(1).Find(existing).Error; err != nil {
+			return nil, err
+		}
+		isCreate := existing.Id == 0
+		var existingMap map[string]any
+		if isCreate {
+			existingMap = map[string]any{"status": float64(1), "sync_official": float64(1)}
+		} else if existingMap, err = toConfigMap(existing); err != nil {
+			return nil, err
+		}
+		merged := mergeConfigValues(existingMap, declared)
+		change := configPlanChange{Kind: "model", Name: name, Id: existing.Id}
+		target := &model.Model{}
+		if err := fromConfigMap(merged, modelStringFields, target); err != nil {
+			change.Action, change.Error = "error", err.Error()
+			addChange(change)
+			continue
+		}
+		if isCreate {
+			change.Action = "create"
+			target.Id = 0
+		} else {
+			change.Changes = declaredChanges(existingMap, merged, declared)
+			if len(change.Changes) == 0 {
+				plan.Unchanged++
+				continue
+			}
+			change.Action = "update"
+			target.Id = existing.Id
+			target.CreatedTime = existing.CreatedTime
+		}
+		addChange(change)
+		modelUpdates = append(modelUpdates, configModelChange{model: target, isCreate: isCreate})
+	}
+
+	// 渠道
+	existingChannels, err := model.GetAllChannels(0, 0, true, true)
+	if err != nil {
+		return nil, err
+	}
+	channelsByName := make(map[string][]*model.Channel)
+	for _, channel := range existingChannels {
+		channelsByName[channel.Name] = append(channelsByName[channel.Name], channel)
+	}
+	channelStringFields := map[string]bool{
+		"setting": true, "settings": true, "other_info": true, "model_mapping": true,
+		"status_code_mapping": true, "param_override": true, "header_override": true, "other": true,
+	}
+	creates := make([]*model.Channel, 0)
+	updates := make([]*model.Channel, 0)
+	for _, spec := range doc.Channels {
+		name, _ := spec["name"].(string)
+		change := configPlanChange{Kind: "channel", Name: name}
+		if name == "" {
+			change.Action, change.Error = "error", "缺少 name"
+			addChange(change)
+			continue
+		}
+		if managedChannels[name] {
+			change.Action, change.Error = "error", "配置文件中存在重名渠道"
+			addChange(change)
+			continue
+		}
+		managedChannels[name] = true
+		if len(channelsByName[name]) > 1 {
+			change.Action, change.Error = "error", "数据库中存在多个同名渠道，无法匹配"
+			addChange(change)
+			continue
+		}
+		key, _ := spec["key"].(string)
+		declared := make(map[string]any, len(spec))
+		for field, value := range spec {
+			if field != "key" {
+				declared[field] = value
+			}
+		}
+
+		var existing *model.Channel
+		existingMap := map[string]any{"status": float64(common.ChannelStatusEnabled), "group": "default"}
+		if len(channelsByName[name]) == 1 {
+			existing = channelsByName[name][0]
+			change.Id = existing.Id
+			if existingMap, err = toConfigMap(newChannelExportItem(existing)); err != nil {
+				return nil, err
+			}
+		}
+		merged := mergeConfigValues(existingMap, declared)
+		item := channelExportItem{}
+		if err := fromConfigMap(merged, channelStringFields, &item); err != nil {
+			change.Action, change.Error = "error", err.Error()
+			addChange(change)
+			continue
+		}
+
+		var channel *model.Channel
+		if existing == nil {
+			if key == "" {
+				change.Action, change.Error = "error", "新建渠道缺少密钥"
+				addChange(change)
+				continue
+			}
+			change.Action = "create"
+			channel = &model.Channel{CreatedTime: common.GetTimestamp()}
+		} else {
+			change.Changes = declaredChanges(existingMap, merged, declared)
+			if key != "" && key != existing.Key {
+				change.Changes = append(change.Changes, "key")
+			}
+			if len(change.Changes) == 0 {
+				plan.Unchanged++
+				continue
+			}
+			change.Action = "update"
+			updated := *existing
+			channel = &updated
+		}
+		item.applyTo(channel, key)
+		if channel.ChannelInfo.IsMultiKey {
+			channel.Keys = nil
+			channel.ChannelInfo.MultiKeySize = len(channel.GetKeys())
+		}
+		if err := validateChannel(channel, existing == nil); err != nil {
+			change.Action, change.Error = "error", err.Error()
+			addChange(change)
+			continue
+		}
+		addChange(change)
+		if existing == nil {
+			creates = append(creates, channel)
+		} else {
+			updates = append(updates, channel)
+		}
+	}
+
+	configState.Lock()
+	configState.managedOptions = managedOptions
+	configState.managedModels = managedModels
+	configState.managedChannels = managedChannels
+	configState.loadedAt = common.GetTimestamp()
+	configState.lastError = ""
+	configState.lastPlan = plan
+	configState.Unlock()
+
+	if !apply {
+		return plan, nil
+	}
+
+	for _, key := range optionKeys {
+		value, ok := optionUpdates[key]
+		if !ok {
+			continue
+		}
+		if err := model.UpdateOption(key, value); err != nil {
+			return plan, fmt.Errorf("更新选项 %s 失败: %w", key, err)
+		}
+	}
+	for _, update := range modelUpdates {
+		if err := update.apply(); err != nil {
+			return plan, fmt.Errorf("更新模型 %s 失败: %w", update.model.ModelName, err)
+		}
+	}
+	if len(modelUpdates) > 0 {
+		model.RefreshPricing()
+	}
+	if len(creates) > 0 || len(updates) > 0 {
+		if err := model.ImportChannels(creates, updates); err != nil {
+			return plan, fmt.Errorf("更新渠道失败: %w", err)
+		}
+		for _, channel := range updates {
+			model.ResetMultiKeyUsage(channel.Id)
+			service.ResetChannelBreakers(channel.Id)
+		}
+		// 回填新建渠道的 ID
+		createIdx := 0
+		for i := range plan.Changes {
+			if plan.Changes[i].Kind == "channel" && plan.Changes[i].Action == "create" {
+				plan.Changes[i].Id = creates[createIdx].Id
+				createIdx++
+			}
+		}
+		if common.MemoryCacheEnabled {
+			model.InitChannelCache()
+		}
+	}
+
+	plan.Applied = true
+	configState.Lock()
+	configState.appliedAt = common.GetTimestamp()
+	configState.drifts = nil
+	configState.Unlock()
+	return plan, nil
+}
+
+type configModelChange struct {
+	model    *model.Model
+	isCreate bool
+}
+
+func (change configModelChange) apply() error {
+	if change.isCreate {
+		return change.model.Insert()
+	}
+	return change.model.Update()
+}
+
+func logConfigPlan(plan *configPlan) {
+	for _, change := range plan.Changes {
+		line := fmt.Sprintf("config file: %s %s %s", change.Action, change.Kind, change.Name)
+		if len(change.Changes) > 0 {
+			line += " (" + strings.Join(change.Changes, ", ") + ")"
+		}
+		if change.Error != "" {
+			common.SysError(line + ": " + change.Error)
+			continue
+		}
+		common.SysLog(line)
+	}
+	common.SysLog(fmt.Sprintf("config file: %d changes, %d unchanged, %d errors, applied: %t",
+		len(plan.Changes)-plan.Errors, plan.Unchanged, plan.Errors, plan.Applied))
+}
+
+// ReloadConfigFile 读取配置文件并同步到数据库；从节点只加载管理范围，由主节点负责写入
+func ReloadConfigFile() error {
+	if configFilePath() == "" {
+		return nil
+	}
+	plan, err := reconcileConfigFile(common.IsMasterNode)
+	if err != nil {
+		common.SysError("failed to apply config file: " + err.Error())
+		if plan == nil {
+			return err
+		}
+	}
+	logConfigPlan(plan)
+	return err
+}
+
+// WatchConfigFileReload 收到 SIGHUP 时重新同步配置文件
+func WatchConfigFileReload() {
+	if configFilePath() == "" {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		common.SysLog("received SIGHUP, reloading config file")
+		_ = ReloadConfigFile()
+	}
+}
+
+// checkConfigManaged 检查对象是否由配置文件管理：reject 模式下拒绝修改并返回 false，flag 模式下记录漂移
+func checkConfigManaged(c *gin.Context, kind string, name string) bool {
+	configState.Lock()
+	defer configState.Unlock()
+	var managed bool
+	switch kind {
+	case "option":
+		managed = configState.managedOptions[name]
+	case "model":
+		managed = configState.managedModels[name]
+	case "channel":
+		managed = configState.managedChannels[name]
+	}
+	if !managed {
+		return true
+	}
+	if configOwnershipMode() == configOwnershipReject {
+		common.ApiErrorMsg(c, fmt.Sprintf("%s 由配置文件管理，请修改配置文件后重新加载", name))
+		return false
+	}
+	configState.drifts = append(configState.drifts, configDrift{Kind: kind, Name: name, Time: common.GetTimestamp()})
+	common.SysLog(fmt.Sprintf("config file: managed %s %s edited outside the config file", kind, name))
+	return true
+}
+
+// checkConfigManagedChannels 按渠道 ID 检查是否由配置文件管理
+func checkConfigManagedChannels(c *gin.Context, ids ...int) bool {
+	configState.RLock()
+	hasManaged := len(configState.managedChannels) > 0
+	configState.RUnlock()
+	if !hasManaged {
+		return true
+	}
+	for _, id := range ids {
+		channel, err := model.GetChannelById(id, false)
+		if err != nil {
+			continue
+		}
+		if !checkConfigManaged(c, "channel", channel.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConfigManagedChannelsByTag 检查按标签批量修改时涉及的渠道是否由配置文件管理
+func checkConfigManagedChannelsByTag(c *gin.Context, tag string) bool {
+	channels, err := model.GetChannelsByTag(tag, false, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return false
+	}
+	return checkConfigManagedChannelList(c, channels)
+}
+
+// checkConfigManagedDisabledChannels 检查删除已禁用渠道时涉及的渠道是否由配置文件管理
+func checkConfigManagedDisabledChannels(c *gin.Context) bool {
+	channels, err := model.GetDisabledChannels()
+	if err != nil {
+		common.ApiError(c, err)
+		return false
+	}
+	return checkConfigManagedChannelList(c, channels)
+}
+
+func checkConfigManagedChannelList(c *gin.Context, channels []*model.Channel) bool {
+	for _, channel := range channels {
+		if !checkConfigManaged(c, "channel", channel.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConfigChannelNameAvailable 新建或改名的渠道不能与配置文件管理的渠道同名，否则下次加载配置时无法按名称匹配
+func checkConfigChannelNameAvailable(c *gin.Context, name string) bool {
+	configState.RLock()
+	managed := configState.managedChannels[name]
+	configState.RUnlock()
+	if managed {
+		common.ApiErrorMsg(c, fmt.Sprintf("渠道名称 %s 已被配置文件管理的渠道使用", name))
+		return false
+	}
+	return true
+}
+
+// checkConfigManagedModel 按模型 ID 检查是否由配置文件管理
+func checkConfigManagedModel(c *gin.Context, id int) bool {
+	existing := &model.Model{}
+	if err := model.DB.First(existing, id).Error; err != nil {
+		return true
+	}
+	return checkConfigManaged(c, "model", existing.ModelName)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetConfigFileStatus 返回配置文件的同步状态、管理范围与漂移记录
+func GetConfigFileStatus(c *gin.Context) {
+	configState.RLock()
+	defer configState.RUnlock()
+	common.ApiSuccess(c, gin.H{
+		"file":             configFilePath(),
+		"ownership":        configOwnershipMode(),
+		"loaded_at":        configState.loadedAt,
+		"applied_at":       configState.appliedAt,
+		"last_error":       configState.lastError,
+		"last_plan":        configState.lastPlan,
+		"managed_options":  sortedKeys(configState.managedOptions),
+		"managed_models":   sortedKeys(configState.managedModels),
+		"managed_channels": sortedKeys(configState.managedChannels),
+		"drifts":           configState.drifts,
+	})
+}
+
+// PlanConfigFile 预览配置文件将产生的变更
+func PlanConfigFile(c *gin.Context) {
+	plan, err := reconcileConfigFile(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+// ApplyConfigFile 立即同步配置文件
+func ApplyConfigFile(c *gin.Context) {
+	if !common.IsMasterNode {
+		common.ApiErrorMsg(c, "请在主节点上同步配置文件")
+		return
+	}
+	plan, err := reconcileConfigFile(true)
+	if plan != nil {
+		logConfigPlan(plan)
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+// configExportValue 将选项值还原为配置文件中的类型
+func configExportValue(value string) any {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "true" || trimmed == "false" || strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var decoded any
+		if err := common.Unmarshal([]byte(trimmed), &decoded); err == nil {
+			return decoded
+		}
+	}
+	if number, err := strconv.ParseFloat(trimmed, 64); err == nil && strconv.FormatFloat(number, 'f', -1, 64) == trimmed {
+		return number
+	}
+	return value
+}
+
+// ExportConfigFile 以配置文件格式导出当前状态，渠道密钥仅导出外部引用
+func ExportConfigFile(c *gin.Context) {
+	doc := gin.H{"version": configFileVersion}
+
+	common.OptionMapRWMutex.RLock()
+	optionMap := make(map[string]string, len(common.OptionMap))
+	for key, value := range common.OptionMap {
+		optionMap[key] = value
+	}
+	common.OptionMapRWMutex.RUnlock()
+
+	sections := map[string]string{
+		"GroupRatio":       "group_ratios",
+		"UserUsableGroups": "user_usable_groups",
+		"ModelRatio":       "model_ratios",
+		"CompletionRatio":  "completion_ratios",
+		"ModelPrice":       "model_prices",
+	}
+	for optionKey, field := range sections {
+		doc[field] = configExportValue(optionMap[optionKey])
+	}
+	doc["rate_limit"] = gin.H{
+		"enabled":          configExportValue(optionMap["ModelRequestRateLimitEnabled"]),
+		"duration_minutes": configExportValue(optionMap["ModelRequestRateLimitDurationMinutes"]),
+		"count":            configExportValue(optionMap["ModelRequestRateLimitCount"]),
+		"success_count":    configExportValue(optionMap["ModelRequestRateLimitSuccessCount"]),
+		"groups":           configExportValue(optionMap["ModelRequestRateLimitGroup"]),
+	}
+
+	settings := make(map[string]map[string]any)
+	for key, value := range config.GlobalConfig.ExportAllConfigs() {
+		if isSensitiveOptionKey(key) {
+			continue
+		}
+		name, field, _ := strings.Cut(key, ".")
+		if settings[name] == nil {
+			settings[name] = make(map[string]any)
+		}
+		if current, ok := optionMap[key]; ok {
+			value = current
+		}
+		settings[name][field] = configExportValue(value)
+	}
+	doc["settings"] = settings
+
+	options := make(map[string]any)
+	for key, value := range optionMap {
+		if _, ok := sections[key]; ok || strings.Contains(key, ".") || strings.HasPrefix(key, "ModelRequestRateLimit") || isSensitiveOptionKey(key) {
+			continue
+		}
+		options[key] = configExportValue(value)
+	}
+	doc["options"] = options
+
+	var models []*model.Model
+	if err := model.DB.Order("model_name").Find(&models).Error; err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	modelItems := make([]map[string]any, 0, len(models))
+	for _, m := range models {
+		item, err := toConfigMap(m)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		delete(item, "id")
+		delete(item, "created_time")
+		delete(item, "updated_time")
+		modelItems = append(modelItems, item)
+	}
+	doc["models"] = modelItems
+
+	channels, err := model.GetAllChannels(0, 0, true, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	channelItems := make([]map[string]any, 0, len(channels))
+	for _, channel := range channels {
+		item, err := toConfigMap(newChannelExportItem(channel))
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if isSecretRefKey(channel.Key) {
+			item["key"] = strings.TrimSpace(channel.Key)
+		}
+		channelItems = append(channelItems, item)
+	}
+	doc["channels"] = channelItems
+
+	data, err := common.Marshal(doc)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	contentType := "application/json"
+	if c.Query("format") != "json" {
+		var generic any
+		if err := common.Unmarshal(data, &generic); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if data, err = yaml.Marshal(generic); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		contentType = "application/yaml"
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// isSecretRefKey 渠道密钥是否全部为外部引用，明文密钥不会导出
+func isSecretRefKey(key string) bool {
+	lines := strings.Split(strings.TrimSpace(key), "\n")
+	for _, line := range lines {
+		if !common.IsSecretRef(line) {
+			return false
+		}
+	}
+	return len(lines) > 0 && lines[0] != ""
+}
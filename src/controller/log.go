@@ -148,6 +148,31 @@ func GetLogsSelfStat(c *gin.Context) {
 	return
 }
 
+// GetMarginReport 按渠道、模型、分组、用户或天统计计费额度、上游成本与利润
+func GetMarginReport(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "channel")
+	if !model.IsValidMarginReportDimension(groupBy) {
+		common.ApiErrorMsg(c, "group_by 只能为 channel、model、group、user 或 day")
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	username := c.Query("username")
+	modelName := c.Query("model_name")
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	group := c.Query("group")
+	items, total, err := model.GetMarginReport(groupBy, startTimestamp, endTimestamp, modelName, username, channel, group)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"group_by": groupBy,
+		"items":    items,
+		"total":    total,
+	})
+}
+
 func DeleteHistoryLogs(c *gin.Context) {
 	targetTimestamp, _ := strconv.ParseInt(c.Query("target_timestamp"), 10, 64)
 	if targetTimestamp == 0 {
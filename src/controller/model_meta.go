@@ -122,6 +122,9 @@ func UpdateModelMeta(c *gin.Context) {
 		common.ApiErrorMsg(c, "缺少模型 ID")
 		return
 	}
+	if !checkConfigManagedModel(c, m.Id) {
+		return
+	}
 
 	if statusOnly {
 		// 只更新状态，防止误清空其他字段
@@ -160,6 +163,9 @@ func DeleteModelMeta(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if !checkConfigManagedModel(c, id) {
+		return
+	}
 	if err := model.DB.Delete(&model.Model{}, id).Error; err != nil {
 		common.ApiError(c, err)
 		return
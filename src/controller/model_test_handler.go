@@ -11,6 +11,7 @@ import (
 	"strconv"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/gin-gonic/gin"
 )
@@ -57,7 +58,6 @@ func ProxyModelTestResponses(c *gin.Context) {
 
 func ProxyModelTestMessages(c *gin.Context) {
 	proxyModelTest(c, "/v1/messages", func(req *http.Request, token *model.Token) {
-		req.Header.Set("x-api-key", "sk-"+token.Key)
 		if req.Header.Get("anthropic-version") == "" {
 			req.Header.Set("anthropic-version", "2023-06-01")
 		}
@@ -94,7 +94,13 @@ func proxyModelTest(c *gin.Context, targetPath string, headerSetter proxyHeaderS
 		return
 	}
 	upstreamReq.Header.Set("Content-Type", "application/json")
-	upstreamReq.Header.Set("Authorization", "Bearer sk-"+token.Key)
+	// 令牌只保存哈希，本机转发时按令牌 ID 认证
+	releaseInternalAuth, err := middleware.SetInternalTokenAuth(upstreamReq, token.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	defer releaseInternalAuth()
 	upstreamReq.Header.Set("Accept", "text/event-stream")
 	upstreamReq.Header.Set("Cache-Control", "no-cache")
 	if headerSetter != nil {
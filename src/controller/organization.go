@@ -0,0 +1,454 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// 组织邀请有效期
+	organizationInvitationValidDuration = 7 * 24 * time.Hour
+	maxOrganizationNameLength           = 128
+)
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+type organizationMemberRequest struct {
+	UserId   int    `json:"user_id"`
+	Role     string `json:"role"`
+	QuotaCap int    `json:"quota_cap"`
+}
+
+type organizationInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type acceptOrganizationInvitationRequest struct {
+	Code string `json:"code"`
+}
+
+type organizationQuotaRequest struct {
+	Quota int `json:"quota"`
+}
+
+// getSelfOrganizationMember 返回当前用户的组织成员记录
+func getSelfOrganizationMember(c *gin.Context) (*model.OrganizationMember, error) {
+	member, err := model.GetOrganizationMemberByUserId(c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("你尚未加入任何组织")
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+// getSelfOrganizationManager 返回当前用户的组织成员记录，并要求其为所有者或管理员
+func getSelfOrganizationManager(c *gin.Context) (*model.OrganizationMember, error) {
+	member, err := getSelfOrganizationMember(c)
+	if err != nil {
+		return nil, err
+	}
+	if !member.CanManage() {
+		return nil, errors.New("只有组织所有者或管理员可以进行此操作")
+	}
+	return member, nil
+}
+
+func checkOrganizationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("组织名称不能为空")
+	}
+	if len(name) > maxOrganizationNameLength {
+		return "", fmt.Errorf("组织名称不能超过 %d 个字符", maxOrganizationNameLength)
+	}
+	return name, nil
+}
+
+// checkGrantOrganizationRole 校验操作者是否可以授予指定角色，所有者角色不可授予，管理员角色只能由所有者授予
+func checkGrantOrganizationRole(operator *model.OrganizationMember, role string) error {
+	if !model.IsValidOrganizationRole(role) || role == model.OrganizationRoleOwner {
+		return errors.New("无效的成员角色")
+	}
+	if role == model.OrganizationRoleAdmin && operator.Role != model.OrganizationRoleOwner {
+		return errors.New("只有组织所有者可以设置管理员")
+	}
+	return nil
+}
+
+// checkManageOrganizationMember 校验操作者是否可以管理目标成员，管理员只能管理普通成员
+func checkManageOrganizationMember(operator *model.OrganizationMember, target *model.OrganizationMember) error {
+	if target.Role == model.OrganizationRoleOwner {
+		return errors.New("不能修改组织所有者")
+	}
+	if target.Role == model.OrganizationRoleAdmin && operator.Role != model.OrganizationRoleOwner {
+		return errors.New("只有组织所有者可以管理管理员")
+	}
+	return nil
+}
+
+// checkTokenOrganization 校验令牌绑定的组织，用户必须是该组织的成员
+func checkTokenOrganization(token *model.Token, userId int) error {
+	if token.OrganizationId == 0 {
+		return nil
+	}
+	if _, err := model.GetOrganizationMember(token.OrganizationId, userId); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("你不是该组织的成员")
+		}
+		return err
+	}
+	return nil
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	name, err := checkOrganizationName(req.Name)
+	if err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	org, err := model.CreateOrganization(name, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func GetSelfOrganization(c *gin.Context) {
+	member, err := getSelfOrganizationMember(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"organization": org,
+		"member":       member,
+	})
+}
+
+func UpdateSelfOrganization(c *gin.Context) {
+	member, err := getSelfOrganizationManager(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	name, err := checkOrganizationName(req.Name)
+	if err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	org := &model.Organization{Id: member.OrganizationId, Name: name}
+	if err := org.UpdateName(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationMembers(c *gin.Context) {
+	member, err := getSelfOrganizationMember(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	members, err := model.GetOrganizationMembers(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	operator, err := getSelfOrganizationManager(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.QuotaCap < 0 {
+		common.ApiErrorMsg(c, "成员额度上限不能为负数")
+		return
+	}
+	target, err := model.GetOrganizationMember(operator.OrganizationId, req.UserId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkManageOrganizationMember(operator, target); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if err := checkGrantOrganizationRole(operator, req.Role); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	target.Role = req.Role
+	target.QuotaCap = req.QuotaCap
+	if err := target.UpdateRoleAndCap(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, target)
+}
+
+// RemoveOrganizationMember 移除组织成员，成员也可以通过此接口退出组织（所有者除外）
+func RemoveOrganizationMember(c *gin.Context) {
+	userId, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	operator, err := getSelfOrganizationMember(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if userId != operator.UserId {
+		if !operator.CanManage() {
+			common.ApiErrorMsg(c, "只有组织所有者或管理员可以进行此操作")
+			return
+		}
+		target, err := model.GetOrganizationMember(operator.OrganizationId, userId)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if err := checkManageOrganizationMember(operator, target); err != nil {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+	}
+	if err := model.RemoveOrganizationMember(operator.OrganizationId, userId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetOrganizationInvitations(c *gin.Context) {
+	member, err := getSelfOrganizationManager(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	invitations, err := model.GetOrganizationInvitations(member.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, invitations)
+}
+
+func CreateOrganizationInvitation(c *gin.Context) {
+	operator, err := getSelfOrganizationManager(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req organizationInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleMember
+	}
+	if err := checkGrantOrganizationRole(operator, req.Role); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if err := common.Validate.Var(req.Email, "required,email"); err != nil {
+		common.ApiErrorMsg(c, "无效的邮箱地址")
+		return
+	}
+	org, err := model.GetOrganizationById(operator.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	invitation := &model.OrganizationInvitation{
+		OrganizationId: operator.OrganizationId,
+		Email:          req.Email,
+		Role:           req.Role,
+		Code:           common.GetRandomString(32),
+		InviterId:      operator.UserId,
+		Status:         model.OrganizationInvitationPending,
+		ExpiredTime:    time.Now().Add(organizationInvitationValidDuration).Unix(),
+		CreatedTime:    common.GetTimestamp(),
+	}
+	if err := model.CreateOrganizationInvitation(invitation); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	link := fmt.Sprintf("%s/console/organization?invitation=%s", system_setting.ServerAddress, invitation.Code)
+	subject := fmt.Sprintf("%s组织邀请", common.SystemName)
+	content := fmt.Sprintf("<p>您好，你被邀请加入%s上的组织「%s」。</p>"+
+		"<p>点击 <a href='%s'>此处</a> 登录后接受邀请。</p>"+
+		"<p>如果链接无法点击，请尝试点击下面的链接或将其复制到浏览器中打开：<br> %s </p>"+
+		"<p>邀请 %d 天内有效，如果你不认识邀请人，请忽略。</p>",
+		common.SystemName, org.Name, link, link, int(organizationInvitationValidDuration.Hours()/24))
+	if err := common.SendEmail(subject, invitation.Email, content); err != nil {
+		// 邀请已创建，邮件发送失败时仍返回邀请码，由管理员自行转发
+		logger.LogError(c, fmt.Sprintf("failed to send organization invitation email to %s: %s", invitation.Email, err.Error()))
+		common.ApiSuccess(c, gin.H{
+			"invitation": invitation,
+			"code":       invitation.Code,
+			"email_sent": false,
+		})
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"invitation": invitation,
+		"email_sent": true,
+	})
+}
+
+func RevokeOrganizationInvitation(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	member, err := getSelfOrganizationManager(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.RevokeOrganizationInvitation(member.OrganizationId, id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func AcceptOrganizationInvitation(c *gin.Context) {
+	var req acceptOrganizationInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Code == "" {
+		common.ApiErrorMsg(c, "邀请码不能为空")
+		return
+	}
+	userId := c.GetInt("id")
+	email, err := model.GetUserEmail(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if email == "" {
+		common.ApiErrorMsg(c, "请先绑定邮箱后再接受邀请")
+		return
+	}
+	member, err := model.AcceptOrganizationInvitation(req.Code, userId, email)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, member)
+}
+
+// GetOrganizationUsage 按成员、模型、令牌或日期汇总组织用量，普通成员只能查看自己的用量
+func GetOrganizationUsage(c *gin.Context) {
+	member, err := getSelfOrganizationMember(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	groupBy := c.DefaultQuery("group_by", "user")
+	if !model.IsValidOrganizationUsageDimension(groupBy) {
+		common.ApiErrorMsg(c, "无效的汇总维度")
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	userId := 0
+	if !member.CanManage() {
+		userId = member.UserId
+	}
+	items, err := model.GetOrganizationUsage(member.OrganizationId, groupBy, startTimestamp, endTimestamp, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, items)
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	orgs, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(orgs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AdjustOrganizationQuota 管理员调整组织额度池，正数为充值，负数为扣减
+func AdjustOrganizationQuota(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req organizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Quota == 0 {
+		common.ApiErrorMsg(c, "调整额度不能为 0")
+		return
+	}
+	org, err := model.GetOrganizationById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.AdjustOrganizationQuota(org.Id, req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage,
+		fmt.Sprintf("管理员调整组织 %s（ID %d）额度池 %s", org.Name, org.Id, logger.LogQuota(req.Quota)))
+	common.ApiSuccess(c, nil)
+}
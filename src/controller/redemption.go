@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 单次批量生成兑换码的数量上限
+const maxRedemptionBatchCount = 100
+
+type addRedemptionRequest struct {
+	Name        string `json:"name"`
+	Quota       int    `json:"quota"`
+	Count       int    `json:"count"`
+	MaxUses     int    `json:"max_uses"`
+	ExpiredTime int64  `json:"expired_time"`
+}
+
+type updateRedemptionStatusRequest struct {
+	Id     int `json:"id"`
+	Status int `json:"status"`
+}
+
+type redeemRequest struct {
+	Key string `json:"key"`
+}
+
+func GetAllRedemptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	redemptions, total, err := model.GetAllRedemptions(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(redemptions)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func SearchRedemptions(c *gin.Context) {
+	keyword := c.Query("keyword")
+	pageInfo := common.GetPageQuery(c)
+	redemptions, total, err := model.SearchRedemptions(keyword, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(redemptions)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetRedemption(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	redemption, err := model.GetRedemptionById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, redemption)
+}
+
+// AddRedemption 批量生成兑换码，返回生成的兑换码
+func AddRedemption(c *gin.Context) {
+	var req addRedemptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if nameLen := utf8.RuneCountInString(req.Name); nameLen == 0 || nameLen > 20 {
+		common.ApiErrorI18n(c, i18n.MsgRedemptionNameLength)
+		return
+	}
+	if req.Count <= 0 {
+		common.ApiErrorI18n(c, i18n.MsgRedemptionCountPositive)
+		return
+	}
+	if req.Count > maxRedemptionBatchCount {
+		common.ApiErrorI18n(c, i18n.MsgRedemptionCountMax)
+		return
+	}
+	if req.Quota <= 0 {
+		common.ApiErrorI18n(c, i18n.MsgRedemptionQuotaPositive)
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		common.ApiErrorI18n(c, i18n.MsgRedemptionMaxUsesPositive)
+		return
+	}
+	if req.ExpiredTime != 0 && req.ExpiredTime < common.GetTimestamp() {
+		common.ApiErrorI18n(c, i18n.MsgRedemptionExpireTimeInvalid)
+		return
+	}
+	now := common.GetTimestamp()
+	redemptions := make([]*model.Redemption, 0, req.Count)
+	keys := make([]string, 0, req.Count)
+	for i := 0; i < req.Count; i++ {
+		key := common.GetUUID()
+		redemptions = append(redemptions, &model.Redemption{
+			UserId:      c.GetInt("id"),
+			Name:        req.Name,
+			Key:         key,
+			Status:      common.RedemptionCodeStatusEnabled,
+			Quota:       req.Quota,
+			MaxUses:     req.MaxUses,
+			CreatedTime: now,
+			ExpiredTime: req.ExpiredTime,
+		})
+		keys = append(keys, key)
+	}
+	if err := model.InsertRedemptions(redemptions); err != nil {
+		common.SysError("failed to insert redemptions: " + err.Error())
+		common.ApiErrorI18n(c, i18n.MsgRedemptionCreateFailed)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("生成兑换码 %s %d 个，每个 %s，可兑换 %d 次",
+		req.Name, req.Count, logger.LogQuota(req.Quota), req.MaxUses))
+	common.ApiSuccess(c, keys)
+}
+
+// UpdateRedemptionStatus 吊销或重新启用兑换码
+func UpdateRedemptionStatus(c *gin.Context) {
+	var req updateRedemptionStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Status != common.RedemptionCodeStatusEnabled && req.Status != common.RedemptionCodeStatusDisabled {
+		common.ApiErrorMsg(c, "无效的兑换码状态")
+		return
+	}
+	redemption, err := model.GetRedemptionById(req.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := redemption.UpdateStatus(req.Status); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, redemption)
+}
+
+func DeleteInvalidRedemptions(c *gin.Context) {
+	rows, err := model.DeleteInvalidRedemptions()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, rows)
+}
+
+// ExportRedemptions 以 CSV 导出兑换码，默认只导出仍可兑换的兑换码
+func ExportRedemptions(c *gin.Context) {
+	name := c.Query("name")
+	onlyUsable := c.Query("all") != "true"
+	redemptions, err := model.GetRedemptionsForExport(name, onlyUsable)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	filename := fmt.Sprintf("redemptions-%s.csv", time.Now().Format("20060102150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"id", "name", "key", "quota", "status", "max_uses", "used_count", "created_time", "expired_time"})
+	for _, redemption := range redemptions {
+		_ = w.Write([]string{
+			strconv.Itoa(redemption.Id),
+			common.CSVSafeCell(redemption.Name),
+			common.CSVSafeCell(redemption.Key),
+			strconv.Itoa(redemption.Quota),
+			strconv.Itoa(redemption.Status),
+			strconv.Itoa(redemption.MaxUses),
+			strconv.Itoa(redemption.UsedCount),
+			strconv.FormatInt(redemption.CreatedTime, 10),
+			strconv.FormatInt(redemption.ExpiredTime, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logger.LogError(c, "failed to export redemptions: "+err.Error())
+	}
+}
+
+// Redeem 用户兑换兑换码
+func Redeem(c *gin.Context) {
+	var req redeemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	quota, err := model.Redeem(req.Key, c.GetInt("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRedemptionNotProvided):
+			common.ApiErrorI18n(c, i18n.MsgRedemptionNotProvided)
+		case errors.Is(err, model.ErrRedemptionInvalid):
+			common.ApiErrorI18n(c, i18n.MsgRedemptionInvalid)
+		case errors.Is(err, model.ErrRedemptionUsed):
+			common.ApiErrorI18n(c, i18n.MsgRedemptionUsed)
+		case errors.Is(err, model.ErrRedemptionExpired):
+			common.ApiErrorI18n(c, i18n.MsgRedemptionExpired)
+		case errors.Is(err, model.ErrRedemptionAlreadyRedeemed):
+			common.ApiErrorI18n(c, i18n.MsgRedemptionAlreadyRedeemed)
+		default:
+			common.SysError("failed to redeem: " + err.Error())
+			common.ApiErrorI18n(c, i18n.MsgRedemptionFailed)
+		}
+		return
+	}
+	common.ApiSuccess(c, quota)
+}
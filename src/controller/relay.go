@@ -169,7 +169,7 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		// Only return quota if downstream failed and quota was actually pre-consumed
 		if newAPIError != nil {
 			newAPIError = service.NormalizeViolationFeeError(newAPIError)
-			if relayInfo.FinalPreConsumedQuota != 0 {
+			if relayInfo.FinalPreConsumedQuota != 0 || relayInfo.SubscriptionPreConsumed != 0 {
 				service.ReturnPreConsumedQuota(c, relayInfo)
 			}
 			service.ChargeViolationFeeIfNeeded(c, relayInfo, newAPIError)
@@ -204,6 +204,8 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
+		attemptStart := time.Now()
+		service.ChannelStatsBegin(channel.Id)
 		switch relayFormat {
 		case types.RelayFormatOpenAIRealtime:
 			newAPIError = relay.WssHelper(c, relayInfo)
@@ -214,6 +216,13 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		default:
 			newAPIError = relayHandler(c, relayInfo)
 		}
+		service.ChannelStatsEnd(channel.Id, !service.IsChannelFaultError(newAPIError), attemptLatency(relayInfo, attemptStart, newAPIError))
+		service.RecordChannelBreakerResult(c, channel.Id, newAPIError)
+		service.RecordChannelHealth(c, relayInfo, channel.Id, attemptStart, newAPIError)
+		if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+			model.RecordMultiKeyResult(channel.Id, common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex), !service.IsChannelFaultError(newAPIError))
+		}
+		service.ReleaseChannelConcurrency(c)
 
 		if newAPIError == nil {
 			return
@@ -242,6 +251,18 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// attemptLatency returns the time to first response of the current attempt, falling back to
+// the whole attempt duration for non-streaming requests. Failed attempts report no latency.
+func attemptLatency(info *relaycommon.RelayInfo, attemptStart time.Time, err *types.NewAPIError) time.Duration {
+	if err != nil {
+		return 0
+	}
+	if info.FirstResponseTime.After(attemptStart) {
+		return info.FirstResponseTime.Sub(attemptStart)
+	}
+	return time.Since(attemptStart)
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -372,6 +393,7 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 			adminInfo["multi_key_index"] = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
 		}
 		service.AppendChannelAffinityAdminInfo(c, adminInfo)
+		service.AppendChannelSelectAdminInfo(c, adminInfo)
 		other["admin_info"] = adminInfo
 		startTime := common.GetContextKeyTime(c, constant.ContextKeyRequestStartTime)
 		if startTime.IsZero() {
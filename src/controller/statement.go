@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// writeStatement 按 format 参数输出账单：json（默认）、csv 或 pdf
+func writeStatement(c *gin.Context, statement *service.Statement) {
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		common.ApiSuccess(c, statement)
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", statement.Filename("csv")))
+		if err := statement.WriteCSV(c.Writer); err != nil {
+			logger.LogError(c, "failed to export statement: "+err.Error())
+		}
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", statement.Filename("pdf")))
+		c.Data(http.StatusOK, "application/pdf", statement.PDF())
+	default:
+		common.ApiErrorMsg(c, "不支持的账单格式")
+	}
+}
+
+// GetSelfStatement 获取当前用户指定月份（默认当月）的账单，
+// 管理员可通过 user_id 或 organization_id 为任意用户或组织生成账单
+func GetSelfStatement(c *gin.Context) {
+	if c.Query("user_id") != "" || c.Query("organization_id") != "" {
+		if c.GetInt("role") < common.RoleAdminUser {
+			common.ApiErrorI18n(c, i18n.MsgForbidden)
+			return
+		}
+		getStatement(c)
+		return
+	}
+	statement, err := service.BuildUserStatement(c.GetInt("id"), c.Query("month"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	writeStatement(c, statement)
+}
+
+// getStatement 管理员为任意用户（user_id）或组织（organization_id）生成账单
+func getStatement(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	orgId, _ := strconv.Atoi(c.Query("organization_id"))
+	if (userId == 0) == (orgId == 0) {
+		common.ApiErrorMsg(c, "请指定 user_id 或 organization_id 其中之一")
+		return
+	}
+	var statement *service.Statement
+	var err error
+	if userId != 0 {
+		statement, err = service.BuildUserStatement(userId, c.Query("month"))
+	} else {
+		statement, err = service.BuildOrganizationStatement(orgId, c.Query("month"))
+	}
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	writeStatement(c, statement)
+}
@@ -0,0 +1,359 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 自动续费检查间隔
+	subscriptionRenewalInterval = 10 * time.Minute
+	// 每轮自动续费处理的订阅数上限
+	subscriptionRenewalBatchSize = 100
+)
+
+type subscribeRequest struct {
+	PlanId    int  `json:"plan_id"`
+	AutoRenew bool `json:"auto_renew"`
+}
+
+type updateSubscriptionRequest struct {
+	AutoRenew bool `json:"auto_renew"`
+}
+
+type grantSubscriptionRequest struct {
+	UserId int `json:"user_id"`
+	PlanId int `json:"plan_id"`
+}
+
+// subscriptionStatus 用户订阅状态，附带当前周期的剩余额度
+type subscriptionStatus struct {
+	*model.UserSubscription
+	Usable         bool             `json:"usable"`
+	Remaining      int64            `json:"remaining"`
+	NextResetTime  int64            `json:"next_reset_time"`
+	ModelRemaining map[string]int64 `json:"model_remaining,omitempty"`
+}
+
+func newSubscriptionStatus(sub *model.UserSubscription, now int64) *subscriptionStatus {
+	status := &subscriptionStatus{
+		UserSubscription: sub,
+		Usable:           sub.IsUsable(now),
+		Remaining:        max(sub.AmountTotal-sub.AmountUsed, 0),
+	}
+	if sub.Plan == nil {
+		return status
+	}
+	status.NextResetTime = sub.NextResetTime(sub.Plan)
+	allowances := sub.Plan.GetModelAllowances()
+	if len(allowances) > 0 {
+		usage := sub.GetModelUsage()
+		status.ModelRemaining = make(map[string]int64, len(allowances))
+		for modelName, allowance := range allowances {
+			status.ModelRemaining[modelName] = max(allowance-usage[modelName], 0)
+		}
+	}
+	return status
+}
+
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+// GetSelfSubscriptions 返回当前用户的订阅状态与扣费策略
+func GetSelfSubscriptions(c *gin.Context) {
+	userId := c.GetInt("id")
+	subs, err := model.GetUserSubscriptions(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userCache, err := model.GetUserCache(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	now := common.GetTimestamp()
+	items := make([]*subscriptionStatus, 0, len(subs))
+	for _, sub := range subs {
+		items = append(items, newSubscriptionStatus(sub, now))
+	}
+	common.ApiSuccess(c, gin.H{
+		"billing_preference": common.NormalizeBillingPreference(userCache.GetSetting().BillingPreference),
+		"subscriptions":      items,
+	})
+}
+
+func Subscribe(c *gin.Context) {
+	var req subscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !plan.Enabled {
+		common.ApiErrorMsg(c, "该套餐已停售")
+		return
+	}
+	userId := c.GetInt("id")
+	sub, err := model.SubscribePlan(userId, plan, plan.PriceQuota, req.AutoRenew)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("订阅套餐 %s，花费 %s", plan.Title, logger.LogQuota(plan.PriceQuota)))
+	common.ApiSuccess(c, newSubscriptionStatus(sub, common.GetTimestamp()))
+}
+
+func RenewSelfSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userId := c.GetInt("id")
+	sub, err := model.GetUserSubscriptionById(id, userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !plan.Enabled {
+		common.ApiErrorMsg(c, "该套餐已停售")
+		return
+	}
+	if err := model.RenewUserSubscription(sub, plan, plan.PriceQuota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("续费套餐 %s，花费 %s", plan.Title, logger.LogQuota(plan.PriceQuota)))
+	sub.Plan = plan
+	common.ApiSuccess(c, newSubscriptionStatus(sub, common.GetTimestamp()))
+}
+
+// UpdateSelfSubscription 修改自动续费设置
+func UpdateSelfSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req updateSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.GetUserSubscriptionById(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub.Status != model.UserSubscriptionStatusActive {
+		common.ApiErrorMsg(c, "订阅已取消")
+		return
+	}
+	sub.AutoRenew = req.AutoRenew
+	if err := sub.UpdateAutoRenew(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := plan.Validate(); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	plan.Id = 0
+	if err := plan.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetSubscriptionPlanById(plan.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := plan.Validate(); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if err := plan.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteSubscriptionPlan(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func GetAllUserSubscriptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	subs, total, err := model.GetAllUserSubscriptions(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(subs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GrantSubscription 管理员为用户开通套餐，不扣除钱包额度
+func GrantSubscription(c *gin.Context) {
+	var req grantSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if _, err := model.GetUserById(req.UserId, false); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.SubscribePlan(req.UserId, plan, 0, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("管理员开通套餐 %s", plan.Title))
+	common.ApiSuccess(c, sub)
+}
+
+func CancelSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.GetUserSubscriptionById(id, 0)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.CancelUserSubscription(sub.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(sub.UserId, model.LogTypeManage, fmt.Sprintf("管理员取消订阅 #%d", sub.Id))
+	common.ApiSuccess(c, nil)
+}
+
+// renewDueSubscriptions 为已到期且开启自动续费的订阅从钱包扣费续费，失败时关闭自动续费并通知用户
+func renewDueSubscriptions() {
+	subs, err := model.GetSubscriptionsDueForRenewal(common.GetTimestamp(), subscriptionRenewalBatchSize)
+	if err != nil {
+		common.SysError("failed to get subscriptions due for renewal: " + err.Error())
+		return
+	}
+	for _, sub := range subs {
+		plan, err := model.GetSubscriptionPlanById(sub.PlanId)
+		if err == nil && !plan.Enabled {
+			err = errors.New("套餐已停售")
+		}
+		if err == nil {
+			err = model.RenewUserSubscription(sub, plan, plan.PriceQuota)
+		}
+		if err == nil {
+			model.RecordLog(sub.UserId, model.LogTypeSystem, fmt.Sprintf("自动续费套餐 %s，花费 %s", plan.Title, logger.LogQuota(plan.PriceQuota)))
+			continue
+		}
+		common.SysError(fmt.Sprintf("failed to renew subscription #%d: %s", sub.Id, err.Error()))
+		sub.AutoRenew = false
+		if updateErr := sub.UpdateAutoRenew(); updateErr != nil {
+			common.SysError(fmt.Sprintf("failed to disable auto renew of subscription #%d: %s", sub.Id, updateErr.Error()))
+		}
+		notifySubscriptionRenewalFailed(sub, err)
+	}
+}
+
+func notifySubscriptionRenewalFailed(sub *model.UserSubscription, renewErr error) {
+	user, err := model.GetUserById(sub.UserId, false)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to notify user %d of subscription renewal: %s", sub.UserId, err.Error()))
+		return
+	}
+	content := "你的订阅 #{{value}} 自动续费失败（{{value}}），已关闭自动续费，请手动续费。"
+	err = service.NotifyUser(user.Id, user.Email, user.GetSetting(), dto.NewNotify(dto.NotifyTypeSubscriptionRenewal, "订阅自动续费失败", content, []interface{}{sub.Id, renewErr.Error()}))
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to notify user %d of subscription renewal: %s", sub.UserId, err.Error()))
+	}
+}
+
+var autoRenewSubscriptionsOnce sync.Once
+
+// AutomaticallyRenewSubscriptions 定期续费开启了自动续费的订阅
+func AutomaticallyRenewSubscriptions() {
+	// 只在Master节点定时续费
+	if !common.IsMasterNode {
+		return
+	}
+	autoRenewSubscriptionsOnce.Do(func() {
+		for {
+			time.Sleep(subscriptionRenewalInterval)
+			renewDueSubscriptions()
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// 自动轮换周期上限（天）
+	maxTokenRotationDays = 3650
+	// 每轮自动轮换处理的令牌数上限
+	tokenRotationBatchSize = 100
+)
+
+type rotateTokenRequest struct {
+	// GraceMinutes 旧密钥继续可用的时长（分钟），为空时使用系统默认值，0 表示立即失效
+	GraceMinutes *int `json:"grace_minutes"`
+}
+
+// RotateToken 为令牌生成新的密钥，额度、限制与使用记录保持不变，旧密钥在过渡期内仍可使用
+func RotateToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req rotateTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	setting := operation_setting.GetTokenRotationSetting()
+	graceMinutes := setting.GracePeriodMinutes
+	if req.GraceMinutes != nil {
+		graceMinutes = *req.GraceMinutes
+	}
+	if graceMinutes < 0 || graceMinutes > setting.MaxGracePeriodMinutes {
+		common.ApiError(c, fmt.Errorf("过渡期必须在 0 到 %d 分钟之间", setting.MaxGracePeriodMinutes))
+		return
+	}
+	token, err := model.GetTokenByIds(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	key, err := token.RotateKey(int64(graceMinutes) * 60)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	// 新密钥仅在此处返回一次
+	common.ApiSuccess(c, gin.H{
+		"id":                 token.Id,
+		"key":                key,
+		"key_prefix":         token.KeyPrefix,
+		"key_rotated_time":   token.KeyRotatedTime,
+		"previous_key_until": token.PreviousKeyUntil,
+	})
+}
+
+func checkTokenRotation(token *model.Token) error {
+	if token.RotationDays < 0 || token.RotationDays > maxTokenRotationDays {
+		return errors.New("自动轮换周期必须在 0 到 " + strconv.Itoa(maxTokenRotationDays) + " 天之间")
+	}
+	return nil
+}
+
+type rotatedToken struct {
+	name  string
+	until int64
+}
+
+// rotateDueTokens 轮换已到期的令牌并按用户汇总通知，新密钥只能在控制台查看一次；
+// 用户没有可用的通知方式时推迟轮换，避免密钥在用户不知情的情况下被更换
+func rotateDueTokens() {
+	graceSeconds := int64(operation_setting.GetTokenRotationSetting().GracePeriodMinutes) * 60
+	now := common.GetTimestamp()
+	// 可以接收通知的用户，值为 nil 表示该用户无法接收通知
+	users := make(map[int]*model.User)
+	rotated := make(map[int][]rotatedToken)
+	count, postponed, afterId := 0, 0, 0
+	for count < tokenRotationBatchSize {
+		tokens, err := model.GetTokensDueForRotation(now, afterId, tokenRotationBatchSize)
+		if err != nil {
+			common.SysError("failed to get tokens due for rotation: " + err.Error())
+			break
+		}
+		if len(tokens) == 0 {
+			break
+		}
+		for _, token := range tokens {
+			afterId = token.Id
+			user, ok := users[token.UserId]
+			if !ok {
+				user, err = model.GetUserById(token.UserId, false)
+				if err != nil || !service.CanNotifyUser(user.Email, user.GetSetting()) {
+					user = nil
+				}
+				users[token.UserId] = user
+			}
+			if user == nil {
+				postponed++
+				continue
+			}
+			key, err := token.RotateKey(graceSeconds)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to rotate token #%d: %s", token.Id, err.Error()))
+				continue
+			}
+			if err := token.SetPendingKey(key); err != nil {
+				common.SysError(fmt.Sprintf("failed to save pending key of token #%d: %s", token.Id, err.Error()))
+			}
+			rotated[token.UserId] = append(rotated[token.UserId], rotatedToken{name: token.Name, until: token.PreviousKeyUntil})
+			count++
+			if count >= tokenRotationBatchSize {
+				break
+			}
+		}
+	}
+	for userId, items := range rotated {
+		notifyTokenRotated(users[userId], items)
+	}
+	if count > 0 {
+		common.SysLog(fmt.Sprintf("automatically rotated %d tokens", count))
+	}
+	if postponed > 0 {
+		common.SysLog(fmt.Sprintf("postponed rotation of %d tokens whose owners have no notification method", postponed))
+	}
+}
+
+// notifyTokenRotated 通知用户令牌已轮换，通知中不包含新密钥，用户需在控制台查看
+func notifyTokenRotated(user *model.User, items []rotatedToken) {
+	var b strings.Builder
+	b.WriteString("以下令牌已按计划自动轮换，请登录控制台查看新的密钥（仅可查看一次）并尽快更新客户端：")
+	for _, item := range items {
+		until := "立即失效"
+		if item.until > 0 {
+			until = "将于 " + time.Unix(item.until, 0).Format("2006-01-02 15:04:05") + " 失效"
+		}
+		b.WriteString(fmt.Sprintf("<br/>令牌「%s」：旧密钥%s", item.name, until))
+	}
+	err := service.NotifyUser(user.Id, user.Email, user.GetSetting(), dto.NewNotify(dto.NotifyTypeTokenRotated, "令牌已自动轮换", b.String(), nil))
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to notify user %d of token rotation: %s", user.Id, err.Error()))
+	}
+}
+
+// RevealRotatedTokenKey 返回自动轮换生成的新密钥，每个新密钥只能查看一次
+func RevealRotatedTokenKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	key, err := model.RevealTokenPendingKey(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"id":  id,
+		"key": key,
+	})
+}
+
+var autoRotateTokensOnce sync.Once
+
+// AutomaticallyRotateTokens 定期轮换设置了自动轮换周期的令牌
+func AutomaticallyRotateTokens() {
+	// 只在Master节点定时轮换
+	if !common.IsMasterNode {
+		return
+	}
+	autoRotateTokensOnce.Do(func() {
+		for {
+			interval := operation_setting.GetTokenRotationSetting().CheckIntervalMinutes
+			if interval <= 0 {
+				interval = 60
+			}
+			time.Sleep(time.Duration(interval) * time.Minute)
+			if !operation_setting.GetTokenRotationSetting().AutoRotateEnabled {
+				continue
+			}
+			rotateDueTokens()
+		}
+	})
+}
@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/i18n"
@@ -112,28 +116,81 @@ func GetTokenUsage(c *gin.Context) {
 		return
 	}
 
+	if err := model.RefreshTokenBudget(token); err != nil {
+		common.SysError("failed to refresh token budget: " + err.Error())
+	}
+
 	expiredAt := token.ExpiredTime
 	if expiredAt == -1 {
 		expiredAt = 0
 	}
 
+	data := gin.H{
+		"object":               "token_usage",
+		"name":                 token.Name,
+		"total_granted":        token.RemainQuota + token.UsedQuota,
+		"total_used":           token.UsedQuota,
+		"total_available":      token.RemainQuota,
+		"unlimited_quota":      token.UnlimitedQuota,
+		"model_limits":         token.GetModelLimitsMap(),
+		"model_limits_enabled": token.ModelLimitsEnabled,
+		"expires_at":           expiredAt,
+	}
+	if token.HasBudget() {
+		budget, err := getTokenBudgetUsage(token)
+		if err != nil {
+			common.SysError("failed to get token budget usage: " + err.Error())
+		} else {
+			data["budget"] = budget
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"code":    true,
 		"message": "ok",
-		"data": gin.H{
-			"object":               "token_usage",
-			"name":                 token.Name,
-			"total_granted":        token.RemainQuota + token.UsedQuota,
-			"total_used":           token.UsedQuota,
-			"total_available":      token.RemainQuota,
-			"unlimited_quota":      token.UnlimitedQuota,
-			"model_limits":         token.GetModelLimitsMap(),
-			"model_limits_enabled": token.ModelLimitsEnabled,
-			"expires_at":           expiredAt,
-		},
+		"data":    data,
 	})
 }
 
+// getTokenBudgetUsage 返回令牌当前预算周期的用量，包括各模型的消耗与上限
+func getTokenBudgetUsage(token *model.Token) (gin.H, error) {
+	_, end, err := model.TokenBudgetPeriodBounds(token.BudgetPeriod, token.BudgetTimezone, time.Unix(token.BudgetPeriodStart, 0))
+	if err != nil {
+		return nil, err
+	}
+	spends, err := model.GetTokenModelSpends(token.Id, token.BudgetPeriodStart)
+	if err != nil {
+		return nil, err
+	}
+	used := 0
+	for _, quota := range spends {
+		used += quota
+	}
+	limits := token.GetBudgetModelLimits()
+	models := make(map[string]gin.H, len(spends)+len(limits))
+	for modelName, quota := range spends {
+		models[modelName] = gin.H{"used": quota}
+	}
+	for modelName, limit := range limits {
+		item, ok := models[modelName]
+		if !ok {
+			item = gin.H{"used": 0}
+			models[modelName] = item
+		}
+		item["limit"] = limit
+	}
+	return gin.H{
+		"period":         token.BudgetPeriod,
+		"amount":         token.BudgetAmount,
+		"timezone":       token.BudgetTimezone,
+		"carry_over":     token.BudgetCarryOver,
+		"carry_over_cap": token.BudgetCarryOverCap,
+		"period_start":   token.BudgetPeriodStart,
+		"period_end":     end.Unix(),
+		"period_used":    used,
+		"models":         models,
+	}, nil
+}
+
 func AddToken(c *gin.Context) {
 	token := model.Token{}
 	err := c.ShouldBindJSON(&token)
@@ -157,6 +214,26 @@ func AddToken(c *gin.Context) {
 			return
 		}
 	}
+	if err := checkTokenBudget(&token); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if err := checkTokenScopes(&token); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if err := checkTokenRotation(&token); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if err := checkTokenOrganization(&token, c.GetInt("id")); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if token.HasBudget() {
+		// 预算令牌从本周期的预算额度开始
+		token.RemainQuota = token.BudgetAmount
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		common.ApiErrorI18n(c, i18n.MsgTokenGenerateFailed)
@@ -177,15 +254,30 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		RPMLimit:           token.RPMLimit,
+		TPMLimit:           token.TPMLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		BudgetPeriod:       token.BudgetPeriod,
+		BudgetAmount:       token.BudgetAmount,
+		BudgetTimezone:     token.BudgetTimezone,
+		BudgetCarryOver:    token.BudgetCarryOver,
+		BudgetCarryOverCap: token.BudgetCarryOverCap,
+		BudgetModelLimits:  token.BudgetModelLimits,
+		BudgetPeriodStart:  token.BudgetPeriodStart,
+		Scopes:             token.Scopes,
+		RotationDays:       token.RotationDays,
+		OrganizationId:     token.OrganizationId,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	// 数据库只保存哈希，完整密钥仅在此处返回一次
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data":    cleanToken,
 	})
 	return
 }
@@ -247,6 +339,7 @@ func UpdateToken(c *gin.Context) {
 	if statusOnly != "" {
 		cleanToken.Status = token.Status
 	} else {
+		wasBudget, remainQuota := cleanToken.HasBudget(), cleanToken.RemainQuota
 		// If you add more fields, please also update token.Update()
 		cleanToken.Name = token.Name
 		cleanToken.ExpiredTime = token.ExpiredTime
@@ -257,6 +350,49 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.RPMLimit = token.RPMLimit
+		cleanToken.TPMLimit = token.TPMLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		if err := checkTokenBudget(&token); err != nil {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+		if err := checkTokenScopes(&token); err != nil {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+		cleanToken.Scopes = token.Scopes
+		if err := checkTokenRotation(&token); err != nil {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+		cleanToken.RotationDays = token.RotationDays
+		if err := checkTokenOrganization(&token, userId); err != nil {
+			common.ApiErrorMsg(c, err.Error())
+			return
+		}
+		cleanToken.OrganizationId = token.OrganizationId
+		samePeriod := cleanToken.BudgetPeriod == token.BudgetPeriod && cleanToken.BudgetTimezone == token.BudgetTimezone
+		if samePeriod {
+			// 周期设置未变化时保留当前周期，避免重复补充额度
+			token.BudgetPeriodStart = cleanToken.BudgetPeriodStart
+		}
+		if token.HasBudget() {
+			if !wasBudget || !samePeriod {
+				// 切换为预算令牌或更换周期时与创建时一致，从本周期的预算额度开始
+				cleanToken.RemainQuota = token.BudgetAmount
+			} else {
+				// 同一周期内剩余额度由预算决定，调整预算时按差额调整，已消耗的部分不退回
+				cleanToken.RemainQuota = max(remainQuota+token.BudgetAmount-cleanToken.BudgetAmount, 0)
+			}
+		}
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetAmount = token.BudgetAmount
+		cleanToken.BudgetTimezone = token.BudgetTimezone
+		cleanToken.BudgetCarryOver = token.BudgetCarryOver
+		cleanToken.BudgetCarryOverCap = token.BudgetCarryOverCap
+		cleanToken.BudgetModelLimits = token.BudgetModelLimits
+		cleanToken.BudgetPeriodStart = token.BudgetPeriodStart
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -292,3 +428,49 @@ func DeleteTokenBatch(c *gin.Context) {
 		"data":    count,
 	})
 }
+
+// checkTokenBudget 校验令牌的预算设置，并将预算周期设置为当前周期
+func checkTokenBudget(token *model.Token) error {
+	if !model.IsValidTokenBudgetPeriod(token.BudgetPeriod) {
+		return errors.New("预算周期只能为 daily、weekly 或 monthly")
+	}
+	if token.BudgetPeriod == "" {
+		token.BudgetPeriodStart = 0
+		return nil
+	}
+	if token.BudgetAmount <= 0 || token.BudgetCarryOverCap < 0 {
+		return errors.New("预算额度必须大于 0，结转上限不能为负数")
+	}
+	start, _, err := model.TokenBudgetPeriodBounds(token.BudgetPeriod, token.BudgetTimezone, time.Now())
+	if err != nil {
+		return fmt.Errorf("无效的时区 %s", token.BudgetTimezone)
+	}
+	if token.BudgetModelLimits != "" {
+		var limits map[string]int
+		if err := common.UnmarshalJsonStr(token.BudgetModelLimits, &limits); err != nil {
+			return errors.New("模型预算必须是模型名称到额度的 JSON 对象")
+		}
+		for modelName, limit := range limits {
+			if limit < 0 {
+				return fmt.Errorf("模型 %s 的预算不能为负数", modelName)
+			}
+		}
+	}
+	token.BudgetPeriodStart = start.Unix()
+	return nil
+}
+
+// checkTokenScopes 校验并规范化令牌的接口范围
+func checkTokenScopes(token *model.Token) error {
+	scopes := make([]string, 0)
+	for _, scope := range token.GetScopes() {
+		if !model.IsValidTokenScope(scope) {
+			return fmt.Errorf("无效的令牌接口范围 %s", scope)
+		}
+		if !slices.Contains(scopes, string(scope)) {
+			scopes = append(scopes, string(scope))
+		}
+	}
+	token.Scopes = strings.Join(scopes, ",")
+	return nil
+}
@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// 单笔充值数量上限
+	maxStripeTopUpAmount = 100000
+	// webhook 请求体大小上限
+	maxStripeWebhookBodySize = 1 << 20
+)
+
+type stripePayRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+func checkStripeTopUpAmount(amount int64) error {
+	if amount < int64(setting.StripeMinTopUp) {
+		return fmt.Errorf("充值数量不能小于 %d", setting.StripeMinTopUp)
+	}
+	if amount > maxStripeTopUpAmount {
+		return fmt.Errorf("充值数量不能大于 %d", maxStripeTopUpAmount)
+	}
+	return nil
+}
+
+func getStripePayMoney(amount int64) float64 {
+	return float64(amount) * setting.StripeUnitPrice
+}
+
+// RequestStripeAmount 返回充值数量对应的支付金额
+func RequestStripeAmount(c *gin.Context) {
+	var req stripePayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkStripeTopUpAmount(req.Amount); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	common.ApiSuccess(c, strconv.FormatFloat(getStripePayMoney(req.Amount), 'f', 2, 64))
+}
+
+// RequestStripePay 创建充值订单与 Stripe Checkout 会话，返回支付链接
+func RequestStripePay(c *gin.Context) {
+	var req stripePayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkStripeTopUpAmount(req.Amount); err != nil {
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if setting.StripeApiSecret == "" || setting.StripePriceId == "" || setting.StripeWebhookSecret == "" {
+		common.ApiErrorMsg(c, "管理员未开启 Stripe 充值")
+		return
+	}
+	userId := c.GetInt("id")
+	email, err := model.GetUserEmail(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	topUp := &model.TopUp{
+		UserId:        userId,
+		Amount:        req.Amount,
+		Quota:         int(float64(req.Amount) * common.QuotaPerUnit),
+		Money:         getStripePayMoney(req.Amount),
+		TradeNo:       fmt.Sprintf("STRIPE-%d-%d-%s", userId, time.Now().UnixMilli(), common.GetRandomString(6)),
+		PaymentMethod: model.TopUpPaymentMethodStripe,
+		Status:        model.TopUpStatusPending,
+		CreateTime:    common.GetTimestamp(),
+	}
+	if err := topUp.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	returnUrl := system_setting.ServerAddress + "/console/topup"
+	session, err := service.CreateStripeCheckoutSession(service.StripeCheckoutParams{
+		TradeNo:       topUp.TradeNo,
+		Quantity:      req.Amount,
+		CustomerEmail: email,
+		SuccessUrl:    returnUrl + "?stripe=success",
+		CancelUrl:     returnUrl + "?stripe=cancel",
+	})
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to create stripe checkout session for %s: %s", topUp.TradeNo, err.Error()))
+		if expireErr := model.ExpireTopUp(topUp.TradeNo); expireErr != nil {
+			common.SysError("failed to expire top up: " + expireErr.Error())
+		}
+		common.ApiErrorMsg(c, "创建支付会话失败，请稍后重试")
+		return
+	}
+	if err := topUp.UpdateSessionId(session.Id); err != nil {
+		// 回调按会话 ID 校验订单，未记录会话 ID 的订单无法入账，不返回支付链接
+		common.SysError("failed to save stripe session id: " + err.Error())
+		if expireErr := model.ExpireTopUp(topUp.TradeNo); expireErr != nil {
+			common.SysError("failed to expire top up: " + expireErr.Error())
+		}
+		common.ApiErrorMsg(c, "创建支付会话失败，请稍后重试")
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"pay_link": session.Url,
+		"trade_no": topUp.TradeNo,
+	})
+}
+
+// StripeWebhook 处理 Stripe 回调，签名校验通过后为已支付的订单入账
+func StripeWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxStripeWebhookBodySize))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if err := service.VerifyStripeSignature(payload, c.GetHeader("Stripe-Signature"), setting.StripeWebhookSecret); err != nil {
+		logger.LogWarn(c, "stripe webhook signature verification failed: "+err.Error())
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var event service.StripeEvent
+	if err := common.Unmarshal(payload, &event); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	session := &event.Data.Object
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		if session.PaymentStatus != "paid" {
+			// 异步支付方式在 async_payment_succeeded 时再入账
+			break
+		}
+		if err := completeStripeTopUp(session); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, model.ErrTopUpSessionMismatch) {
+				// 不属于本站的订单重试也无法入账，记录后直接确认
+				logger.LogWarn(c, fmt.Sprintf("ignore stripe session %s for top up %s: %s", session.Id, session.TradeNo(), err.Error()))
+				break
+			}
+			logger.LogError(c, fmt.Sprintf("failed to complete stripe top up %s: %s", session.TradeNo(), err.Error()))
+			// 返回错误让 Stripe 重试
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	case "checkout.session.expired", "checkout.session.async_payment_failed":
+		if err := model.ExpireTopUpSession(session.TradeNo(), session.Id); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, model.ErrTopUpSessionMismatch) {
+				logger.LogWarn(c, fmt.Sprintf("ignore stripe session %s for top up %s: %s", session.Id, session.TradeNo(), err.Error()))
+				break
+			}
+			logger.LogError(c, fmt.Sprintf("failed to expire stripe top up %s: %s", session.TradeNo(), err.Error()))
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+func completeStripeTopUp(session *service.StripeCheckoutSession) error {
+	tradeNo := session.TradeNo()
+	if tradeNo == "" {
+		return errors.New("stripe session has no trade no")
+	}
+	topUp, credited, err := model.CompleteTopUp(tradeNo, session.Id, session.PaidMoney())
+	if err != nil {
+		return err
+	}
+	if credited {
+		common.SysLog(fmt.Sprintf("stripe top up %s completed, user %d, quota %d", topUp.TradeNo, topUp.UserId, topUp.Quota))
+	}
+	return nil
+}
+
+func GetSelfTopUps(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	topUps, total, err := model.GetUserTopUps(c.GetInt("id"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(topUps)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetAllTopUps(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	pageInfo := common.GetPageQuery(c)
+	topUps, total, err := model.GetAllTopUps(userId, c.Query("status"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(topUps)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// ReconcileTopUp 向 Stripe 查询订单的支付状态，补入漏掉回调的订单
+func ReconcileTopUp(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	topUp, err := model.GetTopUpById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if topUp.PaymentMethod != model.TopUpPaymentMethodStripe || topUp.SessionId == "" {
+		common.ApiErrorMsg(c, "该订单没有可查询的 Stripe 支付会话")
+		return
+	}
+	session, err := service.GetStripeCheckoutSession(topUp.SessionId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	switch {
+	case session.PaymentStatus == "paid":
+		if err := completeStripeTopUp(session); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	case session.Status == "expired":
+		if err := model.ExpireTopUpSession(topUp.TradeNo, session.Id); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	topUp, err = model.GetTopUpById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("对账充值订单 %s，Stripe 状态：%s/%s，订单状态：%s",
+		topUp.TradeNo, session.Status, session.PaymentStatus, topUp.Status))
+	common.ApiSuccess(c, topUp)
+}
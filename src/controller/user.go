@@ -207,6 +207,11 @@ func GetSelf(c *gin.Context) {
 
 	// 获取用户设置并提取sidebar_modules
 	userSetting := user.GetSetting()
+	// 设置中的密钥字段加密存储，返回解密后的设置
+	settingJSON := user.Setting
+	if settingBytes, err := common.Marshal(userSetting); err == nil && user.Setting != "" {
+		settingJSON = string(settingBytes)
+	}
 
 	// 构建响应数据，包含用户信息和权限
 	responseData := map[string]interface{}{
@@ -220,7 +225,7 @@ func GetSelf(c *gin.Context) {
 		"quota":             user.Quota,
 		"used_quota":        user.UsedQuota,
 		"request_count":     user.RequestCount,
-		"setting":           user.Setting,
+		"setting":           settingJSON,
 		"sidebar_modules":   userSetting.SidebarModules, // 正确提取sidebar_modules字段
 		"permissions":       permissions,                // 新增权限字段
 	}
@@ -398,6 +403,34 @@ func UpdateSelf(c *gin.Context) {
 		return
 	}
 
+	// 检查是否是扣费策略更新请求
+	if billingPreference, prefExists := requestData["billing_preference"]; prefExists {
+		userId := c.GetInt("id")
+		user, err := model.GetUserById(userId, false)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		// 获取当前用户设置
+		currentSetting := user.GetSetting()
+
+		// 更新billing_preference字段
+		if prefStr, ok := billingPreference.(string); ok {
+			currentSetting.BillingPreference = common.NormalizeBillingPreference(prefStr)
+		}
+
+		// 保存更新后的设置
+		user.SetSetting(currentSetting)
+		if err := user.Update(false); err != nil {
+			common.ApiErrorI18n(c, i18n.MsgUpdateFailed)
+			return
+		}
+
+		common.ApiSuccessI18n(c, i18n.MsgUpdateSuccess, nil)
+		return
+	}
+
 	// 原有的用户信息更新逻辑
 	var user model.User
 	requestDataBytes, err := json.Marshal(requestData)
@@ -810,6 +843,13 @@ func UpdateUserSetting(c *gin.Context) {
 		}
 	}
 
+	// 限流由管理员设置、扣费策略单独设置，用户更新通知设置时保留
+	currentSetting := user.GetSetting()
+	settings.RPMLimit = currentSetting.RPMLimit
+	settings.TPMLimit = currentSetting.TPMLimit
+	settings.ConcurrencyLimit = currentSetting.ConcurrencyLimit
+	settings.BillingPreference = currentSetting.BillingPreference
+
 	// 更新用户设置
 	user.SetSetting(settings)
 	if err := user.Update(false); err != nil {
@@ -819,3 +859,44 @@ func UpdateUserSetting(c *gin.Context) {
 
 	common.ApiSuccessI18n(c, i18n.MsgSettingSaved, nil)
 }
+
+type UpdateUserRateLimitRequest struct {
+	RPMLimit         int `json:"rpm_limit"`
+	TPMLimit         int `json:"tpm_limit"`
+	ConcurrencyLimit int `json:"concurrency_limit"`
+}
+
+// UpdateUserRateLimit 管理员设置用户的每分钟请求数、每分钟 token 数与并发请求数上限
+func UpdateUserRateLimit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	var req UpdateUserRateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RPMLimit < 0 || req.TPMLimit < 0 || req.ConcurrencyLimit < 0 {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	user, err := model.GetUserById(id, true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	myRole := c.GetInt("role")
+	if myRole <= user.Role && myRole != common.RoleRootUser {
+		common.ApiErrorI18n(c, i18n.MsgUserNoPermissionHigherLevel)
+		return
+	}
+	setting := user.GetSetting()
+	setting.RPMLimit = req.RPMLimit
+	setting.TPMLimit = req.TPMLimit
+	setting.ConcurrencyLimit = req.ConcurrencyLimit
+	user.SetSetting(setting)
+	if err := user.Update(false); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(user.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户限流设置为 RPM %d，TPM %d，并发 %d", req.RPMLimit, req.TPMLimit, req.ConcurrencyLimit))
+	common.ApiSuccess(c, req)
+}
@@ -24,13 +24,78 @@ const (
 )
 
 type ChannelOtherSettings struct {
-	AzureResponsesVersion string        `json:"azure_responses_version,omitempty"`
-	VertexKeyType         VertexKeyType `json:"vertex_key_type,omitempty"` // "json" or "api_key"
-	OpenRouterEnterprise  *bool         `json:"openrouter_enterprise,omitempty"`
-	AllowServiceTier      bool          `json:"allow_service_tier,omitempty"`      // 是否允许 service_tier 透传（默认过滤以避免额外计费）
-	DisableStore          bool          `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
-	AllowSafetyIdentifier bool          `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
-	AwsKeyType            AwsKeyType    `json:"aws_key_type,omitempty"`
+	AzureResponsesVersion  string            `json:"azure_responses_version,omitempty"`
+	VertexKeyType          VertexKeyType     `json:"vertex_key_type,omitempty"` // "json" or "api_key"
+	OpenRouterEnterprise   *bool             `json:"openrouter_enterprise,omitempty"`
+	AllowServiceTier       bool              `json:"allow_service_tier,omitempty"`      // 是否允许 service_tier 透传（默认过滤以避免额外计费）
+	DisableStore           bool              `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
+	AllowSafetyIdentifier  bool              `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
+	AwsKeyType             AwsKeyType        `json:"aws_key_type,omitempty"`
+	MaxConcurrency         int               `json:"max_concurrency,omitempty"`          // 渠道最大并发请求数，0 表示不限制
+	ConcurrencyQueueSize   int               `json:"concurrency_queue_size,omitempty"`   // 并发已满时的等待队列长度，启用 Redis 时由所有实例共享，0 表示直接切换到其他渠道
+	ConcurrencyWaitSeconds int               `json:"concurrency_wait_seconds,omitempty"` // 排队等待超时时间（秒），默认 10
+	RPMLimit               int               `json:"rpm_limit,omitempty"`                // 渠道每分钟请求数上限，0 表示不限制
+	TPMLimit               int               `json:"tpm_limit,omitempty"`                // 渠道每分钟 token 数上限，0 表示不限制
+	TestSuite              []ChannelTestCase `json:"test_suite,omitempty"`               // 渠道测试用例，为空时使用默认的单次测试
+	TestIntervalMinutes    int               `json:"test_interval_minutes,omitempty"`    // 渠道自动测试间隔（分钟），0 表示跟随全局设置，-1 表示不自动测试
+	BalanceAlertThreshold  float64           `json:"balance_alert_threshold,omitempty"`  // 余额告警阈值（美元），0 表示不告警
+	BalanceLowAction       BalanceLowAction  `json:"balance_low_action,omitempty"`       // 余额低于阈值时的处理方式
+	BalanceDemotePriority  int64             `json:"balance_demote_priority,omitempty"`  // 降级时使用的优先级
+	BalanceBudget          float64           `json:"balance_budget,omitempty"`           // 每月预算（美元），用于没有余额接口的渠道，估算余额 = 预算 - 本月本地消耗
+	BalanceCredential      string            `json:"balance_credential,omitempty"`       // 查询余额使用的凭证（Anthropic Admin Key，火山引擎 AK|SK），支持外部密钥引用
+	UpstreamCost           *UpstreamCost     `json:"upstream_cost,omitempty"`            // 上游成本价格，用于统计利润
+}
+
+// UpstreamCost 渠道的上游成本，按模型设置的价格优先于折扣
+type UpstreamCost struct {
+	Discount float64                       `json:"discount,omitempty"` // 相对本站计费价格的折扣，如 0.6 表示上游按本站价格的六折收费
+	Models   map[string]UpstreamModelPrice `json:"models,omitempty"`   // 按模型设置的上游价格，"*" 匹配其他模型
+}
+
+// UpstreamModelPrice 上游模型价格，单位为美元 / 1M tokens
+type UpstreamModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cache_read,omitempty"`  // 缓存命中价格，0 表示按输入价格计算
+	CacheWrite float64 `json:"cache_write,omitempty"` // 缓存写入价格，0 表示按输入价格计算
+	PerRequest float64 `json:"per_request,omitempty"` // 每次请求的固定费用（美元）
+}
+
+type BalanceLowAction string
+
+const (
+	BalanceLowActionNotify  BalanceLowAction = ""        // 仅通知
+	BalanceLowActionDemote  BalanceLowAction = "demote"  // 降低优先级，余额恢复后还原
+	BalanceLowActionDisable BalanceLowAction = "disable" // 禁用渠道，余额恢复后启用
+)
+
+type ChannelTestCaseType string
+
+const (
+	ChannelTestCaseChat       ChannelTestCaseType = "chat"
+	ChannelTestCaseStream     ChannelTestCaseType = "stream"
+	ChannelTestCaseToolCall   ChannelTestCaseType = "tool_call"
+	ChannelTestCaseEmbeddings ChannelTestCaseType = "embeddings"
+	ChannelTestCaseRerank     ChannelTestCaseType = "rerank"
+	ChannelTestCaseImage      ChannelTestCaseType = "image"
+	ChannelTestCaseAudio      ChannelTestCaseType = "audio"
+	ChannelTestCaseResponses  ChannelTestCaseType = "responses"
+)
+
+// ChannelTestCase 渠道测试用例，除请求成功外还会校验响应结构与延迟
+type ChannelTestCase struct {
+	Name           string              `json:"name,omitempty"`
+	Type           ChannelTestCaseType `json:"type"`
+	Model          string              `json:"model,omitempty"`           // 为空时使用渠道的测试模型
+	MaxLatencyMs   int64               `json:"max_latency_ms,omitempty"`  // 超过该延迟视为失败，0 表示不限制
+	ExpectContains string              `json:"expect_contains,omitempty"` // 文本输出需包含的内容（chat / stream / responses）
+}
+
+func (t *ChannelTestCase) DisplayName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return string(t.Type)
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
@@ -10,9 +10,11 @@ type Notify struct {
 const ContentValueParam = "{{value}}"
 
 const (
-	NotifyTypeQuotaExceed   = "quota_exceed"
-	NotifyTypeChannelUpdate = "channel_update"
-	NotifyTypeChannelTest   = "channel_test"
+	NotifyTypeQuotaExceed         = "quota_exceed"
+	NotifyTypeChannelUpdate       = "channel_update"
+	NotifyTypeChannelTest         = "channel_test"
+	NotifyTypeTokenRotated        = "token_rotated"
+	NotifyTypeSubscriptionRenewal = "subscription_renewal"
 )
 
 func NewNotify(t string, title string, content string, values []interface{}) Notify {
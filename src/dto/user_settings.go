@@ -15,6 +15,9 @@ type UserSetting struct {
 	SidebarModules        string  `json:"sidebar_modules,omitempty"`                // SidebarModules 左侧边栏模块配置
 	BillingPreference     string  `json:"billing_preference,omitempty"`             // BillingPreference 扣费策略（订阅/钱包）
 	Language              string  `json:"language,omitempty"`                       // Language 用户语言偏好 (zh, en)
+	RPMLimit              int     `json:"rpm_limit,omitempty"`                      // RPMLimit 每分钟请求数上限，由管理员设置，0 表示不限制
+	TPMLimit              int     `json:"tpm_limit,omitempty"`                      // TPMLimit 每分钟输入加输出 token 数上限，由管理员设置
+	ConcurrencyLimit      int     `json:"concurrency_limit,omitempty"`              // ConcurrencyLimit 同时进行的请求数上限（含流式），由管理员设置
 }
 
 var (
@@ -270,6 +270,20 @@ const isRequestPassThroughEnabled = (record) => {
   }
 };
 
+const isBalanceEstimated = (record) => {
+  if (record.balance_estimated !== undefined) {
+    return record.balance_estimated === true;
+  }
+  if (!record.other_info) {
+    return false;
+  }
+  try {
+    return JSON.parse(record.other_info)?.balance_estimated === true;
+  } catch (error) {
+    return false;
+  }
+};
+
 export const getChannelsColumns = ({
   t,
   COLUMN_KEYS,
@@ -443,7 +457,13 @@ export const getChannelsColumns = ({
                   </Tag>
                 </Tooltip>
                 <Tooltip
-                  content={t('剩余额度$') + record.balance + t('，点击更新')}
+                  content={
+                    (isBalanceEstimated(record)
+                      ? t('按每月预算和本地消耗估算的剩余额度$')
+                      : t('剩余额度$')) +
+                    record.balance +
+                    t('，点击更新')
+                  }
                 >
                   <Tag
                     color='white'
@@ -20,9 +20,7 @@ For commercial licensing, please contact support@quantumnous.com
 import React from 'react';
 import {
   Button,
-  Dropdown,
   Space,
-  SplitButtonGroup,
   Tag,
   AvatarGroup,
   Avatar,
@@ -38,14 +36,8 @@ import {
   renderGroup,
   renderQuota,
   getModelCategories,
-  showError,
 } from '../../../helpers';
-import {
-  IconTreeTriangleDown,
-  IconCopy,
-  IconEyeOpened,
-  IconEyeClosed,
-} from '@douyinfe/semi-icons';
+import { IconEyeOpened } from '@douyinfe/semi-icons';
 
 // progress color helper
 const getProgressColor = (pct) => {
@@ -107,44 +99,34 @@ const renderGroupColumn = (text, record, t) => {
   return renderGroup(text);
 };
 
-// Render token key column with show/hide and copy functionality
-const renderTokenKey = (text, record, showKeys, setShowKeys, copyText) => {
-  const fullKey = 'sk-' + record.key;
-  const maskedKey =
-    'sk-' + record.key.slice(0, 4) + '**********' + record.key.slice(-4);
-  const revealed = !!showKeys[record.id];
+// Render token key column; only the prefix is stored, the full key is shown once
+const renderTokenKey = (text, record, revealTokenKey, t) => {
+  const maskedKey = 'sk-' + (record.key_prefix || '') + '**********';
 
   return (
     <div className='w-[200px]'>
       <Input
         readOnly
-        value={revealed ? fullKey : maskedKey}
+        value={maskedKey}
         size='small'
         suffix={
-          <div className='flex items-center'>
-            <Button
-              theme='borderless'
-              size='small'
-              type='tertiary'
-              icon={revealed ? <IconEyeClosed /> : <IconEyeOpened />}
-              aria-label='toggle token visibility'
-              onClick={(e) => {
-                e.stopPropagation();
-                setShowKeys((prev) => ({ ...prev, [record.id]: !revealed }));
-              }}
-            />
-            <Button
-              theme='borderless'
-              size='small'
-              type='tertiary'
-              icon={<IconCopy />}
-              aria-label='copy token key'
-              onClick={async (e) => {
-                e.stopPropagation();
-                await copyText(fullKey);
-              }}
-            />
-          </div>
+          record.has_pending_key ? (
+            <Tooltip
+              content={t('令牌已自动轮换，点击查看新密钥（仅可查看一次）')}
+            >
+              <Button
+                theme='borderless'
+                size='small'
+                type='warning'
+                icon={<IconEyeOpened />}
+                aria-label='reveal rotated token key'
+                onClick={async (e) => {
+                  e.stopPropagation();
+                  await revealTokenKey(record);
+                }}
+              />
+            </Tooltip>
+          ) : null
         }
       />
     </div>
@@ -310,64 +292,15 @@ const renderQuotaUsage = (text, record, t) => {
 const renderOperations = (
   text,
   record,
-  onOpenLink,
   setEditingToken,
   setShowEdit,
   manageToken,
+  rotateTokenKey,
   refresh,
   t,
 ) => {
-  let chatsArray = [];
-  try {
-    const raw = localStorage.getItem('chats');
-    const parsed = JSON.parse(raw);
-    if (Array.isArray(parsed)) {
-      for (let i = 0; i < parsed.length; i++) {
-        const item = parsed[i];
-        const name = Object.keys(item)[0];
-        if (!name) continue;
-        chatsArray.push({
-          node: 'item',
-          key: i,
-          name,
-          value: item[name],
-          onClick: () => onOpenLink(name, item[name], record),
-        });
-      }
-    }
-  } catch (_) {
-    showError(t('聊天链接配置错误，请联系管理员'));
-  }
-
   return (
     <Space wrap>
-      <SplitButtonGroup
-        className='overflow-hidden'
-        aria-label={t('项目操作按钮组')}
-      >
-        <Button
-          size='small'
-          type='tertiary'
-          onClick={() => {
-            if (chatsArray.length === 0) {
-              showError(t('请联系管理员配置聊天链接'));
-            } else {
-              const first = chatsArray[0];
-              onOpenLink(first.name, first.value, record);
-            }
-          }}
-        >
-          {t('聊天')}
-        </Button>
-        <Dropdown trigger='click' position='bottomRight' menu={chatsArray}>
-          <Button
-            type='tertiary'
-            icon={<IconTreeTriangleDown />}
-            size='small'
-          ></Button>
-        </Dropdown>
-      </SplitButtonGroup>
-
       {record.status === 1 ? (
         <Button
           type='danger'
@@ -402,6 +335,22 @@ const renderOperations = (
         {t('编辑')}
       </Button>
 
+      <Button
+        type='tertiary'
+        size='small'
+        onClick={() => {
+          Modal.confirm({
+            title: t('确定要轮换此令牌的密钥吗？'),
+            content: t(
+              '将生成新的密钥，旧密钥在过渡期结束后失效，新密钥只显示一次',
+            ),
+            onOk: () => rotateTokenKey(record),
+          });
+        }}
+      >
+        {t('轮换')}
+      </Button>
+
       <Button
         type='danger'
         size='small'
@@ -426,11 +375,9 @@ const renderOperations = (
 
 export const getTokensColumns = ({
   t,
-  showKeys,
-  setShowKeys,
-  copyText,
   manageToken,
-  onOpenLink,
+  rotateTokenKey,
+  revealTokenKey,
   setEditingToken,
   setShowEdit,
   refresh,
@@ -461,7 +408,7 @@ export const getTokensColumns = ({
       title: t('密钥'),
       key: 'token_key',
       render: (text, record) =>
-        renderTokenKey(text, record, showKeys, setShowKeys, copyText),
+        renderTokenKey(text, record, revealTokenKey, t),
     },
     {
       title: t('可用模型'),
@@ -499,10 +446,10 @@ export const getTokensColumns = ({
         renderOperations(
           text,
           record,
-          onOpenLink,
           setEditingToken,
           setShowEdit,
           manageToken,
+          rotateTokenKey,
           refresh,
           t,
         ),
@@ -38,11 +38,9 @@ const TokensTable = (tokensData) => {
     handlePageSizeChange,
     rowSelection,
     handleRow,
-    showKeys,
-    setShowKeys,
-    copyText,
     manageToken,
-    onOpenLink,
+    rotateTokenKey,
+    revealTokenKey,
     setEditingToken,
     setShowEdit,
     refresh,
@@ -53,22 +51,18 @@ const TokensTable = (tokensData) => {
   const columns = useMemo(() => {
     return getTokensColumns({
       t,
-      showKeys,
-      setShowKeys,
-      copyText,
       manageToken,
-      onOpenLink,
+      rotateTokenKey,
+      revealTokenKey,
       setEditingToken,
       setShowEdit,
       refresh,
     });
   }, [
     t,
-    showKeys,
-    setShowKeys,
-    copyText,
     manageToken,
-    onOpenLink,
+    rotateTokenKey,
+    revealTokenKey,
     setEditingToken,
     setShowEdit,
     refresh,
@@ -38,6 +38,7 @@ import TokensActions from './TokensActions';
 import TokensFilters from './TokensFilters';
 import TokensDescription from './TokensDescription';
 import EditTokenModal from './modals/EditTokenModal';
+import TokenKeysModal from './modals/TokenKeysModal';
 import { useTokensData } from '../../../hooks/tokens/useTokensData';
 import { useIsMobile } from '../../../hooks/common/useIsMobile';
 import { createCardProPagination } from '../../../helpers/utils';
@@ -50,8 +51,6 @@ function TokensPage() {
   );
   const isMobile = useIsMobile();
   const latestRef = useRef({
-    tokens: [],
-    selectedKeys: [],
     t: (k) => k,
     selectedModel: '',
     prefillKey: '',
@@ -64,15 +63,11 @@ function TokensPage() {
   // Keep latest data for handlers inside notifications
   useEffect(() => {
     latestRef.current = {
-      tokens: tokensData.tokens,
-      selectedKeys: tokensData.selectedKeys,
       t: tokensData.t,
       selectedModel,
       prefillKey,
     };
   }, [
-    tokensData.tokens,
-    tokensData.selectedKeys,
     tokensData.t,
     selectedModel,
     prefillKey,
@@ -134,28 +129,34 @@ function TokensPage() {
           <div style={{ marginBottom: 8 }}>
             {key
               ? t('请选择模型。')
-              : t('选择模型后可一键填充当前选中令牌（或本页第一个令牌）。')}
-          </div>
-          <div style={{ marginBottom: 8 }}>
-            <Select
-              placeholder={t('请选择模型')}
-              optionList={modelOptions}
-              onChange={setSelectedModel}
-              filter={selectFilter}
-              style={{ width: 320 }}
-              showClear
-              searchable
-              emptyContent={t('暂无数据')}
-            />
+              : t(
+                  '令牌密钥仅在创建或轮换时显示一次，可在显示密钥的窗口中通过聊天菜单填充到 FluentRead。',
+                )}
           </div>
+          {key && (
+            <div style={{ marginBottom: 8 }}>
+              <Select
+                placeholder={t('请选择模型')}
+                optionList={modelOptions}
+                onChange={setSelectedModel}
+                filter={selectFilter}
+                style={{ width: 320 }}
+                showClear
+                searchable
+                emptyContent={t('暂无数据')}
+              />
+            </div>
+          )}
           <Space>
-            <Button
-              theme='solid'
-              type='primary'
-              onClick={handlePrefillToFluent}
-            >
-              {t('一键填充到 FluentRead')}
-            </Button>
+            {key && (
+              <Button
+                theme='solid'
+                type='primary'
+                onClick={handlePrefillToFluent}
+              >
+                {t('一键填充到 FluentRead')}
+              </Button>
+            )}
             {!key && (
               <Button
                 type='warning'
@@ -186,8 +187,6 @@ function TokensPage() {
   // Prefill to Fluent handler
   const handlePrefillToFluent = () => {
     const {
-      tokens,
-      selectedKeys,
       t,
       selectedModel: chosenModel,
       prefillKey: overrideKey,
@@ -213,27 +212,16 @@ function TokensPage() {
     }
     if (!serverAddress) serverAddress = window.location.origin;
 
-    let apiKeyToUse = '';
-    if (overrideKey) {
-      apiKeyToUse = 'sk-' + overrideKey;
-    } else {
-      const token =
-        selectedKeys && selectedKeys.length === 1
-          ? selectedKeys[0]
-          : tokens && tokens.length > 0
-            ? tokens[0]
-            : null;
-      if (!token) {
-        Toast.warning(t('没有可用令牌用于填充'));
-        return;
-      }
-      apiKeyToUse = 'sk-' + token.key;
+    // Only the one-time key shown after creation or rotation can be filled
+    if (!overrideKey) {
+      Toast.warning(t('没有可用令牌用于填充'));
+      return;
     }
 
     const payload = {
       id: 'new-api',
       baseUrl: serverAddress,
-      apiKey: apiKeyToUse,
+      apiKey: 'sk-' + overrideKey,
       model: chosenModel,
     };
 
@@ -265,7 +253,7 @@ function TokensPage() {
   // When modelOptions or language changes while the notice is open, refresh the content
   useEffect(() => {
     if (fluentNoticeOpen) {
-      openFluentNotification();
+      openFluentNotification(prefillKey);
     }
     // eslint-disable-next-line react-hooks/exhaustive-deps
   }, [modelOptions, selectedModel, tokensData.t, fluentNoticeOpen]);
@@ -335,9 +323,13 @@ function TokensPage() {
     selectedKeys,
     setEditingToken,
     setShowEdit,
-    batchCopyTokens,
     batchDeleteTokens,
     copyText,
+    onOpenLink,
+
+    // One-time key state
+    revealedKeys,
+    setRevealedKeys,
 
     // Filters state
     formInitValues,
@@ -361,6 +353,15 @@ function TokensPage() {
         editingToken={editingToken}
         visiable={showEdit}
         handleClose={closeEdit}
+        onKeysCreated={setRevealedKeys}
+      />
+
+      <TokenKeysModal
+        keys={revealedKeys}
+        onClose={() => setRevealedKeys([])}
+        copyText={copyText}
+        onOpenLink={onOpenLink}
+        t={t}
       />
 
       <CardPro
@@ -378,9 +379,7 @@ function TokensPage() {
               selectedKeys={selectedKeys}
               setEditingToken={setEditingToken}
               setShowEdit={setShowEdit}
-              batchCopyTokens={batchCopyTokens}
               batchDeleteTokens={batchDeleteTokens}
-              copyText={copyText}
               t={t}
             />
 
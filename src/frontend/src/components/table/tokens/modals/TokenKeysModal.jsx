@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2025 QuantumNous
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+For commercial licensing, please contact support@quantumnous.com
+*/
+
+import React from 'react';
+import {
+  Modal,
+  Banner,
+  Button,
+  Dropdown,
+  Input,
+  Space,
+  Typography,
+} from '@douyinfe/semi-ui';
+import { IconCopy, IconTreeTriangleDown } from '@douyinfe/semi-icons';
+import { showError } from '../../../../helpers';
+
+// Build chat link menu items that open with the given one-time key
+const getChatItems = (key, onOpenLink, t) => {
+  const items = [];
+  try {
+    const parsed = JSON.parse(localStorage.getItem('chats'));
+    if (Array.isArray(parsed)) {
+      for (let i = 0; i < parsed.length; i++) {
+        const item = parsed[i];
+        const name = Object.keys(item)[0];
+        if (!name) continue;
+        items.push({
+          node: 'item',
+          key: i,
+          name,
+          onClick: () => onOpenLink(name, item[name], key),
+        });
+      }
+    }
+  } catch (_) {
+    showError(t('聊天链接配置错误，请联系管理员'));
+  }
+  return items;
+};
+
+// The full key is only returned once (on creation, rotation or reveal), so it is shown here and never stored
+const TokenKeysModal = ({ keys, onClose, copyText, onOpenLink, t }) => {
+  const { Text } = Typography;
+
+  const copyAll = async (withName) => {
+    const content = keys
+      .map((item) =>
+        withName ? item.name + '    sk-' + item.key : 'sk-' + item.key,
+      )
+      .join('\n');
+    await copyText(content);
+  };
+
+  return (
+    <Modal
+      title={t('请保存令牌密钥')}
+      visible={keys.length > 0}
+      onCancel={onClose}
+      closeOnEsc={false}
+      maskClosable={false}
+      footer={
+        <Space>
+          {keys.length > 1 && (
+            <>
+              <Button type='tertiary' onClick={() => copyAll(true)}>
+                {t('名称+密钥')}
+              </Button>
+              <Button type='tertiary' onClick={() => copyAll(false)}>
+                {t('仅密钥')}
+              </Button>
+            </>
+          )}
+          <Button theme='solid' type='primary' onClick={onClose}>
+            {t('我已保存')}
+          </Button>
+        </Space>
+      }
+    >
+      <Banner
+        type='warning'
+        closeIcon={null}
+        className='mb-4'
+        description={t(
+          '密钥只显示这一次，关闭后无法再次查看，请立即复制并妥善保存',
+        )}
+      />
+      <div className='flex flex-col gap-3'>
+        {keys.map((item) => {
+          const chatItems = getChatItems(item.key, onOpenLink, t);
+          return (
+            <div key={item.key}>
+              <Text strong>{item.name}</Text>
+              <div className='flex items-center gap-2 mt-1'>
+                <Input
+                  readOnly
+                  value={'sk-' + item.key}
+                  size='small'
+                  suffix={
+                    <Button
+                      theme='borderless'
+                      size='small'
+                      type='tertiary'
+                      icon={<IconCopy />}
+                      aria-label='copy token key'
+                      onClick={() => copyText('sk-' + item.key)}
+                    />
+                  }
+                />
+                {chatItems.length > 0 && (
+                  <Dropdown
+                    trigger='click'
+                    position='bottomRight'
+                    menu={chatItems}
+                  >
+                    <Button
+                      size='small'
+                      type='tertiary'
+                      icon={<IconTreeTriangleDown />}
+                      iconPosition='right'
+                    >
+                      {t('聊天')}
+                    </Button>
+                  </Dropdown>
+                )}
+              </div>
+            </div>
+          );
+        })}
+      </div>
+    </Modal>
+  );
+};
+
+export default TokenKeysModal;
@@ -30,7 +30,10 @@ export async function fetchTokenKeys() {
     if (!success) throw new Error('Failed to fetch token keys');
 
     const tokenItems = Array.isArray(data) ? data : data.items || [];
-    const activeTokens = tokenItems.filter((token) => token.status === 1);
+    // 服务端只保存密钥哈希，列表中不再返回完整密钥，仅保留仍带有密钥的项
+    const activeTokens = tokenItems.filter(
+      (token) => token.status === 1 && token.key,
+    );
     return activeTokens.map((token) => token.key);
   } catch (error) {
     console.error('Error fetching token keys:', error);
@@ -760,10 +760,11 @@ export const useChannelsData = () => {
     }
 
     const res = await API.get(`/api/channel/update_balance/${record.id}/`);
-    const { success, message, balance } = res.data;
+    const { success, message, balance, balance_estimated } = res.data;
     if (success) {
       updateChannelProperty(record.id, (channel) => {
         channel.balance = balance;
+        channel.balance_estimated = balance_estimated;
         channel.balance_updated_time = Date.now() / 1000;
       });
       showInfo(
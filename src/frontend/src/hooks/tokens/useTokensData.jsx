@@ -52,7 +52,8 @@ export const useTokensData = (openFluentNotification) => {
 
   // UI state
   const [compactMode, setCompactMode] = useTableCompactMode('tokens');
-  const [showKeys, setShowKeys] = useState({});
+  // Full keys returned once by create, rotate or reveal: [{ name, key }]
+  const [revealedKeys, setRevealedKeys] = useState([]);
 
   // Form state
   const [formApi, setFormApi] = useState(null);
@@ -120,10 +121,10 @@ export const useTokensData = (openFluentNotification) => {
     }
   };
 
-  // Open link function for chat integrations
-  const onOpenLink = async (type, url, record) => {
+  // Open link function for chat integrations, using a key shown once
+  const onOpenLink = async (type, url, key) => {
     if (url && url.startsWith('fluent')) {
-      openFluentNotification(record.key);
+      openFluentNotification(key);
       return;
     }
     let status = localStorage.getItem('status');
@@ -139,7 +140,7 @@ export const useTokensData = (openFluentNotification) => {
       let cherryConfig = {
         id: 'new-api',
         baseUrl: serverAddress,
-        apiKey: 'sk-' + record.key,
+        apiKey: 'sk-' + key,
       };
       let encodedConfig = encodeURIComponent(
         encodeToBase64(JSON.stringify(cherryConfig)),
@@ -148,7 +149,7 @@ export const useTokensData = (openFluentNotification) => {
     } else {
       let encodedServerAddress = encodeURIComponent(serverAddress);
       url = url.replaceAll('{address}', encodedServerAddress);
-      url = url.replaceAll('{key}', 'sk-' + record.key);
+      url = url.replaceAll('{key}', 'sk-' + key);
     }
 
     window.open(url, '_blank');
@@ -187,6 +188,30 @@ export const useTokensData = (openFluentNotification) => {
     setLoading(false);
   };
 
+  // Rotate a token key; the old key stays valid during the grace period
+  const rotateTokenKey = async (record) => {
+    const res = await API.post(`/api/token/${record.id}/rotate`);
+    const { success, message, data } = res.data;
+    if (success) {
+      setRevealedKeys([{ name: record.name, key: data.key }]);
+      await refresh();
+    } else {
+      showError(message);
+    }
+  };
+
+  // Reveal the key generated by automatic rotation, which can only be viewed once
+  const revealTokenKey = async (record) => {
+    const res = await API.post(`/api/token/${record.id}/reveal`);
+    const { success, message, data } = res.data;
+    if (success) {
+      setRevealedKeys([{ name: record.name, key: data.key }]);
+      await refresh();
+    } else {
+      showError(message);
+    }
+  };
+
   // Search tokens function
   const searchTokens = async () => {
     const { searchKeyword, searchToken } = getFormValues();
@@ -285,51 +310,6 @@ export const useTokensData = (openFluentNotification) => {
     }
   };
 
-  // Batch copy tokens
-  const batchCopyTokens = (copyType) => {
-    if (selectedKeys.length === 0) {
-      showError(t('请至少选择一个令牌！'));
-      return;
-    }
-
-    Modal.info({
-      title: t('复制令牌'),
-      icon: null,
-      content: t('请选择你的复制方式'),
-      footer: (
-        <div className='flex gap-2'>
-          <button
-            className='px-3 py-1 bg-gray-200 rounded'
-            onClick={async () => {
-              let content = '';
-              for (let i = 0; i < selectedKeys.length; i++) {
-                content +=
-                  selectedKeys[i].name + '    sk-' + selectedKeys[i].key + '\n';
-              }
-              await copyText(content);
-              Modal.destroyAll();
-            }}
-          >
-            {t('名称+密钥')}
-          </button>
-          <button
-            className='px-3 py-1 bg-blue-500 text-white rounded'
-            onClick={async () => {
-              let content = '';
-              for (let i = 0; i < selectedKeys.length; i++) {
-                content += 'sk-' + selectedKeys[i].key + '\n';
-              }
-              await copyText(content);
-              Modal.destroyAll();
-            }}
-          >
-            {t('仅密钥')}
-          </button>
-        </div>
-      ),
-    });
-  };
-
   // Initialize data
   useEffect(() => {
     loadTokens(1)
@@ -362,8 +342,8 @@ export const useTokensData = (openFluentNotification) => {
     // UI state
     compactMode,
     setCompactMode,
-    showKeys,
-    setShowKeys,
+    revealedKeys,
+    setRevealedKeys,
 
     // Form state
     formApi,
@@ -384,7 +364,8 @@ export const useTokensData = (openFluentNotification) => {
     rowSelection,
     handleRow,
     batchDeleteTokens,
-    batchCopyTokens,
+    rotateTokenKey,
+    revealTokenKey,
     syncPageData,
 
     // Translation
@@ -549,6 +549,7 @@ export const useLogsData = () => {
         const pre = other?.subscription_pre_consumed ?? 0;
         const postDelta = other?.subscription_post_delta ?? 0;
         const finalConsumed = other?.subscription_consumed ?? pre + postDelta;
+        const walletOverflow = other?.subscription_wallet_overflow ?? 0;
         const remain = other?.subscription_remain;
         const total = other?.subscription_total;
         // Use multiple Description items to avoid an overlong single line.
@@ -568,6 +569,8 @@ export const useLogsData = () => {
           `${t('预扣')}：${pre} ${unit}`,
           `${t('结算差额')}：${postDelta > 0 ? '+' : ''}${postDelta} ${unit}`,
           `${t('最终抵扣')}：${finalConsumed} ${unit}`,
+          walletOverflow > 0 &&
+            `${t('超出套餐从钱包扣除')}：${walletOverflow} ${unit}`,
         ]
           .filter(Boolean)
           .join('\n');
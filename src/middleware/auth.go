@@ -224,7 +224,13 @@ func TokenAuth() func(c *gin.Context) {
 			parts = strings.Split(key, "-")
 			key = parts[0]
 		}
-		token, err := model.ValidateUserToken(key)
+		var token *model.Token
+		var err error
+		if tokenId, ok := getInternalTokenId(c); ok {
+			token, err = model.ValidateUserTokenById(tokenId)
+		} else {
+			token, err = model.ValidateUserToken(key)
+		}
 		if token != nil {
 			id := c.GetInt("id")
 			if id == 0 {
@@ -284,6 +290,10 @@ func TokenAuth() func(c *gin.Context) {
 		}
 		common.SetContextKey(c, constant.ContextKeyUsingGroup, userGroup)
 
+		if !checkTokenScope(c, token) {
+			return
+		}
+
 		err = SetupContextForToken(c, token, parts...)
 		if err != nil {
 			return
@@ -298,7 +308,7 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	c.Set("id", token.UserId)
 	c.Set("token_id", token.Id)
-	c.Set("token_key", token.Key)
+	common.SetContextKey(c, constant.ContextKeyTokenKeyHash, token.KeyHash)
 	c.Set("token_name", token.Name)
 	c.Set("token_unlimited_quota", token.UnlimitedQuota)
 	if !token.UnlimitedQuota {
@@ -312,6 +322,14 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenRPMLimit, token.RPMLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenTPMLimit, token.TPMLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenConcurrencyLimit, token.ConcurrencyLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenOrganizationId, token.OrganizationId)
+	if token.HasBudget() {
+		common.SetContextKey(c, constant.ContextKeyTokenBudgetPeriodStart, token.BudgetPeriodStart)
+		common.SetContextKey(c, constant.ContextKeyTokenBudgetModelLimits, token.GetBudgetModelLimits())
+	}
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -28,6 +28,9 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// 请求结束时归还选择渠道时占用的并发与熔断试探名额，覆盖校验失败等未实际转发的情况
+		defer service.ReleaseChannelConcurrency(c)
+		defer service.ReleaseChannelBreakerTrials(c)
 		var channel *model.Channel
 		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
@@ -72,6 +75,17 @@ func Distribute() func(c *gin.Context) {
 					return
 				}
 			}
+			// check token budget model limits
+			if budgetModelLimits, ok := common.GetContextKeyType[map[string]int](c, constant.ContextKeyTokenBudgetModelLimits); ok {
+				matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model)
+				if limit, ok := budgetModelLimits[matchName]; ok {
+					periodStart, _ := common.GetContextKeyType[int64](c, constant.ContextKeyTokenBudgetPeriodStart)
+					if err := model.CheckTokenModelBudget(c.GetInt("token_id"), periodStart, matchName, limit); err != nil {
+						abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+						return
+					}
+				}
+			}
 
 			if shouldSelectChannel {
 				if modelRequest.Model == "" {
@@ -100,7 +114,8 @@ func Distribute() func(c *gin.Context) {
 
 				if preferredChannelID, found := service.GetPreferredChannelByAffinity(c, modelRequest.Model, usingGroup); found {
 					preferred, err := model.CacheGetChannel(preferredChannelID)
-					if err == nil && preferred != nil && preferred.Status == common.ChannelStatusEnabled {
+					// 熔断中的渠道不使用亲和性，回落到正常选择
+					if err == nil && preferred != nil && preferred.Status == common.ChannelStatusEnabled && service.IsChannelBreakerAvailable(preferred.Id, service.ChannelBreakerKeyIndexAll) {
 						if usingGroup == "auto" {
 							userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
 							autoGroups := service.GetUserAutoGroup(userGroup)
@@ -118,6 +133,9 @@ func Distribute() func(c *gin.Context) {
 							selectGroup = usingGroup
 							service.MarkChannelAffinityUsed(c, usingGroup, preferred.Id)
 						}
+						if channel != nil && !service.AcquirePreferredChannel(c, channel) {
+							channel = nil
+						}
 					}
 				}
 
@@ -275,7 +293,7 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
+	key, index, newAPIError := channel.GetNextEnabledKeyFor(strconv.Itoa(c.GetInt("id")))
 	if newAPIError != nil {
 		return newAPIError
 	}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 令牌只保存哈希，管理员模型测试等本机转发的请求无法取得令牌明文，
+// 改为携带进程内登记的一次性随机数与令牌 ID 进行认证，随机数只能使用一次且很快过期
+
+const (
+	internalTokenIdHeader     = "X-Internal-Token-Id"
+	internalTokenSecretHeader = "X-Internal-Token-Secret"
+
+	internalTokenNonceTTL = 30 * time.Second
+)
+
+type internalTokenNonce struct {
+	tokenId   int
+	expiresAt time.Time
+}
+
+var internalTokenNonces sync.Map // map[string]internalTokenNonce
+
+// SetInternalTokenAuth 为本机转发的请求登记一次性随机数并设置认证请求头，
+// 返回的函数用于在请求结束后注销未被使用的随机数
+func SetInternalTokenAuth(req *http.Request, tokenId int) (func(), error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(buf)
+	now := time.Now()
+	internalTokenNonces.Range(func(key, value any) bool {
+		if now.After(value.(internalTokenNonce).expiresAt) {
+			internalTokenNonces.Delete(key)
+		}
+		return true
+	})
+	internalTokenNonces.Store(nonce, internalTokenNonce{tokenId: tokenId, expiresAt: now.Add(internalTokenNonceTTL)})
+	req.Header.Set(internalTokenIdHeader, strconv.Itoa(tokenId))
+	req.Header.Set(internalTokenSecretHeader, nonce)
+	return func() {
+		internalTokenNonces.Delete(nonce)
+	}, nil
+}
+
+// getInternalTokenId 校验并消耗本机转发请求的内部认证头，返回令牌 ID；
+// 无论认证是否成功都会移除这两个请求头，避免被转发到上游
+func getInternalTokenId(c *gin.Context) (int, bool) {
+	nonce := c.Request.Header.Get(internalTokenSecretHeader)
+	tokenIdStr := c.Request.Header.Get(internalTokenIdHeader)
+	c.Request.Header.Del(internalTokenSecretHeader)
+	c.Request.Header.Del(internalTokenIdHeader)
+	if nonce == "" {
+		return 0, false
+	}
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return 0, false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return 0, false
+	}
+	value, ok := internalTokenNonces.LoadAndDelete(nonce)
+	if !ok {
+		return 0, false
+	}
+	entry := value.(internalTokenNonce)
+	if time.Now().After(entry.expiresAt) {
+		return 0, false
+	}
+	tokenId, err := strconv.Atoi(tokenIdStr)
+	if err != nil || tokenId <= 0 || tokenId != entry.tokenId {
+		return 0, false
+	}
+	return tokenId, true
+}
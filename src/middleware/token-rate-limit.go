@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tokenRateLimitKeyPrefix = "rateLimit:scope:"
+	// 令牌桶按秒补充令牌，每分钟上限放大 60 倍后可用整数表示每秒补充量
+	rateLimitScale = 60
+	// 并发计数的过期时间，防止实例异常退出后名额无法释放
+	concurrencyLimitTTL = 30 * time.Minute
+)
+
+var memoryScopeLimiter = limiter.NewMemoryLimiter()
+
+// rateLimitScope 一个需要限流的主体（令牌或用户）
+type rateLimitScope struct {
+	name        string
+	id          int
+	rpm         int
+	tpm         int
+	concurrency int
+}
+
+func (s rateLimitScope) key(kind string) string {
+	return fmt.Sprintf("%s%s:%s:%d", tokenRateLimitKeyPrefix, kind, s.name, s.id)
+}
+
+// rateLimitStatus 用于生成 x-ratelimit-* 响应头，取所有主体中最紧张的一项
+type rateLimitStatus struct {
+	limit     int64
+	remaining int64
+	reset     time.Duration
+}
+
+func (s *rateLimitStatus) merge(limit int64, remaining int64, reset time.Duration) {
+	if s.limit == 0 || remaining < s.remaining {
+		s.limit, s.remaining, s.reset = limit, remaining, reset
+	}
+}
+
+func takeScopeBucket(key string, perMinute int, requested int64, force bool) limiter.Result {
+	opts := []limiter.Option{
+		limiter.WithCapacity(int64(perMinute) * rateLimitScale),
+		limiter.WithRate(int64(perMinute)),
+		limiter.WithRequested(requested * rateLimitScale),
+	}
+	if force {
+		opts = append(opts, limiter.WithForce())
+	}
+	if common.RedisEnabled && common.RDB != nil {
+		ctx := context.Background()
+		result, err := limiter.New(ctx, common.RDB).Take(ctx, key, opts...)
+		if err == nil {
+			return result
+		}
+		common.SysError("token rate limit failed, fallback to memory limiter: " + err.Error())
+	}
+	return memoryScopeLimiter.Take(key, opts...)
+}
+
+func acquireScopeConcurrency(key string, limit int) (bool, int64) {
+	if common.RedisEnabled && common.RDB != nil {
+		ctx := context.Background()
+		ok, current, err := limiter.New(ctx, common.RDB).Acquire(ctx, key, int64(limit), concurrencyLimitTTL)
+		if err == nil {
+			return ok, current
+		}
+		common.SysError("token concurrency limit failed, fallback to memory limiter: " + err.Error())
+	}
+	return memoryScopeLimiter.Acquire(key, int64(limit))
+}
+
+func releaseScopeConcurrency(key string) {
+	if common.RedisEnabled && common.RDB != nil {
+		ctx := context.Background()
+		if err := limiter.New(ctx, common.RDB).Release(ctx, key); err == nil {
+			return
+		}
+	}
+	memoryScopeLimiter.Release(key)
+}
+
+// bucketReset 令牌桶恢复满额所需的时间
+func bucketReset(perMinute int, tokens int64) time.Duration {
+	missing := int64(perMinute)*rateLimitScale - tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(float64(missing)/float64(perMinute))) * time.Second
+}
+
+// bucketWait 令牌桶中至少有 requested 个令牌所需的等待时间
+func bucketWait(perMinute int, tokens int64, requested int64) time.Duration {
+	missing := requested*rateLimitScale - tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(float64(missing)/float64(perMinute))) * time.Second
+}
+
+func setRateLimitHeaders(c *gin.Context, requests rateLimitStatus, tokens rateLimitStatus) {
+	header := c.Writer.Header()
+	if requests.limit > 0 {
+		header.Set("x-ratelimit-limit-requests", strconv.FormatInt(requests.limit, 10))
+		header.Set("x-ratelimit-remaining-requests", strconv.FormatInt(max(requests.remaining, 0), 10))
+		header.Set("x-ratelimit-reset-requests", requests.reset.String())
+	}
+	if tokens.limit > 0 {
+		header.Set("x-ratelimit-limit-tokens", strconv.FormatInt(tokens.limit, 10))
+		header.Set("x-ratelimit-remaining-tokens", strconv.FormatInt(max(tokens.remaining, 0), 10))
+		header.Set("x-ratelimit-reset-tokens", tokens.reset.String())
+	}
+}
+
+// abortWithRateLimit 返回与 OpenAI 一致的 429 错误格式
+func abortWithRateLimit(c *gin.Context, limitType string, message string, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	}
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			"type":    limitType,
+			"param":   nil,
+			"code":    "rate_limit_exceeded",
+		},
+	})
+	c.Abort()
+	common.SysLog(fmt.Sprintf("user %d | %s", c.GetInt("id"), message))
+}
+
+func getRateLimitScopes(c *gin.Context) []rateLimitScope {
+	scopes := make([]rateLimitScope, 0, 2)
+	token := rateLimitScope{
+		name:        "token",
+		id:          c.GetInt("token_id"),
+		rpm:         common.GetContextKeyInt(c, constant.ContextKeyTokenRPMLimit),
+		tpm:         common.GetContextKeyInt(c, constant.ContextKeyTokenTPMLimit),
+		concurrency: common.GetContextKeyInt(c, constant.ContextKeyTokenConcurrencyLimit),
+	}
+	if token.id != 0 && (token.rpm > 0 || token.tpm > 0 || token.concurrency > 0) {
+		scopes = append(scopes, token)
+	}
+	if setting, ok := common.GetContextKeyType[dto.UserSetting](c, constant.ContextKeyUserSetting); ok {
+		user := rateLimitScope{
+			name:        "user",
+			id:          c.GetInt("id"),
+			rpm:         setting.RPMLimit,
+			tpm:         setting.TPMLimit,
+			concurrency: setting.ConcurrencyLimit,
+		}
+		if user.id != 0 && (user.rpm > 0 || user.tpm > 0 || user.concurrency > 0) {
+			scopes = append(scopes, user)
+		}
+	}
+	return scopes
+}
+
+// TokenRateLimit 按令牌与用户限制每分钟请求数、每分钟 token 数与并发请求数
+func TokenRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes := getRateLimitScopes(c)
+		if len(scopes) == 0 {
+			c.Next()
+			return
+		}
+
+		var requests, tokens rateLimitStatus
+		for _, scope := range scopes {
+			if scope.tpm > 0 {
+				// 请求前无法得知 token 用量，只检查是否还有剩余，请求结束后按实际用量扣除
+				result := takeScopeBucket(scope.key("tpm"), scope.tpm, 0, false)
+				tokens.merge(int64(scope.tpm), result.Tokens/rateLimitScale, bucketReset(scope.tpm, result.Tokens))
+				if result.Tokens <= 0 {
+					wait := bucketWait(scope.tpm, result.Tokens, 1)
+					setRateLimitHeaders(c, requests, tokens)
+					abortWithRateLimit(c, "tokens", fmt.Sprintf("Rate limit reached for %s on tokens per min (TPM): Limit %d, Used %d. Please try again in %s.",
+						scope.name, scope.tpm, scope.tpm-int(result.Tokens/rateLimitScale), wait), wait)
+					return
+				}
+			}
+			if scope.rpm > 0 {
+				result := takeScopeBucket(scope.key("rpm"), scope.rpm, 1, false)
+				requests.merge(int64(scope.rpm), result.Tokens/rateLimitScale, bucketReset(scope.rpm, result.Tokens))
+				if !result.Allowed {
+					wait := bucketWait(scope.rpm, result.Tokens, 1)
+					setRateLimitHeaders(c, requests, tokens)
+					abortWithRateLimit(c, "requests", fmt.Sprintf("Rate limit reached for %s on requests per min (RPM): Limit %d, Used %d, Requested 1. Please try again in %s.",
+						scope.name, scope.rpm, scope.rpm-int(result.Tokens/rateLimitScale), wait), wait)
+					return
+				}
+			}
+		}
+
+		acquired := make([]string, 0, len(scopes))
+		defer func() {
+			for _, key := range acquired {
+				releaseScopeConcurrency(key)
+			}
+		}()
+		for _, scope := range scopes {
+			if scope.concurrency <= 0 {
+				continue
+			}
+			key := scope.key("concurrency")
+			ok, current := acquireScopeConcurrency(key, scope.concurrency)
+			if !ok {
+				abortWithRateLimit(c, "requests", fmt.Sprintf("Rate limit reached for %s on concurrent requests: Limit %d, Current %d. Please wait for in-flight requests to finish.",
+					scope.name, scope.concurrency, current), time.Second)
+				return
+			}
+			acquired = append(acquired, key)
+		}
+
+		setRateLimitHeaders(c, requests, tokens)
+		c.Next()
+
+		used := common.GetContextKeyInt(c, constant.ContextKeyRelayTotalTokens)
+		if used <= 0 {
+			return
+		}
+		for _, scope := range scopes {
+			if scope.tpm > 0 {
+				takeScopeBucket(scope.key("tpm"), scope.tpm, int64(used), true)
+			}
+		}
+	}
+}
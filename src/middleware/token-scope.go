@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getRequestRelayFormat 根据请求路径推断中继格式，仅用于区分同一路径下的 Claude 与 Gemini 接口
+func getRequestRelayFormat(c *gin.Context) types.RelayFormat {
+	path := c.Request.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/v1/messages"):
+		return types.RelayFormatClaude
+	case c.Request.Method == http.MethodPost &&
+		(strings.HasPrefix(path, "/v1beta/models/") || strings.HasPrefix(path, "/v1/models/")):
+		return types.RelayFormatGemini
+	}
+	return types.RelayFormatOpenAI
+}
+
+// getRequestTokenScope 返回当前请求所需的令牌接口范围，第二个返回值为 false 表示该接口不受范围限制
+func getRequestTokenScope(c *gin.Context) (constant.TokenScope, bool) {
+	path := c.Request.URL.Path
+	if !strings.HasPrefix(path, "/v1") {
+		// 例如 /api/usage/token，令牌查询自身用量不受范围限制
+		return "", false
+	}
+	if strings.HasPrefix(path, "/v1/dashboard/billing") {
+		// 与 /api/usage/token 一致，令牌查询自身的额度与用量不受范围限制
+		return "", false
+	}
+	if c.Request.Method == http.MethodGet &&
+		(strings.HasPrefix(path, "/v1/models") || strings.HasPrefix(path, "/v1beta/models") || strings.HasPrefix(path, "/v1beta/openai/models")) {
+		return constant.TokenScopeModels, true
+	}
+	switch getRequestRelayFormat(c) {
+	case types.RelayFormatClaude:
+		return constant.TokenScopeClaude, true
+	case types.RelayFormatGemini:
+		return constant.TokenScopeGemini, true
+	}
+	if strings.HasPrefix(path, "/v1/files") || strings.HasPrefix(path, "/v1/batches") || strings.HasPrefix(path, "/v1/fine-tunes") {
+		return constant.TokenScopeFiles, true
+	}
+	switch relayconstant.Path2RelayMode(path) {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions:
+		return constant.TokenScopeChat, true
+	case relayconstant.RelayModeResponses, relayconstant.RelayModeResponsesCompact:
+		return constant.TokenScopeResponses, true
+	case relayconstant.RelayModeEmbeddings:
+		return constant.TokenScopeEmbeddings, true
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeEdits:
+		return constant.TokenScopeImages, true
+	case relayconstant.RelayModeAudioSpeech, relayconstant.RelayModeAudioTranscription, relayconstant.RelayModeAudioTranslation:
+		return constant.TokenScopeAudio, true
+	case relayconstant.RelayModeRerank:
+		return constant.TokenScopeRerank, true
+	case relayconstant.RelayModeRealtime:
+		return constant.TokenScopeRealtime, true
+	case relayconstant.RelayModeModerations:
+		return constant.TokenScopeModeration, true
+	}
+	// 无法识别的中继接口只允许未设置范围的令牌调用
+	return "", true
+}
+
+// checkTokenScope 检查令牌是否允许调用当前接口
+func checkTokenScope(c *gin.Context, token *model.Token) bool {
+	if token.Scopes == "" {
+		return true
+	}
+	scope, restricted := getRequestTokenScope(c)
+	if !restricted {
+		return true
+	}
+	if scope != "" && token.HasScope(scope) {
+		return true
+	}
+	message := "该令牌无权调用此接口"
+	if scope != "" {
+		message = "该令牌无权调用此接口，需要 " + string(scope) + " 范围"
+	}
+	abortWithOpenAiMessage(c, http.StatusForbidden, message, types.ErrorCodeAccessDenied)
+	return false
+}
@@ -143,6 +143,22 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 	return &channel, err
 }
 
+func getSatisfiedChannelsFromDB(group string, model string, retry int) ([]*Channel, error) {
+	channelQuery, err := getChannelQuery(group, model, retry)
+	if err != nil {
+		return nil, err
+	}
+	var channelIds []int
+	err = channelQuery.Model(&Ability{}).Pluck("channel_id", &channelIds).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(channelIds) == 0 {
+		return nil, nil
+	}
+	return GetChannelsByIds(channelIds)
+}
+
 func (channel *Channel) AddAbilities(tx *gorm.DB) error {
 	models_ := strings.Split(channel.Models, ",")
 	groups_ := strings.Split(channel.Group, ",")
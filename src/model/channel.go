@@ -21,7 +21,7 @@ import (
 type Channel struct {
 	Id                 int     `json:"id"`
 	Type               int     `json:"type" gorm:"default:0"`
-	Key                string  `json:"key" gorm:"not null"`
+	Key                string  `json:"key" gorm:"not null;serializer:encrypted"`
 	OpenAIOrganization *string `json:"openai_organization"`
 	TestModel          *string `json:"test_model"`
 	Status             int     `json:"status" gorm:"default:1"`
@@ -123,7 +123,37 @@ func (channel *Channel) GetKeys() []string {
 	return keys
 }
 
+var multiKeyAvailabilityChecker func(channelId int, keyIndex int) bool
+
+// SetMultiKeyAvailabilityChecker 注册多密钥可用性检查（如密钥级熔断），在选择密钥时跳过不可用的密钥
+func SetMultiKeyAvailabilityChecker(checker func(channelId int, keyIndex int) bool) {
+	multiKeyAvailabilityChecker = checker
+}
+
 func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
+	return channel.GetNextEnabledKeyFor("")
+}
+
+// GetNextEnabledKeyFor 按多密钥模式选择一个启用的密钥，stickyKey 用于 sticky_user 模式（通常为用户 ID）
+func (channel *Channel) GetNextEnabledKeyFor(stickyKey string) (string, int, *types.NewAPIError) {
+	if channel.HasUndecryptableKey() {
+		return "", 0, types.NewError(errors.New("channel key cannot be decrypted with the configured master keys"), types.ErrorCodeChannelInvalidKey)
+	}
+	key, idx, err := channel.selectEnabledKey(stickyKey)
+	if err != nil {
+		return key, idx, err
+	}
+	if channel.ChannelInfo.IsMultiKey {
+		RecordMultiKeyUsed(channel, idx)
+	}
+	resolved, resolveErr := ResolveChannelKey(channel.Id, key)
+	if resolveErr != nil {
+		return "", idx, types.NewError(resolveErr, types.ErrorCodeChannelInvalidKey)
+	}
+	return resolved, idx, nil
+}
+
+func (channel *Channel) selectEnabledKey(stickyKey string) (string, int, *types.NewAPIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
 		return channel.Key, 0, nil
@@ -165,6 +195,29 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	if len(enabledIdx) == 0 {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
+	// 跳过熔断中的密钥；若全部熔断则仍在启用的密钥中选择
+	if multiKeyAvailabilityChecker != nil {
+		availableIdx := make([]int, 0, len(enabledIdx))
+		for _, idx := range enabledIdx {
+			if multiKeyAvailabilityChecker(channel.Id, idx) {
+				availableIdx = append(availableIdx, idx)
+			}
+		}
+		if len(availableIdx) > 0 && len(availableIdx) < len(enabledIdx) {
+			enabledIdx = availableIdx
+			baseStatus := getStatus
+			available := make(map[int]bool, len(availableIdx))
+			for _, idx := range availableIdx {
+				available[idx] = true
+			}
+			getStatus = func(idx int) int {
+				if !available[idx] {
+					return common.ChannelStatusAutoDisabled
+				}
+				return baseStatus(idx)
+			}
+		}
+	}
 
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
@@ -204,6 +257,16 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		// Fallback – should not happen, but return first enabled key
 		return keys[enabledIdx[0]], enabledIdx[0], nil
+	case constant.MultiKeyModeLeastRecentUsed, constant.MultiKeyModeLowestErrorRate, constant.MultiKeyModeQuotaAware:
+		selectedIdx := pickMultiKeyByUsage(channel, enabledIdx)
+		return keys[selectedIdx], selectedIdx, nil
+	case constant.MultiKeyModeStickyUser:
+		if stickyKey == "" {
+			selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
+			return keys[selectedIdx], selectedIdx, nil
+		}
+		selectedIdx := pickMultiKeyBySticky(stickyKey, enabledIdx)
+		return keys[selectedIdx], selectedIdx, nil
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
 		return keys[enabledIdx[0]], enabledIdx[0], nil
@@ -336,6 +399,7 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 	// 构造WHERE子句
 	var whereClause string
 	var args []interface{}
+	keyCondition, keyArg := channelKeyCondition(keyword)
 	if group != "" && group != "null" {
 		var groupCondition string
 		if common.UsingMySQL {
@@ -344,11 +408,11 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR " + keyCondition + " OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyArg, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR " + keyCondition + " OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyArg, "%"+keyword+"%", "%"+model+"%")
 	}
 
 	// 执行查询
@@ -405,6 +469,32 @@ func BatchInsertChannels(channels []Channel) error {
 	return tx.Commit().Error
 }
 
+// ImportChannels 在同一事务中创建与覆盖渠道，并重建对应的能力
+func ImportChannels(creates []*Channel, updates []*Channel) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, channel := range creates {
+			if err := tx.Create(channel).Error; err != nil {
+				return err
+			}
+			if err := channel.AddAbilities(tx); err != nil {
+				return err
+			}
+		}
+		for _, channel := range updates {
+			if err := tx.Save(channel).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("channel_id = ?", channel.Id).Delete(&Ability{}).Error; err != nil {
+				return err
+			}
+			if err := channel.AddAbilities(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func BatchDeleteChannels(ids []int) error {
 	if len(ids) == 0 {
 		return nil
@@ -598,6 +688,9 @@ func handlerMultiKeyUpdate(channel *Channel, usingKey string, status int, reason
 	} else {
 		var keyIndex int
 		for i, key := range keys {
+			if resolved, ok := common.LookupSecretRef(key); ok {
+				key = resolved
+			}
 			if key == usingKey {
 				keyIndex = i
 				break
@@ -700,6 +793,56 @@ func UpdateChannelStatus(channelId int, usingKey string, status int, reason stri
 	return true
 }
 
+// UpdateMultiKeyStatus 按索引更新多密钥渠道中单个密钥的状态。
+// 启用密钥时，若渠道此前因全部密钥被禁用而自动禁用，则同时恢复渠道。
+func UpdateMultiKeyStatus(channelId int, keyIndex int, status int, reason string) error {
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return err
+	}
+	if !channel.ChannelInfo.IsMultiKey || keyIndex < 0 || keyIndex >= channel.ChannelInfo.MultiKeySize {
+		return fmt.Errorf("invalid key index %d for channel %d", keyIndex, channelId)
+	}
+
+	beforeStatus := channel.Status
+	pollingLock := GetChannelPollingLock(channelId)
+	pollingLock.Lock()
+	info := &channel.ChannelInfo
+	if status == common.ChannelStatusEnabled {
+		delete(info.MultiKeyStatusList, keyIndex)
+		delete(info.MultiKeyDisabledTime, keyIndex)
+		delete(info.MultiKeyDisabledReason, keyIndex)
+		if channel.Status == common.ChannelStatusAutoDisabled && len(info.MultiKeyStatusList) < info.MultiKeySize {
+			channel.Status = common.ChannelStatusEnabled
+		}
+	} else {
+		if info.MultiKeyStatusList == nil {
+			info.MultiKeyStatusList = make(map[int]int)
+		}
+		if info.MultiKeyDisabledReason == nil {
+			info.MultiKeyDisabledReason = make(map[int]string)
+		}
+		if info.MultiKeyDisabledTime == nil {
+			info.MultiKeyDisabledTime = make(map[int]int64)
+		}
+		info.MultiKeyStatusList[keyIndex] = status
+		info.MultiKeyDisabledReason[keyIndex] = reason
+		info.MultiKeyDisabledTime[keyIndex] = common.GetTimestamp()
+		if channel.Status == common.ChannelStatusEnabled && len(info.MultiKeyStatusList) >= info.MultiKeySize {
+			channel.Status = common.ChannelStatusAutoDisabled
+		}
+	}
+	pollingLock.Unlock()
+
+	if err = channel.SaveWithoutKey(); err != nil {
+		return err
+	}
+	if beforeStatus != channel.Status {
+		return UpdateAbilityStatus(channelId, channel.Status == common.ChannelStatusEnabled)
+	}
+	return nil
+}
+
 // UpdateChannelStatusManual toggles the whole channel status without relying on a specific key.
 // This is intended for admin/manual operations (e.g. mapping page toggle).
 func UpdateChannelStatusManual(channelId int, status int, reason string) bool {
@@ -835,6 +978,12 @@ func DeleteChannelByStatus(status int64) (int64, error) {
 	return result.RowsAffected, result.Error
 }
 
+func GetDisabledChannels() ([]*Channel, error) {
+	var channels []*Channel
+	err := DB.Omit("key").Where("status = ? or status = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled).Find(&channels).Error
+	return channels, err
+}
+
 func DeleteDisabledChannel() (int64, error) {
 	result := DB.Where("status = ? or status = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled).Delete(&Channel{})
 	return result.RowsAffected, result.Error
@@ -872,6 +1021,7 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 	// 构造WHERE子句
 	var whereClause string
 	var args []interface{}
+	keyCondition, keyArg := channelKeyCondition(keyword)
 	if group != "" && group != "null" {
 		var groupCondition string
 		if common.UsingMySQL {
@@ -880,11 +1030,11 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + commonGroupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR " + keyCondition + " OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyArg, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + commonKeyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR " + keyCondition + " OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyArg, "%"+keyword+"%", "%"+model+"%")
 	}
 
 	subQuery := baseQuery.Where(whereClause, args...).
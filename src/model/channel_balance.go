@@ -0,0 +1,59 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// ChannelBalanceHistory 渠道余额历史，每次成功查询余额记录一条，用于计算消耗速度
+type ChannelBalanceHistory struct {
+	Id        int     `json:"id"`
+	ChannelId int     `json:"channel_id" gorm:"index:idx_ch_balance_channel_time,priority:1"`
+	CreatedAt int64   `json:"created_at" gorm:"bigint;index:idx_ch_balance_channel_time,priority:2;index"`
+	Balance   float64 `json:"balance"`
+}
+
+func RecordChannelBalance(channelId int, balance float64) error {
+	return DB.Create(&ChannelBalanceHistory{
+		ChannelId: channelId,
+		CreatedAt: common.GetTimestamp(),
+		Balance:   balance,
+	}).Error
+}
+
+func GetChannelBalanceHistory(channelId int, startTime int64) ([]*ChannelBalanceHistory, error) {
+	var history []*ChannelBalanceHistory
+	err := DB.Where("channel_id = ? and created_at >= ?", channelId, startTime).
+		Order("created_at asc").Find(&history).Error
+	return history, err
+}
+
+func DeleteChannelBalanceHistoryBefore(timestamp int64) (int64, error) {
+	result := DB.Where("created_at < ?", timestamp).Delete(&ChannelBalanceHistory{})
+	return result.RowsAffected, result.Error
+}
+
+// UpdateChannelPriority 更新渠道及其能力的优先级
+func UpdateChannelPriority(channelId int, priority int64) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Channel{}).Where("id = ?", channelId).Update("priority", priority).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Ability{}).Where("channel_id = ?", channelId).Update("priority", priority).Error
+	})
+}
+
+// UpdateChannelOtherInfo 仅更新渠道的 other_info 字段
+func UpdateChannelOtherInfo(channel *Channel) error {
+	return DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("other_info", channel.OtherInfo).Error
+}
+
+// SumChannelQuotaSince 统计渠道自指定时间起的消耗额度
+func SumChannelQuotaSince(channelId int, startTime int64) (int64, error) {
+	var quota int64
+	err := LOG_DB.Table("logs").Select("COALESCE(SUM(quota), 0)").
+		Where("channel_id = ? AND type = ? AND created_at >= ?", channelId, LogTypeConsume, startTime).
+		Scan(&quota).Error
+	return quota, err
+}
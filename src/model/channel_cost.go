@@ -0,0 +1,196 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func otherFloat(other map[string]interface{}, key string) float64 {
+	switch v := other[key].(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	}
+	return 0
+}
+
+// upstreamModelPrice 依次按上游模型名、请求模型名和 "*" 匹配上游价格
+func upstreamModelPrice(cost *dto.UpstreamCost, modelName string, other map[string]interface{}) (dto.UpstreamModelPrice, bool) {
+	if len(cost.Models) == 0 {
+		return dto.UpstreamModelPrice{}, false
+	}
+	if upstreamModel, ok := other["upstream_model_name"].(string); ok && upstreamModel != "" {
+		if price, ok := cost.Models[upstreamModel]; ok {
+			return price, true
+		}
+	}
+	if price, ok := cost.Models[modelName]; ok {
+		return price, true
+	}
+	price, ok := cost.Models["*"]
+	return price, ok
+}
+
+// ComputeUpstreamCost 按渠道的上游成本设置计算一次请求的上游成本（额度），
+// known 表示渠道是否设置了适用于该请求的上游成本，成本为 0 的免费上游也属于已知成本
+func ComputeUpstreamCost(params RecordConsumeLogParams) (cost int, known bool) {
+	if params.ChannelId == 0 {
+		return 0, false
+	}
+	channel, err := CacheGetChannel(params.ChannelId)
+	if err != nil {
+		return 0, false
+	}
+	upstream := channel.GetOtherSettings().UpstreamCost
+	if upstream == nil {
+		return 0, false
+	}
+	other := params.Other
+	if price, ok := upstreamModelPrice(upstream, params.ModelName, other); ok {
+		cacheRead := otherFloat(other, "cache_tokens")
+		cacheWrite := otherFloat(other, "cache_creation_tokens")
+		input := float64(params.PromptTokens)
+		if claude, _ := other["claude"].(bool); !claude {
+			// Anthropic 的输入 tokens 不包含缓存，其他渠道的输入 tokens 包含缓存
+			input = math.Max(input-cacheRead-cacheWrite, 0)
+		}
+		if price.CacheRead == 0 {
+			price.CacheRead = price.Input
+		}
+		if price.CacheWrite == 0 {
+			price.CacheWrite = price.Input
+		}
+		usd := (input*price.Input+cacheRead*price.CacheRead+cacheWrite*price.CacheWrite+
+			float64(params.CompletionTokens)*price.Output)/1_000_000 + price.PerRequest
+		return int(math.Round(usd * common.QuotaPerUnit)), true
+	}
+	if upstream.Discount > 0 {
+		// 折扣相对本站标价计算，需要去掉分组倍率
+		groupRatio := otherFloat(other, "group_ratio")
+		if groupRatio <= 0 {
+			groupRatio = 1
+		}
+		return int(math.Round(float64(params.Quota) / groupRatio * upstream.Discount)), true
+	}
+	return 0, false
+}
+
+// MarginReportItem 利润报表的一行，额度单位与日志一致
+type MarginReportItem struct {
+	Key           string  `json:"key" gorm:"column:dimension"`
+	Name          string  `json:"name,omitempty" gorm:"-"`
+	Requests      int64   `json:"requests"`
+	Quota         int64   `json:"quota"`
+	UpstreamCost  int64   `json:"upstream_cost"`
+	UncostedQuota int64   `json:"uncosted_quota"`  // 未设置上游成本的请求产生的额度，这部分利润无法准确计算
+	Margin        int64   `json:"margin" gorm:"-"` // 计费额度 - 上游成本，不含未设置成本的部分
+	MarginRate    float64 `json:"margin_rate" gorm:"-"`
+}
+
+var marginReportDimensions = map[string]string{
+	"channel": "channel_id",
+	"model":   "model_name",
+	"user":    "username",
+	"day":     "created_at - created_at % 86400",
+}
+
+func IsValidMarginReportDimension(groupBy string) bool {
+	_, ok := marginReportDimensions[groupBy]
+	return ok || groupBy == "group"
+}
+
+// GetMarginReport 按渠道、模型、分组、用户或天汇总消费日志的计费额度与上游成本
+func GetMarginReport(groupBy string, startTimestamp int64, endTimestamp int64, modelName string, username string, channel int, group string) (items []*MarginReportItem, total MarginReportItem, err error) {
+	column, ok := marginReportDimensions[groupBy]
+	if groupBy == "group" {
+		column, ok = logGroupCol, true
+	}
+	if !ok {
+		return nil, total, fmt.Errorf("invalid group_by: %s", groupBy)
+	}
+	tx := LOG_DB.Table("logs").Where("type = ?", LogTypeConsume)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if modelName != "" {
+		tx = tx.Where("model_name like ?", modelName)
+	}
+	if username != "" {
+		tx = tx.Where("username = ?", username)
+	}
+	if channel != 0 {
+		tx = tx.Where("channel_id = ?", channel)
+	}
+	if group != "" {
+		tx = tx.Where(logGroupCol+" = ?", group)
+	}
+	err = tx.Select(column + " AS dimension, count(*) AS requests, COALESCE(sum(quota), 0) AS quota, " +
+		"COALESCE(sum(upstream_cost), 0) AS upstream_cost, " +
+		"COALESCE(sum(CASE WHEN cost_known THEN 0 ELSE quota END), 0) AS uncosted_quota").
+		Group("dimension").Order("quota desc").Scan(&items).Error
+	if err != nil {
+		return nil, total, err
+	}
+
+	channelNames := make(map[int]string)
+	if groupBy == "channel" && len(items) > 0 {
+		var channels []struct {
+			Id   int
+			Name string
+		}
+		if err = DB.Table("channels").Select("id, name").Find(&channels).Error; err != nil {
+			return nil, total, err
+		}
+		for _, c := range channels {
+			channelNames[c.Id] = c.Name
+		}
+	}
+	for _, item := range items {
+		switch groupBy {
+		case "channel":
+			id, _ := strconv.Atoi(item.Key)
+			item.Name = channelNames[id]
+		case "day":
+			day, _ := strconv.ParseInt(item.Key, 10, 64)
+			item.Name = time.Unix(day, 0).UTC().Format("2006-01-02")
+		}
+		item.fillMargin()
+		total.Requests += item.Requests
+		total.Quota += item.Quota
+		total.UpstreamCost += item.UpstreamCost
+		total.UncostedQuota += item.UncostedQuota
+	}
+	if groupBy == "day" {
+		sort.Slice(items, func(i, j int) bool {
+			a, _ := strconv.ParseInt(items[i].Key, 10, 64)
+			b, _ := strconv.ParseInt(items[j].Key, 10, 64)
+			return a < b
+		})
+	}
+	total.Key = "total"
+	total.fillMargin()
+	return items, total, nil
+}
+
+func (item *MarginReportItem) fillMargin() {
+	// 利润与利润率只统计设置了上游成本的部分
+	costed := item.Quota - item.UncostedQuota
+	item.Margin = costed - item.UpstreamCost
+	if costed > 0 {
+		item.MarginRate = float64(item.Margin) / float64(costed)
+	}
+}
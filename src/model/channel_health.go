@@ -0,0 +1,197 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelHealthLatencyBounds 延迟直方图的桶上界（毫秒），最后一个桶为溢出桶
+var ChannelHealthLatencyBounds = []int64{100, 250, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 15000, 20000, 30000, 60000, 120000}
+
+// ChannelHealth 渠道健康度统计，按 渠道 × 模型 × 密钥 每小时聚合
+type ChannelHealth struct {
+	Id           int            `json:"id"`
+	ChannelId    int            `json:"channel_id" gorm:"index:idx_ch_health_channel_time,priority:1"`
+	ModelName    string         `json:"model_name" gorm:"size:128;default:''"`
+	KeyIndex     int            `json:"key_index" gorm:"default:-1"` // -1 表示非多密钥渠道
+	CreatedAt    int64          `json:"created_at" gorm:"bigint;index:idx_ch_health_channel_time,priority:2;index"`
+	Requests     int            `json:"requests" gorm:"default:0"`
+	Failures     int            `json:"failures" gorm:"default:0"`
+	ErrorClasses map[string]int `json:"error_classes" gorm:"serializer:json;type:text"`
+	LatencyHist  []int64        `json:"latency_hist" gorm:"serializer:json;type:text"`
+	TTFTHist     []int64        `json:"ttft_hist" gorm:"serializer:json;type:text"`
+	OutputTokens int64          `json:"output_tokens" gorm:"default:0"`
+	GenerationMs int64          `json:"generation_ms" gorm:"default:0"` // 生成输出 token 所用时间，用于计算 tokens/s
+}
+
+// ChannelHealthSample 一次发往渠道的请求结果
+type ChannelHealthSample struct {
+	ChannelId    int
+	ModelName    string
+	KeyIndex     int
+	Failed       bool
+	ErrorClass   string
+	Latency      time.Duration
+	TTFT         time.Duration // 0 表示非流式或未收到首字
+	OutputTokens int
+	GenerationMs int64
+}
+
+var cacheChannelHealth = make(map[string]*ChannelHealth)
+var cacheChannelHealthLock = sync.Mutex{}
+
+func newHistogram() []int64 {
+	return make([]int64, len(ChannelHealthLatencyBounds)+1)
+}
+
+func histogramBucket(d time.Duration) int {
+	ms := d.Milliseconds()
+	for i, bound := range ChannelHealthLatencyBounds {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(ChannelHealthLatencyBounds)
+}
+
+func mergeHistogram(dst []int64, src []int64) []int64 {
+	if len(dst) != len(ChannelHealthLatencyBounds)+1 {
+		merged := newHistogram()
+		copy(merged, dst)
+		dst = merged
+	}
+	for i := 0; i < len(src) && i < len(dst); i++ {
+		dst[i] += src[i]
+	}
+	return dst
+}
+
+// LogChannelHealth 将一次请求结果计入当前小时的内存聚合
+func LogChannelHealth(sample ChannelHealthSample) {
+	now := time.Now().Unix()
+	createdAt := now - (now % 3600)
+	key := fmt.Sprintf("%d-%s-%d-%d", sample.ChannelId, sample.ModelName, sample.KeyIndex, createdAt)
+
+	cacheChannelHealthLock.Lock()
+	defer cacheChannelHealthLock.Unlock()
+	health, ok := cacheChannelHealth[key]
+	if !ok {
+		health = &ChannelHealth{
+			ChannelId:    sample.ChannelId,
+			ModelName:    sample.ModelName,
+			KeyIndex:     sample.KeyIndex,
+			CreatedAt:    createdAt,
+			ErrorClasses: make(map[string]int),
+			LatencyHist:  newHistogram(),
+			TTFTHist:     newHistogram(),
+		}
+		cacheChannelHealth[key] = health
+	}
+	health.Requests++
+	if sample.Failed {
+		health.Failures++
+	}
+	if sample.ErrorClass != "" {
+		health.ErrorClasses[sample.ErrorClass]++
+	}
+	// 仅成功请求计入延迟与速度分布
+	if sample.ErrorClass == "" {
+		health.LatencyHist[histogramBucket(sample.Latency)]++
+		if sample.TTFT > 0 {
+			health.TTFTHist[histogramBucket(sample.TTFT)]++
+		}
+		if sample.OutputTokens > 0 && sample.GenerationMs > 0 {
+			health.OutputTokens += int64(sample.OutputTokens)
+			health.GenerationMs += sample.GenerationMs
+		}
+	}
+}
+
+// SaveChannelHealthCache 将内存中的健康度统计合并写入数据库
+func SaveChannelHealthCache() {
+	cacheChannelHealthLock.Lock()
+	pending := cacheChannelHealth
+	cacheChannelHealth = make(map[string]*ChannelHealth)
+	cacheChannelHealthLock.Unlock()
+
+	for _, health := range pending {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			existing := &ChannelHealth{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("channel_id = ? and model_name = ? and key_index = ? and created_at = ?",
+					health.ChannelId, health.ModelName, health.KeyIndex, health.CreatedAt).
+				First(existing).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return tx.Create(health).Error
+			}
+			if err != nil {
+				return err
+			}
+			existing.Requests += health.Requests
+			existing.Failures += health.Failures
+			if existing.ErrorClasses == nil {
+				existing.ErrorClasses = make(map[string]int)
+			}
+			for class, count := range health.ErrorClasses {
+				existing.ErrorClasses[class] += count
+			}
+			existing.LatencyHist = mergeHistogram(existing.LatencyHist, health.LatencyHist)
+			existing.TTFTHist = mergeHistogram(existing.TTFTHist, health.TTFTHist)
+			existing.OutputTokens += health.OutputTokens
+			existing.GenerationMs += health.GenerationMs
+			return tx.Save(existing).Error
+		})
+		if err != nil {
+			common.SysLog(fmt.Sprintf("save channel health error: channel_id=%d, error=%v", health.ChannelId, err))
+		}
+	}
+}
+
+func GetChannelHealthByChannelId(channelId int, startTime int64, endTime int64) ([]*ChannelHealth, error) {
+	var healths []*ChannelHealth
+	err := DB.Where("channel_id = ? and created_at >= ? and created_at <= ?", channelId, startTime, endTime).
+		Order("created_at asc").Find(&healths).Error
+	return healths, err
+}
+
+func GetAllChannelHealth(startTime int64, endTime int64) ([]*ChannelHealth, error) {
+	var healths []*ChannelHealth
+	err := DB.Where("created_at >= ? and created_at <= ?", startTime, endTime).Find(&healths).Error
+	return healths, err
+}
+
+func DeleteChannelHealthBefore(timestamp int64) (int64, error) {
+	result := DB.Where("created_at < ?", timestamp).Delete(&ChannelHealth{})
+	return result.RowsAffected, result.Error
+}
+
+// UpdateChannelHealthData 定期保存健康度统计，主节点同时清理超过保留期的历史数据
+func UpdateChannelHealthData() {
+	lastCleanup := time.Time{}
+	for {
+		time.Sleep(time.Minute)
+		SaveChannelHealthCache()
+		if !common.IsMasterNode || time.Since(lastCleanup) < time.Hour {
+			continue
+		}
+		lastCleanup = time.Now()
+		days := operation_setting.GetChannelHealthSetting().RetentionDays
+		if days <= 0 {
+			continue
+		}
+		deleted, err := DeleteChannelHealthBefore(time.Now().AddDate(0, 0, -days).Unix())
+		if err != nil {
+			common.SysLog(fmt.Sprintf("cleanup channel health error: %v", err))
+		} else if deleted > 0 {
+			common.SysLog(fmt.Sprintf("cleaned up %d channel health records", deleted))
+		}
+	}
+}
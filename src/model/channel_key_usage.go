@@ -0,0 +1,392 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MultiKeyUsage 多密钥模式下单个密钥的使用统计
+type MultiKeyUsage struct {
+	Requests     int64   `json:"requests"`
+	Failures     int64   `json:"failures"`
+	ErrorRate    float64 `json:"error_rate"`               // 错误率 EWMA
+	LastUsedAt   int64   `json:"last_used_at"`             // 毫秒时间戳
+	LastFailedAt int64   `json:"last_failed_at,omitempty"` // 毫秒时间戳
+}
+
+const multiKeyErrorRateAlpha = 0.2
+
+// ChannelKeyUsage 持久化的多密钥使用统计，每个渠道密钥一行。
+// 各节点只以增量方式原子更新计数，不会覆盖其他节点的统计或管理员对渠道的修改。
+type ChannelKeyUsage struct {
+	Id           int     `json:"id"`
+	ChannelId    int     `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_usage"`
+	KeyIndex     int     `json:"key_index" gorm:"uniqueIndex:idx_channel_key_usage"`
+	Requests     int64   `json:"requests" gorm:"default:0"`
+	Failures     int64   `json:"failures" gorm:"default:0"`
+	ErrorRate    float64 `json:"error_rate" gorm:"default:0"`
+	LastUsedAt   int64   `json:"last_used_at" gorm:"bigint;default:0"`
+	LastFailedAt int64   `json:"last_failed_at" gorm:"bigint;default:0"`
+}
+
+// multiKeyUsageDelta 自上次写回以来的增量；ErrorRate 为最新的 EWMA 值，-1 表示无更新
+type multiKeyUsageDelta struct {
+	Requests     int64
+	Failures     int64
+	ErrorRate    float64
+	LastUsedAt   int64
+	LastFailedAt int64
+}
+
+type multiKeyUsageStore struct {
+	mu      sync.Mutex
+	usage   map[int]MultiKeyUsage
+	pending map[int]*multiKeyUsageDelta
+}
+
+// multiKeyUsageStores 内存中的密钥使用统计，首次使用时从数据库加载，增量定期写回
+var multiKeyUsageStores sync.Map // map[int]*multiKeyUsageStore
+
+var multiKeyQuotaProvider func(channelId int, keyIndex int) (float64, bool)
+
+// SetMultiKeyQuotaProvider 注册密钥剩余额度比例（0-1）的来源，供 quota_aware 模式使用
+func SetMultiKeyQuotaProvider(provider func(channelId int, keyIndex int) (float64, bool)) {
+	multiKeyQuotaProvider = provider
+}
+
+func getMultiKeyUsageStore(channelId int) *multiKeyUsageStore {
+	if store, ok := multiKeyUsageStores.Load(channelId); ok {
+		return store.(*multiKeyUsageStore)
+	}
+	usage := make(map[int]MultiKeyUsage)
+	var rows []ChannelKeyUsage
+	if err := DB.Where("channel_id = ?", channelId).Find(&rows).Error; err != nil {
+		common.SysLog(fmt.Sprintf("failed to load multi-key usage: channel_id=%d, error=%v", channelId, err))
+	}
+	for _, row := range rows {
+		usage[row.KeyIndex] = MultiKeyUsage{
+			Requests:     row.Requests,
+			Failures:     row.Failures,
+			ErrorRate:    row.ErrorRate,
+			LastUsedAt:   row.LastUsedAt,
+			LastFailedAt: row.LastFailedAt,
+		}
+	}
+	store, _ := multiKeyUsageStores.LoadOrStore(channelId, &multiKeyUsageStore{
+		usage:   usage,
+		pending: make(map[int]*multiKeyUsageDelta),
+	})
+	return store.(*multiKeyUsageStore)
+}
+
+// pendingDelta must be called with store.mu held
+func (store *multiKeyUsageStore) pendingDelta(keyIndex int) *multiKeyUsageDelta {
+	delta, ok := store.pending[keyIndex]
+	if !ok {
+		delta = &multiKeyUsageDelta{ErrorRate: -1}
+		store.pending[keyIndex] = delta
+	}
+	return delta
+}
+
+// RecordMultiKeyUsed 记录密钥被选中
+func RecordMultiKeyUsed(channel *Channel, keyIndex int) {
+	store := getMultiKeyUsageStore(channel.Id)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now().UnixMilli()
+	u := store.usage[keyIndex]
+	u.Requests++
+	u.LastUsedAt = now
+	store.usage[keyIndex] = u
+	delta := store.pendingDelta(keyIndex)
+	delta.Requests++
+	delta.LastUsedAt = now
+}
+
+// RecordMultiKeyResult 记录密钥请求结果，用于计算错误率
+func RecordMultiKeyResult(channelId int, keyIndex int, success bool) {
+	store := getMultiKeyUsageStore(channelId)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	u := store.usage[keyIndex]
+	delta := store.pendingDelta(keyIndex)
+	value := 1.0
+	if success {
+		value = 0
+	} else {
+		u.Failures++
+		u.LastFailedAt = time.Now().UnixMilli()
+		delta.Failures++
+		delta.LastFailedAt = u.LastFailedAt
+	}
+	u.ErrorRate += multiKeyErrorRateAlpha * (value - u.ErrorRate)
+	delta.ErrorRate = u.ErrorRate
+	store.usage[keyIndex] = u
+}
+
+// GetMultiKeyUsage 返回渠道各密钥的使用统计
+func GetMultiKeyUsage(channel *Channel) map[int]MultiKeyUsage {
+	store := getMultiKeyUsageStore(channel.Id)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	result := make(map[int]MultiKeyUsage, len(store.usage))
+	for idx, u := range store.usage {
+		result[idx] = u
+	}
+	return result
+}
+
+// ResetMultiKeyUsage 清除渠道的密钥使用统计（密钥被删除导致索引变化时调用）
+func ResetMultiKeyUsage(channelId int) {
+	multiKeyUsageStores.Delete(channelId)
+	if common.RedisEnabled {
+		ctx := context.Background()
+		pipe := common.RDB.TxPipeline()
+		pipe.Del(ctx, multiKeyUsageRedisKey(channelId))
+		pipe.SRem(ctx, multiKeyUsageDirtySetKey, channelId)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog(fmt.Sprintf("failed to reset multi-key usage in redis: channel_id=%d, error=%v", channelId, err))
+		}
+	}
+	if err := DB.Where("channel_id = ?", channelId).Delete(&ChannelKeyUsage{}).Error; err != nil {
+		common.SysLog(fmt.Sprintf("failed to reset multi-key usage: channel_id=%d, error=%v", channelId, err))
+	}
+}
+
+func pickMultiKeyByUsage(channel *Channel, enabledIdx []int) int {
+	store := getMultiKeyUsageStore(channel.Id)
+	store.mu.Lock()
+	usage := make(map[int]MultiKeyUsage, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		usage[idx] = store.usage[idx]
+	}
+	store.mu.Unlock()
+
+	// score 越小越优先，得分相同时选择最久未使用的密钥
+	score := func(idx int) float64 {
+		switch channel.ChannelInfo.MultiKeyMode {
+		case constant.MultiKeyModeLowestErrorRate:
+			return usage[idx].ErrorRate
+		case constant.MultiKeyModeQuotaAware:
+			if multiKeyQuotaProvider != nil {
+				if remaining, ok := multiKeyQuotaProvider(channel.Id, idx); ok {
+					return -remaining
+				}
+			}
+			// 未知额度的密钥视为额度充足
+			return -1
+		}
+		return 0
+	}
+	best := make([]int, 0, len(enabledIdx))
+	var bestScore float64
+	var bestUsed int64
+	for _, idx := range enabledIdx {
+		s := score(idx)
+		used := usage[idx].LastUsedAt
+		if len(best) == 0 || s < bestScore || (s == bestScore && used < bestUsed) {
+			best = append(best[:0], idx)
+			bestScore, bestUsed = s, used
+		} else if s == bestScore && used == bestUsed {
+			best = append(best, idx)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// pickMultiKeyBySticky 使用最高随机权重哈希，密钥被禁用时只有绑定到该密钥的用户会迁移
+func pickMultiKeyBySticky(stickyKey string, enabledIdx []int) int {
+	selected := enabledIdx[0]
+	var maxWeight uint64
+	for i, idx := range enabledIdx {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(stickyKey + ":" + strconv.Itoa(idx)))
+		weight := h.Sum64()
+		if i == 0 || weight > maxWeight {
+			selected, maxWeight = idx, weight
+		}
+	}
+	return selected
+}
+
+const multiKeyUsageDirtySetKey = "multi_key_usage:dirty"
+
+func multiKeyUsageRedisKey(channelId int) string {
+	return "multi_key_usage:" + strconv.Itoa(channelId)
+}
+
+// multiKeyUsageDrainScript 原子地取出并删除渠道在 Redis 中累积的增量
+var multiKeyUsageDrainScript = redis.NewScript(`
+local fields = redis.call('HGETALL', KEYS[1])
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[1])
+return fields
+`)
+
+// applyMultiKeyUsageDeltas 以原子增量更新数据库中的密钥使用统计
+func applyMultiKeyUsageDeltas(channelId int, deltas map[int]*multiKeyUsageDelta) error {
+	for keyIndex, delta := range deltas {
+		row := ChannelKeyUsage{
+			ChannelId:    channelId,
+			KeyIndex:     keyIndex,
+			Requests:     delta.Requests,
+			Failures:     delta.Failures,
+			ErrorRate:    math.Max(delta.ErrorRate, 0),
+			LastUsedAt:   delta.LastUsedAt,
+			LastFailedAt: delta.LastFailedAt,
+		}
+		updates := map[string]interface{}{
+			"requests": gorm.Expr("requests + ?", delta.Requests),
+			"failures": gorm.Expr("failures + ?", delta.Failures),
+		}
+		if delta.ErrorRate >= 0 {
+			updates["error_rate"] = delta.ErrorRate
+		}
+		if delta.LastUsedAt > 0 {
+			updates["last_used_at"] = delta.LastUsedAt
+		}
+		if delta.LastFailedAt > 0 {
+			updates["last_failed_at"] = delta.LastFailedAt
+		}
+		err := DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "channel_id"}, {Name: "key_index"}},
+			DoUpdates: clause.Assignments(updates),
+		}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushMultiKeyUsageDeltas 将增量累加到 Redis，由主节点统一写回数据库
+func pushMultiKeyUsageDeltas(channelId int, deltas map[int]*multiKeyUsageDelta) error {
+	ctx := context.Background()
+	key := multiKeyUsageRedisKey(channelId)
+	pipe := common.RDB.TxPipeline()
+	for keyIndex, delta := range deltas {
+		prefix := strconv.Itoa(keyIndex) + ":"
+		pipe.HIncrBy(ctx, key, prefix+"requests", delta.Requests)
+		pipe.HIncrBy(ctx, key, prefix+"failures", delta.Failures)
+		if delta.ErrorRate >= 0 {
+			pipe.HSet(ctx, key, prefix+"error_rate", delta.ErrorRate)
+		}
+		if delta.LastUsedAt > 0 {
+			pipe.HSet(ctx, key, prefix+"last_used_at", delta.LastUsedAt)
+		}
+		if delta.LastFailedAt > 0 {
+			pipe.HSet(ctx, key, prefix+"last_failed_at", delta.LastFailedAt)
+		}
+	}
+	pipe.SAdd(ctx, multiKeyUsageDirtySetKey, channelId)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// drainMultiKeyUsageFromRedis 主节点取出各节点累积在 Redis 中的增量并写回数据库
+func drainMultiKeyUsageFromRedis() {
+	ctx := context.Background()
+	members, err := common.RDB.SMembers(ctx, multiKeyUsageDirtySetKey).Result()
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to list dirty multi-key usage: %v", err))
+		return
+	}
+	for _, member := range members {
+		channelId, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+		fields, err := multiKeyUsageDrainScript.Run(ctx, common.RDB,
+			[]string{multiKeyUsageRedisKey(channelId), multiKeyUsageDirtySetKey}, member).StringSlice()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to drain multi-key usage: channel_id=%d, error=%v", channelId, err))
+			continue
+		}
+		deltas := make(map[int]*multiKeyUsageDelta)
+		for i := 0; i+1 < len(fields); i += 2 {
+			indexStr, name, ok := strings.Cut(fields[i], ":")
+			keyIndex, err := strconv.Atoi(indexStr)
+			if !ok || err != nil {
+				continue
+			}
+			delta, exists := deltas[keyIndex]
+			if !exists {
+				delta = &multiKeyUsageDelta{ErrorRate: -1}
+				deltas[keyIndex] = delta
+			}
+			switch name {
+			case "requests":
+				delta.Requests, _ = strconv.ParseInt(fields[i+1], 10, 64)
+			case "failures":
+				delta.Failures, _ = strconv.ParseInt(fields[i+1], 10, 64)
+			case "error_rate":
+				delta.ErrorRate, _ = strconv.ParseFloat(fields[i+1], 64)
+			case "last_used_at":
+				delta.LastUsedAt, _ = strconv.ParseInt(fields[i+1], 10, 64)
+			case "last_failed_at":
+				delta.LastFailedAt, _ = strconv.ParseInt(fields[i+1], 10, 64)
+			}
+		}
+		if err := applyMultiKeyUsageDeltas(channelId, deltas); err != nil {
+			common.SysLog(fmt.Sprintf("failed to save multi-key usage: channel_id=%d, error=%v", channelId, err))
+			// 写回失败时放回 Redis，等待下次重试
+			if err := pushMultiKeyUsageDeltas(channelId, deltas); err != nil {
+				common.SysLog(fmt.Sprintf("failed to requeue multi-key usage: channel_id=%d, error=%v", channelId, err))
+			}
+		}
+	}
+}
+
+// FlushMultiKeyUsage 写回本节点累积的密钥使用增量：启用 Redis 时先汇总到 Redis，由主节点统一写入数据库；
+// 否则直接以原子增量写入数据库
+func FlushMultiKeyUsage() {
+	multiKeyUsageStores.Range(func(k, v interface{}) bool {
+		channelId := k.(int)
+		store := v.(*multiKeyUsageStore)
+		store.mu.Lock()
+		if len(store.pending) == 0 {
+			store.mu.Unlock()
+			return true
+		}
+		deltas := store.pending
+		store.pending = make(map[int]*multiKeyUsageDelta)
+		store.mu.Unlock()
+
+		var err error
+		if common.RedisEnabled {
+			err = pushMultiKeyUsageDeltas(channelId, deltas)
+		}
+		if !common.RedisEnabled || err != nil {
+			err = applyMultiKeyUsageDeltas(channelId, deltas)
+		}
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to save multi-key usage: channel_id=%d, error=%v", channelId, err))
+		}
+		return true
+	})
+	if common.RedisEnabled && common.IsMasterNode {
+		drainMultiKeyUsageFromRedis()
+	}
+}
+
+func SyncMultiKeyUsage(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushMultiKeyUsage()
+	}
+}
@@ -0,0 +1,170 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// ChannelSecretRefStatus 渠道外部密钥引用的解析状态
+type ChannelSecretRefStatus struct {
+	ChannelId  int    `json:"channel_id"`
+	Name       string `json:"name"`
+	Refs       int    `json:"refs"`
+	Error      string `json:"error,omitempty"`
+	ResolvedAt int64  `json:"resolved_at"`
+	CheckedAt  int64  `json:"checked_at"`
+}
+
+var (
+	channelSecretRefStatuses     = make(map[int]*ChannelSecretRefStatus)
+	channelSecretRefStatusesLock sync.RWMutex
+)
+
+// channelSecretRefs 返回渠道密钥中的外部引用，整体为一个引用或按行填写多个引用
+func channelSecretRefs(key string) []string {
+	trimmed := strings.TrimSpace(key)
+	if common.IsSecretRef(trimmed) && !strings.Contains(trimmed, "\n") {
+		return []string{trimmed}
+	}
+	var refs []string
+	for _, line := range strings.Split(trimmed, "\n") {
+		if common.IsSecretRef(line) {
+			refs = append(refs, strings.TrimSpace(line))
+		}
+	}
+	return refs
+}
+
+func setChannelSecretRefError(channelId int, err error) {
+	channelSecretRefStatusesLock.Lock()
+	defer channelSecretRefStatusesLock.Unlock()
+	status, ok := channelSecretRefStatuses[channelId]
+	if !ok {
+		status = &ChannelSecretRefStatus{ChannelId: channelId}
+		channelSecretRefStatuses[channelId] = status
+	}
+	status.CheckedAt = time.Now().Unix()
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Error = ""
+	}
+}
+
+// ResolveChannelKey 解析选中的渠道密钥，非引用原样返回，解析失败时记录到渠道状态
+func ResolveChannelKey(channelId int, key string) (string, error) {
+	if !common.IsSecretRef(key) {
+		return key, nil
+	}
+	resolved, err := common.ResolveSecretRef(key)
+	if err != nil {
+		err = fmt.Errorf("failed to resolve secret reference %s: %w", strings.TrimSpace(key), err)
+		setChannelSecretRefError(channelId, err)
+		if resolved != "" {
+			// 刷新失败时继续使用上次成功解析的值
+			return resolved, nil
+		}
+		return "", err
+	}
+	return resolved, nil
+}
+
+// ResolvedKey 返回解析所有外部引用后的完整密钥，用于查询余额、拉取模型等直接使用渠道密钥的场景
+func (channel *Channel) ResolvedKey() (string, error) {
+	if channel.HasUndecryptableKey() {
+		return "", errors.New("channel key cannot be decrypted with the configured master keys")
+	}
+	trimmed := strings.TrimSpace(channel.Key)
+	if common.IsSecretRef(trimmed) && !strings.Contains(trimmed, "\n") {
+		return ResolveChannelKey(channel.Id, trimmed)
+	}
+	if len(channelSecretRefs(channel.Key)) == 0 {
+		return channel.Key, nil
+	}
+	lines := strings.Split(strings.Trim(channel.Key, "\n"), "\n")
+	for i, line := range lines {
+		resolved, err := ResolveChannelKey(channel.Id, line)
+		if err != nil {
+			return "", err
+		}
+		lines[i] = resolved
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// checkChannelSecretRefs 解析渠道中的全部引用并更新各渠道的解析状态
+func checkChannelSecretRefs(channels []*Channel) {
+	active := make(map[int]*ChannelSecretRefStatus)
+	for _, channel := range channels {
+		refs := channelSecretRefs(channel.Key)
+		if len(refs) == 0 {
+			continue
+		}
+		status := &ChannelSecretRefStatus{
+			ChannelId: channel.Id,
+			Name:      channel.Name,
+			Refs:      len(refs),
+			CheckedAt: time.Now().Unix(),
+		}
+		for _, ref := range refs {
+			if _, err := common.ResolveSecretRef(ref); err != nil && status.Error == "" {
+				status.Error = fmt.Sprintf("failed to resolve secret reference %s: %s", ref, err.Error())
+			}
+			if resolvedAt := common.SecretRefResolvedAt(ref); status.ResolvedAt == 0 || (resolvedAt > 0 && resolvedAt < status.ResolvedAt) {
+				status.ResolvedAt = resolvedAt
+			}
+		}
+		if status.Error != "" {
+			common.SysError(fmt.Sprintf("channel #%d: %s", channel.Id, status.Error))
+		}
+		active[channel.Id] = status
+	}
+	channelSecretRefStatusesLock.Lock()
+	channelSecretRefStatuses = active
+	channelSecretRefStatusesLock.Unlock()
+}
+
+// GetChannelSecretRefStatuses 返回所有使用外部密钥引用的渠道及其解析状态
+func GetChannelSecretRefStatuses() []ChannelSecretRefStatus {
+	channelSecretRefStatusesLock.RLock()
+	defer channelSecretRefStatusesLock.RUnlock()
+	statuses := make([]ChannelSecretRefStatus, 0, len(channelSecretRefStatuses))
+	for _, status := range channelSecretRefStatuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ChannelId < statuses[j].ChannelId
+	})
+	return statuses
+}
+
+// CheckChannelSecretRefs 立即刷新外部密钥引用并重新检查所有渠道
+func CheckChannelSecretRefs(refresh bool) error {
+	if refresh {
+		common.RefreshSecretRefs()
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "name", "key").Find(&channels).Error; err != nil {
+		return err
+	}
+	checkChannelSecretRefs(channels)
+	return nil
+}
+
+// RefreshChannelSecretRefs 定期刷新外部密钥引用，密钥轮换后无需重启即可生效
+func RefreshChannelSecretRefs() {
+	refresh := false
+	for {
+		if err := CheckChannelSecretRefs(refresh); err != nil {
+			common.SysError("failed to check channel secret references: " + err.Error())
+		}
+		refresh = true
+		time.Sleep(time.Duration(common.SecretRefRefreshInterval) * time.Second)
+	}
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+)
+
+const (
+	ChannelTestSourceManual    = "manual"
+	ChannelTestSourceScheduled = "scheduled"
+	ChannelTestSourceAll       = "all"
+	ChannelTestSourceSuite     = "suite"
+)
+
+// ChannelTestResult 渠道测试记录，每次测试（每个测试用例）一条
+type ChannelTestResult struct {
+	Id               int    `json:"id"`
+	ChannelId        int    `json:"channel_id" gorm:"index:idx_ch_test_channel_time,priority:1"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index:idx_ch_test_channel_time,priority:2;index"`
+	CaseName         string `json:"case_name" gorm:"size:64;default:''"`
+	CaseType         string `json:"case_type" gorm:"size:32;default:''"`
+	ModelName        string `json:"model_name" gorm:"size:128;default:''"`
+	Source           string `json:"source" gorm:"size:16;default:''"`
+	Success          bool   `json:"success"`
+	LatencyMs        int64  `json:"latency_ms"`
+	StatusCode       int    `json:"status_code"`
+	ErrorMessage     string `json:"error_message" gorm:"type:text"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func (result *ChannelTestResult) Insert() error {
+	if result.CreatedAt == 0 {
+		result.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(result).Error
+}
+
+func GetChannelTestResults(channelId int, startTime int64, caseName string, startIdx int, num int) ([]*ChannelTestResult, int64, error) {
+	var results []*ChannelTestResult
+	var total int64
+	tx := DB.Model(&ChannelTestResult{}).Where("channel_id = ? and created_at >= ?", channelId, startTime)
+	if caseName != "" {
+		tx = tx.Where("case_name = ?", caseName)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := tx.Order("id desc").Limit(num).Offset(startIdx).Find(&results).Error
+	return results, total, err
+}
+
+// GetChannelTestResultsForTrend 返回用于计算趋势的测试记录，仅查询必要字段
+func GetChannelTestResultsForTrend(channelId int, startTime int64) ([]*ChannelTestResult, error) {
+	var results []*ChannelTestResult
+	err := DB.Select("created_at", "case_name", "success", "latency_ms").
+		Where("channel_id = ? and created_at >= ?", channelId, startTime).
+		Order("created_at asc").Find(&results).Error
+	return results, err
+}
+
+func DeleteChannelTestResultsBefore(timestamp int64) (int64, error) {
+	result := DB.Where("created_at < ?", timestamp).Delete(&ChannelTestResult{})
+	return result.RowsAffected, result.Error
+}
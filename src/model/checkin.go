@@ -0,0 +1,117 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+
+	"gorm.io/gorm"
+)
+
+// CheckinDateLayout 签到日期格式，按服务器时区计算
+const CheckinDateLayout = "2006-01-02"
+
+var ErrCheckinAlreadyDone = errors.New("今天已经签到过了")
+
+// Checkin 每日签到记录，每个用户每天一条
+type Checkin struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_checkin_user_date"`
+	CheckinDate string `json:"checkin_date" gorm:"type:varchar(10);uniqueIndex:idx_checkin_user_date"`
+	Quota       int    `json:"quota"`       // 随机奖励额度
+	BonusQuota  int    `json:"bonus_quota"` // 连续签到额外奖励额度
+	Streak      int    `json:"streak"`      // 截至当天的连续签到天数
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// GetLastCheckin 返回用户最近一次签到记录，从未签到时返回 nil
+func GetLastCheckin(userId int) (*Checkin, error) {
+	var checkin Checkin
+	err := DB.Where("user_id = ?", userId).Order("checkin_date desc").First(&checkin).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkin, nil
+}
+
+// GetUserCheckins 返回用户在 [startDate, endDate] 之间的签到记录
+func GetUserCheckins(userId int, startDate string, endDate string) ([]*Checkin, error) {
+	var checkins []*Checkin
+	err := DB.Where("user_id = ? AND checkin_date >= ? AND checkin_date <= ?", userId, startDate, endDate).
+		Order("checkin_date asc").Find(&checkins).Error
+	return checkins, err
+}
+
+// CurrentCheckinStreak 返回截至 today 仍然有效的连续签到天数
+func CurrentCheckinStreak(last *Checkin, today time.Time) int {
+	if last == nil {
+		return 0
+	}
+	if last.CheckinDate == today.Format(CheckinDateLayout) || last.CheckinDate == today.AddDate(0, 0, -1).Format(CheckinDateLayout) {
+		return last.Streak
+	}
+	return 0
+}
+
+// UserCheckin 为用户签到并发放奖励。bonus 根据连续签到天数返回额外奖励。
+// 同一天重复签到（包括并发请求）返回 ErrCheckinAlreadyDone。
+func UserCheckin(userId int, today time.Time, quota int, bonus func(streak int) int) (*Checkin, error) {
+	date := today.Format(CheckinDateLayout)
+	yesterday := today.AddDate(0, 0, -1).Format(CheckinDateLayout)
+	checkin := &Checkin{
+		UserId:      userId,
+		CheckinDate: date,
+		Quota:       quota,
+		Streak:      1,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var existing []Checkin
+		if err := tx.Where("user_id = ? AND checkin_date IN ?", userId, []string{date, yesterday}).Find(&existing).Error; err != nil {
+			return err
+		}
+		for _, record := range existing {
+			if record.CheckinDate == date {
+				return ErrCheckinAlreadyDone
+			}
+			checkin.Streak = record.Streak + 1
+		}
+		if bonus != nil {
+			checkin.BonusQuota = bonus(checkin.Streak)
+		}
+		// 唯一索引保证并发签到时只有一条记录写入成功
+		if err := tx.Create(checkin).Error; err != nil {
+			var count int64
+			if countErr := DB.Model(&Checkin{}).Where("user_id = ? AND checkin_date = ?", userId, date).Count(&count).Error; countErr == nil && count > 0 {
+				return ErrCheckinAlreadyDone
+			}
+			return err
+		}
+		total := checkin.Quota + checkin.BonusQuota
+		if total <= 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", total)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	total := checkin.Quota + checkin.BonusQuota
+	if total > 0 {
+		if err := cacheIncrUserQuota(userId, int64(total)); err != nil {
+			common.SysLog("failed to increase user quota cache: " + err.Error())
+		}
+	}
+	content := fmt.Sprintf("每日签到获得 %s，连续签到 %d 天", logger.LogQuota(checkin.Quota), checkin.Streak)
+	if checkin.BonusQuota > 0 {
+		content += fmt.Sprintf("，额外奖励 %s", logger.LogQuota(checkin.BonusQuota))
+	}
+	RecordLog(userId, LogTypeTopup, content)
+	return checkin, nil
+}
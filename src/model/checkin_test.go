@@ -0,0 +1,96 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupCheckinTestDB(t *testing.T) {
+	t.Helper()
+	common.RedisEnabled = false
+	common.UsingSQLite = true
+	commonKeyCol = "`key`"
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, LOG_DB = db, db
+	if err := db.AutoMigrate(&User{}, &Checkin{}, &Log{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserCheckinStreak(t *testing.T) {
+	setupCheckinTestDB(t)
+	user := &User{Username: "checkin", Quota: 0}
+	if err := DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	bonus := func(streak int) int {
+		if streak == 2 {
+			return 50
+		}
+		return 0
+	}
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+
+	checkin, err := UserCheckin(user.Id, day1, 10, bonus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkin.Streak != 1 || checkin.BonusQuota != 0 {
+		t.Fatalf("day 1: streak %d, bonus %d", checkin.Streak, checkin.BonusQuota)
+	}
+	if _, err := UserCheckin(user.Id, day1.Add(time.Hour), 10, bonus); !errors.Is(err, ErrCheckinAlreadyDone) {
+		t.Fatalf("second checkin on the same day: %v", err)
+	}
+
+	checkin, err = UserCheckin(user.Id, day1.AddDate(0, 0, 1), 10, bonus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkin.Streak != 2 || checkin.BonusQuota != 50 {
+		t.Fatalf("day 2: streak %d, bonus %d", checkin.Streak, checkin.BonusQuota)
+	}
+
+	// 中断一天后重新计算连续天数
+	checkin, err = UserCheckin(user.Id, day1.AddDate(0, 0, 3), 10, bonus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkin.Streak != 1 {
+		t.Fatalf("day 4: streak %d", checkin.Streak)
+	}
+
+	var quota int
+	if err := DB.Model(&User{}).Where("id = ?", user.Id).Select("quota").Scan(&quota).Error; err != nil {
+		t.Fatal(err)
+	}
+	if quota != 80 {
+		t.Fatalf("quota %d, want 80", quota)
+	}
+}
+
+func TestCurrentCheckinStreak(t *testing.T) {
+	today := time.Date(2026, 3, 10, 8, 0, 0, 0, time.Local)
+	last := &Checkin{CheckinDate: "2026-03-09", Streak: 4}
+	if got := CurrentCheckinStreak(last, today); got != 4 {
+		t.Fatalf("checked in yesterday: got %d", got)
+	}
+	last.CheckinDate = "2026-03-10"
+	if got := CurrentCheckinStreak(last, today); got != 4 {
+		t.Fatalf("checked in today: got %d", got)
+	}
+	last.CheckinDate = "2026-03-08"
+	if got := CurrentCheckinStreak(last, today); got != 0 {
+		t.Fatalf("streak broken: got %d", got)
+	}
+	if got := CurrentCheckinStreak(nil, today); got != 0 {
+		t.Fatalf("never checked in: got %d", got)
+	}
+}
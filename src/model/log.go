@@ -3,11 +3,11 @@ package model
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -27,6 +27,8 @@ type Log struct {
 	TokenName        string `json:"token_name" gorm:"index;default:''"`
 	ModelName        string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
 	Quota            int    `json:"quota" gorm:"default:0"`
+	UpstreamCost     int    `json:"upstream_cost" gorm:"default:0"`  // 上游成本（额度），按渠道的上游成本设置计算
+	CostKnown        bool   `json:"cost_known" gorm:"default:false"` // 是否设置了上游成本，为 false 时 upstream_cost 无意义
 	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
 	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
 	UseTime          int    `json:"use_time" gorm:"default:0"`
@@ -34,6 +36,7 @@ type Log struct {
 	ChannelId        int    `json:"channel" gorm:"index"`
 	ChannelName      string `json:"channel_name" gorm:"->"`
 	TokenId          int    `json:"token_id" gorm:"default:0;index"`
+	OrganizationId   int    `json:"organization_id,omitempty" gorm:"default:0;index"` // 组织令牌的消费记录所属组织
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	RequestId        string `json:"request_id,omitempty" gorm:"type:varchar(64);index:idx_logs_request_id;default:''"`
@@ -54,6 +57,8 @@ const (
 func formatUserLogs(logs []*Log) {
 	for i := range logs {
 		logs[i].ChannelName = ""
+		logs[i].UpstreamCost = 0
+		logs[i].CostKnown = false
 		var otherMap map[string]interface{}
 		otherMap, _ = common.StrToMap(logs[i].Other)
 		if otherMap != nil {
@@ -67,15 +72,12 @@ func formatUserLogs(logs []*Log) {
 }
 
 func GetLogByKey(key string) (logs []*Log, err error) {
-	if os.Getenv("LOG_SQL_DSN") != "" {
-		var tk Token
-		if err = DB.Model(&Token{}).Where(logKeyCol+"=?", strings.TrimPrefix(key, "sk-")).First(&tk).Error; err != nil {
-			return nil, err
-		}
-		err = LOG_DB.Model(&Log{}).Where("token_id=?", tk.Id).Find(&logs).Error
-	} else {
-		err = LOG_DB.Joins("left join tokens on tokens.id = logs.token_id").Where("tokens.key = ?", strings.TrimPrefix(key, "sk-")).Find(&logs).Error
+	// 令牌只保存哈希，先按哈希找到令牌，再按令牌 ID 查询日志
+	var tk Token
+	if err = DB.Model(&Token{}).Where("key_hash = ?", HashTokenKey(strings.TrimPrefix(key, "sk-"))).First(&tk).Error; err != nil {
+		return nil, err
 	}
+	err = LOG_DB.Model(&Log{}).Where("token_id = ?", tk.Id).Find(&logs).Error
 	formatUserLogs(logs)
 	return logs, err
 }
@@ -160,6 +162,8 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	// 预算令牌的按模型消耗不受是否记录日志影响
+	recordTokenModelSpend(c, params.TokenId, params.ModelName, params.Quota)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -176,6 +180,7 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 		}
 	}
+	upstreamCost, costKnown := ComputeUpstreamCost(params)
 	log := &Log{
 		UserId:           userId,
 		Username:         username,
@@ -187,8 +192,11 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 		TokenName:        params.TokenName,
 		ModelName:        params.ModelName,
 		Quota:            params.Quota,
+		UpstreamCost:     upstreamCost,
+		CostKnown:        costKnown,
 		ChannelId:        params.ChannelId,
 		TokenId:          params.TokenId,
+		OrganizationId:   common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId),
 		UseTime:          params.UseTimeSeconds,
 		IsStream:         params.IsStream,
 		Group:            params.Group,
@@ -259,6 +259,20 @@ func migrateDB() error {
 		{&Ability{}, "Ability"},
 		{&Log{}, "Log"},
 		{&QuotaData{}, "QuotaData"},
+		{&ChannelHealth{}, "ChannelHealth"},
+		{&ChannelKeyUsage{}, "ChannelKeyUsage"},
+		{&ChannelTestResult{}, "ChannelTestResult"},
+		{&ChannelBalanceHistory{}, "ChannelBalanceHistory"},
+		{&TokenModelSpend{}, "TokenModelSpend"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&OrganizationInvitation{}, "OrganizationInvitation"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&UserSubscription{}, "UserSubscription"},
+		{&Redemption{}, "Redemption"},
+		{&RedemptionUse{}, "RedemptionUse"},
+		{&TopUp{}, "TopUp"},
+		{&Checkin{}, "Checkin"},
 		{&Model{}, "Model"},
 		{&Vendor{}, "Vendor"},
 		{&PrefillGroup{}, "PrefillGroup"},
@@ -275,6 +289,9 @@ func migrateDB() error {
 			return fmt.Errorf("failed to migrate %s: %v", m.name, err)
 		}
 	}
+	if err := migrateTokenKeys(); err != nil {
+		return fmt.Errorf("failed to migrate token keys: %v", err)
+	}
 	return nil
 }
 
@@ -313,6 +330,20 @@ func migrateDBFast() error {
 		{&Ability{}, "Ability"},
 		{&Log{}, "Log"},
 		{&QuotaData{}, "QuotaData"},
+		{&ChannelHealth{}, "ChannelHealth"},
+		{&ChannelKeyUsage{}, "ChannelKeyUsage"},
+		{&ChannelTestResult{}, "ChannelTestResult"},
+		{&ChannelBalanceHistory{}, "ChannelBalanceHistory"},
+		{&TokenModelSpend{}, "TokenModelSpend"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&OrganizationInvitation{}, "OrganizationInvitation"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&UserSubscription{}, "UserSubscription"},
+		{&Redemption{}, "Redemption"},
+		{&RedemptionUse{}, "RedemptionUse"},
+		{&TopUp{}, "TopUp"},
+		{&Checkin{}, "Checkin"},
 		{&Model{}, "Model"},
 		{&Vendor{}, "Vendor"},
 		{&PrefillGroup{}, "PrefillGroup"},
@@ -341,6 +372,9 @@ func migrateDBFast() error {
 			return err
 		}
 	}
+	if err := migrateTokenKeys(); err != nil {
+		return fmt.Errorf("failed to migrate token keys: %v", err)
+	}
 	common.SysLog("database migrated")
 	return nil
 }
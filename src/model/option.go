@@ -205,6 +205,9 @@ func SyncOptions(frequency int) {
 }
 
 func UpdateOption(key string, value string) error {
+	if key == TokenKeyHashSecretOption {
+		return ErrTokenKeyHashSecretReadOnly
+	}
 	// Save to database first
 	option := Option{
 		Key: key,
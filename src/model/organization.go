@@ -0,0 +1,368 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleAdmin  = "admin"
+	OrganizationRoleMember = "member"
+)
+
+const (
+	OrganizationInvitationPending  = "pending"
+	OrganizationInvitationAccepted = "accepted"
+	OrganizationInvitationRevoked  = "revoked"
+)
+
+// Organization 组织，成员的组织令牌共用组织的额度池
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(128);index"`
+	Quota       int    `json:"quota" gorm:"default:0"`      // 额度池剩余额度
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"` // 额度池累计消耗
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// OrganizationMember 组织成员，每个用户最多加入一个组织
+type OrganizationMember struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"index"`
+	UserId         int    `json:"user_id" gorm:"uniqueIndex"`
+	Username       string `json:"username" gorm:"-"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	QuotaCap       int    `json:"quota_cap" gorm:"default:0"`  // 成员可从额度池使用的额度上限，0 表示不限制
+	UsedQuota      int    `json:"used_quota" gorm:"default:0"` // 成员从额度池累计使用的额度
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+// OrganizationInvitation 通过邮件发送的组织邀请
+type OrganizationInvitation struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"index"`
+	Email          string `json:"email" gorm:"type:varchar(255);index"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	Code           string `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	InviterId      int    `json:"inviter_id"`
+	Status         string `json:"status" gorm:"type:varchar(16);default:'pending'"`
+	ExpiredTime    int64  `json:"expired_time" gorm:"bigint"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleMember:
+		return true
+	}
+	return false
+}
+
+// CanManage 判断成员是否可以管理组织成员与邀请
+func (member *OrganizationMember) CanManage() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleAdmin
+}
+
+// RemainingCap 返回成员在上限内还能使用的额度，不限制时返回 -1
+func (member *OrganizationMember) RemainingCap() int {
+	if member.QuotaCap <= 0 {
+		return -1
+	}
+	return max(member.QuotaCap-member.UsedQuota, 0)
+}
+
+// CreateOrganization 创建组织，创建者成为所有者
+func CreateOrganization(name string, ownerId int) (*Organization, error) {
+	org := &Organization{Name: name, CreatedTime: common.GetTimestamp()}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&OrganizationMember{}).Where("user_id = ?", ownerId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("你已经加入了一个组织")
+		}
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrganizationId: org.Id,
+			UserId:         ownerId,
+			Role:           OrganizationRoleOwner,
+			CreatedTime:    org.CreatedTime,
+		}).Error
+	})
+	return org, err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var org Organization
+	err := DB.First(&org, "id = ?", id).Error
+	return &org, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (orgs []*Organization, total int64, err error) {
+	if err = DB.Model(&Organization{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+func (org *Organization) UpdateName() error {
+	return DB.Model(org).Select("name").Updates(org).Error
+}
+
+// GetOrganizationMemberByUserId 返回用户所在组织的成员记录
+func GetOrganizationMemberByUserId(userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.First(&member, "user_id = ?", userId).Error
+	return &member, err
+}
+
+// GetOrganizationMember 返回用户在指定组织中的成员记录
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.First(&member, "organization_id = ? AND user_id = ?", orgId, userId).Error
+	return &member, err
+}
+
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("organization_id = ?", orgId).Order("id").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	userIds := make([]int, 0, len(members))
+	for _, member := range members {
+		userIds = append(userIds, member.UserId)
+	}
+	var users []struct {
+		Id       int
+		Username string
+	}
+	if err := DB.Model(&User{}).Select("id, username").Where("id IN ?", userIds).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	usernames := make(map[int]string, len(users))
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+	for _, member := range members {
+		member.Username = usernames[member.UserId]
+	}
+	return members, nil
+}
+
+func (member *OrganizationMember) UpdateRoleAndCap() error {
+	return DB.Model(member).Select("role", "quota_cap").Updates(member).Error
+}
+
+// RemoveOrganizationMember 移除成员，该成员的组织令牌同时解除与组织的关联
+func RemoveOrganizationMember(orgId int, userId int) error {
+	var tokens []Token
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("organization_id = ? AND user_id = ? AND role <> ?", orgId, userId, OrganizationRoleOwner).
+			Delete(&OrganizationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("成员不存在或为组织所有者")
+		}
+		if err := tx.Select("id", "key_hash", "previous_key_hash").
+			Where("user_id = ? AND organization_id = ?", userId, orgId).Find(&tokens).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Token{}).Where("user_id = ? AND organization_id = ?", userId, orgId).
+			Update("organization_id", 0).Error
+	})
+	if err != nil {
+		return err
+	}
+	// 缓存中的令牌仍带有组织 ID，需清除以免移除后继续从组织额度池扣费
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			for _, token := range tokens {
+				_ = cacheDeleteToken(token.KeyHash)
+				if token.PreviousKeyHash != "" {
+					_ = cacheDeleteToken(token.PreviousKeyHash)
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// AdjustOrganizationQuota 调整组织额度池，delta 为负数时扣减
+func AdjustOrganizationQuota(orgId int, delta int) error {
+	result := DB.Model(&Organization{}).Where("id = ? AND quota + ? >= 0", orgId, delta).
+		Update("quota", gorm.Expr("quota + ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("组织不存在或额度不足")
+	}
+	return nil
+}
+
+// DecreaseOrganizationQuota 从组织额度池扣减额度，并累计到成员的使用量
+func DecreaseOrganizationQuota(orgId int, userId int, quota int) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return changeOrganizationQuota(orgId, userId, -quota)
+}
+
+// IncreaseOrganizationQuota 向组织额度池返还额度，并从成员的使用量中扣除
+func IncreaseOrganizationQuota(orgId int, userId int, quota int) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return changeOrganizationQuota(orgId, userId, quota)
+}
+
+func changeOrganizationQuota(orgId int, userId int, delta int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Organization{}).Where("id = ?", orgId).Updates(map[string]interface{}{
+			"quota":      gorm.Expr("quota + ?", delta),
+			"used_quota": gorm.Expr("used_quota - ?", delta),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&OrganizationMember{}).Where("organization_id = ? AND user_id = ?", orgId, userId).
+			Update("used_quota", gorm.Expr("used_quota - ?", delta)).Error
+	})
+}
+
+// CreateOrganizationInvitation 创建邀请，同一邮箱未处理的旧邀请会被撤销
+func CreateOrganizationInvitation(invitation *OrganizationInvitation) error {
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&OrganizationInvitation{}).
+			Where("organization_id = ? AND email = ? AND status = ?", invitation.OrganizationId, invitation.Email, OrganizationInvitationPending).
+			Update("status", OrganizationInvitationRevoked).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(invitation).Error
+	})
+}
+
+func GetOrganizationInvitations(orgId int) ([]*OrganizationInvitation, error) {
+	var invitations []*OrganizationInvitation
+	err := DB.Where("organization_id = ? AND status = ?", orgId, OrganizationInvitationPending).
+		Order("id desc").Find(&invitations).Error
+	return invitations, err
+}
+
+func RevokeOrganizationInvitation(orgId int, id int) error {
+	result := DB.Model(&OrganizationInvitation{}).
+		Where("id = ? AND organization_id = ? AND status = ?", id, orgId, OrganizationInvitationPending).
+		Update("status", OrganizationInvitationRevoked)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请不存在或已处理")
+	}
+	return nil
+}
+
+// AcceptOrganizationInvitation 接受邀请并加入组织，邀请邮箱必须与用户邮箱一致
+func AcceptOrganizationInvitation(code string, userId int, email string) (*OrganizationMember, error) {
+	member := &OrganizationMember{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var invitation OrganizationInvitation
+		if err := tx.First(&invitation, "code = ?", code).Error; err != nil {
+			return errors.New("邀请不存在")
+		}
+		if invitation.Status != OrganizationInvitationPending {
+			return errors.New("邀请已失效")
+		}
+		if invitation.ExpiredTime < common.GetTimestamp() {
+			return errors.New("邀请已过期")
+		}
+		if !strings.EqualFold(invitation.Email, strings.TrimSpace(email)) {
+			return errors.New("该邀请不是发给当前账号邮箱的")
+		}
+		var count int64
+		if err := tx.Model(&OrganizationMember{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("你已经加入了一个组织")
+		}
+		result := tx.Model(&OrganizationInvitation{}).Where("id = ? AND status = ?", invitation.Id, OrganizationInvitationPending).
+			Update("status", OrganizationInvitationAccepted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("邀请已失效")
+		}
+		*member = OrganizationMember{
+			OrganizationId: invitation.OrganizationId,
+			UserId:         userId,
+			Role:           invitation.Role,
+			CreatedTime:    common.GetTimestamp(),
+		}
+		return tx.Create(member).Error
+	})
+	return member, err
+}
+
+// OrganizationUsageItem 组织用量报表的一行
+type OrganizationUsageItem struct {
+	Key              string `json:"key" gorm:"column:dimension"`
+	Requests         int64  `json:"requests"`
+	Quota            int64  `json:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+var organizationUsageDimensions = map[string]string{
+	"user":  "username",
+	"model": "model_name",
+	"token": "token_name",
+	"day":   "created_at - created_at % 86400",
+}
+
+func IsValidOrganizationUsageDimension(groupBy string) bool {
+	_, ok := organizationUsageDimensions[groupBy]
+	return ok
+}
+
+// GetOrganizationUsage 按成员、模型、令牌或天汇总组织令牌的消费日志
+func GetOrganizationUsage(orgId int, groupBy string, startTimestamp int64, endTimestamp int64, userId int) ([]*OrganizationUsageItem, error) {
+	column, ok := organizationUsageDimensions[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid group_by: %s", groupBy)
+	}
+	tx := LOG_DB.Table("logs").Where("type = ? AND organization_id = ?", LogTypeConsume, orgId)
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	var items []*OrganizationUsageItem
+	err := tx.Select(column + " AS dimension, count(*) AS requests, COALESCE(sum(quota), 0) AS quota, " +
+		"COALESCE(sum(prompt_tokens), 0) AS prompt_tokens, COALESCE(sum(completion_tokens), 0) AS completion_tokens").
+		Group("dimension").Order("quota desc").Scan(&items).Error
+	return items, err
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupOrganizationTestDB(t *testing.T) {
+	t.Helper()
+	common.RedisEnabled = false
+	common.UsingSQLite = true
+	commonKeyCol = "`key`"
+	t.Setenv("TOKEN_KEY_SALT", "organization-test-salt")
+	tokenKeySalt = ""
+	t.Cleanup(func() { tokenKeySalt = "" })
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, LOG_DB = db, db
+	if err := db.AutoMigrate(&User{}, &Token{}, &Organization{}, &OrganizationMember{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createOrganizationTestMember 创建组织与一个成员，返回组织与该成员的组织令牌
+func createOrganizationTestMember(t *testing.T) (*Organization, *Token) {
+	t.Helper()
+	org, err := CreateOrganization("org", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(&OrganizationMember{OrganizationId: org.Id, UserId: 2, Role: OrganizationRoleMember}).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &Token{UserId: 2, Name: "org", Key: "organizationmemberkey0", OrganizationId: org.Id}
+	if err := token.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	return org, token
+}
+
+func TestRemoveOrganizationMemberDetachesTokens(t *testing.T) {
+	setupOrganizationTestDB(t)
+	org, token := createOrganizationTestMember(t)
+
+	if err := RemoveOrganizationMember(org.Id, 1); err == nil {
+		t.Fatal("owner must not be removable")
+	}
+	if err := RemoveOrganizationMember(org.Id, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetOrganizationMember(org.Id, 2); err == nil {
+		t.Fatal("member still exists after removal")
+	}
+	detached, err := GetTokenByKey("organizationmemberkey0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detached.Id != token.Id || detached.OrganizationId != 0 {
+		t.Fatalf("token still bound to organization %d", detached.OrganizationId)
+	}
+}
+
+// 需要 Redis：设置 REDIS_CONN_STRING 后运行
+func TestRemoveOrganizationMemberClearsTokenCache(t *testing.T) {
+	if os.Getenv("REDIS_CONN_STRING") == "" {
+		t.Skip("REDIS_CONN_STRING not set")
+	}
+	setupOrganizationTestDB(t)
+	if err := common.InitRedisClient(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { common.RedisEnabled = false })
+	org, token := createOrganizationTestMember(t)
+	if err := cacheSetToken(*token); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cacheDeleteToken(token.KeyHash) })
+	if cached, err := cacheGetTokenByKeyHash(token.KeyHash); err != nil || cached.OrganizationId != org.Id {
+		t.Fatalf("token not cached with organization: %v", err)
+	}
+
+	if err := RemoveOrganizationMember(org.Id, 2); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := cacheGetTokenByKeyHash(token.KeyHash); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("token cache still holds the organization after member removal")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	refreshed, err := GetTokenByKey("organizationmemberkey0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.OrganizationId != 0 {
+		t.Fatalf("token reloaded with organization %d", refreshed.OrganizationId)
+	}
+}
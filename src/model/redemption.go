@@ -0,0 +1,206 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRedemptionNotProvided     = errors.New("未提供兑换码")
+	ErrRedemptionInvalid         = errors.New("无效的兑换码")
+	ErrRedemptionUsed            = errors.New("该兑换码已被使用")
+	ErrRedemptionExpired         = errors.New("该兑换码已过期")
+	ErrRedemptionAlreadyRedeemed = errors.New("你已兑换过该兑换码")
+)
+
+// Redemption 兑换码，可设置有效期与可兑换次数，每个用户对同一兑换码只能兑换一次
+type Redemption struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id"` // 创建者
+	Key          string `json:"key" gorm:"type:char(32);uniqueIndex"`
+	Status       int    `json:"status" gorm:"default:1"`
+	Name         string `json:"name" gorm:"index"`
+	Quota        int    `json:"quota" gorm:"default:100"`
+	MaxUses      int    `json:"max_uses" gorm:"default:1"`
+	UsedCount    int    `json:"used_count" gorm:"default:0"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	RedeemedTime int64  `json:"redeemed_time" gorm:"bigint"` // 最近一次兑换时间
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint"`  // 0 表示永不过期
+}
+
+// RedemptionUse 兑换记录
+type RedemptionUse struct {
+	Id           int   `json:"id"`
+	RedemptionId int   `json:"redemption_id" gorm:"uniqueIndex:idx_redemption_user"`
+	UserId       int   `json:"user_id" gorm:"uniqueIndex:idx_redemption_user;index"`
+	Quota        int   `json:"quota"`
+	CreatedTime  int64 `json:"created_time" gorm:"bigint"`
+}
+
+func GetAllRedemptions(startIdx int, num int) (redemptions []*Redemption, total int64, err error) {
+	if err = DB.Model(&Redemption{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&redemptions).Error
+	return redemptions, total, err
+}
+
+// SearchRedemptions 按 ID 或名称前缀搜索兑换码
+func SearchRedemptions(keyword string, startIdx int, num int) (redemptions []*Redemption, total int64, err error) {
+	tx := DB.Model(&Redemption{})
+	if id, convErr := strconv.Atoi(keyword); convErr == nil && id > 0 {
+		tx = tx.Where("id = ? OR name LIKE ?", id, keyword+"%")
+	} else {
+		tx = tx.Where("name LIKE ?", keyword+"%")
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&redemptions).Error
+	return redemptions, total, err
+}
+
+// GetRedemptionsForExport 返回指定名称（为空时为全部）的兑换码，onlyUsable 为 true 时只返回仍可兑换的兑换码
+func GetRedemptionsForExport(name string, onlyUsable bool) ([]*Redemption, error) {
+	var redemptions []*Redemption
+	tx := DB.Order("id asc")
+	if name != "" {
+		tx = tx.Where("name = ?", name)
+	}
+	if onlyUsable {
+		now := common.GetTimestamp()
+		tx = tx.Where("status = ? AND used_count < max_uses AND (expired_time = 0 OR expired_time > ?)",
+			common.RedemptionCodeStatusEnabled, now)
+	}
+	err := tx.Find(&redemptions).Error
+	return redemptions, err
+}
+
+func GetRedemptionById(id int) (*Redemption, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var redemption Redemption
+	err := DB.First(&redemption, "id = ?", id).Error
+	return &redemption, err
+}
+
+// InsertRedemptions 批量创建兑换码
+func InsertRedemptions(redemptions []*Redemption) error {
+	return DB.Create(&redemptions).Error
+}
+
+// UpdateStatus 启用或吊销兑换码，已用完的兑换码不能重新启用
+func (redemption *Redemption) UpdateStatus(status int) error {
+	result := DB.Model(&Redemption{}).Where("id = ? AND status <> ?", redemption.Id, common.RedemptionCodeStatusUsed).
+		Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("兑换码已用完，无法修改状态")
+	}
+	redemption.Status = status
+	return nil
+}
+
+// DeleteInvalidRedemptions 删除已用完、已吊销或已过期的兑换码，并在同一事务内删除其兑换记录
+func DeleteInvalidRedemptions() (int64, error) {
+	now := common.GetTimestamp()
+	var rows int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var ids []int
+		err := tx.Model(&Redemption{}).Where("status IN ? OR (expired_time <> 0 AND expired_time < ?)",
+			[]int{common.RedemptionCodeStatusUsed, common.RedemptionCodeStatusDisabled}, now).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		// 分批删除，避免 IN 参数过多
+		for start := 0; start < len(ids); start += 500 {
+			batch := ids[start:min(start+500, len(ids))]
+			if err := tx.Where("redemption_id IN ?", batch).Delete(&RedemptionUse{}).Error; err != nil {
+				return err
+			}
+			result := tx.Where("id IN ?", batch).Delete(&Redemption{})
+			if result.Error != nil {
+				return result.Error
+			}
+			rows += result.RowsAffected
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// Redeem 兑换兑换码，在同一事务内校验兑换码、记录兑换并增加用户额度，返回兑换到的额度
+func Redeem(key string, userId int) (int, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return 0, ErrRedemptionNotProvided
+	}
+	if userId == 0 {
+		return 0, errors.New("无效的 user id")
+	}
+	redemption := &Redemption{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(commonKeyCol+" = ?", key).First(redemption).Error
+		if err != nil {
+			return ErrRedemptionInvalid
+		}
+		now := common.GetTimestamp()
+		if redemption.Status != common.RedemptionCodeStatusEnabled || redemption.UsedCount >= redemption.MaxUses {
+			return ErrRedemptionUsed
+		}
+		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < now {
+			return ErrRedemptionExpired
+		}
+		var count int64
+		if err := tx.Model(&RedemptionUse{}).Where("redemption_id = ? AND user_id = ?", redemption.Id, userId).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRedemptionAlreadyRedeemed
+		}
+		if err := tx.Create(&RedemptionUse{
+			RedemptionId: redemption.Id,
+			UserId:       userId,
+			Quota:        redemption.Quota,
+			CreatedTime:  now,
+		}).Error; err != nil {
+			return err
+		}
+		status := redemption.Status
+		if redemption.UsedCount+1 >= redemption.MaxUses {
+			status = common.RedemptionCodeStatusUsed
+		}
+		result := tx.Model(&Redemption{}).Where("id = ? AND status = ? AND used_count < max_uses", redemption.Id, common.RedemptionCodeStatusEnabled).
+			Updates(map[string]any{
+				"used_count":    gorm.Expr("used_count + 1"),
+				"status":        status,
+				"redeemed_time": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRedemptionUsed
+		}
+		return tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := cacheIncrUserQuota(userId, int64(redemption.Quota)); err != nil {
+		common.SysLog("failed to increase user quota cache: " + err.Error())
+	}
+	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，兑换码ID %d", logger.LogQuota(redemption.Quota), redemption.Id))
+	return redemption.Quota, nil
+}
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// encryptedSerializer 在写入数据库时加密字段，读取时解密，未配置主密钥时透明读写明文
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("failed to scan encrypted field %s: unsupported type %T", field.Name, dbValue)
+	}
+	plaintext, err := common.DecryptSecret(value)
+	if err != nil {
+		// 解密失败时保留原始密文，避免整个查询失败；密文写回时原样保存，使用方需通过 IsEncryptedSecret 识别并拒绝使用
+		common.SysError(fmt.Sprintf("failed to decrypt field %s: %s", field.Name, err.Error()))
+		plaintext = value
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, _ := fieldValue.(string)
+	// 已是密文（包括无法解密而保留的原始密文）时 EncryptSecret 原样返回，不会二次加密
+	return common.EncryptSecret(value)
+}
+
+// HasUndecryptableKey 渠道密钥是否因主密钥错误或数据损坏而无法解密，此类渠道不能参与选择
+func (channel *Channel) HasUndecryptableKey() bool {
+	return common.IsEncryptedSecret(channel.Key)
+}
+
+func encryptUserSettingSecrets(setting *dto.UserSetting) {
+	var err error
+	if setting.WebhookSecret, err = common.EncryptSecret(setting.WebhookSecret); err != nil {
+		common.SysError("failed to encrypt webhook secret: " + err.Error())
+	}
+	if setting.GotifyToken, err = common.EncryptSecret(setting.GotifyToken); err != nil {
+		common.SysError("failed to encrypt gotify token: " + err.Error())
+	}
+}
+
+func decryptUserSettingSecrets(setting *dto.UserSetting) {
+	if plaintext, err := common.DecryptSecret(setting.WebhookSecret); err != nil {
+		common.SysError("failed to decrypt webhook secret: " + err.Error())
+	} else {
+		setting.WebhookSecret = plaintext
+	}
+	if plaintext, err := common.DecryptSecret(setting.GotifyToken); err != nil {
+		common.SysError("failed to decrypt gotify token: " + err.Error())
+	} else {
+		setting.GotifyToken = plaintext
+	}
+}
+
+// channelKeyCondition 返回按密钥精确搜索渠道的条件；启用加密后密文无法直接比较，改为在内存中解密匹配
+func channelKeyCondition(keyword string) (string, interface{}) {
+	if !common.SecretEncryptionEnabled() {
+		return commonKeyCol + " = ?", keyword
+	}
+	ids := make([]int, 0)
+	if keyword != "" {
+		var rows []channelSecretRow
+		if err := DB.Table("channels").Select("id", commonKeyCol).Find(&rows).Error; err != nil {
+			common.SysError("failed to search channel keys: " + err.Error())
+		}
+		for _, row := range rows {
+			if key, err := common.DecryptSecret(row.Key); err == nil && key == keyword {
+				ids = append(ids, row.Id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return "id IN (?)", []int{0}
+	}
+	return "id IN (?)", ids
+}
+
+type channelSecretRow struct {
+	Id  int
+	Key string
+}
+
+type userSecretRow struct {
+	Id      int
+	Setting string
+}
+
+const secretReencryptBatchSize = 100
+
+// ReencryptSecrets 使用当前主密钥重新加密数据库中的敏感字段。
+// rotate 为 false 时仅迁移明文数据；为 true 时同时重新加密旧主密钥加密的数据，用于主密钥轮换
+func ReencryptSecrets(rotate bool) (int, error) {
+	needs := func(value string) bool {
+		if rotate {
+			return common.SecretNeedsReencrypt(value)
+		}
+		return value != "" && common.SecretEncryptionEnabled() && !common.IsEncryptedSecret(value)
+	}
+
+	updated := 0
+	lastId := 0
+	for {
+		var rows []channelSecretRow
+		err := DB.Table("channels").Select("id", commonKeyCol).Where("id > ?", lastId).
+			Order("id").Limit(secretReencryptBatchSize).Find(&rows).Error
+		if err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			lastId = row.Id
+			if !needs(row.Key) {
+				continue
+			}
+			key, err := common.ReencryptSecret(row.Key)
+			if err != nil {
+				return updated, fmt.Errorf("channel #%d: %w", row.Id, err)
+			}
+			if err := DB.Table("channels").Where("id = ?", row.Id).Update("key", key).Error; err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+
+	lastId = 0
+	for {
+		var rows []userSecretRow
+		err := DB.Table("users").Select("id", "setting").Where("id > ? AND setting <> ''", lastId).
+			Order("id").Limit(secretReencryptBatchSize).Find(&rows).Error
+		if err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			lastId = row.Id
+			setting := map[string]interface{}{}
+			if err := common.Unmarshal([]byte(row.Setting), &setting); err != nil {
+				continue
+			}
+			changed := false
+			for _, field := range []string{"webhook_secret", "gotify_token"} {
+				value, _ := setting[field].(string)
+				if !needs(value) {
+					continue
+				}
+				reencrypted, err := common.ReencryptSecret(value)
+				if err != nil {
+					return updated, fmt.Errorf("user #%d %s: %w", row.Id, field, err)
+				}
+				setting[field] = reencrypted
+				changed = true
+			}
+			if !changed {
+				continue
+			}
+			settingBytes, err := common.Marshal(setting)
+			if err != nil {
+				return updated, err
+			}
+			if err := DB.Table("users").Where("id = ?", row.Id).Update("setting", string(settingBytes)).Error; err != nil {
+				return updated, err
+			}
+			if common.RedisEnabled {
+				_ = updateUserSettingCache(row.Id, string(settingBytes))
+			}
+			updated++
+		}
+	}
+	return updated, nil
+}
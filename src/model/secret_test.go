@@ -0,0 +1,142 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupSecretTestDB(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() { _ = common.InitSecretEncryption() })
+	common.RedisEnabled = false
+	common.UsingSQLite = true
+	commonKeyCol = "`key`"
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, LOG_DB = db, db
+	if err := db.AutoMigrate(&Channel{}, &User{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func useSecretKeys(t *testing.T, active string, old string) {
+	t.Helper()
+	t.Setenv("SECRET_ENCRYPTION_KEY", active)
+	t.Setenv("SECRET_ENCRYPTION_KEY_FILE", "")
+	t.Setenv("SECRET_ENCRYPTION_OLD_KEYS", old)
+	if err := common.InitSecretEncryption(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storedChannelKey(t *testing.T, id int) string {
+	t.Helper()
+	var row channelSecretRow
+	if err := DB.Table("channels").Select("id", commonKeyCol).Where("id = ?", id).Take(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	return row.Key
+}
+
+func loadChannel(t *testing.T, id int) *Channel {
+	t.Helper()
+	channel := &Channel{}
+	if err := DB.First(channel, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+func TestEncryptedSerializerRoundTrip(t *testing.T) {
+	setupSecretTestDB(t)
+	useSecretKeys(t, "master-key-a", "")
+	channel := &Channel{Name: "secret", Key: "sk-round-trip"}
+	if err := DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	stored := storedChannelKey(t, channel.Id)
+	if !common.IsEncryptedSecret(stored) || strings.Contains(stored, "sk-round-trip") {
+		t.Fatalf("key stored in plaintext: %s", stored)
+	}
+	loaded := loadChannel(t, channel.Id)
+	if loaded.Key != "sk-round-trip" || loaded.HasUndecryptableKey() {
+		t.Fatalf("unexpected decrypted key: %s", loaded.Key)
+	}
+}
+
+func TestReencryptSecretsRotatesMasterKey(t *testing.T) {
+	setupSecretTestDB(t)
+	useSecretKeys(t, "master-key-a", "")
+	channel := &Channel{Name: "secret", Key: "sk-rotate"}
+	if err := DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	before := storedChannelKey(t, channel.Id)
+
+	useSecretKeys(t, "master-key-b", "master-key-a")
+	if key := loadChannel(t, channel.Id).Key; key != "sk-rotate" {
+		t.Fatalf("old master key not used for decryption: %s", key)
+	}
+	updated, err := ReencryptSecrets(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 1 {
+		t.Fatalf("expected 1 re-encrypted secret, got %d", updated)
+	}
+	after := storedChannelKey(t, channel.Id)
+	if after == before || common.SecretNeedsReencrypt(after) {
+		t.Fatalf("secret not re-encrypted with the active master key: %s", after)
+	}
+	if updated, err := ReencryptSecrets(true); err != nil || updated != 0 {
+		t.Fatalf("second rotation should be a no-op: updated %d, err %v", updated, err)
+	}
+
+	// 轮换完成后移除旧主密钥仍可解密
+	useSecretKeys(t, "master-key-b", "")
+	if key := loadChannel(t, channel.Id).Key; key != "sk-rotate" {
+		t.Fatalf("unexpected key after rotation: %s", key)
+	}
+}
+
+func TestEncryptedSerializerWrongMasterKey(t *testing.T) {
+	setupSecretTestDB(t)
+	useSecretKeys(t, "master-key-a", "")
+	channel := &Channel{Name: "secret", Key: "sk-wrong-key"}
+	if err := DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	stored := storedChannelKey(t, channel.Id)
+
+	useSecretKeys(t, "master-key-b", "")
+	loaded := loadChannel(t, channel.Id)
+	if !loaded.HasUndecryptableKey() {
+		t.Fatalf("undecryptable key not detected: %s", loaded.Key)
+	}
+	if key, _, err := loaded.GetNextEnabledKey(); err == nil || key != "" {
+		t.Fatalf("undecryptable channel must not yield a key: %q, %v", key, err)
+	}
+	if _, err := loaded.ResolvedKey(); err == nil {
+		t.Fatal("undecryptable channel must not resolve a key")
+	}
+
+	// 写回时保留原始密文，恢复正确的主密钥后仍可解密
+	loaded.Name = "renamed"
+	if err := DB.Save(loaded).Error; err != nil {
+		t.Fatal(err)
+	}
+	if after := storedChannelKey(t, channel.Id); after != stored {
+		t.Fatalf("stored secret changed after save: %s", after)
+	}
+	useSecretKeys(t, "master-key-a", "")
+	if key := loadChannel(t, channel.Id).Key; key != "sk-wrong-key" {
+		t.Fatalf("secret corrupted after save: %s", key)
+	}
+}
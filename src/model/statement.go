@@ -0,0 +1,28 @@
+package model
+
+// StatementItem 月度账单的一行，按模型与令牌汇总
+type StatementItem struct {
+	ModelName        string `json:"model_name"`
+	TokenName        string `json:"token_name"`
+	Requests         int64  `json:"requests"`
+	Quota            int64  `json:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+// GetStatementItems 汇总 [startTimestamp, endTimestamp) 内的消费日志。
+// userId 与 orgId 至少指定一个，同时指定时取交集。
+func GetStatementItems(userId int, orgId int, startTimestamp int64, endTimestamp int64) ([]*StatementItem, error) {
+	tx := LOG_DB.Table("logs").Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, startTimestamp, endTimestamp)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if orgId != 0 {
+		tx = tx.Where("organization_id = ?", orgId)
+	}
+	var items []*StatementItem
+	err := tx.Select("model_name, token_name, count(*) AS requests, COALESCE(sum(quota), 0) AS quota, " +
+		"COALESCE(sum(prompt_tokens), 0) AS prompt_tokens, COALESCE(sum(completion_tokens), 0) AS completion_tokens").
+		Group("model_name, token_name").Order("model_name asc, token_name asc").Scan(&items).Error
+	return items, err
+}
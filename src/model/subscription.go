@@ -0,0 +1,491 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// SubscriptionAmountTypeQuota 套餐按额度计量
+	SubscriptionAmountTypeQuota = "quota"
+	// SubscriptionAmountTypeRequest 套餐按请求次数计量
+	SubscriptionAmountTypeRequest = "request"
+)
+
+const (
+	UserSubscriptionStatusActive    = "active"
+	UserSubscriptionStatusCancelled = "cancelled"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// ErrSubscriptionUnavailable 表示没有可用于本次请求的订阅，调用方可以回退到钱包扣费
+var ErrSubscriptionUnavailable = errors.New("没有可用的订阅额度")
+
+// SubscriptionPlan 订阅套餐，每个周期包含一定的额度或请求次数
+type SubscriptionPlan struct {
+	Id              int    `json:"id"`
+	Title           string `json:"title" gorm:"type:varchar(128)"`
+	Description     string `json:"description" gorm:"type:text"`
+	Enabled         bool   `json:"enabled" gorm:"default:true"`
+	PriceQuota      int    `json:"price_quota" gorm:"default:0"`                        // 订阅或续费一次从钱包扣除的额度
+	AmountType      string `json:"amount_type" gorm:"type:varchar(16);default:'quota'"` // quota 或 request
+	Amount          int64  `json:"amount" gorm:"default:0"`                             // 每个周期包含的额度或请求次数
+	PeriodDays      int    `json:"period_days" gorm:"default:30"`                       // 额度重置周期（天），0 表示整个订阅期共用
+	DurationDays    int    `json:"duration_days" gorm:"default:30"`                     // 订阅或续费一次的有效期（天）
+	ModelAllowances string `json:"model_allowances" gorm:"type:text"`                   // 每个周期各模型可用的额度或次数上限，JSON 对象
+	CreatedTime     int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime     int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// UserSubscription 用户订阅，记录有效期与当前周期的用量
+type UserSubscription struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	PlanId      int    `json:"plan_id" gorm:"index"`
+	Status      string `json:"status" gorm:"type:varchar(16);default:'active';index"`
+	AutoRenew   bool   `json:"auto_renew" gorm:"default:false"`
+	StartTime   int64  `json:"start_time" gorm:"bigint"`
+	PeriodStart int64  `json:"period_start" gorm:"bigint"`
+	ExpireTime  int64  `json:"expire_time" gorm:"bigint;index"`
+	AmountTotal int64  `json:"amount_total" gorm:"default:0"` // 订阅时的套餐周期额度快照
+	AmountUsed  int64  `json:"amount_used" gorm:"default:0"`  // 当前周期已使用的额度或次数
+	ModelUsage  string `json:"model_usage" gorm:"type:text"`  // 当前周期各模型的用量，JSON 对象
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+
+	Plan *SubscriptionPlan `json:"plan,omitempty" gorm:"-"`
+}
+
+func IsValidSubscriptionAmountType(amountType string) bool {
+	return amountType == SubscriptionAmountTypeQuota || amountType == SubscriptionAmountTypeRequest
+}
+
+func (plan *SubscriptionPlan) GetModelAllowances() map[string]int64 {
+	allowances := make(map[string]int64)
+	if strings.TrimSpace(plan.ModelAllowances) == "" {
+		return allowances
+	}
+	if err := common.UnmarshalJsonStr(plan.ModelAllowances, &allowances); err != nil {
+		common.SysLog(fmt.Sprintf("failed to unmarshal model allowances of subscription plan %d: %s", plan.Id, err.Error()))
+	}
+	return allowances
+}
+
+// Validate 校验套餐配置并规范化模型额度
+func (plan *SubscriptionPlan) Validate() error {
+	plan.Title = strings.TrimSpace(plan.Title)
+	if plan.Title == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if !IsValidSubscriptionAmountType(plan.AmountType) {
+		return errors.New("无效的套餐计量方式")
+	}
+	if plan.Amount <= 0 {
+		return errors.New("套餐周期额度必须大于 0")
+	}
+	if plan.PriceQuota < 0 {
+		return errors.New("套餐价格不能为负数")
+	}
+	if plan.DurationDays <= 0 {
+		return errors.New("套餐有效期必须大于 0 天")
+	}
+	if plan.PeriodDays < 0 || plan.PeriodDays > plan.DurationDays {
+		return errors.New("额度重置周期必须在 0 到套餐有效期之间")
+	}
+	if strings.TrimSpace(plan.ModelAllowances) == "" {
+		plan.ModelAllowances = ""
+		return nil
+	}
+	allowances := make(map[string]int64)
+	if err := common.UnmarshalJsonStr(plan.ModelAllowances, &allowances); err != nil {
+		return errors.New("模型额度格式错误，应为模型名到额度的 JSON 对象")
+	}
+	for modelName, allowance := range allowances {
+		if strings.TrimSpace(modelName) == "" || allowance <= 0 {
+			return errors.New("模型额度必须为正数且模型名不能为空")
+		}
+	}
+	data, err := common.Marshal(allowances)
+	if err != nil {
+		return err
+	}
+	plan.ModelAllowances = string(data)
+	return nil
+}
+
+func GetAllSubscriptionPlans(enabledOnly bool) ([]*SubscriptionPlan, error) {
+	var plans []*SubscriptionPlan
+	tx := DB.Order("id asc")
+	if enabledOnly {
+		tx = tx.Where("enabled = ?", true)
+	}
+	err := tx.Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var plan SubscriptionPlan
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	plan.UpdatedTime = plan.CreatedTime
+	return DB.Create(plan).Error
+}
+
+// Update 更新套餐，已有订阅的周期额度快照不受影响，续费时按新配置生效
+func (plan *SubscriptionPlan) Update() error {
+	plan.UpdatedTime = common.GetTimestamp()
+	return DB.Model(plan).Select("title", "description", "enabled", "price_quota", "amount_type", "amount",
+		"period_days", "duration_days", "model_allowances", "updated_time").Updates(plan).Error
+}
+
+// DeleteSubscriptionPlan 删除套餐，仍有有效订阅的套餐只能停用
+func DeleteSubscriptionPlan(id int) error {
+	var count int64
+	err := DB.Model(&UserSubscription{}).
+		Where("plan_id = ? AND status = ? AND expire_time > ?", id, UserSubscriptionStatusActive, common.GetTimestamp()).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有有效订阅，请先停用")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+// IsUsable 判断订阅在指定时间是否可用
+func (sub *UserSubscription) IsUsable(now int64) bool {
+	return sub.Status == UserSubscriptionStatusActive && sub.StartTime <= now && sub.ExpireTime > now
+}
+
+func (sub *UserSubscription) GetModelUsage() map[string]int64 {
+	usage := make(map[string]int64)
+	if strings.TrimSpace(sub.ModelUsage) == "" {
+		return usage
+	}
+	if err := common.UnmarshalJsonStr(sub.ModelUsage, &usage); err != nil {
+		common.SysLog(fmt.Sprintf("failed to unmarshal model usage of subscription %d: %s", sub.Id, err.Error()))
+	}
+	return usage
+}
+
+func (sub *UserSubscription) setModelUsage(usage map[string]int64) error {
+	if len(usage) == 0 {
+		sub.ModelUsage = ""
+		return nil
+	}
+	data, err := common.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	sub.ModelUsage = string(data)
+	return nil
+}
+
+// refreshPeriod 当前周期结束时推进到包含 now 的周期并清零用量，返回是否发生了重置
+func (sub *UserSubscription) refreshPeriod(plan *SubscriptionPlan, now int64) bool {
+	if plan.PeriodDays <= 0 {
+		return false
+	}
+	periodSeconds := int64(plan.PeriodDays) * secondsPerDay
+	if now < sub.PeriodStart+periodSeconds {
+		return false
+	}
+	sub.PeriodStart += (now - sub.PeriodStart) / periodSeconds * periodSeconds
+	sub.AmountUsed = 0
+	sub.ModelUsage = ""
+	return true
+}
+
+// NextResetTime 返回当前周期的重置时间，不重置时返回到期时间
+func (sub *UserSubscription) NextResetTime(plan *SubscriptionPlan) int64 {
+	if plan.PeriodDays <= 0 {
+		return sub.ExpireTime
+	}
+	return min(sub.PeriodStart+int64(plan.PeriodDays)*secondsPerDay, sub.ExpireTime)
+}
+
+// GetUserSubscriptions 返回用户的订阅，包含已过期与已取消的记录
+func GetUserSubscriptions(userId int) ([]*UserSubscription, error) {
+	var subs []*UserSubscription
+	if err := DB.Where("user_id = ?", userId).Order("expire_time desc").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	now := common.GetTimestamp()
+	for _, sub := range subs {
+		plan, err := GetSubscriptionPlanById(sub.PlanId)
+		if err != nil {
+			continue
+		}
+		sub.Plan = plan
+		// 仅用于展示，周期重置在扣费时落库
+		sub.refreshPeriod(plan, now)
+	}
+	return subs, nil
+}
+
+func GetUserSubscriptionById(id int, userId int) (*UserSubscription, error) {
+	var sub UserSubscription
+	tx := DB.Where("id = ?", id)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err := tx.First(&sub).Error
+	return &sub, err
+}
+
+func GetAllUserSubscriptions(startIdx int, num int) (subs []*UserSubscription, total int64, err error) {
+	if err = DB.Model(&UserSubscription{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&subs).Error
+	return subs, total, err
+}
+
+// chargeUserQuota 在事务内按条件扣除用户钱包额度，余额不足时返回错误
+func chargeUserQuota(tx *gorm.DB, userId int, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	result := tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, quota).
+		Update("quota", gorm.Expr("quota - ?", quota))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("钱包余额不足")
+	}
+	return nil
+}
+
+// SubscribePlan 订阅套餐，price 为从钱包扣除的额度（管理员赠送时为 0）
+func SubscribePlan(userId int, plan *SubscriptionPlan, price int, autoRenew bool) (*UserSubscription, error) {
+	now := common.GetTimestamp()
+	sub := &UserSubscription{
+		UserId:      userId,
+		PlanId:      plan.Id,
+		Status:      UserSubscriptionStatusActive,
+		AutoRenew:   autoRenew,
+		StartTime:   now,
+		PeriodStart: now,
+		ExpireTime:  now + int64(plan.DurationDays)*secondsPerDay,
+		AmountTotal: plan.Amount,
+		CreatedTime: now,
+		UpdatedTime: now,
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&UserSubscription{}).
+			Where("user_id = ? AND plan_id = ? AND status = ? AND expire_time > ?", userId, plan.Id, UserSubscriptionStatusActive, now).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("你已订阅该套餐，请使用续费")
+		}
+		if err := chargeUserQuota(tx, userId, price); err != nil {
+			return err
+		}
+		return tx.Create(sub).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateUserSubscriptionCache(userId)
+	if price > 0 {
+		_ = invalidateUserCache(userId)
+	}
+	sub.Plan = plan
+	return sub, nil
+}
+
+// RenewUserSubscription 续费订阅，有效期从当前到期时间（已过期时从现在）顺延，并按套餐当前配置刷新周期额度
+func RenewUserSubscription(sub *UserSubscription, plan *SubscriptionPlan, price int) error {
+	now := common.GetTimestamp()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var current UserSubscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "id = ?", sub.Id).Error; err != nil {
+			return err
+		}
+		if current.Status != UserSubscriptionStatusActive {
+			return errors.New("订阅已取消，无法续费")
+		}
+		if current.ExpireTime-now > int64(plan.DurationDays)*secondsPerDay {
+			return errors.New("订阅剩余有效期超过一次续费的时长，暂不能续费")
+		}
+		if err := chargeUserQuota(tx, current.UserId, price); err != nil {
+			return err
+		}
+		if current.ExpireTime <= now {
+			// 已过期的订阅重新开始计算周期
+			current.StartTime = now
+			current.PeriodStart = now
+			current.ExpireTime = now
+			current.AmountUsed = 0
+			current.ModelUsage = ""
+		}
+		current.ExpireTime += int64(plan.DurationDays) * secondsPerDay
+		current.AmountTotal = plan.Amount
+		current.UpdatedTime = now
+		*sub = current
+		return tx.Model(&current).Select("start_time", "period_start", "expire_time", "amount_total", "amount_used",
+			"model_usage", "updated_time").Updates(&current).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateUserSubscriptionCache(sub.UserId)
+	if price > 0 {
+		_ = invalidateUserCache(sub.UserId)
+	}
+	return nil
+}
+
+func (sub *UserSubscription) UpdateAutoRenew() error {
+	sub.UpdatedTime = common.GetTimestamp()
+	return DB.Model(sub).Select("auto_renew", "updated_time").Updates(sub).Error
+}
+
+// CancelUserSubscription 立即取消订阅，取消后不可再使用
+func CancelUserSubscription(id int) error {
+	var sub UserSubscription
+	if err := DB.Select("id", "user_id").First(&sub, "id = ?", id).Error; err != nil {
+		return errors.New("订阅不存在或已取消")
+	}
+	result := DB.Model(&UserSubscription{}).Where("id = ? AND status = ?", id, UserSubscriptionStatusActive).
+		Updates(map[string]any{"status": UserSubscriptionStatusCancelled, "auto_renew": false, "updated_time": common.GetTimestamp()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订阅不存在或已取消")
+	}
+	invalidateUserSubscriptionCache(sub.UserId)
+	return nil
+}
+
+// GetSubscriptionsDueForRenewal 返回已到期且开启自动续费的订阅
+func GetSubscriptionsDueForRenewal(now int64, limit int) ([]*UserSubscription, error) {
+	var subs []*UserSubscription
+	err := DB.Where("status = ? AND auto_renew = ? AND expire_time <= ?", UserSubscriptionStatusActive, true, now).
+		Order("expire_time asc").Limit(limit).Find(&subs).Error
+	return subs, err
+}
+
+// PreConsumeUserSubscription 从用户可用的订阅中预扣指定用量，优先使用最早到期的订阅。
+// 没有订阅满足本次请求时返回 ErrSubscriptionUnavailable。
+func PreConsumeUserSubscription(userId int, modelName string, quota int) (*UserSubscription, *SubscriptionPlan, int64, error) {
+	now := common.GetTimestamp()
+	var candidates []*UserSubscription
+	err := DB.Where("user_id = ? AND status = ? AND start_time <= ? AND expire_time > ?", userId, UserSubscriptionStatusActive, now, now).
+		Order("expire_time asc").Find(&candidates).Error
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for _, candidate := range candidates {
+		plan, err := GetSubscriptionPlanById(candidate.PlanId)
+		if err != nil {
+			continue
+		}
+		amount := int64(quota)
+		if plan.AmountType == SubscriptionAmountTypeRequest {
+			amount = 1
+		}
+		var sub UserSubscription
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, "id = ?", candidate.Id).Error; err != nil {
+				return err
+			}
+			if !sub.IsUsable(now) {
+				return ErrSubscriptionUnavailable
+			}
+			sub.refreshPeriod(plan, now)
+			// 至少还要有 1 个单位的剩余额度才能使用订阅
+			if sub.AmountUsed+max(amount, 1) > sub.AmountTotal {
+				return ErrSubscriptionUnavailable
+			}
+			usage := sub.GetModelUsage()
+			if allowance, ok := plan.GetModelAllowances()[modelName]; ok {
+				if usage[modelName]+max(amount, 1) > allowance {
+					return ErrSubscriptionUnavailable
+				}
+			}
+			sub.AmountUsed += amount
+			usage[modelName] += amount
+			if err := sub.setModelUsage(usage); err != nil {
+				return err
+			}
+			sub.UpdatedTime = now
+			return tx.Model(&sub).Select("period_start", "amount_used", "model_usage", "updated_time").Updates(&sub).Error
+		})
+		if errors.Is(err, ErrSubscriptionUnavailable) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return &sub, plan, amount, nil
+	}
+	return nil, nil, 0, ErrSubscriptionUnavailable
+}
+
+// AdjustUserSubscriptionUsage 结算或退还订阅用量，delta 为负数时退还；周期已重置时不再调整上个周期的用量。
+// 增加的用量不超过周期与模型的剩余额度，返回实际计入订阅的用量，超出部分由调用方另行结算
+func AdjustUserSubscriptionUsage(id int, modelName string, delta int64, periodStart int64) (int64, error) {
+	if delta == 0 {
+		return 0, nil
+	}
+	applied := delta
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var sub UserSubscription
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sub, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if sub.PeriodStart != periodStart {
+			return nil
+		}
+		usage := sub.GetModelUsage()
+		if delta > 0 {
+			remaining := sub.AmountTotal - sub.AmountUsed
+			var plan SubscriptionPlan
+			if err := tx.First(&plan, "id = ?", sub.PlanId).Error; err == nil {
+				if allowance, ok := plan.GetModelAllowances()[modelName]; ok {
+					remaining = min(remaining, allowance-usage[modelName])
+				}
+			}
+			applied = min(delta, max(remaining, 0))
+			if applied == 0 {
+				return nil
+			}
+		}
+		sub.AmountUsed = max(sub.AmountUsed+applied, 0)
+		usage[modelName] = max(usage[modelName]+applied, 0)
+		if usage[modelName] == 0 {
+			delete(usage, modelName)
+		}
+		if err := sub.setModelUsage(usage); err != nil {
+			return err
+		}
+		sub.UpdatedTime = common.GetTimestamp()
+		return tx.Model(&sub).Select("amount_used", "model_usage", "updated_time").Updates(&sub).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return applied, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 每次请求都要判断用户是否有有效订阅，绝大多数用户没有订阅，
+// 因此缓存用户有效订阅中最晚的到期时间，0 表示没有有效订阅，订阅变化时清除缓存
+
+const subscriptionExpireMemoryTTL = 60 // 未启用 Redis 时内存缓存的有效期（秒）
+
+type subscriptionExpireEntry struct {
+	expireTime int64
+	cachedAt   int64
+}
+
+var subscriptionExpireStore sync.Map // map[int]subscriptionExpireEntry
+
+func getSubscriptionExpireCacheKey(userId int) string {
+	return fmt.Sprintf("user_subscription_expire:%d", userId)
+}
+
+// invalidateUserSubscriptionCache 清除用户的有效订阅缓存
+func invalidateUserSubscriptionCache(userId int) {
+	subscriptionExpireStore.Delete(userId)
+	if common.RedisEnabled {
+		if err := common.RedisDel(getSubscriptionExpireCacheKey(userId)); err != nil {
+			common.SysLog("failed to invalidate user subscription cache: " + err.Error())
+		}
+	}
+}
+
+func getUserSubscriptionExpireFromDB(userId int, now int64) (int64, error) {
+	var expireTime int64
+	err := DB.Model(&UserSubscription{}).
+		Where("user_id = ? AND status = ? AND start_time <= ? AND expire_time > ?", userId, UserSubscriptionStatusActive, now, now).
+		Select("COALESCE(MAX(expire_time), 0)").Scan(&expireTime).Error
+	return expireTime, err
+}
+
+// HasActiveSubscription 判断用户当前是否有有效订阅，结果会被缓存
+func HasActiveSubscription(userId int) (bool, error) {
+	now := common.GetTimestamp()
+	if common.RedisEnabled {
+		if value, err := common.RedisGet(getSubscriptionExpireCacheKey(userId)); err == nil {
+			if expireTime, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return expireTime > now, nil
+			}
+		}
+	} else if value, ok := subscriptionExpireStore.Load(userId); ok {
+		entry := value.(subscriptionExpireEntry)
+		if now-entry.cachedAt < subscriptionExpireMemoryTTL {
+			return entry.expireTime > now, nil
+		}
+	}
+	expireTime, err := getUserSubscriptionExpireFromDB(userId, now)
+	if err != nil {
+		return false, err
+	}
+	if common.RedisEnabled {
+		err = common.RedisSet(getSubscriptionExpireCacheKey(userId), strconv.FormatInt(expireTime, 10),
+			time.Duration(common.RedisKeyCacheSeconds())*time.Second)
+		if err != nil {
+			common.SysLog("failed to update user subscription cache: " + err.Error())
+		}
+	} else {
+		subscriptionExpireStore.Store(userId, subscriptionExpireEntry{expireTime: expireTime, cachedAt: now})
+	}
+	return expireTime > now, nil
+}
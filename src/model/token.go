@@ -3,9 +3,11 @@ package model
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
 )
@@ -13,7 +15,9 @@ import (
 type Token struct {
 	Id                 int            `json:"id"`
 	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Key                string         `json:"key,omitempty" gorm:"-"`                   // 明文密钥，仅在创建时返回一次，不写入数据库
+	KeyHash            string         `json:"-" gorm:"type:char(64);uniqueIndex"`       // 密钥的加盐哈希
+	KeyPrefix          string         `json:"key_prefix" gorm:"type:varchar(16);index"` // 密钥的明文前缀，用于展示与搜索
 	Status             int            `json:"status" gorm:"default:1"`
 	Name               string         `json:"name" gorm:"index" `
 	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
@@ -26,7 +30,25 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
-	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	CrossGroupRetry    bool           `json:"cross_group_retry"`                                  // 跨分组重试，仅auto分组有效
+	RPMLimit           int            `json:"rpm_limit" gorm:"default:0"`                         // 每分钟请求数上限，0 表示不限制
+	TPMLimit           int            `json:"tpm_limit" gorm:"default:0"`                         // 每分钟输入加输出 token 数上限
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`                 // 同时进行的请求数上限（含流式）
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:''"`   // 预算周期：daily、weekly、monthly，为空表示一次性额度
+	BudgetAmount       int            `json:"budget_amount" gorm:"default:0"`                     // 每个周期补充的额度
+	BudgetTimezone     string         `json:"budget_timezone" gorm:"type:varchar(64);default:''"` // 计算周期边界使用的时区，为空表示服务器时区
+	BudgetCarryOver    bool           `json:"budget_carry_over"`                                  // 是否将上个周期的剩余（或超支）额度结转到新周期
+	BudgetCarryOverCap int            `json:"budget_carry_over_cap" gorm:"default:0"`             // 结转额度上限，0 表示不限制
+	BudgetModelLimits  string         `json:"budget_model_limits" gorm:"type:text"`               // 每个周期内各模型的额度上限，JSON 对象
+	BudgetPeriodStart  int64          `json:"budget_period_start" gorm:"bigint;default:0"`        // 当前预算周期的开始时间
+	Scopes             string         `json:"scopes" gorm:"type:varchar(255);default:''"`         // 允许调用的接口范围，逗号分隔，为空表示不限制
+	PreviousKeyHash    string         `json:"-" gorm:"type:char(64);index"`                       // 轮换前的密钥哈希，过渡期内仍可使用
+	PreviousKeyUntil   int64          `json:"previous_key_until" gorm:"bigint;default:0"`         // 旧密钥的失效时间
+	RotationDays       int            `json:"rotation_days" gorm:"default:0"`                     // 自动轮换周期（天），0 表示不自动轮换
+	KeyRotatedTime     int64          `json:"key_rotated_time" gorm:"bigint;default:0"`           // 最近一次轮换密钥的时间
+	PendingKey         string         `json:"-" gorm:"type:text"`                                 // 自动轮换生成、尚未查看的新密钥（加密存储），查看一次后清除
+	HasPendingKey      bool           `json:"has_pending_key" gorm:"-"`                           // 是否有尚未查看的新密钥，供控制台提示用户查看
+	OrganizationId     int            `json:"organization_id" gorm:"index;default:0"`             // 所属组织，非 0 时从组织额度池扣费
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -38,6 +60,11 @@ type TokenSummary struct {
 	UserId int
 }
 
+func (token *Token) AfterFind(tx *gorm.DB) error {
+	token.HasPendingKey = token.PendingKey != ""
+	return nil
+}
+
 func (token *Token) Clean() {
 	token.Key = ""
 }
@@ -81,10 +108,17 @@ func ListTokensForAdmin() ([]TokenSummary, error) {
 }
 
 func SearchUserTokens(userId int, keyword string, token string) (tokens []*Token, err error) {
+	query := DB.Where("user_id = ?", userId).Where("name LIKE ?", "%"+keyword+"%")
 	if token != "" {
-		token = strings.Trim(token, "sk-")
+		token = strings.TrimPrefix(token, "sk-")
+		// 只保存了哈希与前缀，完整密钥按哈希精确匹配，较短的输入按前缀匹配
+		if len(token) > tokenKeyPrefixLength {
+			query = query.Where("key_hash = ?", HashTokenKey(token))
+		} else {
+			query = query.Where("key_prefix LIKE ?", token+"%")
+		}
 	}
-	err = DB.Where("user_id = ?", userId).Where("name LIKE ?", "%"+keyword+"%").Where(commonKeyCol+" LIKE ?", "%"+token+"%").Find(&tokens).Error
+	err = query.Find(&tokens).Error
 	return tokens, err
 }
 
@@ -94,40 +128,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	}
 	token, err = GetTokenByKey(key, false)
 	if err == nil {
-		if token.Status == common.TokenStatusExhausted {
-			keyPrefix := key[:3]
-			keySuffix := key[len(key)-3:]
-			return token, errors.New("该令牌额度已用尽 TokenStatusExhausted[sk-" + keyPrefix + "***" + keySuffix + "]")
-		} else if token.Status == common.TokenStatusExpired {
-			return token, errors.New("该令牌已过期")
-		}
-		if token.Status != common.TokenStatusEnabled {
-			return token, errors.New("该令牌状态不可用")
-		}
-		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
-			if !common.RedisEnabled {
-				token.Status = common.TokenStatusExpired
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			return token, errors.New("该令牌已过期")
-		}
-		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
-			if !common.RedisEnabled {
-				// in this case, we can make sure the token is exhausted
-				token.Status = common.TokenStatusExhausted
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			keyPrefix := key[:3]
-			keySuffix := key[len(key)-3:]
-			return token, errors.New(fmt.Sprintf("[sk-%s***%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", keyPrefix, keySuffix, token.RemainQuota))
-		}
-		return token, nil
+		return token, validateToken(token)
 	}
 	common.SysLog("ValidateUserToken: failed to get token: " + err.Error())
 	if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -137,6 +138,55 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	}
 }
 
+// ValidateUserTokenById 按 ID 校验令牌，用于无法取得令牌明文的内部请求
+func ValidateUserTokenById(id int) (*Token, error) {
+	token, err := GetTokenById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("无效的令牌")
+		}
+		return nil, errors.New("无效的令牌，数据库查询出错，请联系管理员")
+	}
+	return token, validateToken(token)
+}
+
+func validateToken(token *Token) error {
+	// 跨过预算周期边界时先补充额度，再检查是否用尽
+	if err := RefreshTokenBudget(token); err != nil {
+		common.SysLog(fmt.Sprintf("failed to refresh token budget: token_id=%d, error=%v", token.Id, err))
+	}
+	if token.Status == common.TokenStatusExhausted {
+		return errors.New("该令牌额度已用尽 TokenStatusExhausted[" + token.GetMaskedKey() + "]")
+	} else if token.Status == common.TokenStatusExpired {
+		return errors.New("该令牌已过期")
+	}
+	if token.Status != common.TokenStatusEnabled {
+		return errors.New("该令牌状态不可用")
+	}
+	if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+		if !common.RedisEnabled {
+			token.Status = common.TokenStatusExpired
+			err := token.SelectUpdate()
+			if err != nil {
+				common.SysLog("failed to update token status" + err.Error())
+			}
+		}
+		return errors.New("该令牌已过期")
+	}
+	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+		if !common.RedisEnabled {
+			// in this case, we can make sure the token is exhausted
+			token.Status = common.TokenStatusExhausted
+			err := token.SelectUpdate()
+			if err != nil {
+				common.SysLog("failed to update token status" + err.Error())
+			}
+		}
+		return errors.New(fmt.Sprintf("[%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", token.GetMaskedKey(), token.RemainQuota))
+	}
+	return nil
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -164,7 +214,19 @@ func GetTokenById(id int) (*Token, error) {
 	return &token, err
 }
 
-func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
+func GetTokenByKey(key string, fromDB bool) (*Token, error) {
+	token, err := GetTokenByKeyHash(HashTokenKey(key), fromDB)
+	if err == nil {
+		token.Key = key
+	}
+	return token, err
+}
+
+// GetTokenByKeyHash 按密钥哈希获取令牌，优先读取缓存
+func GetTokenByKeyHash(keyHash string, fromDB bool) (token *Token, err error) {
+	if keyHash == "" {
+		return nil, errors.New("令牌哈希为空")
+	}
 	defer func() {
 		// Update Redis cache asynchronously on successful DB read
 		if shouldUpdateRedis(fromDB, err) && token != nil {
@@ -177,18 +239,29 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 	}()
 	if !fromDB && common.RedisEnabled {
 		// Try Redis first
-		token, err := cacheGetTokenByKey(key)
+		token, err := cacheGetTokenByKeyHash(keyHash)
 		if err == nil {
 			return token, nil
 		}
 		// Don't return error - fall through to DB
 	}
 	fromDB = true
-	err = DB.Where(commonKeyCol+" = ?", key).First(&token).Error
+	err = DB.Where("key_hash = ?", keyHash).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 轮换后的过渡期内旧密钥仍然有效
+		if previous, previousErr := getTokenByPreviousKeyHash(keyHash); previousErr == nil {
+			return previous, nil
+		}
+	}
 	return token, err
 }
 
 func (token *Token) Insert() error {
+	if token.KeyHash == "" {
+		if err := token.SetKey(token.Key); err != nil {
+			return err
+		}
+	}
 	var err error
 	err = DB.Create(token).Error
 	return err
@@ -207,7 +280,10 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry",
+		"rpm_limit", "tpm_limit", "concurrency_limit", "budget_period", "budget_amount", "budget_timezone",
+		"budget_carry_over", "budget_carry_over_cap", "budget_model_limits", "budget_period_start",
+		"scopes", "rotation_days", "organization_id").Updates(token).Error
 	return err
 }
 
@@ -230,7 +306,7 @@ func (token *Token) Delete() (err error) {
 	defer func() {
 		if shouldUpdateRedis(true, err) {
 			gopool.Go(func() {
-				err := cacheDeleteToken(token.Key)
+				err := cacheDeleteToken(token.KeyHash)
 				if err != nil {
 					common.SysLog("failed to delete token cache: " + err.Error())
 				}
@@ -261,6 +337,28 @@ func (token *Token) GetModelLimitsMap() map[string]bool {
 	return limitsMap
 }
 
+// GetScopes 返回令牌允许调用的接口范围，为空表示不限制
+func (token *Token) GetScopes() []constant.TokenScope {
+	scopes := make([]constant.TokenScope, 0)
+	for _, scope := range strings.Split(token.Scopes, ",") {
+		scope = strings.TrimSpace(scope)
+		if scope != "" {
+			scopes = append(scopes, constant.TokenScope(scope))
+		}
+	}
+	return scopes
+}
+
+// HasScope 判断令牌是否允许调用指定范围的接口
+func (token *Token) HasScope(scope constant.TokenScope) bool {
+	scopes := token.GetScopes()
+	return len(scopes) == 0 || slices.Contains(scopes, scope)
+}
+
+func IsValidTokenScope(scope constant.TokenScope) bool {
+	return slices.Contains(constant.TokenScopes, scope)
+}
+
 func DisableModelLimits(tokenId int) error {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
@@ -284,13 +382,13 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+func IncreaseTokenQuota(id int, keyHash string, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if common.RedisEnabled {
 		gopool.Go(func() {
-			err := cacheIncrTokenQuota(key, int64(quota))
+			err := cacheIncrTokenQuota(keyHash, int64(quota))
 			if err != nil {
 				common.SysLog("failed to increase token quota: " + err.Error())
 			}
@@ -314,13 +412,13 @@ func increaseTokenQuota(id int, quota int) (err error) {
 	return err
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+func DecreaseTokenQuota(id int, keyHash string, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if common.RedisEnabled {
 		gopool.Go(func() {
-			err := cacheDecrTokenQuota(key, int64(quota))
+			err := cacheDecrTokenQuota(keyHash, int64(quota))
 			if err != nil {
 				common.SysLog("failed to decrease token quota: " + err.Error())
 			}
@@ -377,7 +475,7 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 	if common.RedisEnabled {
 		gopool.Go(func() {
 			for _, t := range tokens {
-				_ = cacheDeleteToken(t.Key)
+				_ = cacheDeleteToken(t.KeyHash)
 			}
 		})
 	}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TokenBudgetPeriodDaily   = "daily"
+	TokenBudgetPeriodWeekly  = "weekly"
+	TokenBudgetPeriodMonthly = "monthly"
+)
+
+// TokenModelSpend 令牌在一个预算周期内各模型的消耗，用于按模型限额与统计本周期用量
+type TokenModelSpend struct {
+	Id          int    `json:"id"`
+	TokenId     int    `json:"token_id" gorm:"uniqueIndex:idx_token_model_spend,priority:1"`
+	PeriodStart int64  `json:"period_start" gorm:"bigint;uniqueIndex:idx_token_model_spend,priority:2"`
+	ModelName   string `json:"model_name" gorm:"type:varchar(255);uniqueIndex:idx_token_model_spend,priority:3"`
+	Quota       int    `json:"quota" gorm:"default:0"`
+}
+
+func IsValidTokenBudgetPeriod(period string) bool {
+	switch period {
+	case "", TokenBudgetPeriodDaily, TokenBudgetPeriodWeekly, TokenBudgetPeriodMonthly:
+		return true
+	}
+	return false
+}
+
+func loadBudgetLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+// TokenBudgetPeriodBounds 返回 now 所在预算周期的起止时间，周期边界按令牌设置的时区计算，周从周一开始
+func TokenBudgetPeriodBounds(period string, timezone string, now time.Time) (start time.Time, end time.Time, err error) {
+	loc, err := loadBudgetLocation(timezone)
+	if err != nil {
+		return start, end, err
+	}
+	now = now.In(loc)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch period {
+	case TokenBudgetPeriodDaily:
+		start, end = day, day.AddDate(0, 0, 1)
+	case TokenBudgetPeriodWeekly:
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		end = start.AddDate(0, 0, 7)
+	case TokenBudgetPeriodMonthly:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0)
+	default:
+		return start, end, fmt.Errorf("invalid budget period: %s", period)
+	}
+	return start, end, nil
+}
+
+func (token *Token) HasBudget() bool {
+	return token.BudgetPeriod != "" && !token.UnlimitedQuota
+}
+
+// GetBudgetModelLimits 返回本周期内各模型的额度上限
+func (token *Token) GetBudgetModelLimits() map[string]int {
+	limits := make(map[string]int)
+	if token.BudgetModelLimits == "" {
+		return limits
+	}
+	if err := common.UnmarshalJsonStr(token.BudgetModelLimits, &limits); err != nil {
+		common.SysLog(fmt.Sprintf("failed to unmarshal token budget model limits: token_id=%d, error=%v", token.Id, err))
+	}
+	return limits
+}
+
+// budgetRefill 计算新周期开始时的剩余额度
+func (token *Token) budgetRefill() int {
+	if !token.BudgetCarryOver {
+		return token.BudgetAmount
+	}
+	carried := token.RemainQuota
+	if token.BudgetCarryOverCap > 0 && carried > token.BudgetCarryOverCap {
+		carried = token.BudgetCarryOverCap
+	}
+	// 超支部分同样结转到下个周期
+	return carried + token.BudgetAmount
+}
+
+// RefreshTokenBudget 跨过预算周期边界时按结转策略重置令牌额度，多实例并发时只有一个实例生效
+func RefreshTokenBudget(token *Token) error {
+	if !token.HasBudget() {
+		return nil
+	}
+	start, _, err := TokenBudgetPeriodBounds(token.BudgetPeriod, token.BudgetTimezone, time.Now())
+	if err != nil {
+		return err
+	}
+	if token.BudgetPeriodStart >= start.Unix() {
+		return nil
+	}
+	previousStart := token.BudgetPeriodStart
+	updates := map[string]interface{}{
+		"remain_quota":        token.budgetRefill(),
+		"budget_period_start": start.Unix(),
+	}
+	if token.Status == common.TokenStatusExhausted {
+		updates["status"] = common.TokenStatusEnabled
+	}
+	result := DB.Model(&Token{}).Where("id = ? AND budget_period_start = ?", token.Id, previousStart).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	key := token.Key
+	if err = DB.First(token, "id = ?", token.Id).Error; err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		if err := cacheSetToken(*token); err != nil {
+			common.SysLog("failed to update token cache: " + err.Error())
+		}
+	}
+	token.Key = key
+	if result.RowsAffected > 0 {
+		// 只保留上一个周期的按模型消耗，便于对账
+		DB.Where("token_id = ? AND period_start < ?", token.Id, previousStart).Delete(&TokenModelSpend{})
+		common.SysLog(fmt.Sprintf("token #%d budget period reset, remain quota %d", token.Id, token.RemainQuota))
+	}
+	return nil
+}
+
+// recordTokenModelSpend 记录预算令牌本周期内的模型消耗
+func recordTokenModelSpend(c *gin.Context, tokenId int, modelName string, quota int) {
+	if tokenId == 0 || quota <= 0 {
+		return
+	}
+	periodStart, ok := common.GetContextKeyType[int64](c, constant.ContextKeyTokenBudgetPeriodStart)
+	if !ok {
+		return
+	}
+	spend := &TokenModelSpend{
+		TokenId:     tokenId,
+		PeriodStart: periodStart,
+		ModelName:   ratio_setting.FormatMatchingModelName(modelName),
+		Quota:       quota,
+	}
+	gopool.Go(func() {
+		err := DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "token_id"}, {Name: "period_start"}, {Name: "model_name"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"quota": gorm.Expr("token_model_spends.quota + ?", quota)}),
+		}).Create(spend).Error
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to record token model spend: token_id=%d, error=%v", tokenId, err))
+		}
+	})
+}
+
+// GetTokenModelSpends 返回令牌在指定周期内各模型的消耗
+func GetTokenModelSpends(tokenId int, periodStart int64) (map[string]int, error) {
+	var spends []TokenModelSpend
+	err := DB.Where("token_id = ? AND period_start = ?", tokenId, periodStart).Find(&spends).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(spends))
+	for _, spend := range spends {
+		result[spend.ModelName] = spend.Quota
+	}
+	return result, nil
+}
+
+// CheckTokenModelBudget 检查令牌本周期内该模型的消耗是否已达到上限
+func CheckTokenModelBudget(tokenId int, periodStart int64, modelName string, limit int) error {
+	var spend TokenModelSpend
+	err := DB.Where("token_id = ? AND period_start = ? AND model_name = ?", tokenId, periodStart, modelName).
+		Limit(1).Find(&spend).Error
+	if err != nil {
+		return err
+	}
+	if spend.Quota >= limit {
+		return errors.New("该令牌本周期内模型 " + modelName + " 的额度已用尽")
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 令牌只保存加盐哈希与少量明文前缀，完整的令牌仅在创建时返回一次。
+// 盐优先读取环境变量 TOKEN_KEY_SALT，未设置时首次使用随机生成并保存在 options 表中，
+// 多实例部署共用同一数据库即可得到相同的盐。
+
+const (
+	TokenKeyHashSecretOption = "TokenKeyHashSecret"
+	// 令牌列表中展示的明文前缀长度
+	tokenKeyPrefixLength = 6
+)
+
+// ErrTokenKeyHashSecretReadOnly 修改盐会使所有已发放的令牌失效，因此不允许通过选项接口修改
+var ErrTokenKeyHashSecretReadOnly = errors.New("token key hash secret is read-only")
+
+var (
+	tokenKeySalt      string
+	tokenKeySaltMutex sync.Mutex
+)
+
+func getTokenKeySalt() (string, error) {
+	tokenKeySaltMutex.Lock()
+	defer tokenKeySaltMutex.Unlock()
+	if tokenKeySalt != "" {
+		return tokenKeySalt, nil
+	}
+	if salt := os.Getenv("TOKEN_KEY_SALT"); salt != "" {
+		tokenKeySalt = salt
+		return tokenKeySalt, nil
+	}
+	if DB == nil {
+		return "", errors.New("database is not initialized")
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	// 多个实例同时生成时只保留先写入的一个
+	err := DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&Option{Key: TokenKeyHashSecretOption, Value: hex.EncodeToString(buf)}).Error
+	if err != nil {
+		return "", err
+	}
+	var option Option
+	if err := DB.Where(commonKeyCol+" = ?", TokenKeyHashSecretOption).First(&option).Error; err != nil {
+		return "", err
+	}
+	if option.Value == "" {
+		return "", errors.New("token key salt is empty")
+	}
+	tokenKeySalt = option.Value
+	return tokenKeySalt, nil
+}
+
+// HashTokenKey 返回令牌的加盐哈希，用于数据库查找与缓存键
+func HashTokenKey(key string) string {
+	salt, err := getTokenKeySalt()
+	if err != nil {
+		common.SysError("failed to load token key salt: " + err.Error())
+		return ""
+	}
+	return common.GenerateHMACWithKey([]byte(salt), key)
+}
+
+func tokenKeyPrefix(key string) string {
+	if len(key) <= tokenKeyPrefixLength {
+		return key
+	}
+	return key[:tokenKeyPrefixLength]
+}
+
+// SetKey 设置令牌的明文密钥并计算哈希与展示前缀，明文不会写入数据库
+func (token *Token) SetKey(key string) error {
+	keyHash := HashTokenKey(key)
+	if keyHash == "" {
+		return errors.New("failed to hash token key")
+	}
+	token.Key = key
+	token.KeyHash = keyHash
+	token.KeyPrefix = tokenKeyPrefix(key)
+	return nil
+}
+
+// GetMaskedKey 返回用于展示的脱敏令牌
+func (token *Token) GetMaskedKey() string {
+	return "sk-" + token.KeyPrefix + "********"
+}
+
+// hasLegacyTokenKeyColumn 判断 tokens 表是否仍有旧版本的明文 key 列。
+// SQLite 的 HasColumn 按建表语句模糊匹配，会把 key_hash 等列误判为 key，因此按列名精确比较
+func hasLegacyTokenKeyColumn() bool {
+	columns, err := DB.Migrator().ColumnTypes(&Token{})
+	if err != nil {
+		return false
+	}
+	for _, column := range columns {
+		if column.Name() == "key" {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateTokenKeys 将旧版本明文保存的令牌转换为哈希，并清空明文列
+func migrateTokenKeys() error {
+	if !hasLegacyTokenKeyColumn() {
+		return nil
+	}
+	type legacyToken struct {
+		Id  int
+		Key string
+	}
+	migrated := 0
+	lastId := 0
+	for {
+		var rows []legacyToken
+		err := DB.Table("tokens").Select("id, "+commonKeyCol+" AS "+commonKeyCol).
+			Where("id > ? AND key_hash IS NULL AND "+commonKeyCol+" IS NOT NULL AND "+commonKeyCol+" <> ''", lastId).
+			Order("id").Limit(100).Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			lastId = row.Id
+			var token Token
+			if err := token.SetKey(row.Key); err != nil {
+				return err
+			}
+			err := DB.Table("tokens").Where("id = ?", row.Id).Updates(map[string]interface{}{
+				"key_hash":   token.KeyHash,
+				"key_prefix": token.KeyPrefix,
+				"key":        gorm.Expr("NULL"),
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to hash key of token #%d: %w", row.Id, err)
+			}
+			migrated++
+		}
+	}
+	if migrated > 0 {
+		common.SysLog(fmt.Sprintf("hashed %d plaintext token keys", migrated))
+	}
+	return nil
+}
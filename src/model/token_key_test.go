@@ -0,0 +1,177 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTokenKeyTestDB(t *testing.T) {
+	t.Helper()
+	common.RedisEnabled = false
+	common.UsingSQLite = true
+	commonKeyCol = "`key`"
+	t.Setenv("TOKEN_KEY_SALT", "token-key-test-salt")
+	tokenKeySalt = ""
+	t.Cleanup(func() { tokenKeySalt = "" })
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, LOG_DB = db, db
+	if err := db.AutoMigrate(&Token{}, &Option{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type legacyTokenRow struct {
+	Id        int
+	Key       *string
+	KeyHash   *string
+	KeyPrefix string
+}
+
+func legacyTokenRowOf(t *testing.T, id int) legacyTokenRow {
+	t.Helper()
+	var row legacyTokenRow
+	err := DB.Table("tokens").Select("id, "+commonKeyCol+", key_hash, key_prefix").Where("id = ?", id).Take(&row).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+func TestMigrateTokenKeysIsIdempotent(t *testing.T) {
+	setupTokenKeyTestDB(t)
+	if err := DB.Exec("ALTER TABLE tokens ADD COLUMN " + commonKeyCol + " char(48)").Error; err != nil {
+		t.Fatal(err)
+	}
+	legacyKey := "legacyplaintextkey0123456789"
+	err := DB.Exec("INSERT INTO tokens (id, user_id, name, "+commonKeyCol+", key_hash) VALUES (?, ?, ?, ?, NULL)",
+		1, 1, "legacy", legacyKey).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	migrated := legacyTokenRowOf(t, 1)
+	if migrated.Key != nil {
+		t.Fatalf("plaintext key not cleared: %s", *migrated.Key)
+	}
+	if migrated.KeyHash == nil || *migrated.KeyHash != HashTokenKey(legacyKey) {
+		t.Fatalf("unexpected key hash: %v", migrated.KeyHash)
+	}
+	if migrated.KeyPrefix != legacyKey[:tokenKeyPrefixLength] {
+		t.Fatalf("unexpected key prefix: %s", migrated.KeyPrefix)
+	}
+
+	if err := migrateTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if again := legacyTokenRowOf(t, 1); again.Key != nil || again.KeyHash == nil || *again.KeyHash != *migrated.KeyHash {
+		t.Fatalf("second migration changed the token: %+v", again)
+	}
+
+	token, err := GetTokenByKey(legacyKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Id != 1 || token.Key != legacyKey {
+		t.Fatalf("unexpected token after migration: id %d, key %s", token.Id, token.Key)
+	}
+}
+
+func TestTokenKeyHashLookup(t *testing.T) {
+	setupTokenKeyTestDB(t)
+	token := &Token{UserId: 1, Name: "lookup", Key: "lookupkey0123456789"}
+	if err := token.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := GetTokenByKey("lookupkey0123456789", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id != token.Id {
+		t.Fatalf("expected token %d, got %d", token.Id, found.Id)
+	}
+	if _, err := GetTokenByKey("lookupkey0123456780", true); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown key should not match: %v", err)
+	}
+
+	duplicate := &Token{UserId: 2, Name: "duplicate", KeyHash: token.KeyHash, KeyPrefix: token.KeyPrefix}
+	if err := duplicate.Insert(); err == nil {
+		t.Fatal("duplicate key hash must be rejected")
+	}
+}
+
+func TestSearchUserTokensByKey(t *testing.T) {
+	setupTokenKeyTestDB(t)
+	for _, token := range []*Token{
+		{UserId: 1, Name: "alpha", Key: "abcdef0123456789"},
+		{UserId: 1, Name: "beta", Key: "abcxyz0123456789"},
+		{UserId: 2, Name: "other", Key: "abcdefother00000"},
+	} {
+		if err := token.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(tokens []*Token) map[string]bool {
+		result := make(map[string]bool, len(tokens))
+		for _, token := range tokens {
+			result[token.Name] = true
+		}
+		return result
+	}
+
+	tokens, err := SearchUserTokens(1, "", "sk-abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tokens); len(got) != 2 || !got["alpha"] || !got["beta"] {
+		t.Fatalf("prefix search: %v", got)
+	}
+
+	tokens, err = SearchUserTokens(1, "", "abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tokens); len(got) != 1 || !got["alpha"] {
+		t.Fatalf("full prefix search: %v", got)
+	}
+
+	tokens, err = SearchUserTokens(1, "", "sk-abcdef0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tokens); len(got) != 1 || !got["alpha"] {
+		t.Fatalf("full key search: %v", got)
+	}
+
+	// 超过前缀长度的输入按完整密钥匹配，不会退化为前缀匹配
+	tokens, err = SearchUserTokens(1, "", "abcdef0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Fatalf("partial key longer than prefix should not match: %v", names(tokens))
+	}
+}
+
+func TestUpdateOptionRejectsTokenKeyHashSecret(t *testing.T) {
+	setupTokenKeyTestDB(t)
+	if err := UpdateOption(TokenKeyHashSecretOption, "attacker-salt"); !errors.Is(err, ErrTokenKeyHashSecretReadOnly) {
+		t.Fatalf("expected read-only error, got %v", err)
+	}
+	var count int64
+	DB.Model(&Option{}).Where(commonKeyCol+" = ?", TokenKeyHashSecretOption).Count(&count)
+	if count != 0 {
+		t.Fatal("token key hash secret must not be written by UpdateOption")
+	}
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+// RotateKey 为令牌生成新的密钥，旧密钥在 graceSeconds 秒内仍可使用。
+// 过渡期内再次轮换时，更早的旧密钥立即失效。返回新密钥明文
+func (token *Token) RotateKey(graceSeconds int64) (string, error) {
+	key, err := common.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	oldKeyHash := token.KeyHash
+	rotated := *token
+	if err := rotated.SetKey(key); err != nil {
+		return "", err
+	}
+	now := common.GetTimestamp()
+	updates := map[string]interface{}{
+		"key_hash":           rotated.KeyHash,
+		"key_prefix":         rotated.KeyPrefix,
+		"previous_key_hash":  gorm.Expr("NULL"),
+		"previous_key_until": 0,
+		"key_rotated_time":   now,
+		"pending_key":        "",
+	}
+	if graceSeconds > 0 {
+		updates["previous_key_hash"] = oldKeyHash
+		updates["previous_key_until"] = now + graceSeconds
+	}
+	// 以旧哈希为条件，避免并发轮换时互相覆盖
+	result := DB.Model(&Token{}).Where("id = ? AND key_hash = ?", token.Id, oldKeyHash).Updates(updates)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errors.New("令牌已被轮换，请刷新后重试")
+	}
+	if err := DB.First(token, "id = ?", token.Id).Error; err != nil {
+		return "", err
+	}
+	token.Key = key
+	if common.RedisEnabled {
+		cached := *token
+		gopool.Go(func() {
+			// 旧密钥的缓存删除后，过渡期内的请求回落到数据库按旧哈希查找
+			_ = cacheDeleteToken(oldKeyHash)
+			if err := cacheSetToken(cached); err != nil {
+				common.SysLog("failed to update token cache: " + err.Error())
+			}
+		})
+	}
+	return key, nil
+}
+
+// getTokenByPreviousKeyHash 查找过渡期内仍可使用旧密钥的令牌
+func getTokenByPreviousKeyHash(keyHash string) (*Token, error) {
+	var token Token
+	err := DB.Where("previous_key_hash = ? AND previous_key_until > ?", keyHash, common.GetTimestamp()).
+		First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// SetPendingKey 保存自动轮换生成的新密钥，供用户在控制台查看一次，密钥按敏感字段加密存储
+func (token *Token) SetPendingKey(key string) error {
+	encrypted, err := common.EncryptSecret(key)
+	if err != nil {
+		return err
+	}
+	result := DB.Model(&Token{}).Where("id = ? AND key_hash = ?", token.Id, token.KeyHash).Update("pending_key", encrypted)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("令牌已被轮换")
+	}
+	token.PendingKey = encrypted
+	return nil
+}
+
+// RevealTokenPendingKey 返回自动轮换生成的新密钥并将其清除，每个新密钥只能查看一次
+func RevealTokenPendingKey(id int, userId int) (string, error) {
+	token, err := GetTokenByIds(id, userId)
+	if err != nil {
+		return "", err
+	}
+	if token.PendingKey == "" {
+		return "", errors.New("没有待查看的新密钥")
+	}
+	key, err := common.DecryptSecret(token.PendingKey)
+	if err != nil {
+		return "", err
+	}
+	// 以当前值为条件清除，避免并发查看时同一密钥返回两次
+	result := DB.Model(&Token{}).Where("id = ? AND pending_key = ?", token.Id, token.PendingKey).Update("pending_key", "")
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errors.New("没有待查看的新密钥")
+	}
+	return key, nil
+}
+
+// GetTokensDueForRotation 返回 id 大于 afterId 且已到自动轮换时间的令牌，未轮换过的令牌从创建时间开始计算
+func GetTokensDueForRotation(now int64, afterId int, limit int) ([]*Token, error) {
+	var tokens []*Token
+	err := DB.Where("rotation_days > 0 AND status = ? AND id > ?", common.TokenStatusEnabled, afterId).
+		Where("(CASE WHEN key_rotated_time > 0 THEN key_rotated_time ELSE created_time END) + rotation_days * 86400 <= ?", now).
+		Order("id").Limit(limit).Find(&tokens).Error
+	return tokens, err
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TopUpStatusPending = "pending"
+	TopUpStatusSuccess = "success"
+	TopUpStatusExpired = "expired"
+)
+
+const TopUpPaymentMethodStripe = "stripe"
+
+// ErrTopUpSessionMismatch 支付回调的会话与订单记录的会话不一致
+var ErrTopUpSessionMismatch = errors.New("支付会话与订单不匹配")
+
+// TopUp 在线充值订单
+type TopUp struct {
+	Id            int     `json:"id"`
+	UserId        int     `json:"user_id" gorm:"index"`
+	Amount        int64   `json:"amount"` // 充值数量，每单位对应 common.QuotaPerUnit 额度
+	Quota         int     `json:"quota"`  // 到账额度
+	Money         float64 `json:"money"`  // 支付金额，支付完成后以支付平台的实付金额为准
+	TradeNo       string  `json:"trade_no" gorm:"type:varchar(64);uniqueIndex"`
+	SessionId     string  `json:"session_id" gorm:"type:varchar(255);index"` // 支付平台的会话 ID
+	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(32)"`
+	Status        string  `json:"status" gorm:"type:varchar(16);index"`
+	CreateTime    int64   `json:"create_time" gorm:"bigint"`
+	CompleteTime  int64   `json:"complete_time" gorm:"bigint"`
+}
+
+func (topUp *TopUp) Insert() error {
+	return DB.Create(topUp).Error
+}
+
+// UpdateSessionId 记录支付平台创建的会话 ID
+func (topUp *TopUp) UpdateSessionId(sessionId string) error {
+	topUp.SessionId = sessionId
+	return DB.Model(topUp).Update("session_id", sessionId).Error
+}
+
+func GetTopUpByTradeNo(tradeNo string) (*TopUp, error) {
+	var topUp TopUp
+	err := DB.First(&topUp, "trade_no = ?", tradeNo).Error
+	return &topUp, err
+}
+
+func GetTopUpById(id int) (*TopUp, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var topUp TopUp
+	err := DB.First(&topUp, "id = ?", id).Error
+	return &topUp, err
+}
+
+func GetUserTopUps(userId int, startIdx int, num int) (topUps []*TopUp, total int64, err error) {
+	tx := DB.Model(&TopUp{}).Where("user_id = ?", userId)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&topUps).Error
+	return topUps, total, err
+}
+
+// GetAllTopUps 返回全部订单，userId、status 为空值时不过滤
+func GetAllTopUps(userId int, status string, startIdx int, num int) (topUps []*TopUp, total int64, err error) {
+	tx := DB.Model(&TopUp{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&topUps).Error
+	return topUps, total, err
+}
+
+// CompleteTopUp 完成订单并为用户增加额度，重复调用不会重复入账，返回本次是否入账。
+// sessionId 必须与下单时记录的支付会话一致；paidMoney 为支付平台返回的实付金额，大于 0 时覆盖下单时的预估金额。
+func CompleteTopUp(tradeNo string, sessionId string, paidMoney float64) (*TopUp, bool, error) {
+	topUp := &TopUp{}
+	credited := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(topUp, "trade_no = ?", tradeNo).Error; err != nil {
+			return err
+		}
+		if sessionId == "" || topUp.SessionId != sessionId {
+			return ErrTopUpSessionMismatch
+		}
+		if topUp.Status == TopUpStatusSuccess {
+			return nil
+		}
+		now := common.GetTimestamp()
+		updates := map[string]any{"status": TopUpStatusSuccess, "complete_time": now}
+		if paidMoney > 0 {
+			updates["money"] = paidMoney
+		}
+		result := tx.Model(&TopUp{}).Where("id = ? AND status <> ?", topUp.Id, TopUpStatusSuccess).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 并发的回调已经入账
+			return nil
+		}
+		if err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", topUp.Quota)).Error; err != nil {
+			return err
+		}
+		topUp.Status = TopUpStatusSuccess
+		topUp.CompleteTime = now
+		if paidMoney > 0 {
+			topUp.Money = paidMoney
+		}
+		credited = true
+		return nil
+	})
+	if err != nil || !credited {
+		return topUp, false, err
+	}
+	if err := cacheIncrUserQuota(topUp.UserId, int64(topUp.Quota)); err != nil {
+		common.SysLog("failed to increase user quota cache: " + err.Error())
+	}
+	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用 %s 充值成功，充值额度: %s，支付金额：%.2f，订单号：%s",
+		topUp.PaymentMethod, logger.LogQuota(topUp.Quota), topUp.Money, topUp.TradeNo))
+	return topUp, true, nil
+}
+
+// ExpireTopUp 将未支付的订单标记为已过期
+func ExpireTopUp(tradeNo string) error {
+	return DB.Model(&TopUp{}).Where("trade_no = ? AND status = ?", tradeNo, TopUpStatusPending).
+		Update("status", TopUpStatusExpired).Error
+}
+
+// ExpireTopUpSession 按支付平台的会话将未支付的订单标记为已过期，sessionId 必须与下单时记录的支付会话一致
+func ExpireTopUpSession(tradeNo string, sessionId string) error {
+	var topUp TopUp
+	if err := DB.Select("id", "session_id").First(&topUp, "trade_no = ?", tradeNo).Error; err != nil {
+		return err
+	}
+	if sessionId == "" || topUp.SessionId != sessionId {
+		return ErrTopUpSessionMismatch
+	}
+	return DB.Model(&TopUp{}).Where("id = ? AND status = ?", topUp.Id, TopUpStatusPending).
+		Update("status", TopUpStatusExpired).Error
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestExpireTopUpSessionChecksSession(t *testing.T) {
+	common.RedisEnabled = false
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	DB, LOG_DB = db, db
+	if err := db.AutoMigrate(&TopUp{}); err != nil {
+		t.Fatal(err)
+	}
+	topUp := &TopUp{UserId: 1, TradeNo: "T1", SessionId: "cs_current", Status: TopUpStatusPending}
+	if err := topUp.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 旧会话（例如重新下单前的会话）过期不应影响订单
+	if err := ExpireTopUpSession("T1", "cs_stale"); !errors.Is(err, ErrTopUpSessionMismatch) {
+		t.Fatalf("expected session mismatch, got %v", err)
+	}
+	if err := ExpireTopUpSession("T1", ""); !errors.Is(err, ErrTopUpSessionMismatch) {
+		t.Fatalf("expected session mismatch for empty session, got %v", err)
+	}
+	if err := ExpireTopUpSession("T_missing", "cs_current"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected record not found, got %v", err)
+	}
+	current, _ := GetTopUpById(topUp.Id)
+	if current.Status != TopUpStatusPending {
+		t.Fatalf("top up expired by a foreign session: %s", current.Status)
+	}
+
+	if err := ExpireTopUpSession("T1", "cs_current"); err != nil {
+		t.Fatal(err)
+	}
+	current, _ = GetTopUpById(topUp.Id)
+	if current.Status != TopUpStatusExpired {
+		t.Fatalf("expected expired top up, got %s", current.Status)
+	}
+}
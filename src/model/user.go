@@ -68,11 +68,13 @@ func (user *User) GetSetting() dto.UserSetting {
 		if err != nil {
 			common.SysLog("failed to unmarshal setting: " + err.Error())
 		}
+		decryptUserSettingSecrets(&setting)
 	}
 	return setting
 }
 
 func (user *User) SetSetting(setting dto.UserSetting) {
+	encryptUserSettingSecrets(&setting)
 	settingBytes, err := json.Marshal(setting)
 	if err != nil {
 		common.SysLog("failed to marshal setting: " + err.Error())
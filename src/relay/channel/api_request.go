@@ -69,6 +69,10 @@ var passthroughSkipHeaderNamesLower = map[string]struct{}{
 	"x-api-key":      {},
 	"x-goog-api-key": {},
 
+	// Internal auth headers for in-process forwarding (e.g. admin model tests).
+	"x-internal-token-id":     {},
+	"x-internal-token-secret": {},
+
 	// WebSocket handshake headers are generated by the client/dialer.
 	"sec-websocket-key":        {},
 	"sec-websocket-version":    {},
@@ -506,6 +510,7 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	service.RecordUpstreamRateLimit(info.ChannelId, info.ChannelIsMultiKey, info.ChannelMultiKeyIndex, resp.StatusCode, resp.Header)
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
@@ -80,8 +80,9 @@ type TokenCountMeta struct {
 
 type RelayInfo struct {
 	TokenId           int
-	TokenKey          string
+	TokenKeyHash      string
 	TokenGroup        string
+	OrganizationId    int // 组织令牌所属组织，非 0 时从组织额度池扣费
 	UserId            int
 	UsingGroup        string // 使用的分组，当auto跨分组重试时，会变动
 	UserGroup         string // 用户所在分组
@@ -123,9 +124,15 @@ type RelayInfo struct {
 	SubscriptionPreConsumed int64
 	// SubscriptionPostDelta is the post-consume delta applied to amount_used (quota units; can be negative).
 	SubscriptionPostDelta int64
+	// SubscriptionWalletOverflow is the settled quota beyond the remaining plan allowance that was charged to the wallet.
+	SubscriptionWalletOverflow int
 	// SubscriptionPlanId / SubscriptionPlanTitle are used for logging/UI display.
 	SubscriptionPlanId    int
 	SubscriptionPlanTitle string
+	// SubscriptionAmountType is the plan's metering unit ("quota" or "request").
+	SubscriptionAmountType string
+	// SubscriptionPeriodStart is the usage period the pre-consume was applied to; settlement is skipped once it has reset.
+	SubscriptionPeriodStart int64
 	// RequestId is used for idempotent pre-consume/refund
 	RequestId string
 	// SubscriptionAmountTotal / SubscriptionAmountUsedAfterPreConsume are used to compute remaining in logs.
@@ -433,9 +440,10 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		OriginModelName: common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
 
 		TokenId:        common.GetContextKeyInt(c, constant.ContextKeyTokenId),
-		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
+		TokenKeyHash:   common.GetContextKeyString(c, constant.ContextKeyTokenKeyHash),
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 		TokenGroup:     tokenGroup,
+		OrganizationId: common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId),
 
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
@@ -509,6 +509,8 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		other["image_generation_call"] = true
 		other["image_generation_call_price"] = imageGenerationCallPrice
 	}
+	service.RecordRelayTokenUsage(ctx, promptTokens, completionTokens)
+	service.RecordChannelTokenUsage(ctx, relayInfo.ChannelId, promptTokens, completionTokens)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
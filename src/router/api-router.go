@@ -45,6 +45,13 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.DELETE("/self", controller.DeleteSelf)
 				selfRoute.GET("/token", controller.GenerateAccessToken)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
+				selfRoute.POST("/redeem", middleware.CriticalRateLimit(), controller.Redeem)
+				selfRoute.GET("/checkin", controller.GetCheckinCalendar)
+				selfRoute.POST("/checkin", middleware.CriticalRateLimit(), controller.Checkin)
+				selfRoute.POST("/stripe/amount", controller.RequestStripeAmount)
+				selfRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.RequestStripePay)
+				selfRoute.GET("/topup/self", controller.GetSelfTopUps)
+				selfRoute.GET("/statements", controller.GetSelfStatement)
 			}
 
 			adminRoute := userRoute.Group("/")
@@ -56,6 +63,7 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.POST("/", controller.CreateUser)
 				adminRoute.POST("/manage", controller.ManageUser)
 				adminRoute.PUT("/", controller.UpdateUser)
+				adminRoute.PUT("/:id/rate_limit", controller.UpdateUserRateLimit)
 				adminRoute.DELETE("/:id", controller.DeleteUser)
 			}
 		}
@@ -70,6 +78,15 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
 		}
 
+		configRoute := apiRouter.Group("/config")
+		configRoute.Use(middleware.RootAuth())
+		{
+			configRoute.GET("/", controller.GetConfigFileStatus)
+			configRoute.GET("/plan", controller.PlanConfigFile)
+			configRoute.POST("/apply", controller.ApplyConfigFile)
+			configRoute.GET("/export", controller.ExportConfigFile)
+		}
+
 		performanceRoute := apiRouter.Group("/performance")
 		performanceRoute.Use(middleware.RootAuth())
 		{
@@ -111,6 +128,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/test/:id", controller.TestChannel)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+			channelRoute.GET("/:id/balance_history", controller.GetChannelBalanceHistory)
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
@@ -130,6 +148,18 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/tag/models", controller.GetTagModels)
 			channelRoute.POST("/copy/:id", controller.CopyChannel)
 			channelRoute.POST("/multi_key/manage", controller.ManageMultiKeys)
+			channelRoute.POST("/multi_key/probe", controller.ProbeDisabledKeys)
+			channelRoute.GET("/:id/breaker", controller.GetChannelBreaker)
+			channelRoute.DELETE("/:id/breaker", controller.ResetChannelBreaker)
+			channelRoute.GET("/:id/rate_limit", controller.GetChannelRateLimit)
+			channelRoute.GET("/health/summary", controller.GetChannelHealthSummary)
+			channelRoute.GET("/secret_refs", controller.GetChannelSecretRefStatus)
+			channelRoute.POST("/secret_refs/refresh", controller.RefreshChannelSecretRefs)
+			channelRoute.GET("/:id/health", controller.GetChannelHealth)
+			channelRoute.POST("/:id/test_suite", controller.RunChannelTestSuite)
+			channelRoute.GET("/:id/test_results", controller.GetChannelTestResults)
+			channelRoute.POST("/export", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), controller.ExportChannels)
+			channelRoute.POST("/import", middleware.RootAuth(), middleware.CriticalRateLimit(), controller.ImportChannels)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -140,9 +170,76 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
+			tokenRoute.POST("/:id/rotate", controller.RotateToken)
+			tokenRoute.POST("/:id/reveal", controller.RevealRotatedTokenKey)
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
 		}
 
+		organizationRoute := apiRouter.Group("/organization")
+		{
+			organizationRoute.GET("/", middleware.AdminAuth(), controller.GetAllOrganizations)
+			organizationRoute.POST("/:id/quota", middleware.AdminAuth(), controller.AdjustOrganizationQuota)
+			organizationRoute.POST("/", middleware.UserAuth(), controller.CreateOrganization)
+			organizationRoute.POST("/invitation/accept", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.AcceptOrganizationInvitation)
+			selfOrganizationRoute := organizationRoute.Group("/self")
+			selfOrganizationRoute.Use(middleware.UserAuth())
+			{
+				selfOrganizationRoute.GET("/", controller.GetSelfOrganization)
+				selfOrganizationRoute.PUT("/", controller.UpdateSelfOrganization)
+				selfOrganizationRoute.GET("/members", controller.GetOrganizationMembers)
+				selfOrganizationRoute.PUT("/members", controller.UpdateOrganizationMember)
+				selfOrganizationRoute.DELETE("/members/:user_id", controller.RemoveOrganizationMember)
+				selfOrganizationRoute.GET("/invitations", controller.GetOrganizationInvitations)
+				selfOrganizationRoute.POST("/invitations", middleware.CriticalRateLimit(), controller.CreateOrganizationInvitation)
+				selfOrganizationRoute.DELETE("/invitations/:id", controller.RevokeOrganizationInvitation)
+				selfOrganizationRoute.GET("/usage", controller.GetOrganizationUsage)
+			}
+		}
+
+		apiRouter.POST("/stripe/webhook", controller.StripeWebhook)
+		topUpRoute := apiRouter.Group("/topup")
+		topUpRoute.Use(middleware.AdminAuth())
+		{
+			topUpRoute.GET("/", controller.GetAllTopUps)
+			topUpRoute.POST("/:id/reconcile", controller.ReconcileTopUp)
+		}
+
+		redemptionRoute := apiRouter.Group("/redemption")
+		redemptionRoute.Use(middleware.AdminAuth())
+		{
+			redemptionRoute.GET("/", controller.GetAllRedemptions)
+			redemptionRoute.GET("/search", controller.SearchRedemptions)
+			redemptionRoute.GET("/export", middleware.DisableCache(), controller.ExportRedemptions)
+			redemptionRoute.GET("/:id", controller.GetRedemption)
+			redemptionRoute.POST("/", controller.AddRedemption)
+			redemptionRoute.PUT("/", controller.UpdateRedemptionStatus)
+			redemptionRoute.DELETE("/invalid", controller.DeleteInvalidRedemptions)
+		}
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+			selfSubscriptionRoute := subscriptionRoute.Group("/self")
+			selfSubscriptionRoute.Use(middleware.UserAuth())
+			{
+				selfSubscriptionRoute.GET("/", controller.GetSelfSubscriptions)
+				selfSubscriptionRoute.POST("/", middleware.CriticalRateLimit(), controller.Subscribe)
+				selfSubscriptionRoute.PUT("/:id", controller.UpdateSelfSubscription)
+				selfSubscriptionRoute.POST("/:id/renew", middleware.CriticalRateLimit(), controller.RenewSelfSubscription)
+			}
+			adminSubscriptionRoute := subscriptionRoute.Group("/")
+			adminSubscriptionRoute.Use(middleware.AdminAuth())
+			{
+				adminSubscriptionRoute.GET("/plan", controller.GetAllSubscriptionPlans)
+				adminSubscriptionRoute.POST("/plan", controller.AddSubscriptionPlan)
+				adminSubscriptionRoute.PUT("/plan", controller.UpdateSubscriptionPlan)
+				adminSubscriptionRoute.DELETE("/plan/:id", controller.DeleteSubscriptionPlan)
+				adminSubscriptionRoute.GET("/", controller.GetAllUserSubscriptions)
+				adminSubscriptionRoute.POST("/grant", controller.GrantSubscription)
+				adminSubscriptionRoute.DELETE("/:id", controller.CancelSubscription)
+			}
+		}
+
 		usageRoute := apiRouter.Group("/usage")
 		usageRoute.Use(middleware.CriticalRateLimit())
 		{
@@ -158,6 +255,7 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
+		logRoute.GET("/margin", middleware.AdminAuth(), controller.GetMarginReport)
 		logRoute.GET("/channel_affinity_usage_cache", middleware.AdminAuth(), controller.GetChannelAffinityUsageCacheStats)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
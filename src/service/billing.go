@@ -1,18 +1,33 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
 const (
-	BillingSourceWallet = "wallet"
+	BillingSourceWallet       = "wallet"
+	BillingSourceSubscription = "subscription"
 )
 
-// PreConsumeBilling pre-consumes from wallet quota only (payments/subscriptions disabled).
+// 扣费策略，取值见 common.NormalizeBillingPreference
+const (
+	BillingPreferenceSubscriptionFirst = "subscription_first"
+	BillingPreferenceWalletFirst       = "wallet_first"
+	BillingPreferenceSubscriptionOnly  = "subscription_only"
+	BillingPreferenceWalletOnly        = "wallet_only"
+)
+
+// PreConsumeBilling pre-consumes from the user's subscription or wallet quota according to the billing preference.
+// Organization tokens always draw from the organization quota pool.
 func PreConsumeBilling(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
 	if relayInfo == nil {
 		return types.NewError(fmt.Errorf("relayInfo is nil"), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
@@ -21,5 +36,114 @@ func PreConsumeBilling(c *gin.Context, preConsumedQuota int, relayInfo *relaycom
 	relayInfo.SubscriptionId = 0
 	relayInfo.SubscriptionPreConsumed = 0
 	relayInfo.SubscriptionPostDelta = 0
-	return PreConsumeQuota(c, preConsumedQuota, relayInfo)
+	relayInfo.SubscriptionWalletOverflow = 0
+	if relayInfo.OrganizationId != 0 {
+		return PreConsumeQuota(c, preConsumedQuota, relayInfo)
+	}
+	switch common.NormalizeBillingPreference(relayInfo.UserSetting.BillingPreference) {
+	case BillingPreferenceWalletOnly:
+		return PreConsumeQuota(c, preConsumedQuota, relayInfo)
+	case BillingPreferenceSubscriptionOnly:
+		return preConsumeSubscription(c, preConsumedQuota, relayInfo)
+	case BillingPreferenceWalletFirst:
+		apiErr := PreConsumeQuota(c, preConsumedQuota, relayInfo)
+		if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeInsufficientUserQuota {
+			return apiErr
+		}
+		// 钱包余额不足时尝试使用订阅，订阅也不可用时返回钱包的错误
+		if subErr := preConsumeSubscription(c, preConsumedQuota, relayInfo); subErr != nil {
+			return apiErr
+		}
+		return nil
+	default:
+		apiErr := preConsumeSubscription(c, preConsumedQuota, relayInfo)
+		if apiErr == nil || !errors.Is(apiErr, model.ErrSubscriptionUnavailable) {
+			return apiErr
+		}
+		return PreConsumeQuota(c, preConsumedQuota, relayInfo)
+	}
+}
+
+// preConsumeSubscription 从订阅预扣用量，令牌额度仍按预扣额度扣减
+func preConsumeSubscription(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	// 先按缓存判断是否有有效订阅，没有订阅的用户（绝大多数）直接回退到钱包，不查询订阅也不预扣令牌额度
+	hasSubscription, err := model.HasActiveSubscription(relayInfo.UserId)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if !hasSubscription {
+		return types.NewErrorWithStatusCode(model.ErrSubscriptionUnavailable, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if preConsumedQuota > 0 {
+		if err := PreConsumeTokenQuota(relayInfo, preConsumedQuota); err != nil {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+	}
+	sub, plan, amount, err := model.PreConsumeUserSubscription(relayInfo.UserId, relayInfo.OriginModelName, preConsumedQuota)
+	if err != nil {
+		if preConsumedQuota > 0 && !relayInfo.IsPlayground {
+			if refundErr := model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKeyHash, preConsumedQuota); refundErr != nil {
+				common.SysLog("error return pre-consumed token quota: " + refundErr.Error())
+			}
+		}
+		if errors.Is(err, model.ErrSubscriptionUnavailable) {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+		return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+	}
+	relayInfo.BillingSource = BillingSourceSubscription
+	relayInfo.SubscriptionId = sub.Id
+	relayInfo.SubscriptionPlanId = plan.Id
+	relayInfo.SubscriptionPlanTitle = plan.Title
+	relayInfo.SubscriptionAmountType = plan.AmountType
+	relayInfo.SubscriptionPeriodStart = sub.PeriodStart
+	relayInfo.SubscriptionPreConsumed = amount
+	relayInfo.SubscriptionAmountTotal = sub.AmountTotal
+	relayInfo.SubscriptionAmountUsedAfterPreConsume = sub.AmountUsed
+	relayInfo.FinalPreConsumedQuota = preConsumedQuota
+	logger.LogInfo(c, fmt.Sprintf("用户 %d 使用订阅 %d（%s）预扣 %d, 当前周期已用 %d/%d",
+		relayInfo.UserId, sub.Id, plan.Title, amount, sub.AmountUsed, sub.AmountTotal))
+	return nil
+}
+
+// postConsumeSubscription 按实际消耗结算订阅用量，按次计量的套餐在预扣时已计入。
+// 订阅剩余额度不足以覆盖补扣部分时，除 subscription_only 外超出部分从钱包扣除；退还时先退还钱包扣除的部分
+func postConsumeSubscription(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.SubscriptionAmountType == model.SubscriptionAmountTypeRequest {
+		return nil
+	}
+	if quota < 0 && relayInfo.SubscriptionWalletOverflow > 0 {
+		refund := min(-quota, relayInfo.SubscriptionWalletOverflow)
+		if err := model.IncreaseUserQuota(relayInfo.UserId, refund, false); err != nil {
+			return err
+		}
+		relayInfo.SubscriptionWalletOverflow -= refund
+		quota += refund
+	}
+	applied, err := model.AdjustUserSubscriptionUsage(relayInfo.SubscriptionId, relayInfo.OriginModelName, int64(quota), relayInfo.SubscriptionPeriodStart)
+	if err != nil {
+		return err
+	}
+	relayInfo.SubscriptionPostDelta += applied
+	overflow := quota - int(applied)
+	if overflow <= 0 || common.NormalizeBillingPreference(relayInfo.UserSetting.BillingPreference) == BillingPreferenceSubscriptionOnly {
+		return nil
+	}
+	if err := model.DecreaseUserQuota(relayInfo.UserId, overflow); err != nil {
+		return err
+	}
+	relayInfo.SubscriptionWalletOverflow += overflow
+	return nil
+}
+
+// refundSubscriptionPreConsumed 请求失败时退还订阅与令牌的预扣用量
+func refundSubscriptionPreConsumed(relayInfo *relaycommon.RelayInfo) error {
+	_, err := model.AdjustUserSubscriptionUsage(relayInfo.SubscriptionId, relayInfo.OriginModelName, -relayInfo.SubscriptionPreConsumed, relayInfo.SubscriptionPeriodStart)
+	if err != nil {
+		return err
+	}
+	if relayInfo.FinalPreConsumedQuota == 0 || relayInfo.IsPlayground {
+		return nil
+	}
+	return model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKeyHash, relayInfo.FinalPreConsumedQuota)
 }
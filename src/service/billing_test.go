@@ -0,0 +1,243 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupBillingTestDB(t *testing.T) {
+	t.Helper()
+	common.RedisEnabled = false
+	common.UsingSQLite = true
+	t.Setenv("TOKEN_KEY_SALT", "billing-test-salt")
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	model.DB, model.LOG_DB = db, db
+	require.NoError(t, db.AutoMigrate(&model.User{}, &model.Token{}, &model.Organization{}, &model.OrganizationMember{},
+		&model.SubscriptionPlan{}, &model.UserSubscription{}, &model.Log{}))
+}
+
+func createBillingTestToken(t *testing.T, userId int, orgId int, remainQuota int) *model.Token {
+	t.Helper()
+	key, err := common.GenerateKey()
+	require.NoError(t, err)
+	token := &model.Token{UserId: userId, Name: "billing", Key: key, RemainQuota: remainQuota, OrganizationId: orgId}
+	require.NoError(t, token.Insert())
+	return token
+}
+
+func billingTestRelayInfo(token *model.Token) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UserId:          token.UserId,
+		TokenId:         token.Id,
+		TokenKeyHash:    token.KeyHash,
+		OrganizationId:  token.OrganizationId,
+		OriginModelName: "gpt-test",
+	}
+}
+
+func billingTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	return c
+}
+
+// setupOrganizationBilling 创建一个额度池为 1000 的组织，成员 userId=2 的额度上限为 memberCap
+func setupOrganizationBilling(t *testing.T, memberCap int) (*model.Organization, *model.Token) {
+	t.Helper()
+	setupBillingTestDB(t)
+	require.NoError(t, model.DB.Create(&model.User{Id: 1, Username: "owner", Quota: 0}).Error)
+	require.NoError(t, model.DB.Create(&model.User{Id: 2, Username: "member", Quota: 0}).Error)
+	org, err := model.CreateOrganization("org", 1)
+	require.NoError(t, err)
+	require.NoError(t, model.AdjustOrganizationQuota(org.Id, 1000))
+	require.NoError(t, model.DB.Create(&model.OrganizationMember{
+		OrganizationId: org.Id,
+		UserId:         2,
+		Role:           model.OrganizationRoleMember,
+		QuotaCap:       memberCap,
+	}).Error)
+	return org, createBillingTestToken(t, 2, org.Id, 500)
+}
+
+func requireOrganizationUsage(t *testing.T, orgId int, userId int, orgQuota int, memberUsed int) {
+	t.Helper()
+	org, err := model.GetOrganizationById(orgId)
+	require.NoError(t, err)
+	require.Equal(t, orgQuota, org.Quota)
+	member, err := model.GetOrganizationMember(orgId, userId)
+	require.NoError(t, err)
+	require.Equal(t, memberUsed, member.UsedQuota)
+}
+
+func TestPreConsumeBillingOrganizationMemberCapExceeded(t *testing.T) {
+	org, token := setupOrganizationBilling(t, 100)
+	require.NoError(t, model.DecreaseOrganizationQuota(org.Id, 2, 80))
+
+	relayInfo := billingTestRelayInfo(token)
+	apiErr := PreConsumeBilling(billingTestContext(), 50, relayInfo)
+	require.NotNil(t, apiErr)
+	require.Equal(t, types.ErrorCodeInsufficientUserQuota, apiErr.GetErrorCode())
+	requireOrganizationUsage(t, org.Id, 2, 920, 80)
+
+	// 上限内的请求从组织额度池扣费，不使用成员自己的钱包
+	relayInfo = billingTestRelayInfo(token)
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 20, relayInfo))
+	require.Equal(t, BillingSourceWallet, relayInfo.BillingSource)
+	requireOrganizationUsage(t, org.Id, 2, 900, 100)
+	quota, err := model.GetUserQuota(2, true)
+	require.NoError(t, err)
+	require.Equal(t, 0, quota)
+}
+
+func TestOrganizationBillingRefundOnFailedRelay(t *testing.T) {
+	org, token := setupOrganizationBilling(t, 0)
+	relayInfo := billingTestRelayInfo(token)
+
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 100, relayInfo))
+	require.Equal(t, 100, relayInfo.FinalPreConsumedQuota)
+	requireOrganizationUsage(t, org.Id, 2, 900, 100)
+
+	// 退还在后台执行，令牌额度最后退还
+	ReturnPreConsumedQuota(billingTestContext(), relayInfo)
+	require.Eventually(t, func() bool {
+		refunded, err := model.GetTokenById(token.Id)
+		return err == nil && refunded.RemainQuota == 500
+	}, 2*time.Second, 10*time.Millisecond)
+	requireOrganizationUsage(t, org.Id, 2, 1000, 0)
+}
+
+func TestOrganizationBillingPostConsumeDelta(t *testing.T) {
+	org, token := setupOrganizationBilling(t, 0)
+	relayInfo := billingTestRelayInfo(token)
+
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 100, relayInfo))
+	require.NoError(t, PostConsumeQuota(relayInfo, 30, 0, false))
+	requireOrganizationUsage(t, org.Id, 2, 870, 130)
+	require.NoError(t, PostConsumeQuota(relayInfo, -50, 0, false))
+	requireOrganizationUsage(t, org.Id, 2, 920, 80)
+}
+
+// setupSubscriptionBilling 创建钱包额度为 walletQuota 的用户 3，并订阅每周期 100 额度的套餐
+func setupSubscriptionBilling(t *testing.T, preference string, walletQuota int, modelAllowances string) (*model.UserSubscription, *relaycommon.RelayInfo) {
+	t.Helper()
+	setupBillingTestDB(t)
+	require.NoError(t, model.DB.Create(&model.User{Id: 3, Username: "subscriber", Quota: walletQuota}).Error)
+	plan := &model.SubscriptionPlan{
+		Title:           "plan",
+		Enabled:         true,
+		AmountType:      model.SubscriptionAmountTypeQuota,
+		Amount:          100,
+		PeriodDays:      30,
+		DurationDays:    30,
+		ModelAllowances: modelAllowances,
+	}
+	require.NoError(t, plan.Insert())
+	sub, err := model.SubscribePlan(3, plan, 0, false)
+	require.NoError(t, err)
+	relayInfo := billingTestRelayInfo(createBillingTestToken(t, 3, 0, 1000))
+	relayInfo.UserSetting = dto.UserSetting{BillingPreference: preference}
+	return sub, relayInfo
+}
+
+func requireSubscriptionBilling(t *testing.T, subId int, amountUsed int64, walletQuota int) {
+	t.Helper()
+	sub, err := model.GetUserSubscriptionById(subId, 0)
+	require.NoError(t, err)
+	require.Equal(t, amountUsed, sub.AmountUsed)
+	quota, err := model.GetUserQuota(3, true)
+	require.NoError(t, err)
+	require.Equal(t, walletQuota, quota)
+}
+
+func TestSubscriptionFirstBillsOverflowToWallet(t *testing.T) {
+	sub, relayInfo := setupSubscriptionBilling(t, BillingPreferenceSubscriptionFirst, 1000, "")
+
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	require.Equal(t, BillingSourceSubscription, relayInfo.BillingSource)
+	requireSubscriptionBilling(t, sub.Id, 50, 1000)
+
+	// 实际消耗超出套餐剩余额度，超出部分从钱包扣除
+	require.NoError(t, PostConsumeQuota(relayInfo, 80, 0, false))
+	require.Equal(t, int64(50), relayInfo.SubscriptionPostDelta)
+	require.Equal(t, 30, relayInfo.SubscriptionWalletOverflow)
+	requireSubscriptionBilling(t, sub.Id, 100, 970)
+
+	// 退还时先退还钱包扣除的部分
+	require.NoError(t, PostConsumeQuota(relayInfo, -40, 0, false))
+	require.Equal(t, 0, relayInfo.SubscriptionWalletOverflow)
+	requireSubscriptionBilling(t, sub.Id, 90, 1000)
+}
+
+func TestSubscriptionOnlyNeverChargesWallet(t *testing.T) {
+	sub, relayInfo := setupSubscriptionBilling(t, BillingPreferenceSubscriptionOnly, 1000, "")
+
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	require.NoError(t, PostConsumeQuota(relayInfo, 80, 0, false))
+	require.Equal(t, 0, relayInfo.SubscriptionWalletOverflow)
+	requireSubscriptionBilling(t, sub.Id, 100, 1000)
+
+	// 套餐额度用尽后不回退到钱包
+	next := billingTestRelayInfo(&model.Token{UserId: 3, Id: relayInfo.TokenId, KeyHash: relayInfo.TokenKeyHash})
+	next.UserSetting = relayInfo.UserSetting
+	apiErr := PreConsumeBilling(billingTestContext(), 10, next)
+	require.NotNil(t, apiErr)
+	require.Equal(t, types.ErrorCodeInsufficientUserQuota, apiErr.GetErrorCode())
+	requireSubscriptionBilling(t, sub.Id, 100, 1000)
+}
+
+func TestWalletFirstUsesSubscriptionWhenWalletInsufficient(t *testing.T) {
+	sub, relayInfo := setupSubscriptionBilling(t, BillingPreferenceWalletFirst, 1000, "")
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	require.Equal(t, BillingSourceWallet, relayInfo.BillingSource)
+	requireSubscriptionBilling(t, sub.Id, 0, 950)
+
+	sub, relayInfo = setupSubscriptionBilling(t, BillingPreferenceWalletFirst, 10, "")
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	require.Equal(t, BillingSourceSubscription, relayInfo.BillingSource)
+	require.NoError(t, PostConsumeQuota(relayInfo, 60, 0, false))
+	require.Equal(t, 10, relayInfo.SubscriptionWalletOverflow)
+	requireSubscriptionBilling(t, sub.Id, 100, 0)
+}
+
+func TestWalletOnlyIgnoresSubscription(t *testing.T) {
+	sub, relayInfo := setupSubscriptionBilling(t, BillingPreferenceWalletOnly, 1000, "")
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	require.Equal(t, BillingSourceWallet, relayInfo.BillingSource)
+	require.NoError(t, PostConsumeQuota(relayInfo, 20, 0, false))
+	requireSubscriptionBilling(t, sub.Id, 0, 930)
+}
+
+func TestSubscriptionOverflowRespectsModelAllowance(t *testing.T) {
+	sub, relayInfo := setupSubscriptionBilling(t, BillingPreferenceSubscriptionFirst, 1000, `{"gpt-test":60}`)
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	require.NoError(t, PostConsumeQuota(relayInfo, 30, 0, false))
+	require.Equal(t, int64(10), relayInfo.SubscriptionPostDelta)
+	require.Equal(t, 20, relayInfo.SubscriptionWalletOverflow)
+	requireSubscriptionBilling(t, sub.Id, 60, 980)
+}
+
+func TestSubscriptionRefundOnFailedRelay(t *testing.T) {
+	sub, relayInfo := setupSubscriptionBilling(t, BillingPreferenceSubscriptionFirst, 1000, "")
+	require.Nil(t, PreConsumeBilling(billingTestContext(), 50, relayInfo))
+	requireSubscriptionBilling(t, sub.Id, 50, 1000)
+
+	ReturnPreConsumedQuota(billingTestContext(), relayInfo)
+	require.Eventually(t, func() bool {
+		token, err := model.GetTokenById(relayInfo.TokenId)
+		return err == nil && token.RemainQuota == 1000
+	}, 2*time.Second, 10*time.Millisecond)
+	requireSubscriptionBilling(t, sub.Id, 0, 1000)
+}
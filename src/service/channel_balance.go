@@ -0,0 +1,177 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// 余额状态记录在渠道 other_info 中，用于去重告警与余额恢复后还原
+const (
+	balanceInfoAlerted     = "balance_alerted"
+	balanceInfoDemotedFrom = "balance_demoted_from"
+	balanceInfoDisabled    = "balance_disabled"
+	balanceInfoEstimated   = "balance_estimated"
+)
+
+// BalanceBurnRate 根据余额历史估算的消耗速度
+type BalanceBurnRate struct {
+	BurnPerDay float64 `json:"burn_per_day"`
+	DaysLeft   float64 `json:"days_left"`   // -1 表示无法估算（历史不足或没有消耗）
+	RunsOutAt  int64   `json:"runs_out_at"` // 预计耗尽时间，0 表示无法估算
+}
+
+// ComputeBalanceBurnRate 累计相邻记录间的余额下降（忽略充值导致的上升）计算平均消耗速度
+func ComputeBalanceBurnRate(history []*model.ChannelBalanceHistory, balance float64) BalanceBurnRate {
+	rate := BalanceBurnRate{DaysLeft: -1}
+	if len(history) < 2 {
+		return rate
+	}
+	elapsed := history[len(history)-1].CreatedAt - history[0].CreatedAt
+	if elapsed < 3600 {
+		return rate
+	}
+	burned := 0.0
+	for i := 1; i < len(history); i++ {
+		if drop := history[i-1].Balance - history[i].Balance; drop > 0 {
+			burned += drop
+		}
+	}
+	rate.BurnPerDay = burned / float64(elapsed) * 86400
+	if rate.BurnPerDay <= 0 {
+		return rate
+	}
+	rate.DaysLeft = math.Max(balance, 0) / rate.BurnPerDay
+	rate.RunsOutAt = time.Now().Unix() + int64(rate.DaysLeft*86400)
+	return rate
+}
+
+func GetChannelBalanceBurnRate(channelId int, balance float64) (BalanceBurnRate, error) {
+	hours := operation_setting.GetChannelBalanceSetting().BurnRateWindowHours
+	if hours <= 0 {
+		hours = 72
+	}
+	history, err := model.GetChannelBalanceHistory(channelId, time.Now().Add(-time.Duration(hours)*time.Hour).Unix())
+	if err != nil {
+		return BalanceBurnRate{DaysLeft: -1}, err
+	}
+	return ComputeBalanceBurnRate(history, balance), nil
+}
+
+func formatBalanceProjection(rate BalanceBurnRate) string {
+	if rate.DaysLeft < 0 {
+		return "暂无足够的历史数据估算耗尽时间"
+	}
+	if rate.DaysLeft < 1 {
+		return fmt.Sprintf("近期每天消耗约 $%.2f，预计约 %.0f 小时后耗尽", rate.BurnPerDay, rate.DaysLeft*24)
+	}
+	return fmt.Sprintf("近期每天消耗约 $%.2f，预计约 %.1f 天后耗尽", rate.BurnPerDay, rate.DaysLeft)
+}
+
+// HandleChannelBalance 记录余额历史，并根据渠道阈值告警、降级或禁用；余额恢复后还原。
+// estimated 为 true 时余额来自本地消耗估算，不触发 DisableAtZero，只有渠道显式设置了禁用才会禁用
+func HandleChannelBalance(channelId int, balance float64, estimated bool) {
+	if err := model.RecordChannelBalance(channelId, balance); err != nil {
+		common.SysLog(fmt.Sprintf("failed to record channel balance: channel_id=%d, error=%v", channelId, err))
+	}
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		return
+	}
+	setting := operation_setting.GetChannelBalanceSetting()
+	otherSettings := channel.GetOtherSettings()
+	threshold := otherSettings.BalanceAlertThreshold
+	exhausted := balance <= 0
+	low := exhausted || (threshold > 0 && balance < threshold)
+
+	info := channel.GetOtherInfo()
+	alerted, _ := info[balanceInfoAlerted].(bool)
+	demotedFrom, demoted := info[balanceInfoDemotedFrom].(float64)
+	disabled, _ := info[balanceInfoDisabled].(bool)
+	notifyType := fmt.Sprintf("%s_balance_%d", dto.NotifyTypeChannelUpdate, channel.Id)
+	priorityChanged := false
+
+	if low {
+		shouldDisable := (exhausted && setting.DisableAtZero && !estimated) || otherSettings.BalanceLowAction == dto.BalanceLowActionDisable
+		if shouldDisable && channel.Status == common.ChannelStatusEnabled {
+			DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, "", channel.GetAutoBan()), "余额不足")
+			disabled = channel.GetAutoBan()
+		}
+		if otherSettings.BalanceLowAction == dto.BalanceLowActionDemote && !demoted {
+			if err := model.UpdateChannelPriority(channel.Id, otherSettings.BalanceDemotePriority); err != nil {
+				common.SysLog(fmt.Sprintf("failed to demote channel priority: channel_id=%d, error=%v", channel.Id, err))
+			} else {
+				demotedFrom, demoted = float64(channel.GetPriority()), true
+				priorityChanged = true
+			}
+		}
+		if !alerted && setting.AlertEnabled && (threshold > 0 || exhausted) {
+			rate, _ := GetChannelBalanceBurnRate(channel.Id, balance)
+			subject := fmt.Sprintf("通道「%s」（#%d）余额不足", channel.Name, channel.Id)
+			content := fmt.Sprintf("通道「%s」（#%d）余额已耗尽", channel.Name, channel.Id)
+			if !exhausted {
+				content = fmt.Sprintf("通道「%s」（#%d）当前余额 $%.2f，低于告警阈值 $%.2f。%s", channel.Name, channel.Id, balance, threshold, formatBalanceProjection(rate))
+			}
+			if priorityChanged {
+				content += fmt.Sprintf("。已将优先级由 %d 降为 %d", int64(demotedFrom), otherSettings.BalanceDemotePriority)
+			}
+			NotifyRootUser(notifyType, subject, content)
+			alerted = true
+		}
+	} else {
+		if demoted {
+			if err := model.UpdateChannelPriority(channel.Id, int64(demotedFrom)); err != nil {
+				common.SysLog(fmt.Sprintf("failed to restore channel priority: channel_id=%d, error=%v", channel.Id, err))
+			} else {
+				demoted = false
+				priorityChanged = true
+			}
+		}
+		if disabled && channel.Status == common.ChannelStatusAutoDisabled {
+			EnableChannel(channel.Id, "", channel.Name)
+		}
+		disabled = false
+		alerted = false
+	}
+
+	// 禁用或启用渠道时会更新 other_info，需重新读取后再写入余额状态
+	if channel, err = model.GetChannelById(channelId, true); err != nil {
+		return
+	}
+	info = channel.GetOtherInfo()
+	delete(info, balanceInfoAlerted)
+	delete(info, balanceInfoDemotedFrom)
+	delete(info, balanceInfoDisabled)
+	delete(info, balanceInfoEstimated)
+	if estimated {
+		info[balanceInfoEstimated] = true
+	}
+	if alerted {
+		info[balanceInfoAlerted] = true
+	}
+	if demoted {
+		info[balanceInfoDemotedFrom] = demotedFrom
+	}
+	if disabled {
+		info[balanceInfoDisabled] = true
+	}
+	channel.SetOtherInfo(info)
+	if err := model.UpdateChannelOtherInfo(channel); err != nil {
+		common.SysLog(fmt.Sprintf("failed to update channel balance state: channel_id=%d, error=%v", channel.Id, err))
+	}
+	if priorityChanged {
+		model.InitChannelCache()
+	}
+}
+
+// IsChannelDisabledByBalance 渠道是否因余额不足被自动禁用，此类渠道仍需查询余额以便充值后恢复
+func IsChannelDisabledByBalance(channel *model.Channel) bool {
+	disabled, _ := channel.GetOtherInfo()[balanceInfoDisabled].(bool)
+	return disabled && channel.Status == common.ChannelStatusAutoDisabled
+}
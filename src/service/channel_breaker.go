@@ -0,0 +1,384 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+)
+
+// ChannelBreakerKeyIndexAll 表示渠道级熔断器（不区分密钥）
+const ChannelBreakerKeyIndexAll = -1
+
+const ginKeyChannelBreakerTrials = "channel_breaker_trials"
+
+type channelBreakerKey struct {
+	ChannelId int
+	KeyIndex  int
+}
+
+type channelBreaker struct {
+	mu sync.Mutex
+
+	state               string
+	consecutiveFailures int
+	windowStart         time.Time
+	windowRequests      int
+	windowFailures      int
+	openedAt            time.Time
+	openUntil           time.Time
+	halfOpenInFlight    int
+	halfOpenSuccesses   int
+	tripCount           int
+	lastReason          string
+}
+
+// ChannelBreakerState 熔断器状态，用于管理接口展示
+type ChannelBreakerState struct {
+	ChannelId           int     `json:"channel_id"`
+	KeyIndex            int     `json:"key_index"` // -1 表示渠道级
+	State               string  `json:"state"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	WindowRequests      int     `json:"window_requests"`
+	WindowFailures      int     `json:"window_failures"`
+	ErrorRate           float64 `json:"error_rate"`
+	OpenedAt            int64   `json:"opened_at,omitempty"`
+	OpenUntil           int64   `json:"open_until,omitempty"`
+	TripCount           int     `json:"trip_count"`
+	LastReason          string  `json:"last_reason,omitempty"`
+}
+
+var channelBreakers sync.Map // map[channelBreakerKey]*channelBreaker
+
+// channelBreakerTrial 请求占用的半开试探名额，generation 为占用时的熔断次数，用于识别名额是否属于当前半开周期
+type channelBreakerTrial struct {
+	key        channelBreakerKey
+	generation int
+}
+
+func getChannelBreaker(channelId int, keyIndex int) *channelBreaker {
+	key := channelBreakerKey{ChannelId: channelId, KeyIndex: keyIndex}
+	if b, ok := channelBreakers.Load(key); ok {
+		return b.(*channelBreaker)
+	}
+	b, _ := channelBreakers.LoadOrStore(key, &channelBreaker{state: BreakerStateClosed, windowStart: time.Now()})
+	return b.(*channelBreaker)
+}
+
+func peekChannelBreaker(channelId int, keyIndex int) *channelBreaker {
+	if b, ok := channelBreakers.Load(channelBreakerKey{ChannelId: channelId, KeyIndex: keyIndex}); ok {
+		return b.(*channelBreaker)
+	}
+	return nil
+}
+
+// IsChannelBreakerAvailable 只读判断渠道（或密钥）当前是否可被选择，不占用半开试探名额
+func IsChannelBreakerAvailable(channelId int, keyIndex int) bool {
+	s := operation_setting.GetCircuitBreakerSetting()
+	if !s.Enabled {
+		return true
+	}
+	if keyIndex != ChannelBreakerKeyIndexAll && !s.MultiKeyEnabled {
+		return true
+	}
+	b := peekChannelBreaker(channelId, keyIndex)
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerStateOpen:
+		return !time.Now().Before(b.openUntil)
+	case BreakerStateHalfOpen:
+		return b.halfOpenInFlight < halfOpenMaxRequests(s)
+	}
+	return true
+}
+
+// ChannelBreakerAcquire 在请求发出前调用，半开状态下占用一个试探名额；返回 false 表示熔断中。
+// 占用的名额记录在请求上下文中，由 RecordChannelBreakerResult 或 ReleaseChannelBreakerTrials 归还。
+func ChannelBreakerAcquire(c *gin.Context, channelId int, keyIndex int) bool {
+	s := operation_setting.GetCircuitBreakerSetting()
+	if !s.Enabled {
+		return true
+	}
+	if keyIndex != ChannelBreakerKeyIndexAll && !s.MultiKeyEnabled {
+		return true
+	}
+	b := peekChannelBreaker(channelId, keyIndex)
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerStateOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.toHalfOpen(channelId, keyIndex)
+		fallthrough
+	case BreakerStateHalfOpen:
+		if b.halfOpenInFlight >= halfOpenMaxRequests(s) {
+			return false
+		}
+		b.halfOpenInFlight++
+		if c != nil {
+			trials, _ := c.Get(ginKeyChannelBreakerTrials)
+			list, _ := trials.([]channelBreakerTrial)
+			c.Set(ginKeyChannelBreakerTrials, append(list, channelBreakerTrial{
+				key:        channelBreakerKey{ChannelId: channelId, KeyIndex: keyIndex},
+				generation: b.tripCount,
+			}))
+		}
+	}
+	return true
+}
+
+// takeChannelBreakerTrials 从请求上下文中取出匹配的试探名额，channelId 为 0 时取出全部
+func takeChannelBreakerTrials(c *gin.Context, channelId int) []channelBreakerTrial {
+	if c == nil {
+		return nil
+	}
+	trials, ok := c.Get(ginKeyChannelBreakerTrials)
+	if !ok {
+		return nil
+	}
+	list, _ := trials.([]channelBreakerTrial)
+	var taken, kept []channelBreakerTrial
+	for _, trial := range list {
+		if channelId == 0 || trial.key.ChannelId == channelId {
+			taken = append(taken, trial)
+		} else {
+			kept = append(kept, trial)
+		}
+	}
+	c.Set(ginKeyChannelBreakerTrials, kept)
+	return taken
+}
+
+// ReleaseChannelBreakerTrials 归还请求占用但未发出（未记录结果）的半开试探名额，可重复调用
+func ReleaseChannelBreakerTrials(c *gin.Context) {
+	for _, trial := range takeChannelBreakerTrials(c, 0) {
+		b := peekChannelBreaker(trial.key.ChannelId, trial.key.KeyIndex)
+		if b == nil {
+			continue
+		}
+		b.mu.Lock()
+		// 名额所在的半开周期已结束（已关闭或重新熔断）时无需归还
+		if b.state == BreakerStateHalfOpen && b.tripCount == trial.generation && b.halfOpenInFlight > 0 {
+			b.halfOpenInFlight--
+		}
+		b.mu.Unlock()
+	}
+}
+
+// ChannelBreakerRecord 记录一次请求结果并驱动状态迁移
+func ChannelBreakerRecord(channelId int, keyIndex int, success bool, reason string) {
+	s := operation_setting.GetCircuitBreakerSetting()
+	if !s.Enabled || channelId <= 0 {
+		return
+	}
+	if keyIndex != ChannelBreakerKeyIndexAll && !s.MultiKeyEnabled {
+		return
+	}
+	b := getChannelBreaker(channelId, keyIndex)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	window := time.Duration(s.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+	if now.Sub(b.windowStart) >= window {
+		b.windowStart = now
+		b.windowRequests = 0
+		b.windowFailures = 0
+	}
+	b.windowRequests++
+	if success {
+		b.consecutiveFailures = 0
+	} else {
+		b.windowFailures++
+		b.consecutiveFailures++
+		b.lastReason = reason
+	}
+
+	if b.state == BreakerStateOpen && !now.Before(b.openUntil) {
+		// 未经 ChannelBreakerAcquire 的请求（如多密钥渠道的密钥级熔断器）由结果驱动进入半开，本次结果即为试探结果
+		b.toHalfOpen(channelId, keyIndex)
+		b.halfOpenInFlight++
+	}
+
+	switch b.state {
+	case BreakerStateHalfOpen:
+		if b.halfOpenInFlight > 0 {
+			b.halfOpenInFlight--
+		}
+		if !success {
+			b.trip(channelId, keyIndex, s, now, "half-open trial failed")
+			return
+		}
+		b.halfOpenSuccesses++
+		required := s.HalfOpenSuccesses
+		if required <= 0 {
+			required = 1
+		}
+		if b.halfOpenSuccesses >= required {
+			b.state = BreakerStateClosed
+			b.consecutiveFailures = 0
+			b.windowStart = now
+			b.windowRequests = 0
+			b.windowFailures = 0
+			common.SysLog(fmt.Sprintf("channel #%d (key %d) circuit breaker closed after successful trials", channelId, keyIndex))
+		}
+	case BreakerStateClosed:
+		if success {
+			return
+		}
+		if s.ConsecutiveFailures > 0 && b.consecutiveFailures >= s.ConsecutiveFailures {
+			b.trip(channelId, keyIndex, s, now, fmt.Sprintf("%d consecutive failures", b.consecutiveFailures))
+			return
+		}
+		if s.ErrorRateThreshold > 0 && b.windowRequests >= s.MinRequests {
+			rate := float64(b.windowFailures) / float64(b.windowRequests)
+			if rate >= s.ErrorRateThreshold {
+				b.trip(channelId, keyIndex, s, now, fmt.Sprintf("error rate %.2f over %d requests", rate, b.windowRequests))
+			}
+		}
+	}
+}
+
+// RecordChannelBreakerResult 记录一次转发结果到渠道级熔断器，多密钥渠道同时记录到所用密钥的熔断器
+func RecordChannelBreakerResult(c *gin.Context, channelId int, err *types.NewAPIError) {
+	if !operation_setting.GetCircuitBreakerSetting().Enabled {
+		return
+	}
+	success := !IsChannelFaultError(err)
+	reason := ""
+	if !success {
+		reason = err.Error()
+	}
+	// 结果记录会归还试探名额，避免请求结束时重复归还
+	takeChannelBreakerTrials(c, channelId)
+	ChannelBreakerRecord(channelId, ChannelBreakerKeyIndexAll, success, reason)
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		keyIndex := common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+		ChannelBreakerRecord(channelId, keyIndex, success, reason)
+	}
+}
+
+// toHalfOpen must be called with b.mu held
+func (b *channelBreaker) toHalfOpen(channelId int, keyIndex int) {
+	b.state = BreakerStateHalfOpen
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+	common.SysLog(fmt.Sprintf("channel #%d (key %d) circuit breaker half-open, sending trial request", channelId, keyIndex))
+}
+
+// trip must be called with b.mu held
+func (b *channelBreaker) trip(channelId int, keyIndex int, s *operation_setting.CircuitBreakerSetting, now time.Time, cause string) {
+	openSeconds := s.OpenSeconds
+	if openSeconds <= 0 {
+		openSeconds = 30
+	}
+	b.state = BreakerStateOpen
+	b.openedAt = now
+	b.openUntil = now.Add(time.Duration(openSeconds) * time.Second)
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+	b.tripCount++
+	common.SysLog(fmt.Sprintf("channel #%d (key %d) circuit breaker opened for %ds: %s, last error: %s", channelId, keyIndex, openSeconds, cause, b.lastReason))
+}
+
+func halfOpenMaxRequests(s *operation_setting.CircuitBreakerSetting) int {
+	if s.HalfOpenMaxRequests <= 0 {
+		return 1
+	}
+	return s.HalfOpenMaxRequests
+}
+
+func (b *channelBreaker) snapshot(key channelBreakerKey) ChannelBreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := ChannelBreakerState{
+		ChannelId:           key.ChannelId,
+		KeyIndex:            key.KeyIndex,
+		State:               b.state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		WindowRequests:      b.windowRequests,
+		WindowFailures:      b.windowFailures,
+		TripCount:           b.tripCount,
+		LastReason:          b.lastReason,
+	}
+	if b.state == BreakerStateOpen && !time.Now().Before(b.openUntil) {
+		// 等待下一次请求触发半开试探
+		state.State = BreakerStateHalfOpen
+	}
+	if b.windowRequests > 0 {
+		state.ErrorRate = float64(b.windowFailures) / float64(b.windowRequests)
+	}
+	if !b.openedAt.IsZero() {
+		state.OpenedAt = b.openedAt.Unix()
+		state.OpenUntil = b.openUntil.Unix()
+	}
+	return state
+}
+
+// GetChannelBreakerStates 返回渠道（含各密钥）的熔断器状态，按密钥索引排序
+func GetChannelBreakerStates(channelId int) []ChannelBreakerState {
+	states := make([]ChannelBreakerState, 0)
+	channelBreakers.Range(func(k, v interface{}) bool {
+		key := k.(channelBreakerKey)
+		if key.ChannelId == channelId {
+			states = append(states, v.(*channelBreaker).snapshot(key))
+		}
+		return true
+	})
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].KeyIndex < states[j].KeyIndex
+	})
+	return states
+}
+
+// GetOpenChannelBreakers 返回所有非关闭状态的渠道级熔断器，key 为渠道 ID
+func GetOpenChannelBreakers() map[int]ChannelBreakerState {
+	result := make(map[int]ChannelBreakerState)
+	channelBreakers.Range(func(k, v interface{}) bool {
+		key := k.(channelBreakerKey)
+		if key.KeyIndex != ChannelBreakerKeyIndexAll {
+			return true
+		}
+		state := v.(*channelBreaker).snapshot(key)
+		if state.State != BreakerStateClosed {
+			result[key.ChannelId] = state
+		}
+		return true
+	})
+	return result
+}
+
+// ResetChannelBreakers 清除渠道（含各密钥）的熔断器状态
+func ResetChannelBreakers(channelId int) {
+	channelBreakers.Range(func(k, v interface{}) bool {
+		if k.(channelBreakerKey).ChannelId == channelId {
+			channelBreakers.Delete(k)
+		}
+		return true
+	})
+}
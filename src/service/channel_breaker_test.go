@@ -0,0 +1,96 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func enableTestCircuitBreaker(t *testing.T) {
+	s := operation_setting.GetCircuitBreakerSetting()
+	original := *s
+	t.Cleanup(func() { *s = original })
+	s.Enabled = true
+	s.MultiKeyEnabled = true
+	s.ConsecutiveFailures = 2
+	s.ErrorRateThreshold = 0
+	s.HalfOpenMaxRequests = 1
+	s.HalfOpenSuccesses = 2
+}
+
+// expireChannelBreaker 让熔断器的打开时间立即到期
+func expireChannelBreaker(channelId int, keyIndex int) {
+	b := peekChannelBreaker(channelId, keyIndex)
+	b.mu.Lock()
+	b.openUntil = time.Now().Add(-time.Second)
+	b.mu.Unlock()
+}
+
+func TestChannelBreakerReleaseUnusedTrial(t *testing.T) {
+	enableTestCircuitBreaker(t)
+	const channelId = 91001
+	t.Cleanup(func() { ResetChannelBreakers(channelId) })
+
+	ChannelBreakerRecord(channelId, ChannelBreakerKeyIndexAll, false, "boom")
+	ChannelBreakerRecord(channelId, ChannelBreakerKeyIndexAll, false, "boom")
+	require.False(t, IsChannelBreakerAvailable(channelId, ChannelBreakerKeyIndexAll))
+	expireChannelBreaker(channelId, ChannelBreakerKeyIndexAll)
+
+	// 占用试探名额后请求在转发前失败（如请求校验错误），名额应在请求结束时归还
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.True(t, ChannelBreakerAcquire(c, channelId, ChannelBreakerKeyIndexAll))
+	require.False(t, IsChannelBreakerAvailable(channelId, ChannelBreakerKeyIndexAll))
+	ReleaseChannelBreakerTrials(c)
+	require.True(t, IsChannelBreakerAvailable(channelId, ChannelBreakerKeyIndexAll))
+	ReleaseChannelBreakerTrials(c)
+	require.Equal(t, 0, peekChannelBreaker(channelId, ChannelBreakerKeyIndexAll).halfOpenInFlight)
+
+	// 已记录结果的试探名额不会被重复归还
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	require.True(t, ChannelBreakerAcquire(c, channelId, ChannelBreakerKeyIndexAll))
+	RecordChannelBreakerResult(c, channelId, nil)
+	other, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.True(t, ChannelBreakerAcquire(other, channelId, ChannelBreakerKeyIndexAll))
+	ReleaseChannelBreakerTrials(c)
+	require.False(t, IsChannelBreakerAvailable(channelId, ChannelBreakerKeyIndexAll))
+	RecordChannelBreakerResult(other, channelId, nil)
+	require.Equal(t, BreakerStateClosed, GetChannelBreakerStates(channelId)[0].State)
+}
+
+func TestChannelBreakerKeyLifecycle(t *testing.T) {
+	enableTestCircuitBreaker(t)
+	const channelId, keyIndex = 91002, 3
+	t.Cleanup(func() { ResetChannelBreakers(channelId) })
+
+	// 密钥级熔断器不经过 ChannelBreakerAcquire，完全由请求结果驱动
+	ChannelBreakerRecord(channelId, keyIndex, false, "401")
+	ChannelBreakerRecord(channelId, keyIndex, false, "401")
+	require.False(t, IsChannelBreakerAvailable(channelId, keyIndex))
+	require.Equal(t, 1, peekChannelBreaker(channelId, keyIndex).tripCount)
+
+	// 到期后试探失败应再次熔断
+	expireChannelBreaker(channelId, keyIndex)
+	require.True(t, IsChannelBreakerAvailable(channelId, keyIndex))
+	ChannelBreakerRecord(channelId, keyIndex, false, "401")
+	require.False(t, IsChannelBreakerAvailable(channelId, keyIndex))
+	require.Equal(t, 2, peekChannelBreaker(channelId, keyIndex).tripCount)
+
+	// 到期后连续试探成功应恢复关闭
+	expireChannelBreaker(channelId, keyIndex)
+	ChannelBreakerRecord(channelId, keyIndex, true, "")
+	require.Equal(t, BreakerStateHalfOpen, peekChannelBreaker(channelId, keyIndex).state)
+	require.True(t, IsChannelBreakerAvailable(channelId, keyIndex))
+	ChannelBreakerRecord(channelId, keyIndex, true, "")
+	require.Equal(t, BreakerStateClosed, peekChannelBreaker(channelId, keyIndex).state)
+
+	// 熔断期内的迟到结果不改变状态
+	ChannelBreakerRecord(channelId, keyIndex, false, "401")
+	ChannelBreakerRecord(channelId, keyIndex, false, "401")
+	ChannelBreakerRecord(channelId, keyIndex, true, "")
+	require.Equal(t, BreakerStateOpen, peekChannelBreaker(channelId, keyIndex).state)
+}
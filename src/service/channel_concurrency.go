@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	channelConcurrencyRedisPrefix = "new-api:channel_concurrency:v1:"
+	channelQueueRedisPrefix       = "new-api:channel_concurrency_queue:v1:"
+	ginKeyChannelConcurrencyLease = "channel_concurrency_lease"
+
+	// channelConcurrencyLeaseTTL 并发占用的最长保留时间，防止实例异常退出后名额无法释放
+	channelConcurrencyLeaseTTL  = 30 * time.Minute
+	channelConcurrencyPollDelay = 100 * time.Millisecond
+	defaultConcurrencyWait      = 10 * time.Second
+)
+
+// channelConcurrencyAcquireScript 基于有序集合的分布式信号量，成员分数为占用时间（毫秒）
+var channelConcurrencyAcquireScript = redis.NewScript(`
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local member = ARGV[4]
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - ttl)
+if redis.call('ZCARD', key) >= limit then
+  return 0
+end
+redis.call('ZADD', key, now, member)
+redis.call('PEXPIRE', key, ttl)
+return 1
+`)
+
+// channelQueueJoinScript 跨实例统计等待队列长度，成员分数为等待截止时间（毫秒），过期成员视为已离开
+var channelQueueJoinScript = redis.NewScript(`
+local key = KEYS[1]
+local size = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local deadline = tonumber(ARGV[3])
+local member = ARGV[4]
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if redis.call('ZCARD', key) >= size then
+  return 0
+end
+redis.call('ZADD', key, deadline, member)
+redis.call('PEXPIREAT', key, deadline)
+return 1
+`)
+
+type channelSemaphore struct {
+	mu       sync.Mutex
+	inFlight int
+	waiting  int
+	notify   chan struct{}
+}
+
+type channelConcurrencyLease struct {
+	channelId int
+	member    string
+	redis     bool
+}
+
+var channelSemaphores sync.Map // map[int]*channelSemaphore
+
+func getChannelSemaphore(channelId int) *channelSemaphore {
+	if sem, ok := channelSemaphores.Load(channelId); ok {
+		return sem.(*channelSemaphore)
+	}
+	sem, _ := channelSemaphores.LoadOrStore(channelId, &channelSemaphore{notify: make(chan struct{})})
+	return sem.(*channelSemaphore)
+}
+
+func channelConcurrencyRedisKey(channelId int) string {
+	return channelConcurrencyRedisPrefix + strconv.Itoa(channelId)
+}
+
+func channelQueueRedisKey(channelId int) string {
+	return channelQueueRedisPrefix + strconv.Itoa(channelId)
+}
+
+// tryAcquireChannelConcurrency 尝试占用渠道的一个并发名额，渠道未设置并发上限时直接返回 true
+func tryAcquireChannelConcurrency(c *gin.Context, channel *model.Channel) bool {
+	limit := channel.GetOtherSettings().MaxConcurrency
+	if limit <= 0 {
+		return true
+	}
+	lease, ok := acquireChannelSlot(channel.Id, limit)
+	if !ok {
+		return false
+	}
+	setChannelConcurrencyLease(c, lease)
+	return true
+}
+
+// waitChannelConcurrency 在渠道的等待队列中等待并发名额，队列已满、超时或请求取消时返回 false
+func waitChannelConcurrency(c *gin.Context, channel *model.Channel) bool {
+	settings := channel.GetOtherSettings()
+	if settings.MaxConcurrency <= 0 {
+		return true
+	}
+	if settings.ConcurrencyQueueSize <= 0 {
+		return false
+	}
+	wait := defaultConcurrencyWait
+	if settings.ConcurrencyWaitSeconds > 0 {
+		wait = time.Duration(settings.ConcurrencyWaitSeconds) * time.Second
+	}
+
+	leave, ok := joinChannelQueue(channel.Id, settings.ConcurrencyQueueSize, wait)
+	if !ok {
+		return false
+	}
+	defer leave()
+
+	sem := getChannelSemaphore(channel.Id)
+
+	ctx := context.Background()
+	if c != nil && c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	for {
+		sem.mu.Lock()
+		notify := sem.notify
+		sem.mu.Unlock()
+		if lease, ok := acquireChannelSlot(channel.Id, settings.MaxConcurrency); ok {
+			setChannelConcurrencyLease(c, lease)
+			return true
+		}
+		// 本实例释放名额时会立即唤醒，其他实例释放的名额通过轮询感知
+		select {
+		case <-notify:
+		case <-time.After(channelConcurrencyPollDelay):
+		case <-deadline.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// joinChannelQueue 进入渠道的等待队列，队列已满时返回 false；启用 Redis 时队列长度跨实例统计
+func joinChannelQueue(channelId int, size int, wait time.Duration) (leave func(), ok bool) {
+	if common.RedisEnabled && common.RDB != nil {
+		member := common.GetUUID()
+		key := channelQueueRedisKey(channelId)
+		now := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		joined, err := channelQueueJoinScript.Run(ctx, common.RDB, []string{key},
+			size, now.UnixMilli(), now.Add(wait+channelConcurrencyPollDelay).UnixMilli(), member).Int()
+		if err == nil {
+			if joined != 1 {
+				return nil, false
+			}
+			return func() {
+				if err := common.RDB.ZRem(context.Background(), key, member).Err(); err != nil {
+					common.SysError(fmt.Sprintf("channel concurrency queue leave failed: channel_id=%d, err=%v", channelId, err))
+				}
+			}, true
+		}
+		common.SysError(fmt.Sprintf("channel concurrency queue join failed, fallback to memory: channel_id=%d, err=%v", channelId, err))
+	}
+
+	sem := getChannelSemaphore(channelId)
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if sem.waiting >= size {
+		return nil, false
+	}
+	sem.waiting++
+	return func() {
+		sem.mu.Lock()
+		sem.waiting--
+		sem.mu.Unlock()
+	}, true
+}
+
+func acquireChannelSlot(channelId int, limit int) (*channelConcurrencyLease, bool) {
+	if common.RedisEnabled && common.RDB != nil {
+		member := common.GetUUID()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		ok, err := channelConcurrencyAcquireScript.Run(ctx, common.RDB, []string{channelConcurrencyRedisKey(channelId)},
+			limit, time.Now().UnixMilli(), channelConcurrencyLeaseTTL.Milliseconds(), member).Int()
+		if err == nil {
+			if ok != 1 {
+				return nil, false
+			}
+			sem := getChannelSemaphore(channelId)
+			sem.mu.Lock()
+			sem.inFlight++
+			sem.mu.Unlock()
+			return &channelConcurrencyLease{channelId: channelId, member: member, redis: true}, true
+		}
+		common.SysError(fmt.Sprintf("channel concurrency acquire failed, fallback to memory: channel_id=%d, err=%v", channelId, err))
+	}
+
+	sem := getChannelSemaphore(channelId)
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+	if sem.inFlight >= limit {
+		return nil, false
+	}
+	sem.inFlight++
+	return &channelConcurrencyLease{channelId: channelId}, true
+}
+
+func setChannelConcurrencyLease(c *gin.Context, lease *channelConcurrencyLease) {
+	if c == nil {
+		lease.release()
+		return
+	}
+	ReleaseChannelConcurrency(c)
+	c.Set(ginKeyChannelConcurrencyLease, lease)
+}
+
+func (lease *channelConcurrencyLease) release() {
+	if lease.redis && common.RDB != nil {
+		err := common.RDB.ZRem(context.Background(), channelConcurrencyRedisKey(lease.channelId), lease.member).Err()
+		if err != nil {
+			common.SysError(fmt.Sprintf("channel concurrency release failed: channel_id=%d, err=%v", lease.channelId, err))
+		}
+	}
+	sem := getChannelSemaphore(lease.channelId)
+	sem.mu.Lock()
+	if sem.inFlight > 0 {
+		sem.inFlight--
+	}
+	close(sem.notify)
+	sem.notify = make(chan struct{})
+	sem.mu.Unlock()
+}
+
+// ReleaseChannelConcurrency 释放当前请求占用的渠道并发名额，可重复调用
+func ReleaseChannelConcurrency(c *gin.Context) {
+	if c == nil {
+		return
+	}
+	anyLease, ok := c.Get(ginKeyChannelConcurrencyLease)
+	if !ok || anyLease == nil {
+		return
+	}
+	lease, ok := anyLease.(*channelConcurrencyLease)
+	if !ok || lease == nil {
+		return
+	}
+	c.Set(ginKeyChannelConcurrencyLease, nil)
+	lease.release()
+}
+
+// GetChannelInFlightCounts 返回渠道的实时在途请求数；设置了并发上限的渠道读取信号量计数（跨实例），其余读取运行时统计
+func GetChannelInFlightCounts(channels []*model.Channel) map[int]int64 {
+	result := make(map[int]int64, len(channels))
+	ids := make([]int, 0, len(channels))
+	limited := make([]int, 0)
+	for _, channel := range channels {
+		if channel == nil {
+			continue
+		}
+		ids = append(ids, channel.Id)
+		if channel.GetOtherSettings().MaxConcurrency > 0 {
+			limited = append(limited, channel.Id)
+		}
+	}
+	for id, stats := range GetChannelRuntimeStats(ids) {
+		result[id] = stats.InFlight
+	}
+	for _, id := range limited {
+		sem := getChannelSemaphore(id)
+		sem.mu.Lock()
+		result[id] = int64(sem.inFlight)
+		sem.mu.Unlock()
+	}
+	if !common.RedisEnabled || common.RDB == nil || len(limited) == 0 {
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	minScore := strconv.FormatInt(time.Now().Add(-channelConcurrencyLeaseTTL).UnixMilli(), 10)
+	pipe := common.RDB.Pipeline()
+	cmds := make(map[int]*redis.IntCmd, len(limited))
+	for _, id := range limited {
+		cmds[id] = pipe.ZCount(ctx, channelConcurrencyRedisKey(id), minScore, "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		common.SysError(fmt.Sprintf("channel concurrency read failed, fallback to local counts: err=%v", err))
+		return result
+	}
+	for id, cmd := range cmds {
+		if count, err := cmd.Result(); err == nil {
+			result[id] = count
+		}
+	}
+	return result
+}
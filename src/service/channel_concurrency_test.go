@@ -0,0 +1,94 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func testConcurrencyChannel(id int, settings dto.ChannelOtherSettings) *model.Channel {
+	channel := &model.Channel{Id: id}
+	channel.SetOtherSettings(settings)
+	return channel
+}
+
+func TestChannelConcurrencyAcquireAndRelease(t *testing.T) {
+	channel := testConcurrencyChannel(90301, dto.ChannelOtherSettings{MaxConcurrency: 1})
+	first, _ := gin.CreateTestContext(httptest.NewRecorder())
+	second, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	require.True(t, tryAcquireChannelConcurrency(first, channel))
+	require.False(t, tryAcquireChannelConcurrency(second, channel))
+	require.Equal(t, int64(1), GetChannelInFlightCounts([]*model.Channel{channel})[channel.Id])
+
+	ReleaseChannelConcurrency(first)
+	ReleaseChannelConcurrency(first)
+	require.Equal(t, int64(0), GetChannelInFlightCounts([]*model.Channel{channel})[channel.Id])
+
+	require.True(t, tryAcquireChannelConcurrency(second, channel))
+	ReleaseChannelConcurrency(second)
+}
+
+func TestChannelConcurrencyWaitTimeout(t *testing.T) {
+	channel := testConcurrencyChannel(90302, dto.ChannelOtherSettings{
+		MaxConcurrency:         1,
+		ConcurrencyQueueSize:   1,
+		ConcurrencyWaitSeconds: 1,
+	})
+	holder, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.True(t, tryAcquireChannelConcurrency(holder, channel))
+	t.Cleanup(func() { ReleaseChannelConcurrency(holder) })
+
+	waiter, _ := gin.CreateTestContext(httptest.NewRecorder())
+	start := time.Now()
+	require.False(t, waitChannelConcurrency(waiter, channel))
+	require.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// 超时离开后队列名额应归还
+	sem := getChannelSemaphore(channel.Id)
+	sem.mu.Lock()
+	require.Equal(t, 0, sem.waiting)
+	sem.mu.Unlock()
+}
+
+func TestChannelConcurrencyWaitWokenByRelease(t *testing.T) {
+	channel := testConcurrencyChannel(90303, dto.ChannelOtherSettings{
+		MaxConcurrency:         1,
+		ConcurrencyQueueSize:   1,
+		ConcurrencyWaitSeconds: 5,
+	})
+	holder, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.True(t, tryAcquireChannelConcurrency(holder, channel))
+
+	waiter, _ := gin.CreateTestContext(httptest.NewRecorder())
+	done := make(chan bool, 1)
+	go func() {
+		done <- waitChannelConcurrency(waiter, channel)
+	}()
+
+	// 等待队列已被占满时直接拒绝
+	require.Eventually(t, func() bool {
+		sem := getChannelSemaphore(channel.Id)
+		sem.mu.Lock()
+		defer sem.mu.Unlock()
+		return sem.waiting == 1
+	}, time.Second, 10*time.Millisecond)
+	other, _ := gin.CreateTestContext(httptest.NewRecorder())
+	require.False(t, waitChannelConcurrency(other, channel))
+
+	ReleaseChannelConcurrency(holder)
+	select {
+	case ok := <-done:
+		require.True(t, ok)
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter was not woken after release")
+	}
+	ReleaseChannelConcurrency(waiter)
+	require.Equal(t, int64(0), GetChannelInFlightCounts([]*model.Channel{channel})[channel.Id])
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 渠道健康度错误分类
+const (
+	ChannelHealthErrorTimeout     = "timeout"
+	ChannelHealthErrorRateLimit   = "rate_limit"
+	ChannelHealthErrorAuth        = "auth"
+	ChannelHealthErrorServer      = "server_error"
+	ChannelHealthErrorClient      = "client_error"
+	ChannelHealthErrorNetwork     = "network"
+	ChannelHealthErrorOther       = "other"
+	channelHealthMaxQueryHours    = 24 * 90
+	channelHealthDefaultQueryHour = 24
+)
+
+// ClassifyChannelError 将请求错误归类，用于健康度统计
+func ClassifyChannelError(err *types.NewAPIError) string {
+	if err == nil {
+		return ""
+	}
+	code := err.GetErrorCode()
+	lowerMessage := strings.ToLower(err.Error())
+	switch {
+	case code == types.ErrorCodeChannelResponseTimeExceeded,
+		errors.Is(err, context.DeadlineExceeded),
+		strings.Contains(lowerMessage, "timeout"),
+		strings.Contains(lowerMessage, "deadline exceeded"):
+		return ChannelHealthErrorTimeout
+	case err.StatusCode == http.StatusTooManyRequests:
+		return ChannelHealthErrorRateLimit
+	case err.StatusCode == http.StatusUnauthorized, err.StatusCode == http.StatusForbidden,
+		code == types.ErrorCodeChannelInvalidKey:
+		return ChannelHealthErrorAuth
+	case code == types.ErrorCodeDoRequestFailed:
+		return ChannelHealthErrorNetwork
+	case err.StatusCode >= http.StatusInternalServerError:
+		return ChannelHealthErrorServer
+	case err.StatusCode >= http.StatusBadRequest:
+		return ChannelHealthErrorClient
+	}
+	return ChannelHealthErrorOther
+}
+
+// RecordChannelHealth 记录一次发往渠道的请求结果，在每次尝试结束后调用
+func RecordChannelHealth(c *gin.Context, info *relaycommon.RelayInfo, channelId int, attemptStart time.Time, err *types.NewAPIError) {
+	completionTokens := common.GetContextKeyInt(c, constant.ContextKeyRelayCompletionTokens)
+	common.SetContextKey(c, constant.ContextKeyRelayCompletionTokens, 0)
+	if channelId <= 0 || !operation_setting.GetChannelHealthSetting().Enabled {
+		return
+	}
+	keyIndex := -1
+	if common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		keyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	sample := model.ChannelHealthSample{
+		ChannelId:  channelId,
+		ModelName:  info.OriginModelName,
+		KeyIndex:   keyIndex,
+		Failed:     IsChannelFaultError(err),
+		ErrorClass: ClassifyChannelError(err),
+	}
+	if err == nil {
+		now := time.Now()
+		sample.Latency = now.Sub(attemptStart)
+		generationStart := attemptStart
+		if info.IsStream && info.FirstResponseTime.After(attemptStart) {
+			sample.TTFT = info.FirstResponseTime.Sub(attemptStart)
+			generationStart = info.FirstResponseTime
+		}
+		sample.OutputTokens = completionTokens
+		sample.GenerationMs = now.Sub(generationStart).Milliseconds()
+	}
+	model.LogChannelHealth(sample)
+}
+
+// ChannelHealthStats 健康度汇总结果
+type ChannelHealthStats struct {
+	ChannelId       int            `json:"channel_id,omitempty"`
+	ChannelName     string         `json:"channel_name,omitempty"`
+	ModelName       string         `json:"model_name,omitempty"`
+	KeyIndex        *int           `json:"key_index,omitempty"`
+	Timestamp       int64          `json:"timestamp,omitempty"`
+	Requests        int            `json:"requests"`
+	Failures        int            `json:"failures"`
+	SuccessRate     float64        `json:"success_rate"`
+	ErrorClasses    map[string]int `json:"error_classes"`
+	LatencyP50      int64          `json:"latency_p50_ms"`
+	LatencyP95      int64          `json:"latency_p95_ms"`
+	LatencyP99      int64          `json:"latency_p99_ms"`
+	TTFTP50         int64          `json:"ttft_p50_ms"`
+	TTFTP95         int64          `json:"ttft_p95_ms"`
+	TTFTP99         int64          `json:"ttft_p99_ms"`
+	TokensPerSecond float64        `json:"tokens_per_second"`
+
+	latencyHist  []int64
+	ttftHist     []int64
+	outputTokens int64
+	generationMs int64
+}
+
+func (s *ChannelHealthStats) add(h *model.ChannelHealth) {
+	s.Requests += h.Requests
+	s.Failures += h.Failures
+	if s.ErrorClasses == nil {
+		s.ErrorClasses = make(map[string]int)
+	}
+	for class, count := range h.ErrorClasses {
+		s.ErrorClasses[class] += count
+	}
+	s.latencyHist = addHistogram(s.latencyHist, h.LatencyHist)
+	s.ttftHist = addHistogram(s.ttftHist, h.TTFTHist)
+	s.outputTokens += h.OutputTokens
+	s.generationMs += h.GenerationMs
+}
+
+func (s *ChannelHealthStats) finish() {
+	if s.ErrorClasses == nil {
+		s.ErrorClasses = make(map[string]int)
+	}
+	s.SuccessRate = 1
+	if s.Requests > 0 {
+		s.SuccessRate = float64(s.Requests-s.Failures) / float64(s.Requests)
+	}
+	s.LatencyP50 = histogramPercentile(s.latencyHist, 0.50)
+	s.LatencyP95 = histogramPercentile(s.latencyHist, 0.95)
+	s.LatencyP99 = histogramPercentile(s.latencyHist, 0.99)
+	s.TTFTP50 = histogramPercentile(s.ttftHist, 0.50)
+	s.TTFTP95 = histogramPercentile(s.ttftHist, 0.95)
+	s.TTFTP99 = histogramPercentile(s.ttftHist, 0.99)
+	if s.generationMs > 0 {
+		s.TokensPerSecond = float64(s.outputTokens) * 1000 / float64(s.generationMs)
+	}
+}
+
+func addHistogram(dst []int64, src []int64) []int64 {
+	if dst == nil {
+		dst = make([]int64, len(model.ChannelHealthLatencyBounds)+1)
+	}
+	for i := 0; i < len(src) && i < len(dst); i++ {
+		dst[i] += src[i]
+	}
+	return dst
+}
+
+// histogramPercentile 根据直方图估算百分位数（毫秒），桶内线性插值，溢出桶返回最后一个上界
+func histogramPercentile(hist []int64, p float64) int64 {
+	var total int64
+	for _, count := range hist {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	bounds := model.ChannelHealthLatencyBounds
+	target := p * float64(total)
+	var cumulative int64
+	for i, count := range hist {
+		if count == 0 {
+			continue
+		}
+		if float64(cumulative+count) >= target {
+			if i >= len(bounds) {
+				return bounds[len(bounds)-1]
+			}
+			var lower int64
+			if i > 0 {
+				lower = bounds[i-1]
+			}
+			fraction := (target - float64(cumulative)) / float64(count)
+			return lower + int64(fraction*float64(bounds[i]-lower))
+		}
+		cumulative += count
+	}
+	return bounds[len(bounds)-1]
+}
+
+// ChannelHealthQuery 健康度查询条件
+type ChannelHealthQuery struct {
+	Hours     int
+	ModelName string
+	KeyIndex  *int
+}
+
+func (q ChannelHealthQuery) timeRange() (int64, int64) {
+	hours := q.Hours
+	if hours <= 0 {
+		hours = channelHealthDefaultQueryHour
+	}
+	if hours > channelHealthMaxQueryHours {
+		hours = channelHealthMaxQueryHours
+	}
+	now := time.Now().Unix()
+	startTime := now - int64(hours)*3600
+	return startTime - startTime%3600, now
+}
+
+func (q ChannelHealthQuery) match(h *model.ChannelHealth) bool {
+	if q.ModelName != "" && h.ModelName != q.ModelName {
+		return false
+	}
+	if q.KeyIndex != nil && h.KeyIndex != *q.KeyIndex {
+		return false
+	}
+	return true
+}
+
+// GetChannelHealthReport 返回单个渠道的健康度：整体汇总、按 模型 × 密钥 的明细以及按小时的趋势
+func GetChannelHealthReport(channelId int, query ChannelHealthQuery) (map[string]any, error) {
+	startTime, endTime := query.timeRange()
+	healths, err := model.GetChannelHealthByChannelId(channelId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	overall := &ChannelHealthStats{ChannelId: channelId}
+	breakdown := make(map[string]*ChannelHealthStats)
+	series := make(map[int64]*ChannelHealthStats)
+	for _, h := range healths {
+		if !query.match(h) {
+			continue
+		}
+		overall.add(h)
+
+		key := h.ModelName + "\x00" + strconv.Itoa(h.KeyIndex)
+		item, ok := breakdown[key]
+		if !ok {
+			keyIndex := h.KeyIndex
+			item = &ChannelHealthStats{ModelName: h.ModelName, KeyIndex: &keyIndex}
+			breakdown[key] = item
+		}
+		item.add(h)
+
+		point, ok := series[h.CreatedAt]
+		if !ok {
+			point = &ChannelHealthStats{Timestamp: h.CreatedAt}
+			series[h.CreatedAt] = point
+		}
+		point.add(h)
+	}
+
+	overall.finish()
+	items := make([]*ChannelHealthStats, 0, len(breakdown))
+	for _, item := range breakdown {
+		item.finish()
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ModelName != items[j].ModelName {
+			return items[i].ModelName < items[j].ModelName
+		}
+		return *items[i].KeyIndex < *items[j].KeyIndex
+	})
+	points := make([]*ChannelHealthStats, 0, len(series))
+	for _, point := range series {
+		point.finish()
+		points = append(points, point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Timestamp < points[j].Timestamp
+	})
+	return map[string]any{
+		"start_time": startTime,
+		"end_time":   endTime,
+		"summary":    overall,
+		"breakdown":  items,
+		"series":     points,
+	}, nil
+}
+
+// GetChannelHealthFleetSummary 返回所有渠道的健康度汇总，以及按模型跨渠道的汇总，便于比较不同供应商
+func GetChannelHealthFleetSummary(query ChannelHealthQuery) (map[string]any, error) {
+	startTime, endTime := query.timeRange()
+	healths, err := model.GetAllChannelHealth(startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	byChannel := make(map[int]*ChannelHealthStats)
+	byModel := make(map[string]*ChannelHealthStats)
+	for _, h := range healths {
+		if !query.match(h) {
+			continue
+		}
+		channelStats, ok := byChannel[h.ChannelId]
+		if !ok {
+			channelStats = &ChannelHealthStats{ChannelId: h.ChannelId}
+			if channel, err := model.CacheGetChannel(h.ChannelId); err == nil && channel != nil {
+				channelStats.ChannelName = channel.Name
+			}
+			byChannel[h.ChannelId] = channelStats
+		}
+		channelStats.add(h)
+
+		modelStats, ok := byModel[h.ModelName]
+		if !ok {
+			modelStats = &ChannelHealthStats{ModelName: h.ModelName}
+			byModel[h.ModelName] = modelStats
+		}
+		modelStats.add(h)
+	}
+
+	channels := make([]*ChannelHealthStats, 0, len(byChannel))
+	for _, stats := range byChannel {
+		stats.finish()
+		channels = append(channels, stats)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].ChannelId < channels[j].ChannelId
+	})
+	models := make([]*ChannelHealthStats, 0, len(byModel))
+	for _, stats := range byModel {
+		stats.finish()
+		models = append(models, stats)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ModelName < models[j].ModelName
+	})
+	return map[string]any{
+		"start_time": startTime,
+		"end_time":   endTime,
+		"channels":   channels,
+		"models":     models,
+	}, nil
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+const ginKeyChannelSelectLogInfo = "channel_select_log_info"
+
+type channelSelectCandidate struct {
+	channel *model.Channel
+	stats   ChannelRuntimeStats
+	score   float64
+}
+
+// selectSatisfiedChannel 根据分组/模型配置的策略从当前优先级的渠道中选择一个。
+// 熔断中、被上游限流、超出静态 RPM/TPM 上限或并发已满的渠道会被跳过，当前优先级全部不可用时依次尝试更低优先级；
+// 仍无可用渠道时，在第一个配置了等待队列的渠道上排队等待。
+func selectSatisfiedChannel(c *gin.Context, group string, modelName string, retry int) (*model.Channel, error) {
+	strategy, ruleName := operation_setting.ResolveChannelSelectStrategy(group, modelName)
+	breakerEnabled := operation_setting.GetCircuitBreakerSetting().Enabled
+	if strategy == operation_setting.ChannelSelectStrategyWeighted && !breakerEnabled {
+		channel, err := model.GetRandomSatisfiedChannel(group, modelName, retry)
+		if err != nil || channel == nil {
+			return channel, err
+		}
+		if !isChannelThrottled(channel) && tryAcquireChannelConcurrency(c, channel) {
+			recordChannelRequestUsage(channel)
+			return channel, nil
+		}
+		// 渠道暂不可用，回落到逐个尝试其他渠道
+	}
+
+	var queued *model.Channel
+	var lastPriority *int64
+	for ; ; retry++ {
+		channels, err := model.GetSatisfiedChannels(group, modelName, retry)
+		if err != nil {
+			return nil, err
+		}
+		if len(channels) == 0 {
+			if lastPriority == nil {
+				return nil, nil
+			}
+			break
+		}
+		// 优先级已用尽时会返回相同优先级的渠道
+		priority := channels[0].GetPriority()
+		if lastPriority != nil && *lastPriority == priority {
+			break
+		}
+		lastPriority = &priority
+
+		channels = lo.Filter(channels, func(channel *model.Channel, _ int) bool {
+			return IsChannelBreakerAvailable(channel.Id, ChannelBreakerKeyIndexAll) && !isChannelThrottled(channel)
+		})
+		for len(channels) > 0 {
+			var selected *model.Channel
+			if strategy == operation_setting.ChannelSelectStrategyWeighted {
+				selected, err = model.PickWeightedChannel(channels)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				selected = pickByStrategy(c, strategy, ruleName, group, modelName, retry, channels)
+			}
+			channels = removeChannel(channels, selected.Id)
+			if !tryAcquireChannelConcurrency(c, selected) {
+				if queued == nil && selected.GetOtherSettings().ConcurrencyQueueSize > 0 {
+					queued = selected
+				}
+				continue
+			}
+			// 半开状态的渠道只放行有限的试探请求，名额被占用时换一个渠道
+			if !ChannelBreakerAcquire(c, selected.Id, ChannelBreakerKeyIndexAll) {
+				ReleaseChannelConcurrency(c)
+				continue
+			}
+			recordChannelRequestUsage(selected)
+			return selected, nil
+		}
+	}
+
+	if queued != nil {
+		if !waitChannelConcurrency(c, queued) {
+			return nil, fmt.Errorf("channel #%d concurrency limit reached and wait queue is full or timed out", queued.Id)
+		}
+		if ChannelBreakerAcquire(c, queued.Id, ChannelBreakerKeyIndexAll) {
+			recordChannelRequestUsage(queued)
+			return queued, nil
+		}
+		ReleaseChannelConcurrency(c)
+	}
+	return nil, fmt.Errorf("all channels of group %s model %s are circuit broken, rate limited or at concurrency limit", group, modelName)
+}
+
+// AcquirePreferredChannel 为亲和性等直接指定的渠道占用并发名额与熔断试探名额，失败时应回落到正常选择
+func AcquirePreferredChannel(c *gin.Context, channel *model.Channel) bool {
+	if isChannelThrottled(channel) || !tryAcquireChannelConcurrency(c, channel) {
+		return false
+	}
+	if !ChannelBreakerAcquire(c, channel.Id, ChannelBreakerKeyIndexAll) {
+		ReleaseChannelConcurrency(c)
+		return false
+	}
+	recordChannelRequestUsage(channel)
+	return true
+}
+
+func removeChannel(channels []*model.Channel, channelId int) []*model.Channel {
+	result := make([]*model.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Id != channelId {
+			result = append(result, channel)
+		}
+	}
+	return result
+}
+
+func pickByStrategy(c *gin.Context, strategy string, ruleName string, group string, modelName string, retry int, channels []*model.Channel) *model.Channel {
+	ids := make([]int, 0, len(channels))
+	for _, channel := range channels {
+		ids = append(ids, channel.Id)
+	}
+	statsMap := GetChannelRuntimeStats(ids)
+	candidates := make([]*channelSelectCandidate, 0, len(channels))
+	for _, channel := range channels {
+		candidates = append(candidates, &channelSelectCandidate{
+			channel: channel,
+			stats:   statsMap[channel.Id],
+		})
+	}
+
+	var selected *channelSelectCandidate
+	switch strategy {
+	case operation_setting.ChannelSelectStrategyLeastRequests:
+		for _, cand := range candidates {
+			cand.score = float64(cand.stats.InFlight)
+		}
+		selected = pickLowestScore(candidates)
+	case operation_setting.ChannelSelectStrategyLatency:
+		// 失败的请求不记录延迟，按成功率放大得分，避免持续失败的渠道因没有延迟样本而被一直选中
+		defaultLatency := defaultCandidateLatency(candidates)
+		for _, cand := range candidates {
+			cand.score = candidateLatency(cand, defaultLatency) / math.Max(cand.stats.SuccessRate, 0.01)
+		}
+		selected = pickLowestScore(candidates)
+	case operation_setting.ChannelSelectStrategySuccessRate:
+		selected = pickBySuccessRate(candidates)
+	case operation_setting.ChannelSelectStrategyP2C:
+		selected = pickPowerOfTwo(candidates)
+	default:
+		selected = candidates[rand.Intn(len(candidates))]
+	}
+
+	recordChannelSelectDecision(c, strategy, ruleName, group, modelName, retry, selected, candidates)
+	return selected.channel
+}
+
+func pickLowestScore(candidates []*channelSelectCandidate) *channelSelectCandidate {
+	best := make([]*channelSelectCandidate, 0, len(candidates))
+	for _, cand := range candidates {
+		if len(best) == 0 || cand.score < best[0].score {
+			best = append(best[:0], cand)
+		} else if cand.score == best[0].score {
+			best = append(best, cand)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+func pickBySuccessRate(candidates []*channelSelectCandidate) *channelSelectCandidate {
+	allZeroWeight := true
+	for _, cand := range candidates {
+		if cand.channel.GetWeight() > 0 {
+			allZeroWeight = false
+			break
+		}
+	}
+	total := 0.0
+	for _, cand := range candidates {
+		weight := 1.0
+		if !allZeroWeight {
+			weight = float64(cand.channel.GetWeight())
+		}
+		cand.score = math.Max(cand.stats.SuccessRate, 0.01) * weight
+		total += cand.score
+	}
+	if total <= 0 {
+		return candidates[rand.Intn(len(candidates))]
+	}
+	r := rand.Float64() * total
+	for _, cand := range candidates {
+		r -= cand.score
+		if r < 0 {
+			return cand
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// defaultCandidateLatency 返回有延迟样本的渠道的平均延迟，无延迟样本的渠道按此计算，避免被无限偏好
+func defaultCandidateLatency(candidates []*channelSelectCandidate) float64 {
+	knownLatency, knownCount := 0.0, 0
+	for _, cand := range candidates {
+		if cand.stats.LatencyEWMA > 0 {
+			knownLatency += cand.stats.LatencyEWMA
+			knownCount++
+		}
+	}
+	if knownCount == 0 {
+		return 1.0
+	}
+	return knownLatency / float64(knownCount)
+}
+
+func candidateLatency(cand *channelSelectCandidate, defaultLatency float64) float64 {
+	if cand.stats.LatencyEWMA > 0 {
+		return cand.stats.LatencyEWMA
+	}
+	return defaultLatency
+}
+
+func pickPowerOfTwo(candidates []*channelSelectCandidate) *channelSelectCandidate {
+	defaultLatency := defaultCandidateLatency(candidates)
+	for _, cand := range candidates {
+		cand.score = float64(cand.stats.InFlight+1) * candidateLatency(cand, defaultLatency) / math.Max(cand.stats.SuccessRate, 0.01)
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	if b.score < a.score {
+		return b
+	}
+	return a
+}
+
+func recordChannelSelectDecision(c *gin.Context, strategy string, ruleName string, group string, modelName string, retry int, selected *channelSelectCandidate, candidates []*channelSelectCandidate) {
+	if c == nil || selected == nil {
+		return
+	}
+	scores := make([]map[string]interface{}, 0, len(candidates))
+	for _, cand := range candidates {
+		scores = append(scores, map[string]interface{}{
+			"channel_id":   cand.channel.Id,
+			"score":        math.Round(cand.score*1000) / 1000,
+			"in_flight":    cand.stats.InFlight,
+			"latency_ms":   math.Round(cand.stats.LatencyEWMA),
+			"success_rate": math.Round(cand.stats.SuccessRate*1000) / 1000,
+		})
+	}
+	decision := map[string]interface{}{
+		"strategy":   strategy,
+		"group":      group,
+		"model":      modelName,
+		"retry":      retry,
+		"selected":   selected.channel.Id,
+		"candidates": scores,
+	}
+	if ruleName != "" {
+		decision["rule_name"] = ruleName
+	}
+	var decisions []map[string]interface{}
+	if existing, ok := c.Get(ginKeyChannelSelectLogInfo); ok {
+		if list, ok := existing.([]map[string]interface{}); ok {
+			decisions = list
+		}
+	}
+	c.Set(ginKeyChannelSelectLogInfo, append(decisions, decision))
+}
+
+func AppendChannelSelectAdminInfo(c *gin.Context, adminInfo map[string]interface{}) {
+	if c == nil || adminInfo == nil {
+		return
+	}
+	anyInfo, ok := c.Get(ginKeyChannelSelectLogInfo)
+	if !ok || anyInfo == nil {
+		return
+	}
+	adminInfo["channel_select"] = anyInfo
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func testSelectChannels(ids ...int) []*model.Channel {
+	channels := make([]*model.Channel, 0, len(ids))
+	for _, id := range ids {
+		channels = append(channels, &model.Channel{Id: id})
+	}
+	return channels
+}
+
+func TestPickByStrategyLeastRequests(t *testing.T) {
+	channels := testSelectChannels(90101, 90102, 90103)
+	ChannelStatsBegin(90101)
+	ChannelStatsBegin(90101)
+	ChannelStatsBegin(90103)
+	t.Cleanup(func() {
+		ChannelStatsEnd(90101, true, 0)
+		ChannelStatsEnd(90101, true, 0)
+		ChannelStatsEnd(90103, true, 0)
+	})
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	for i := 0; i < 20; i++ {
+		selected := pickByStrategy(c, operation_setting.ChannelSelectStrategyLeastRequests, "rule", "default", "gpt-4o", 0, channels)
+		require.Equal(t, 90102, selected.Id)
+	}
+	decisions, ok := c.Get(ginKeyChannelSelectLogInfo)
+	require.True(t, ok)
+	require.Len(t, decisions, 20)
+	require.Equal(t, "rule", decisions.([]map[string]interface{})[0]["rule_name"])
+}
+
+func TestPickByStrategyLatency(t *testing.T) {
+	channels := testSelectChannels(90201, 90202)
+	ChannelStatsBegin(90201)
+	ChannelStatsEnd(90201, true, 800*time.Millisecond)
+	ChannelStatsBegin(90202)
+	ChannelStatsEnd(90202, true, 200*time.Millisecond)
+	for i := 0; i < 20; i++ {
+		selected := pickByStrategy(nil, operation_setting.ChannelSelectStrategyLatency, "", "default", "gpt-4o", 0, channels)
+		require.Equal(t, 90202, selected.Id)
+	}
+
+	// 暂无延迟样本的渠道按已知平均延迟计算，不会压过更快的渠道
+	channels = append(channels, &model.Channel{Id: 90203})
+	for i := 0; i < 20; i++ {
+		selected := pickByStrategy(nil, operation_setting.ChannelSelectStrategyLatency, "", "default", "gpt-4o", 0, channels)
+		require.Equal(t, 90202, selected.Id)
+	}
+}
+
+func TestPickByStrategyLatencySkipsFailingChannel(t *testing.T) {
+	// 失败的请求不记录延迟，持续失败的渠道没有延迟样本，也不应被一直选中
+	channels := testSelectChannels(90211, 90212)
+	for i := 0; i < 10; i++ {
+		ChannelStatsBegin(90211)
+		ChannelStatsEnd(90211, false, 0)
+	}
+	ChannelStatsBegin(90212)
+	ChannelStatsEnd(90212, true, 900*time.Millisecond)
+	for i := 0; i < 20; i++ {
+		selected := pickByStrategy(nil, operation_setting.ChannelSelectStrategyLatency, "", "default", "gpt-4o", 0, channels)
+		require.Equal(t, 90212, selected.Id)
+	}
+}
+
+func TestPickBySuccessRate(t *testing.T) {
+	candidates := []*channelSelectCandidate{
+		{channel: &model.Channel{Id: 1}, stats: ChannelRuntimeStats{SuccessRate: 1}},
+		{channel: &model.Channel{Id: 2}, stats: ChannelRuntimeStats{SuccessRate: 0}},
+	}
+	counts := map[int]int{}
+	for i := 0; i < 2000; i++ {
+		counts[pickBySuccessRate(candidates).channel.Id]++
+	}
+	// 失败渠道保留 1% 的权重以便恢复
+	require.Greater(t, counts[1], 1800)
+	require.Greater(t, counts[2], 0)
+}
+
+func TestPickPowerOfTwo(t *testing.T) {
+	fast := &channelSelectCandidate{channel: &model.Channel{Id: 1}, stats: ChannelRuntimeStats{SuccessRate: 1, LatencyEWMA: 100}}
+	busy := &channelSelectCandidate{channel: &model.Channel{Id: 2}, stats: ChannelRuntimeStats{SuccessRate: 1, LatencyEWMA: 100, InFlight: 5}}
+	unknown := &channelSelectCandidate{channel: &model.Channel{Id: 3}, stats: ChannelRuntimeStats{SuccessRate: 1}}
+	for i := 0; i < 20; i++ {
+		require.Equal(t, 1, pickPowerOfTwo([]*channelSelectCandidate{fast, busy}).channel.Id)
+	}
+	// 无延迟样本的渠道按已知平均延迟计算，在途更少时仍会被选中
+	for i := 0; i < 20; i++ {
+		require.Equal(t, 3, pickPowerOfTwo([]*channelSelectCandidate{busy, unknown}).channel.Id)
+	}
+	require.Equal(t, 1, pickPowerOfTwo([]*channelSelectCandidate{fast}).channel.Id)
+}
+
+func TestResolveChannelSelectStrategy(t *testing.T) {
+	s := operation_setting.GetChannelSelectSetting()
+	original := *s
+	t.Cleanup(func() { *s = original })
+	s.DefaultStrategy = operation_setting.ChannelSelectStrategyLeastRequests
+	s.Rules = []operation_setting.ChannelSelectRule{
+		{Name: "invalid", Strategy: "unknown"},
+		{Name: "claude", Groups: []string{" vip "}, ModelRegex: []string{"^claude-"}, Strategy: operation_setting.ChannelSelectStrategyLatency},
+		{Name: "all-p2c", ModelRegex: []string{"^gpt-"}, Strategy: operation_setting.ChannelSelectStrategyP2C},
+	}
+
+	strategy, rule := operation_setting.ResolveChannelSelectStrategy("vip", "claude-3-opus")
+	require.Equal(t, operation_setting.ChannelSelectStrategyLatency, strategy)
+	require.Equal(t, "claude", rule)
+	strategy, rule = operation_setting.ResolveChannelSelectStrategy("default", "gpt-4o")
+	require.Equal(t, operation_setting.ChannelSelectStrategyP2C, strategy)
+	require.Equal(t, "all-p2c", rule)
+	strategy, rule = operation_setting.ResolveChannelSelectStrategy("default", "claude-3-opus")
+	require.Equal(t, operation_setting.ChannelSelectStrategyLeastRequests, strategy)
+	require.Empty(t, rule)
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/go-redis/redis/v8"
+)
+
+const channelStatsRedisPrefix = "new-api:channel_stats:v1:"
+
+// ChannelRuntimeStats 渠道运行时统计，用于延迟/负载感知的渠道选择
+type ChannelRuntimeStats struct {
+	InFlight    int64   `json:"in_flight"`       // 在途请求数
+	LatencyEWMA float64 `json:"latency_ewma_ms"` // 首字延迟 EWMA（毫秒），0 表示暂无样本
+	SuccessRate float64 `json:"success_rate"`    // 成功率 EWMA，暂无样本时为 1
+	Requests    int64   `json:"requests"`
+	Failures    int64   `json:"failures"`
+	UpdatedAt   int64   `json:"updated_at"`
+}
+
+type channelStatsEntry struct {
+	mu    sync.Mutex
+	stats ChannelRuntimeStats
+}
+
+var channelStatsStore sync.Map // map[int]*channelStatsEntry
+
+// channelStatsEndScript 原子地更新 Redis 中的渠道统计（在途数、成功率与延迟 EWMA）
+var channelStatsEndScript = redis.NewScript(`
+local key = KEYS[1]
+local alpha = tonumber(ARGV[1])
+local success = tonumber(ARGV[2])
+local latency = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local now = ARGV[5]
+local inflight = redis.call('HINCRBY', key, 'in_flight', -1)
+if inflight < 0 then
+  redis.call('HSET', key, 'in_flight', 0)
+end
+redis.call('HINCRBY', key, 'requests', 1)
+if success == 0 then
+  redis.call('HINCRBY', key, 'failures', 1)
+end
+local sr = tonumber(redis.call('HGET', key, 'success_rate') or '1')
+sr = sr + alpha * (success - sr)
+redis.call('HSET', key, 'success_rate', tostring(sr))
+if latency > 0 then
+  local l = tonumber(redis.call('HGET', key, 'latency_ewma') or '0')
+  if l <= 0 then
+    l = latency
+  else
+    l = l + alpha * (latency - l)
+  end
+  redis.call('HSET', key, 'latency_ewma', tostring(l))
+end
+redis.call('HSET', key, 'updated_at', now)
+redis.call('EXPIRE', key, ttl)
+return 1
+`)
+
+func getChannelStatsEntry(channelId int) *channelStatsEntry {
+	if entry, ok := channelStatsStore.Load(channelId); ok {
+		return entry.(*channelStatsEntry)
+	}
+	entry, _ := channelStatsStore.LoadOrStore(channelId, &channelStatsEntry{
+		stats: ChannelRuntimeStats{SuccessRate: 1},
+	})
+	return entry.(*channelStatsEntry)
+}
+
+func channelStatsRedisOn() bool {
+	return operation_setting.GetChannelSelectSetting().RedisStatsEnabled && common.RedisEnabled && common.RDB != nil
+}
+
+func channelStatsAlpha() float64 {
+	alpha := operation_setting.GetChannelSelectSetting().EWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	return alpha
+}
+
+func channelStatsTTL() time.Duration {
+	ttl := operation_setting.GetChannelSelectSetting().StatsTTLSeconds
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// ChannelStatsBegin 记录一次发往渠道的请求开始
+func ChannelStatsBegin(channelId int) {
+	if channelId <= 0 {
+		return
+	}
+	entry := getChannelStatsEntry(channelId)
+	entry.mu.Lock()
+	entry.stats.InFlight++
+	entry.mu.Unlock()
+
+	if channelStatsRedisOn() {
+		gopool.Go(func() {
+			ctx := context.Background()
+			key := channelStatsRedisPrefix + strconv.Itoa(channelId)
+			pipe := common.RDB.TxPipeline()
+			pipe.HIncrBy(ctx, key, "in_flight", 1)
+			pipe.Expire(ctx, key, channelStatsTTL())
+			if _, err := pipe.Exec(ctx); err != nil {
+				common.SysError(fmt.Sprintf("channel stats begin failed: channel_id=%d, err=%v", channelId, err))
+			}
+		})
+	}
+}
+
+// ChannelStatsEnd 记录一次发往渠道的请求结束，latency<=0 时不更新延迟
+func ChannelStatsEnd(channelId int, success bool, latency time.Duration) {
+	if channelId <= 0 {
+		return
+	}
+	alpha := channelStatsAlpha()
+	latencyMs := float64(latency.Milliseconds())
+	successValue := 0.0
+	if success {
+		successValue = 1
+	}
+
+	entry := getChannelStatsEntry(channelId)
+	entry.mu.Lock()
+	if entry.stats.InFlight > 0 {
+		entry.stats.InFlight--
+	}
+	entry.stats.Requests++
+	if !success {
+		entry.stats.Failures++
+	}
+	entry.stats.SuccessRate += alpha * (successValue - entry.stats.SuccessRate)
+	if latencyMs > 0 {
+		if entry.stats.LatencyEWMA <= 0 {
+			entry.stats.LatencyEWMA = latencyMs
+		} else {
+			entry.stats.LatencyEWMA += alpha * (latencyMs - entry.stats.LatencyEWMA)
+		}
+	}
+	entry.stats.UpdatedAt = common.GetTimestamp()
+	entry.mu.Unlock()
+
+	if channelStatsRedisOn() {
+		gopool.Go(func() {
+			key := channelStatsRedisPrefix + strconv.Itoa(channelId)
+			err := channelStatsEndScript.Run(context.Background(), common.RDB, []string{key},
+				alpha, successValue, latencyMs, int64(channelStatsTTL().Seconds()), common.GetTimestamp()).Err()
+			if err != nil && err != redis.Nil {
+				common.SysError(fmt.Sprintf("channel stats end failed: channel_id=%d, err=%v", channelId, err))
+			}
+		})
+	}
+}
+
+// GetChannelRuntimeStats 返回指定渠道的运行时统计，启用 Redis 共享时优先读取 Redis
+func GetChannelRuntimeStats(channelIds []int) map[int]ChannelRuntimeStats {
+	result := make(map[int]ChannelRuntimeStats, len(channelIds))
+	for _, id := range channelIds {
+		entry := getChannelStatsEntry(id)
+		entry.mu.Lock()
+		result[id] = entry.stats
+		entry.mu.Unlock()
+	}
+	if !channelStatsRedisOn() || len(channelIds) == 0 {
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	pipe := common.RDB.Pipeline()
+	cmds := make(map[int]*redis.StringStringMapCmd, len(channelIds))
+	for _, id := range channelIds {
+		cmds[id] = pipe.HGetAll(ctx, channelStatsRedisPrefix+strconv.Itoa(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		common.SysError(fmt.Sprintf("channel stats read failed, fallback to local stats: err=%v", err))
+		return result
+	}
+	for id, cmd := range cmds {
+		fields, err := cmd.Result()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		stats := ChannelRuntimeStats{SuccessRate: 1}
+		stats.InFlight, _ = strconv.ParseInt(fields["in_flight"], 10, 64)
+		if stats.InFlight < 0 {
+			stats.InFlight = 0
+		}
+		stats.Requests, _ = strconv.ParseInt(fields["requests"], 10, 64)
+		stats.Failures, _ = strconv.ParseInt(fields["failures"], 10, 64)
+		stats.UpdatedAt, _ = strconv.ParseInt(fields["updated_at"], 10, 64)
+		if v, err := strconv.ParseFloat(fields["latency_ewma"], 64); err == nil {
+			stats.LatencyEWMA = v
+		}
+		if v, err := strconv.ParseFloat(fields["success_rate"], 64); err == nil {
+			stats.SuccessRate = v
+		}
+		result[id] = stats
+	}
+	return result
+}
+
+// IsChannelFaultError 判断错误是否应计入渠道失败（用户请求错误不计入）
+func IsChannelFaultError(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	if types.IsChannelError(err) {
+		return true
+	}
+	code := err.StatusCode
+	if code < 100 || code > 599 {
+		return true
+	}
+	return code >= http.StatusInternalServerError ||
+		code == http.StatusTooManyRequests ||
+		code == http.StatusUnauthorized ||
+		code == http.StatusForbidden
+}
@@ -0,0 +1,435 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const channelUsageRedisPrefix = "new-api:channel_usage:v1:"
+
+// UpstreamRateLimitState 上游返回的限流状态，-1 表示上游未返回该项
+type UpstreamRateLimitState struct {
+	ChannelId         int    `json:"channel_id"`
+	KeyIndex          int    `json:"key_index"` // -1 表示渠道级
+	LimitRequests     int64  `json:"limit_requests"`
+	RemainingRequests int64  `json:"remaining_requests"`
+	RequestsResetAt   int64  `json:"requests_reset_at,omitempty"`
+	LimitTokens       int64  `json:"limit_tokens"`
+	RemainingTokens   int64  `json:"remaining_tokens"`
+	TokensResetAt     int64  `json:"tokens_reset_at,omitempty"`
+	BlockedUntil      int64  `json:"blocked_until,omitempty"`
+	BlockedReason     string `json:"blocked_reason,omitempty"`
+	UpdatedAt         int64  `json:"updated_at"`
+}
+
+type upstreamRateLimitHeaders struct {
+	limitRequests     int64
+	remainingRequests int64
+	requestsReset     time.Time
+	limitTokens       int64
+	remainingTokens   int64
+	tokensReset       time.Time
+	retryAfter        time.Duration
+}
+
+type upstreamRateLimitEntry struct {
+	mu           sync.Mutex
+	state        UpstreamRateLimitState
+	blockedUntil time.Time
+}
+
+var upstreamRateLimitStore sync.Map // map[channelBreakerKey]*upstreamRateLimitEntry
+
+// parseUpstreamRateLimitHeaders 解析 OpenAI 与 Anthropic 风格的限流响应头，found 表示至少存在一项
+func parseUpstreamRateLimitHeaders(header http.Header, now time.Time) (h upstreamRateLimitHeaders, found bool) {
+	h = upstreamRateLimitHeaders{limitRequests: -1, remainingRequests: -1, limitTokens: -1, remainingTokens: -1}
+	parseInt := func(names ...string) int64 {
+		for _, name := range names {
+			if v := header.Get(name); v != "" {
+				if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+					found = true
+					return n
+				}
+			}
+		}
+		return -1
+	}
+	parseReset := func(names ...string) time.Time {
+		for _, name := range names {
+			v := strings.TrimSpace(header.Get(name))
+			if v == "" {
+				continue
+			}
+			// OpenAI: "6m0s" / "20ms"；Anthropic: RFC 3339 时间
+			if d, err := time.ParseDuration(v); err == nil {
+				found = true
+				return now.Add(d)
+			}
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				found = true
+				return t
+			}
+			if secs, err := strconv.ParseFloat(v, 64); err == nil {
+				found = true
+				return now.Add(time.Duration(secs * float64(time.Second)))
+			}
+		}
+		return time.Time{}
+	}
+
+	h.limitRequests = parseInt("x-ratelimit-limit-requests", "anthropic-ratelimit-requests-limit")
+	h.remainingRequests = parseInt("x-ratelimit-remaining-requests", "anthropic-ratelimit-requests-remaining")
+	h.requestsReset = parseReset("x-ratelimit-reset-requests", "anthropic-ratelimit-requests-reset")
+	h.limitTokens = parseInt("x-ratelimit-limit-tokens", "anthropic-ratelimit-tokens-limit")
+	h.remainingTokens = parseInt("x-ratelimit-remaining-tokens", "anthropic-ratelimit-tokens-remaining")
+	h.tokensReset = parseReset("x-ratelimit-reset-tokens", "anthropic-ratelimit-tokens-reset")
+	if h.remainingTokens < 0 {
+		// Anthropic 可能只返回输入/输出 token 的限额，取较小的剩余值
+		input := parseInt("anthropic-ratelimit-input-tokens-remaining")
+		output := parseInt("anthropic-ratelimit-output-tokens-remaining")
+		if input >= 0 && (output < 0 || input <= output) {
+			h.remainingTokens = input
+			h.tokensReset = parseReset("anthropic-ratelimit-input-tokens-reset")
+		} else if output >= 0 {
+			h.remainingTokens = output
+			h.tokensReset = parseReset("anthropic-ratelimit-output-tokens-reset")
+		}
+	}
+
+	if v := strings.TrimSpace(header.Get("retry-after-ms")); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil {
+			found = true
+			h.retryAfter = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	if v := strings.TrimSpace(header.Get("Retry-After")); v != "" && h.retryAfter == 0 {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			found = true
+			h.retryAfter = time.Duration(secs * float64(time.Second))
+		} else if t, err := http.ParseTime(v); err == nil {
+			found = true
+			h.retryAfter = t.Sub(now)
+		}
+	}
+	return h, found
+}
+
+// RecordUpstreamRateLimit 记录上游响应中的限流信息，多密钥渠道按密钥记录
+func RecordUpstreamRateLimit(channelId int, isMultiKey bool, keyIndex int, statusCode int, header http.Header) {
+	s := operation_setting.GetUpstreamRateLimitSetting()
+	if !s.Enabled || channelId <= 0 {
+		return
+	}
+	now := time.Now()
+	h, found := parseUpstreamRateLimitHeaders(header, now)
+	if !found && statusCode != http.StatusTooManyRequests {
+		return
+	}
+	if !isMultiKey {
+		keyIndex = ChannelBreakerKeyIndexAll
+	}
+
+	maxCooldown := time.Duration(s.MaxCooldownSeconds) * time.Second
+	if maxCooldown <= 0 {
+		maxCooldown = 5 * time.Minute
+	}
+	var blockedUntil time.Time
+	var reason string
+	extend := func(until time.Time, why string) {
+		if until.IsZero() || !until.After(now) {
+			return
+		}
+		if until.Sub(now) > maxCooldown {
+			until = now.Add(maxCooldown)
+		}
+		if until.After(blockedUntil) {
+			blockedUntil = until
+			reason = why
+		}
+	}
+	if h.retryAfter > 0 && (statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable) {
+		extend(now.Add(h.retryAfter), fmt.Sprintf("status %d with Retry-After", statusCode))
+	}
+	if h.remainingRequests == 0 {
+		extend(h.requestsReset, "remaining requests exhausted")
+	}
+	if h.remainingTokens >= 0 && h.remainingTokens <= s.RemainingTokensThreshold {
+		extend(h.tokensReset, "remaining tokens exhausted")
+	}
+	if statusCode == http.StatusTooManyRequests && blockedUntil.IsZero() && s.DefaultCooldownSeconds > 0 {
+		extend(now.Add(time.Duration(s.DefaultCooldownSeconds)*time.Second), "status 429 without reset time")
+	}
+
+	key := channelBreakerKey{ChannelId: channelId, KeyIndex: keyIndex}
+	v, _ := upstreamRateLimitStore.LoadOrStore(key, &upstreamRateLimitEntry{})
+	entry := v.(*upstreamRateLimitEntry)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.state = UpstreamRateLimitState{
+		ChannelId:         channelId,
+		KeyIndex:          keyIndex,
+		LimitRequests:     h.limitRequests,
+		RemainingRequests: h.remainingRequests,
+		LimitTokens:       h.limitTokens,
+		RemainingTokens:   h.remainingTokens,
+		UpdatedAt:         now.Unix(),
+	}
+	if !h.requestsReset.IsZero() {
+		entry.state.RequestsResetAt = h.requestsReset.Unix()
+	}
+	if !h.tokensReset.IsZero() {
+		entry.state.TokensResetAt = h.tokensReset.Unix()
+	}
+	if blockedUntil.After(entry.blockedUntil) {
+		entry.blockedUntil = blockedUntil
+		entry.state.BlockedReason = reason
+		common.SysLog(fmt.Sprintf("channel #%d (key %d) upstream rate limited until %s: %s",
+			channelId, keyIndex, blockedUntil.Format(time.RFC3339), reason))
+	} else if entry.blockedUntil.After(now) {
+		entry.state.BlockedReason = "previous limit not yet reset"
+	}
+	if entry.blockedUntil.After(now) {
+		entry.state.BlockedUntil = entry.blockedUntil.Unix()
+	}
+}
+
+// IsUpstreamRateLimited 判断渠道（或密钥）是否处于上游限流冷却中
+func IsUpstreamRateLimited(channelId int, keyIndex int) bool {
+	if !operation_setting.GetUpstreamRateLimitSetting().Enabled {
+		return false
+	}
+	v, ok := upstreamRateLimitStore.Load(channelBreakerKey{ChannelId: channelId, KeyIndex: keyIndex})
+	if !ok {
+		return false
+	}
+	entry := v.(*upstreamRateLimitEntry)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return time.Now().Before(entry.blockedUntil)
+}
+
+// GetUpstreamRateLimitStates 返回渠道（含各密钥）最近一次记录的上游限流状态
+func GetUpstreamRateLimitStates(channelId int) []UpstreamRateLimitState {
+	now := time.Now()
+	states := make([]UpstreamRateLimitState, 0)
+	upstreamRateLimitStore.Range(func(k, v interface{}) bool {
+		if k.(channelBreakerKey).ChannelId != channelId {
+			return true
+		}
+		entry := v.(*upstreamRateLimitEntry)
+		entry.mu.Lock()
+		state := entry.state
+		if !now.Before(entry.blockedUntil) {
+			state.BlockedUntil = 0
+			state.BlockedReason = ""
+		}
+		entry.mu.Unlock()
+		states = append(states, state)
+		return true
+	})
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].KeyIndex < states[j].KeyIndex
+	})
+	return states
+}
+
+// GetKeyRemainingQuotaRatio 根据最近一次上游限流响应头估算密钥剩余额度比例（0-1），未知时 ok 为 false
+func GetKeyRemainingQuotaRatio(channelId int, keyIndex int) (ratio float64, ok bool) {
+	v, found := upstreamRateLimitStore.Load(channelBreakerKey{ChannelId: channelId, KeyIndex: keyIndex})
+	if !found {
+		return 0, false
+	}
+	entry := v.(*upstreamRateLimitEntry)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	now := time.Now()
+	if now.Before(entry.blockedUntil) {
+		return 0, true
+	}
+	ratio = 1
+	state := entry.state
+	if state.LimitRequests > 0 && state.RemainingRequests >= 0 && now.Unix() < state.RequestsResetAt {
+		ratio = math.Min(ratio, float64(state.RemainingRequests)/float64(state.LimitRequests))
+		ok = true
+	}
+	if state.LimitTokens > 0 && state.RemainingTokens >= 0 && now.Unix() < state.TokensResetAt {
+		ratio = math.Min(ratio, float64(state.RemainingTokens)/float64(state.LimitTokens))
+		ok = true
+	}
+	return ratio, ok
+}
+
+// IsChannelKeySelectable 判断多密钥渠道的某个密钥当前是否可被选择（未熔断且未被上游限流）
+func IsChannelKeySelectable(channelId int, keyIndex int) bool {
+	return IsChannelBreakerAvailable(channelId, keyIndex) && !IsUpstreamRateLimited(channelId, keyIndex)
+}
+
+// isChannelThrottled 判断渠道是否因上游限流或静态 RPM/TPM 上限而应暂时避开
+func isChannelThrottled(channel *model.Channel) bool {
+	if IsUpstreamRateLimited(channel.Id, ChannelBreakerKeyIndexAll) {
+		return true
+	}
+	if channel.ChannelInfo.IsMultiKey && allChannelKeysRateLimited(channel) {
+		return true
+	}
+	return !withinChannelStaticLimits(channel)
+}
+
+func allChannelKeysRateLimited(channel *model.Channel) bool {
+	if !operation_setting.GetUpstreamRateLimitSetting().Enabled || channel.ChannelInfo.MultiKeySize <= 0 {
+		return false
+	}
+	for i := 0; i < channel.ChannelInfo.MultiKeySize; i++ {
+		if status, ok := channel.ChannelInfo.MultiKeyStatusList[i]; ok && status != common.ChannelStatusEnabled {
+			continue
+		}
+		if !IsUpstreamRateLimited(channel.Id, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// channelUsageWindow 静态 RPM/TPM 上限按自然分钟计数
+type channelUsageWindow struct {
+	mu       sync.Mutex
+	minute   int64
+	requests int64
+	tokens   int64
+}
+
+var channelUsageWindows sync.Map // map[int]*channelUsageWindow
+
+func getChannelUsageWindow(channelId int, minute int64) *channelUsageWindow {
+	v, _ := channelUsageWindows.LoadOrStore(channelId, &channelUsageWindow{minute: minute})
+	w := v.(*channelUsageWindow)
+	w.mu.Lock()
+	if w.minute != minute {
+		w.minute = minute
+		w.requests = 0
+		w.tokens = 0
+	}
+	w.mu.Unlock()
+	return w
+}
+
+func channelUsageRedisKey(channelId int, minute int64) string {
+	return channelUsageRedisPrefix + strconv.Itoa(channelId) + ":" + strconv.FormatInt(minute, 10)
+}
+
+// getChannelMinuteUsage 返回渠道当前分钟的请求数与 token 数，启用 Redis 时跨实例统计
+func getChannelMinuteUsage(channelId int) (requests int64, tokens int64) {
+	minute := time.Now().Unix() / 60
+	if common.RedisEnabled && common.RDB != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		values, err := common.RDB.HMGet(ctx, channelUsageRedisKey(channelId, minute), "requests", "tokens").Result()
+		if err == nil {
+			if len(values) == 2 {
+				if v, ok := values[0].(string); ok {
+					requests, _ = strconv.ParseInt(v, 10, 64)
+				}
+				if v, ok := values[1].(string); ok {
+					tokens, _ = strconv.ParseInt(v, 10, 64)
+				}
+			}
+			return requests, tokens
+		}
+		if err != redis.Nil {
+			common.SysError(fmt.Sprintf("channel usage read failed, fallback to local counts: channel_id=%d, err=%v", channelId, err))
+		}
+	}
+	w := getChannelUsageWindow(channelId, minute)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.requests, w.tokens
+}
+
+func addChannelMinuteUsage(channelId int, requests int64, tokens int64) {
+	minute := time.Now().Unix() / 60
+	w := getChannelUsageWindow(channelId, minute)
+	w.mu.Lock()
+	w.requests += requests
+	w.tokens += tokens
+	w.mu.Unlock()
+
+	if common.RedisEnabled && common.RDB != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		key := channelUsageRedisKey(channelId, minute)
+		pipe := common.RDB.TxPipeline()
+		if requests != 0 {
+			pipe.HIncrBy(ctx, key, "requests", requests)
+		}
+		if tokens != 0 {
+			pipe.HIncrBy(ctx, key, "tokens", tokens)
+		}
+		pipe.Expire(ctx, key, 2*time.Minute)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError(fmt.Sprintf("channel usage update failed: channel_id=%d, err=%v", channelId, err))
+		}
+	}
+}
+
+func withinChannelStaticLimits(channel *model.Channel) bool {
+	settings := channel.GetOtherSettings()
+	if settings.RPMLimit <= 0 && settings.TPMLimit <= 0 {
+		return true
+	}
+	requests, tokens := getChannelMinuteUsage(channel.Id)
+	if settings.RPMLimit > 0 && requests >= int64(settings.RPMLimit) {
+		return false
+	}
+	if settings.TPMLimit > 0 && tokens >= int64(settings.TPMLimit) {
+		return false
+	}
+	return true
+}
+
+// recordChannelRequestUsage 渠道被选中后计入静态 RPM 上限
+func recordChannelRequestUsage(channel *model.Channel) {
+	settings := channel.GetOtherSettings()
+	if settings.RPMLimit <= 0 && settings.TPMLimit <= 0 {
+		return
+	}
+	addChannelMinuteUsage(channel.Id, 1, 0)
+}
+
+// RecordChannelTokenUsage 请求结束后计入静态 TPM 上限
+func RecordChannelTokenUsage(ctx *gin.Context, channelId int, promptTokens int, completionTokens int) {
+	tokens := promptTokens + completionTokens
+	if channelId <= 0 || tokens <= 0 {
+		return
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil || channel == nil || channel.GetOtherSettings().TPMLimit <= 0 {
+		return
+	}
+	gopool.Go(func() {
+		addChannelMinuteUsage(channelId, 0, int64(tokens))
+	})
+}
+
+// GetChannelStaticLimitUsage 返回渠道当前分钟的请求数与 token 数
+func GetChannelStaticLimitUsage(channelId int) map[string]int64 {
+	requests, tokens := getChannelMinuteUsage(channelId)
+	return map[string]int64{
+		"requests": requests,
+		"tokens":   tokens,
+	}
+}
@@ -70,6 +70,7 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	}
 
 	AppendChannelAffinityAdminInfo(ctx, adminInfo)
+	AppendChannelSelectAdminInfo(ctx, adminInfo)
 
 	other["admin_info"] = adminInfo
 	appendRequestPath(ctx, relayInfo, other)
@@ -85,6 +86,16 @@ func appendBillingInfo(relayInfo *relaycommon.RelayInfo, other map[string]interf
 	if relayInfo.BillingSource != "" {
 		other["billing_source"] = relayInfo.BillingSource
 	}
+	if relayInfo.BillingSource == BillingSourceSubscription {
+		other["subscription_id"] = relayInfo.SubscriptionId
+		other["subscription_plan_id"] = relayInfo.SubscriptionPlanId
+		other["subscription_plan_title"] = relayInfo.SubscriptionPlanTitle
+		other["subscription_consumed"] = relayInfo.SubscriptionPreConsumed + relayInfo.SubscriptionPostDelta
+		other["subscription_remaining"] = relayInfo.SubscriptionAmountTotal - relayInfo.SubscriptionAmountUsedAfterPreConsume - relayInfo.SubscriptionPostDelta
+		if relayInfo.SubscriptionWalletOverflow > 0 {
+			other["subscription_wallet_overflow"] = relayInfo.SubscriptionWalletOverflow
+		}
+	}
 }
 
 func appendRequestConversionChain(relayInfo *relaycommon.RelayInfo, other map[string]interface{}) {
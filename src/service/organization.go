@@ -0,0 +1,57 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"gorm.io/gorm"
+)
+
+// 组织令牌从组织额度池扣费，其余令牌从用户额度扣费
+
+// fundingName 返回扣费来源的名称，用于错误提示
+func fundingName(relayInfo *relaycommon.RelayInfo) string {
+	if relayInfo.OrganizationId != 0 {
+		return "组织"
+	}
+	return "用户"
+}
+
+// getFundingQuota 返回本次请求可用的额度，组织令牌受成员额度上限约束
+func getFundingQuota(relayInfo *relaycommon.RelayInfo) (int, error) {
+	if relayInfo.OrganizationId == 0 {
+		return model.GetUserQuota(relayInfo.UserId, false)
+	}
+	org, err := model.GetOrganizationById(relayInfo.OrganizationId)
+	if err != nil {
+		return 0, err
+	}
+	member, err := model.GetOrganizationMember(relayInfo.OrganizationId, relayInfo.UserId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, errors.New("当前用户已不是该令牌所属组织的成员")
+		}
+		return 0, err
+	}
+	quota := org.Quota
+	if remaining := member.RemainingCap(); remaining >= 0 && remaining < quota {
+		quota = remaining
+	}
+	return quota, nil
+}
+
+func decreaseFundingQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.OrganizationId == 0 {
+		return model.DecreaseUserQuota(relayInfo.UserId, quota)
+	}
+	return model.DecreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, quota)
+}
+
+func increaseFundingQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.OrganizationId == 0 {
+		return model.IncreaseUserQuota(relayInfo.UserId, quota, false)
+	}
+	return model.IncreaseOrganizationQuota(relayInfo.OrganizationId, relayInfo.UserId, quota)
+}
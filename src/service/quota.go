@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"strings"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -28,12 +27,12 @@ func calcQuotaFromTokens(tokens int, pricePer1M float64) int {
 }
 
 func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.RealtimeUsage) error {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := getFundingQuota(relayInfo)
 	if err != nil {
 		return err
 	}
 
-	token, err := model.GetTokenByKey(strings.TrimPrefix(relayInfo.TokenKey, "sk-"), false)
+	token, err := model.GetTokenByKeyHash(relayInfo.TokenKeyHash, false)
 	if err != nil {
 		return err
 	}
@@ -53,6 +52,9 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	}
 
 	if userQuota < quota {
+		if relayInfo.OrganizationId != 0 {
+			return fmt.Errorf("organization quota is not enough, organization quota: %s, need quota: %s", logger.FormatQuota(userQuota), logger.FormatQuota(quota))
+		}
 		return fmt.Errorf("user quota is not enough, user quota: %s, need quota: %s", logger.FormatQuota(userQuota), logger.FormatQuota(quota))
 	}
 
@@ -106,6 +108,8 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 		logContent += ", " + extraContent
 	}
 	other := GenerateWssOtherInfo(ctx, relayInfo, usage, 1, 1, 1, 1, 1, modelPrice, relayInfo.PriceData.GroupRatioInfo.GroupSpecialRatio)
+	RecordRelayTokenUsage(ctx, usage.InputTokens, usage.OutputTokens)
+	RecordChannelTokenUsage(ctx, relayInfo.ChannelId, usage.InputTokens, usage.OutputTokens)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     usage.InputTokens,
@@ -180,6 +184,8 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		0, 1,
 		0, 1,
 		modelPrice, relayInfo.PriceData.GroupRatioInfo.GroupSpecialRatio)
+	RecordRelayTokenUsage(ctx, promptTokens, completionTokens)
+	RecordChannelTokenUsage(ctx, relayInfo.ChannelId, promptTokens, completionTokens)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     promptTokens,
@@ -259,6 +265,8 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	}
 	other := GenerateAudioOtherInfo(ctx, relayInfo, usage, 1, 1,
 		1, 1, 1, modelPrice, relayInfo.PriceData.GroupRatioInfo.GroupSpecialRatio)
+	RecordRelayTokenUsage(ctx, usage.PromptTokens, usage.CompletionTokens)
+	RecordChannelTokenUsage(ctx, relayInfo.ChannelId, usage.PromptTokens, usage.CompletionTokens)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, model.RecordConsumeLogParams{
 		ChannelId:        relayInfo.ChannelId,
 		PromptTokens:     usage.PromptTokens,
@@ -285,27 +293,38 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	//if relayInfo.TokenUnlimited {
 	//	return nil
 	//}
-	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	token, err := model.GetTokenByKeyHash(relayInfo.TokenKeyHash, false)
 	if err != nil {
 		return err
 	}
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKeyHash, quota)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// RecordRelayTokenUsage 在用量结算时记录本次请求的 token 数，供渠道健康度统计与令牌/用户 TPM 限流使用
+func RecordRelayTokenUsage(ctx *gin.Context, promptTokens int, completionTokens int) {
+	if ctx == nil {
+		return
+	}
+	common.SetContextKey(ctx, constant.ContextKeyRelayCompletionTokens, completionTokens)
+	common.SetContextKey(ctx, constant.ContextKeyRelayTotalTokens, promptTokens+completionTokens)
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
-	// 1) Consume from wallet quota
-	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+	// 1) Consume from subscription or wallet quota (organization pool for organization tokens)
+	if relayInfo.BillingSource == BillingSourceSubscription {
+		err = postConsumeSubscription(relayInfo, quota)
+	} else if quota > 0 {
+		err = decreaseFundingQuota(relayInfo, quota)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		err = increaseFundingQuota(relayInfo, -quota)
 	}
 	if err != nil {
 		return err
@@ -313,16 +332,17 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 
 	if !relayInfo.IsPlayground {
 		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKeyHash, quota)
 		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKeyHash, -quota)
 		}
 		if err != nil {
 			return err
 		}
 	}
 
-	if sendEmail {
+	// 组织额度池与订阅不属于钱包余额，不发送钱包额度预警
+	if sendEmail && relayInfo.OrganizationId == 0 && relayInfo.BillingSource != BillingSourceSubscription {
 		if (quota + preConsumedQuota) != 0 {
 			checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
 		}
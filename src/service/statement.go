@@ -0,0 +1,302 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const (
+	StatementSubjectUser         = "user"
+	StatementSubjectOrganization = "organization"
+
+	StatementMonthLayout = "2006-01"
+)
+
+// StatementLine 账单明细行，在汇总结果上附加折算后的金额
+type StatementLine struct {
+	*model.StatementItem
+	Amount float64 `json:"amount"`
+}
+
+// Statement 用户或组织的月度账单
+type Statement struct {
+	Subject               string           `json:"subject"`
+	SubjectId             int              `json:"subject_id"`
+	SubjectName           string           `json:"subject_name"`
+	Month                 string           `json:"month"`
+	StartTime             int64            `json:"start_time"`
+	EndTime               int64            `json:"end_time"` // 不含
+	Currency              string           `json:"currency"`
+	ExchangeRate          float64          `json:"exchange_rate"` // 1 USD 折合的结算货币
+	Lines                 []*StatementLine `json:"lines"`
+	TotalRequests         int64            `json:"total_requests"`
+	TotalQuota            int64            `json:"total_quota"`
+	TotalPromptTokens     int64            `json:"total_prompt_tokens"`
+	TotalCompletionTokens int64            `json:"total_completion_tokens"`
+	TotalAmount           float64          `json:"total_amount"`
+	GeneratedTime         int64            `json:"generated_time"`
+}
+
+// ParseStatementMonth 解析 YYYY-MM，返回该月在服务器时区下的起止时间，空字符串表示当月
+func ParseStatementMonth(month string) (time.Time, time.Time, error) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	if month != "" {
+		parsed, err := time.ParseInLocation(StatementMonthLayout, month, now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("月份格式应为 YYYY-MM")
+		}
+		start = parsed
+	}
+	if start.After(now) {
+		return time.Time{}, time.Time{}, errors.New("不能生成未来月份的账单")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// statementCurrency 返回账单使用的结算货币与汇率；按点数展示额度时按美元结算
+func statementCurrency() (string, float64) {
+	switch operation_setting.GetQuotaDisplayType() {
+	case operation_setting.QuotaDisplayTypeCNY:
+		return "CNY", operation_setting.GetUsdToCurrencyRate(operation_setting.USDExchangeRate)
+	case operation_setting.QuotaDisplayTypeCustom:
+		return operation_setting.GetCurrencySymbol(), operation_setting.GetUsdToCurrencyRate(operation_setting.USDExchangeRate)
+	default:
+		return "USD", 1
+	}
+}
+
+func newStatement(subject string, subjectId int, subjectName string, start time.Time, end time.Time, items []*model.StatementItem) *Statement {
+	currency, rate := statementCurrency()
+	statement := &Statement{
+		Subject:       subject,
+		SubjectId:     subjectId,
+		SubjectName:   subjectName,
+		Month:         start.Format(StatementMonthLayout),
+		StartTime:     start.Unix(),
+		EndTime:       end.Unix(),
+		Currency:      currency,
+		ExchangeRate:  rate,
+		Lines:         make([]*StatementLine, 0, len(items)),
+		GeneratedTime: common.GetTimestamp(),
+	}
+	for _, item := range items {
+		line := &StatementLine{
+			StatementItem: item,
+			Amount:        float64(item.Quota) / common.QuotaPerUnit * rate,
+		}
+		statement.Lines = append(statement.Lines, line)
+		statement.TotalRequests += item.Requests
+		statement.TotalQuota += item.Quota
+		statement.TotalPromptTokens += item.PromptTokens
+		statement.TotalCompletionTokens += item.CompletionTokens
+	}
+	// 总额按总额度折算，避免逐行累加的舍入误差
+	statement.TotalAmount = float64(statement.TotalQuota) / common.QuotaPerUnit * rate
+	return statement
+}
+
+// BuildUserStatement 生成用户的月度账单，包含其全部令牌（含组织令牌）的消费
+func BuildUserStatement(userId int, month string) (*Statement, error) {
+	start, end, err := ParseStatementMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	username, err := model.GetUsernameById(userId, false)
+	if err != nil {
+		return nil, err
+	}
+	items, err := model.GetStatementItems(userId, 0, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return newStatement(StatementSubjectUser, userId, username, start, end, items), nil
+}
+
+// BuildOrganizationStatement 生成组织的月度账单，只包含组织令牌的消费
+func BuildOrganizationStatement(orgId int, month string) (*Statement, error) {
+	start, end, err := ParseStatementMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		return nil, err
+	}
+	items, err := model.GetStatementItems(0, orgId, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return newStatement(StatementSubjectOrganization, orgId, org.Name, start, end, items), nil
+}
+
+// Filename 返回账单下载文件名
+func (s *Statement) Filename(ext string) string {
+	return fmt.Sprintf("statement-%s-%d-%s.%s", s.Subject, s.SubjectId, s.Month, ext)
+}
+
+func formatStatementAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 4, 64)
+}
+
+// WriteCSV 以 CSV 输出账单明细，最后一行为合计
+func (s *Statement) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"month", "model_name", "token_name", "requests", "prompt_tokens", "completion_tokens", "quota", "amount", "currency"})
+	for _, line := range s.Lines {
+		_ = writer.Write([]string{
+			s.Month,
+			common.CSVSafeCell(line.ModelName),
+			common.CSVSafeCell(line.TokenName),
+			strconv.FormatInt(line.Requests, 10),
+			strconv.FormatInt(line.PromptTokens, 10),
+			strconv.FormatInt(line.CompletionTokens, 10),
+			strconv.FormatInt(line.Quota, 10),
+			formatStatementAmount(line.Amount),
+			s.Currency,
+		})
+	}
+	_ = writer.Write([]string{
+		s.Month,
+		"TOTAL",
+		"",
+		strconv.FormatInt(s.TotalRequests, 10),
+		strconv.FormatInt(s.TotalPromptTokens, 10),
+		strconv.FormatInt(s.TotalCompletionTokens, 10),
+		strconv.FormatInt(s.TotalQuota, 10),
+		formatStatementAmount(s.TotalAmount),
+		s.Currency,
+	})
+	writer.Flush()
+	return writer.Error()
+}
+
+const (
+	statementPdfPageWidth   = 595 // A4
+	statementPdfPageHeight  = 842
+	statementPdfMargin      = 40
+	statementPdfFontSize    = 8
+	statementPdfLineHeight  = 11
+	statementPdfLinesByPage = (statementPdfPageHeight - 2*statementPdfMargin) / statementPdfLineHeight
+)
+
+// statementPdfText 将文本转为 PDF 内置等宽字体可显示的字符串，非 ASCII 字符以 ? 代替
+func statementPdfText(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if n >= width {
+			break
+		}
+		if r < 0x20 || r > 0x7e {
+			r = '?'
+		}
+		switch r {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+func statementPdfRow(name string, token string, requests string, prompt string, completion string, amount string) string {
+	return fmt.Sprintf("%-30s %-20s %9s %13s %13s %16s",
+		statementPdfText(name, 30), statementPdfText(token, 20), requests, prompt, completion, statementPdfText(amount, 16))
+}
+
+// PDF 内置字体无法显示中文，账单正文使用英文
+func (s *Statement) pdfLines() []string {
+	separator := strings.Repeat("-", 106)
+	lines := []string{
+		"MONTHLY USAGE STATEMENT",
+		"",
+		statementPdfText(fmt.Sprintf("%s: %s (#%d)", strings.ToUpper(s.Subject[:1])+s.Subject[1:], s.SubjectName, s.SubjectId), 106),
+		fmt.Sprintf("Period: %s to %s", time.Unix(s.StartTime, 0).Format("2006-01-02"), time.Unix(s.EndTime-1, 0).Format("2006-01-02")),
+		statementPdfText(fmt.Sprintf("Currency: %s (1 USD = %s)", s.Currency, strconv.FormatFloat(s.ExchangeRate, 'f', -1, 64)), 106),
+		fmt.Sprintf("Generated: %s", time.Unix(s.GeneratedTime, 0).Format("2006-01-02 15:04:05 MST")),
+		"",
+		statementPdfRow("Model", "Token", "Requests", "Prompt", "Completion", "Amount"),
+		separator,
+	}
+	for _, line := range s.Lines {
+		lines = append(lines, statementPdfRow(line.ModelName, line.TokenName,
+			strconv.FormatInt(line.Requests, 10),
+			strconv.FormatInt(line.PromptTokens, 10),
+			strconv.FormatInt(line.CompletionTokens, 10),
+			formatStatementAmount(line.Amount)))
+	}
+	if len(s.Lines) == 0 {
+		lines = append(lines, "No usage in this period.")
+	}
+	lines = append(lines, separator, statementPdfRow("Total", "",
+		strconv.FormatInt(s.TotalRequests, 10),
+		strconv.FormatInt(s.TotalPromptTokens, 10),
+		strconv.FormatInt(s.TotalCompletionTokens, 10),
+		formatStatementAmount(s.TotalAmount)))
+	return lines
+}
+
+// PDF 按 A4 纸张分页输出账单，使用内置 Courier 字体，不依赖外部字体文件
+func (s *Statement) PDF() []byte {
+	lines := s.pdfLines()
+	var pages [][]string
+	for len(lines) > 0 {
+		n := min(len(lines), statementPdfLinesByPage)
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+
+	// 对象编号：1 目录，2 页面树，3 字体，之后每页依次为页面对象与内容流
+	var objects []string
+	kids := make([]string, 0, len(pages))
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	)
+	for i, page := range pages {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", statementPdfFontSize, statementPdfLineHeight,
+			statementPdfMargin, statementPdfPageHeight-statementPdfMargin)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", line)
+		}
+		fmt.Fprintf(&content, "ET\nBT\n/F1 %d Tf\n%d %d Td\n(Page %d of %d) Tj\nET\n", statementPdfFontSize,
+			statementPdfPageWidth-statementPdfMargin-80, statementPdfMargin/2, i+1, len(pages))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				statementPdfPageWidth, statementPdfPageHeight, 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
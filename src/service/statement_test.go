@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+func testStatement(lines int) *Statement {
+	items := make([]*model.StatementItem, 0, lines)
+	for i := 0; i < lines; i++ {
+		items = append(items, &model.StatementItem{
+			ModelName:        "gpt-4o-mini",
+			TokenName:        "默认令牌 (" + strconv.Itoa(i) + ")",
+			Requests:         2,
+			Quota:            int64(common.QuotaPerUnit),
+			PromptTokens:     100,
+			CompletionTokens: 50,
+		})
+	}
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.Local)
+	return newStatement(StatementSubjectUser, 1, "alice", start, start.AddDate(0, 1, 0), items)
+}
+
+func TestStatementCSV(t *testing.T) {
+	statement := testStatement(3)
+	var buf bytes.Buffer
+	if err := statement.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(records) != 5 {
+		t.Fatalf("expected header, 3 lines and total, got %d records", len(records))
+	}
+	total := records[4]
+	if total[1] != "TOTAL" || total[3] != "6" || total[7] != "3.0000" || total[8] != "USD" {
+		t.Fatalf("unexpected total row: %v", total)
+	}
+}
+
+func TestStatementCSVEscapesFormulas(t *testing.T) {
+	statement := testStatement(4)
+	names := []string{"=HYPERLINK(\"http://x\")", "+1", "@SUM(A1)", "-2"}
+	for i, name := range names {
+		statement.Lines[i].TokenName = name
+	}
+	var buf bytes.Buffer
+	if err := statement.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	for i, name := range names {
+		if got := records[i+1][2]; got != "'"+name {
+			t.Fatalf("token name %q exported as %q", name, got)
+		}
+	}
+	if got := records[1][1]; got != "gpt-4o-mini" {
+		t.Fatalf("safe cell should be unchanged, got %q", got)
+	}
+}
+
+func TestStatementPDF(t *testing.T) {
+	// 行数超过一页时应分页
+	statement := testStatement(statementPdfLinesByPage + 5)
+	pdf := statement.PDF()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("missing pdf header or trailer")
+	}
+	if !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Fatal("expected two pages")
+	}
+	if !bytes.Contains(pdf, []byte(`(gpt-4o-mini                    ???? \(0\)`)) {
+		t.Fatal("expected escaped token name with non-ASCII replaced")
+	}
+	// 交叉引用表中的偏移量必须指向对应对象
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if startxref == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(startxref[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatal("startxref does not point to xref table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if !bytes.HasPrefix(pdf[offset:], []byte(strconv.Itoa(i+1)+" 0 obj\n")) {
+			t.Fatalf("xref entry %d points to wrong offset", i+1)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// Stripe 签名时间戳允许的最大偏差
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeCheckoutSession Stripe Checkout 会话中用到的字段
+type StripeCheckoutSession struct {
+	Id                string            `json:"id"`
+	Url               string            `json:"url"`
+	Status            string            `json:"status"`         // open, complete, expired
+	PaymentStatus     string            `json:"payment_status"` // paid, unpaid, no_payment_required
+	ClientReferenceId string            `json:"client_reference_id"`
+	AmountTotal       int64             `json:"amount_total"`
+	Currency          string            `json:"currency"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// TradeNo 返回下单时写入的订单号
+func (session *StripeCheckoutSession) TradeNo() string {
+	if session.ClientReferenceId != "" {
+		return session.ClientReferenceId
+	}
+	return session.Metadata["trade_no"]
+}
+
+// Stripe 金额以货币最小单位计，多数货币为 1/100，以下货币例外
+// https://docs.stripe.com/currencies#special-cases
+var (
+	stripeZeroDecimalCurrencies = map[string]bool{
+		"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+		"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+	}
+	stripeThreeDecimalCurrencies = map[string]bool{
+		"bhd": true, "jod": true, "kwd": true, "omr": true, "tnd": true,
+	}
+)
+
+// stripeMinorUnitDivisor 返回货币主单位包含的最小单位数
+func stripeMinorUnitDivisor(currency string) float64 {
+	currency = strings.ToLower(currency)
+	switch {
+	case stripeZeroDecimalCurrencies[currency]:
+		return 1
+	case stripeThreeDecimalCurrencies[currency]:
+		return 1000
+	default:
+		return 100
+	}
+}
+
+// PaidMoney 返回实付金额（以货币主单位计）
+func (session *StripeCheckoutSession) PaidMoney() float64 {
+	return float64(session.AmountTotal) / stripeMinorUnitDivisor(session.Currency)
+}
+
+// StripeEvent Stripe webhook 事件
+type StripeEvent struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object StripeCheckoutSession `json:"object"`
+	} `json:"data"`
+}
+
+type stripeErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// StripeCheckoutParams 创建 Checkout 会话的参数
+type StripeCheckoutParams struct {
+	TradeNo       string
+	Quantity      int64
+	CustomerEmail string
+	SuccessUrl    string
+	CancelUrl     string
+}
+
+func doStripeRequest(method string, path string, form url.Values) (*StripeCheckoutSession, error) {
+	if setting.StripeApiSecret == "" {
+		return nil, errors.New("未配置 Stripe API 密钥")
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(setting.StripeApiBase, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+setting.StripeApiSecret)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp stripeErrorResponse
+		if err := common.Unmarshal(data, &errResp); err == nil && errResp.Error.Message != "" {
+			return nil, fmt.Errorf("stripe error: %s", errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("stripe error: status code %d", resp.StatusCode)
+	}
+	var session StripeCheckoutSession
+	if err := common.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreateStripeCheckoutSession 创建 Stripe Checkout 支付会话
+func CreateStripeCheckoutSession(params StripeCheckoutParams) (*StripeCheckoutSession, error) {
+	if setting.StripePriceId == "" {
+		return nil, errors.New("未配置 Stripe 价格 ID")
+	}
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("line_items[0][price]", setting.StripePriceId)
+	form.Set("line_items[0][quantity]", strconv.FormatInt(params.Quantity, 10))
+	form.Set("client_reference_id", params.TradeNo)
+	form.Set("metadata[trade_no]", params.TradeNo)
+	form.Set("success_url", params.SuccessUrl)
+	form.Set("cancel_url", params.CancelUrl)
+	if params.CustomerEmail != "" {
+		form.Set("customer_email", params.CustomerEmail)
+	}
+	if setting.StripePromotionCodesEnabled {
+		form.Set("allow_promotion_codes", "true")
+	}
+	return doStripeRequest(http.MethodPost, "/v1/checkout/sessions", form)
+}
+
+// GetStripeCheckoutSession 查询 Stripe Checkout 会话，用于对账
+func GetStripeCheckoutSession(sessionId string) (*StripeCheckoutSession, error) {
+	return doStripeRequest(http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(sessionId), nil)
+}
+
+// VerifyStripeSignature 校验 Stripe-Signature 请求头
+func VerifyStripeSignature(payload []byte, header string, secret string) error {
+	if secret == "" {
+		return errors.New("未配置 Stripe webhook 密钥")
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("invalid stripe signature header")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid stripe signature timestamp")
+	}
+	if diff := time.Since(time.Unix(ts, 0)); diff > stripeSignatureTolerance || diff < -stripeSignatureTolerance {
+		return errors.New("stripe signature timestamp is outside the tolerance")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		actual, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(actual, expected) {
+			return nil
+		}
+	}
+	return errors.New("stripe signature mismatch")
+}
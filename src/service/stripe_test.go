@@ -0,0 +1,131 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func signStripePayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	payload := []byte(`{"id":"evt_1","type":"checkout.session.completed"}`)
+	now := time.Now().Unix()
+	tests := []struct {
+		name    string
+		header  string
+		secret  string
+		wantErr bool
+	}{
+		{"valid", signStripePayload("whsec_test", now, payload), "whsec_test", false},
+		{"valid with extra signatures", "v0=abc," + signStripePayload("whsec_test", now, payload) + ",v1=00", "whsec_test", false},
+		{"wrong secret", signStripePayload("whsec_other", now, payload), "whsec_test", true},
+		{"expired timestamp", signStripePayload("whsec_test", now-3600, payload), "whsec_test", true},
+		{"missing signature", "t=" + strconv.FormatInt(now, 10), "whsec_test", true},
+		{"empty secret", signStripePayload("", now, payload), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyStripeSignature(payload, tt.header, tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyStripeSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStripeCheckoutSession(t *testing.T) {
+	// Stripe Checkout 接口的本地替身
+	stripe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"Invalid API Key provided"}}`))
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/checkout/sessions":
+			if err := r.ParseForm(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.PostForm.Get("line_items[0][price]") != "price_1" || r.PostForm.Get("line_items[0][quantity]") != "5" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"message":"bad line items"}}`))
+				return
+			}
+			w.Write([]byte(`{"id":"cs_test_1","url":"https://checkout.test/cs_test_1","status":"open","payment_status":"unpaid","client_reference_id":"` +
+				r.PostForm.Get("client_reference_id") + `"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/checkout/sessions/cs_test_1":
+			w.Write([]byte(`{"id":"cs_test_1","status":"complete","payment_status":"paid","amount_total":4000,"currency":"usd","metadata":{"trade_no":"T1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"No such checkout.session"}}`))
+		}
+	}))
+	defer stripe.Close()
+
+	InitHttpClient()
+	setting.StripeApiBase = stripe.URL
+	setting.StripeApiSecret = "sk_test"
+	setting.StripePriceId = "price_1"
+	t.Cleanup(func() {
+		setting.StripeApiSecret = ""
+		setting.StripePriceId = ""
+	})
+
+	session, err := CreateStripeCheckoutSession(StripeCheckoutParams{TradeNo: "T1", Quantity: 5, SuccessUrl: "https://a/ok", CancelUrl: "https://a/cancel"})
+	if err != nil {
+		t.Fatalf("CreateStripeCheckoutSession() error = %v", err)
+	}
+	if session.Id != "cs_test_1" || session.Url == "" || session.TradeNo() != "T1" {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+
+	session, err = GetStripeCheckoutSession("cs_test_1")
+	if err != nil {
+		t.Fatalf("GetStripeCheckoutSession() error = %v", err)
+	}
+	if session.PaymentStatus != "paid" || session.TradeNo() != "T1" || session.PaidMoney() != 40 {
+		t.Fatalf("unexpected session: %+v", session)
+	}
+
+	if _, err := GetStripeCheckoutSession("cs_missing"); err == nil {
+		t.Fatal("expected error for missing session")
+	}
+	setting.StripeApiSecret = "sk_wrong"
+	if _, err := GetStripeCheckoutSession("cs_test_1"); err == nil || err.Error() != "stripe error: Invalid API Key provided" {
+		t.Fatalf("expected stripe error message, got %v", err)
+	}
+}
+
+func TestStripeCheckoutSessionPaidMoney(t *testing.T) {
+	tests := []struct {
+		currency string
+		amount   int64
+		want     float64
+	}{
+		{"usd", 4000, 40},
+		{"EUR", 1999, 19.99},
+		{"jpy", 4000, 4000},
+		{"krw", 50000, 50000},
+		{"kwd", 12345, 12.345},
+	}
+	for _, tt := range tests {
+		session := StripeCheckoutSession{AmountTotal: tt.amount, Currency: tt.currency}
+		if got := session.PaidMoney(); got != tt.want {
+			t.Errorf("PaidMoney(%d %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
@@ -22,6 +22,24 @@ func NotifyRootUser(t string, subject string, content string) {
 	}
 }
 
+// CanNotifyUser 判断用户当前的通知方式是否配置了可用的接收地址
+func CanNotifyUser(userEmail string, userSetting dto.UserSetting) bool {
+	switch userSetting.NotifyType {
+	case "", dto.NotifyTypeEmail:
+		if common.SMTPServer == "" {
+			return false
+		}
+		return userSetting.NotificationEmail != "" || userEmail != ""
+	case dto.NotifyTypeWebhook:
+		return userSetting.WebhookUrl != ""
+	case dto.NotifyTypeBark:
+		return userSetting.BarkUrl != ""
+	case dto.NotifyTypeGotify:
+		return userSetting.GotifyUrl != "" && userSetting.GotifyToken != ""
+	}
+	return false
+}
+
 func NotifyUser(userId int, userEmail string, userSetting dto.UserSetting, data dto.Notify) error {
 	notifyType := userSetting.NotifyType
 	if notifyType == "" {
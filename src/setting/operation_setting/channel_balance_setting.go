@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelBalanceSetting 渠道余额监控配置
+type ChannelBalanceSetting struct {
+	// AlertEnabled 余额低于渠道阈值时是否通知管理员
+	AlertEnabled bool `json:"alert_enabled"`
+	// DisableAtZero 余额耗尽时是否自动禁用渠道，充值后自动恢复；按本地消耗估算的余额不受此项影响
+	DisableAtZero bool `json:"disable_at_zero"`
+	// HistoryRetentionDays 余额历史保留天数，0 表示永久保留
+	HistoryRetentionDays int `json:"history_retention_days"`
+	// BurnRateWindowHours 计算消耗速度时使用的历史窗口（小时）
+	BurnRateWindowHours int `json:"burn_rate_window_hours"`
+}
+
+var channelBalanceSetting = ChannelBalanceSetting{
+	AlertEnabled:         true,
+	DisableAtZero:        true,
+	HistoryRetentionDays: 90,
+	BurnRateWindowHours:  72,
+}
+
+func init() {
+	config.GlobalConfig.Register("channel_balance_setting", &channelBalanceSetting)
+}
+
+func GetChannelBalanceSetting() *ChannelBalanceSetting {
+	return &channelBalanceSetting
+}
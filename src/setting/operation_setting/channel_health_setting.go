@@ -0,0 +1,24 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelHealthSetting 渠道健康度统计配置
+type ChannelHealthSetting struct {
+	// Enabled 是否按 渠道 × 模型 × 密钥 统计成功率、错误类型、延迟、首字时间与输出速度
+	Enabled bool `json:"enabled"`
+	// RetentionDays 历史统计保留天数，0 表示永久保留
+	RetentionDays int `json:"retention_days"`
+}
+
+var channelHealthSetting = ChannelHealthSetting{
+	Enabled:       true,
+	RetentionDays: 30,
+}
+
+func init() {
+	config.GlobalConfig.Register("channel_health_setting", &channelHealthSetting)
+}
+
+func GetChannelHealthSetting() *ChannelHealthSetting {
+	return &channelHealthSetting
+}
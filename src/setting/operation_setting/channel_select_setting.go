@@ -0,0 +1,116 @@
+package operation_setting
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	ChannelSelectStrategyWeighted      = "weighted"       // 按优先级与静态权重随机（默认）
+	ChannelSelectStrategyLeastRequests = "least_requests" // 最少在途请求
+	ChannelSelectStrategyLatency       = "latency"        // EWMA 首字延迟最低
+	ChannelSelectStrategySuccessRate   = "success_rate"   // 按成功率加权随机
+	ChannelSelectStrategyP2C           = "p2c"            // power of two choices
+)
+
+type ChannelSelectRule struct {
+	Name       string   `json:"name"`
+	Groups     []string `json:"groups,omitempty"`      // 为空表示匹配所有分组
+	ModelRegex []string `json:"model_regex,omitempty"` // 为空表示匹配所有模型
+	Strategy   string   `json:"strategy"`
+}
+
+type ChannelSelectSetting struct {
+	DefaultStrategy string              `json:"default_strategy"`
+	Rules           []ChannelSelectRule `json:"rules"`
+	// EWMAAlpha 平滑系数，越大越偏向最近的样本
+	EWMAAlpha float64 `json:"ewma_alpha"`
+	// RedisStatsEnabled 在启用 Redis 时跨实例共享渠道统计
+	RedisStatsEnabled bool `json:"redis_stats_enabled"`
+	// StatsTTLSeconds 渠道统计在 Redis 中的过期时间
+	StatsTTLSeconds int `json:"stats_ttl_seconds"`
+}
+
+var channelSelectSetting = ChannelSelectSetting{
+	DefaultStrategy:   ChannelSelectStrategyWeighted,
+	Rules:             []ChannelSelectRule{},
+	EWMAAlpha:         0.3,
+	RedisStatsEnabled: false,
+	StatsTTLSeconds:   3600,
+}
+
+var channelSelectRegexCache sync.Map // map[string]*regexp.Regexp
+
+func init() {
+	config.GlobalConfig.Register("channel_select_setting", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+func IsValidChannelSelectStrategy(strategy string) bool {
+	switch strategy {
+	case ChannelSelectStrategyWeighted, ChannelSelectStrategyLeastRequests, ChannelSelectStrategyLatency,
+		ChannelSelectStrategySuccessRate, ChannelSelectStrategyP2C:
+		return true
+	}
+	return false
+}
+
+// ResolveChannelSelectStrategy 返回分组/模型对应的选择策略，规则按顺序匹配，未命中时使用默认策略
+func ResolveChannelSelectStrategy(group string, modelName string) (string, string) {
+	s := GetChannelSelectSetting()
+	for _, rule := range s.Rules {
+		if !IsValidChannelSelectStrategy(rule.Strategy) {
+			continue
+		}
+		if len(rule.Groups) > 0 && !containsTrimmed(rule.Groups, group) {
+			continue
+		}
+		if len(rule.ModelRegex) > 0 && !matchAnyRegex(rule.ModelRegex, modelName) {
+			continue
+		}
+		return rule.Strategy, rule.Name
+	}
+	if IsValidChannelSelectStrategy(s.DefaultStrategy) {
+		return s.DefaultStrategy, ""
+	}
+	return ChannelSelectStrategyWeighted, ""
+}
+
+func containsTrimmed(list []string, value string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyRegex(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		var re *regexp.Regexp
+		if cached, ok := channelSelectRegexCache.Load(pattern); ok {
+			re = cached.(*regexp.Regexp)
+		} else {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				continue
+			}
+			re = compiled
+			channelSelectRegexCache.Store(pattern, re)
+		}
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
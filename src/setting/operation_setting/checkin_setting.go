@@ -7,6 +7,15 @@ type CheckinSetting struct {
 	Enabled  bool `json:"enabled"`   // 是否启用签到功能
 	MinQuota int  `json:"min_quota"` // 签到最小额度奖励
 	MaxQuota int  `json:"max_quota"` // 签到最大额度奖励
+	// StreakBonuses 连续签到达到指定天数当天额外奖励的额度，
+	// 使用切片而不是 map，保存配置时整体替换，删除的档位不会残留
+	StreakBonuses []CheckinStreakBonus `json:"streak_bonuses"`
+}
+
+// CheckinStreakBonus 连续签到奖励档位
+type CheckinStreakBonus struct {
+	Days  int `json:"days"`  // 连续签到天数
+	Quota int `json:"quota"` // 额外奖励的额度
 }
 
 // 默认配置
@@ -31,6 +40,16 @@ func IsCheckinEnabled() bool {
 	return checkinSetting.Enabled
 }
 
+// GetCheckinStreakBonus 获取连续签到指定天数时的额外奖励
+func GetCheckinStreakBonus(streak int) int {
+	for _, bonus := range checkinSetting.StreakBonuses {
+		if bonus.Days == streak {
+			return bonus.Quota
+		}
+	}
+	return 0
+}
+
 // GetCheckinQuotaRange 获取签到额度范围
 func GetCheckinQuotaRange() (min, max int) {
 	return checkinSetting.MinQuota, checkinSetting.MaxQuota
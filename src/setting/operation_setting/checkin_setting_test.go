@@ -0,0 +1,27 @@
+package operation_setting
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckinStreakBonusesReplacedOnUpdate(t *testing.T) {
+	orig := checkinSetting
+	t.Cleanup(func() { checkinSetting = orig })
+
+	require.NoError(t, config.UpdateConfigFromMap(&checkinSetting, map[string]string{
+		"streak_bonuses": `[{"days":3,"quota":100},{"days":7,"quota":500}]`,
+	}))
+	require.Equal(t, 100, GetCheckinStreakBonus(3))
+	require.Equal(t, 500, GetCheckinStreakBonus(7))
+	require.Equal(t, 0, GetCheckinStreakBonus(5))
+
+	// 删除的档位不应残留
+	require.NoError(t, config.UpdateConfigFromMap(&checkinSetting, map[string]string{
+		"streak_bonuses": `[{"days":7,"quota":300}]`,
+	}))
+	require.Equal(t, 0, GetCheckinStreakBonus(3))
+	require.Equal(t, 300, GetCheckinStreakBonus(7))
+}
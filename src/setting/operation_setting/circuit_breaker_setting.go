@@ -0,0 +1,44 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// CircuitBreakerSetting 渠道熔断配置
+type CircuitBreakerSetting struct {
+	Enabled bool `json:"enabled"`
+	// ConsecutiveFailures 连续失败次数达到该值时熔断，0 表示不按连续失败熔断
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// ErrorRateThreshold 统计窗口内错误率达到该值时熔断（0-1），0 表示不按错误率熔断
+	ErrorRateThreshold float64 `json:"error_rate_threshold"`
+	// MinRequests 统计窗口内至少有该数量的请求才计算错误率
+	MinRequests int `json:"min_requests"`
+	// WindowSeconds 错误率统计窗口
+	WindowSeconds int `json:"window_seconds"`
+	// OpenSeconds 熔断后进入半开状态前的等待时间
+	OpenSeconds int `json:"open_seconds"`
+	// HalfOpenMaxRequests 半开状态下允许同时放行的试探请求数
+	HalfOpenMaxRequests int `json:"half_open_max_requests"`
+	// HalfOpenSuccesses 半开状态下连续成功该次数后恢复
+	HalfOpenSuccesses int `json:"half_open_successes"`
+	// MultiKeyEnabled 是否对多密钥渠道的单个密钥分别熔断
+	MultiKeyEnabled bool `json:"multi_key_enabled"`
+}
+
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:             false,
+	ConsecutiveFailures: 5,
+	ErrorRateThreshold:  0.5,
+	MinRequests:         20,
+	WindowSeconds:       60,
+	OpenSeconds:         30,
+	HalfOpenMaxRequests: 1,
+	HalfOpenSuccesses:   2,
+	MultiKeyEnabled:     true,
+}
+
+func init() {
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
@@ -10,12 +10,15 @@ import (
 type MonitorSetting struct {
 	AutoTestChannelEnabled bool    `json:"auto_test_channel_enabled"`
 	AutoTestChannelMinutes float64 `json:"auto_test_channel_minutes"`
+	// TestResultRetentionDays 渠道测试记录保留天数，0 表示永久保留
+	TestResultRetentionDays int `json:"test_result_retention_days"`
 }
 
 // 默认配置
 var monitorSetting = MonitorSetting{
-	AutoTestChannelEnabled: false,
-	AutoTestChannelMinutes: 10,
+	AutoTestChannelEnabled:  false,
+	AutoTestChannelMinutes:  10,
+	TestResultRetentionDays: 30,
 }
 
 func init() {
@@ -0,0 +1,32 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// MultiKeyProbeSetting 多密钥渠道中自动禁用密钥的定期探测配置
+type MultiKeyProbeSetting struct {
+	Enabled bool `json:"enabled"`
+	// IntervalMinutes 探测间隔，连续探测失败的密钥按指数退避延长间隔
+	IntervalMinutes int `json:"interval_minutes"`
+	// MaxProbesPerRun 单次最多探测的密钥数量
+	MaxProbesPerRun int `json:"max_probes_per_run"`
+	// EscalatePermanent 探测到永久性错误（401、密钥无效等）时将密钥转为手动禁用，不再探测
+	EscalatePermanent bool `json:"escalate_permanent"`
+	// NotifySummary 探测完成后向管理员发送汇总通知
+	NotifySummary bool `json:"notify_summary"`
+}
+
+var multiKeyProbeSetting = MultiKeyProbeSetting{
+	Enabled:           false,
+	IntervalMinutes:   30,
+	MaxProbesPerRun:   100,
+	EscalatePermanent: true,
+	NotifySummary:     true,
+}
+
+func init() {
+	config.GlobalConfig.Register("multi_key_probe_setting", &multiKeyProbeSetting)
+}
+
+func GetMultiKeyProbeSetting() *MultiKeyProbeSetting {
+	return &multiKeyProbeSetting
+}
@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// TokenRotationSetting 令牌密钥轮换配置
+type TokenRotationSetting struct {
+	// GracePeriodMinutes 轮换后旧密钥继续可用的默认时长（分钟）
+	GracePeriodMinutes int `json:"grace_period_minutes"`
+	// MaxGracePeriodMinutes 手动轮换时允许指定的最长过渡期（分钟）
+	MaxGracePeriodMinutes int `json:"max_grace_period_minutes"`
+	// AutoRotateEnabled 是否按令牌设置的周期自动轮换密钥
+	AutoRotateEnabled bool `json:"auto_rotate_enabled"`
+	// CheckIntervalMinutes 检查到期令牌的间隔（分钟）
+	CheckIntervalMinutes int `json:"check_interval_minutes"`
+}
+
+var tokenRotationSetting = TokenRotationSetting{
+	GracePeriodMinutes:    1440,
+	MaxGracePeriodMinutes: 10080,
+	AutoRotateEnabled:     true,
+	CheckIntervalMinutes:  60,
+}
+
+func init() {
+	config.GlobalConfig.Register("token_rotation_setting", &tokenRotationSetting)
+}
+
+func GetTokenRotationSetting() *TokenRotationSetting {
+	return &tokenRotationSetting
+}
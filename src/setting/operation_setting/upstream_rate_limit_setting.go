@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// UpstreamRateLimitSetting 上游限流感知配置
+type UpstreamRateLimitSetting struct {
+	// Enabled 是否解析上游返回的 x-ratelimit-* / anthropic-ratelimit-* / Retry-After 响应头并在选择渠道时避开已耗尽的渠道或密钥
+	Enabled bool `json:"enabled"`
+	// DefaultCooldownSeconds 上游返回 429 但没有重置时间时的冷却时间
+	DefaultCooldownSeconds int `json:"default_cooldown_seconds"`
+	// MaxCooldownSeconds 单次冷却的最长时间，防止异常的重置时间导致渠道长期不可用
+	MaxCooldownSeconds int `json:"max_cooldown_seconds"`
+	// RemainingTokensThreshold 剩余 token 数低于该值时视为已耗尽
+	RemainingTokensThreshold int64 `json:"remaining_tokens_threshold"`
+}
+
+var upstreamRateLimitSetting = UpstreamRateLimitSetting{
+	Enabled:                  false, // 默认关闭，按需开启
+	DefaultCooldownSeconds:   10,
+	MaxCooldownSeconds:       300,
+	RemainingTokensThreshold: 0,
+}
+
+func init() {
+	config.GlobalConfig.Register("upstream_rate_limit_setting", &upstreamRateLimitSetting)
+}
+
+func GetUpstreamRateLimitSetting() *UpstreamRateLimitSetting {
+	return &upstreamRateLimitSetting
+}
@@ -1,8 +1,13 @@
 package setting
 
+import "github.com/QuantumNous/new-api/common"
+
 var StripeApiSecret = ""
 var StripeWebhookSecret = ""
 var StripePriceId = ""
 var StripeUnitPrice = 8.0
 var StripeMinTopUp = 1
 var StripePromotionCodesEnabled = false
+
+// StripeApiBase Stripe API 地址，测试时可指向本地模拟服务
+var StripeApiBase = common.GetEnvOrDefaultString("STRIPE_API_BASE", "https://api.stripe.com")